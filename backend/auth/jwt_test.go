@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerateAndParseToken(t *testing.T) {
+	token, err := GenerateToken(Claims{UserID: "user_123", ExpiresAt: time.Now().Add(time.Hour).Unix()}, "secret")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	claims, err := ParseToken(token, "secret")
+	if err != nil {
+		t.Fatalf("Expected token to be valid, got %v", err)
+	}
+	if claims.UserID != "user_123" {
+		t.Errorf("Expected user ID user_123, got %s", claims.UserID)
+	}
+}
+
+func TestParseTokenRejectsWrongSecret(t *testing.T) {
+	token, _ := GenerateToken(Claims{UserID: "user_123"}, "secret")
+
+	if _, err := ParseToken(token, "other"); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestParseTokenRejectsExpired(t *testing.T) {
+	token, _ := GenerateToken(Claims{UserID: "user_123", ExpiresAt: time.Now().Add(-time.Minute).Unix()}, "secret")
+
+	if _, err := ParseToken(token, "secret"); err != ErrExpiredToken {
+		t.Errorf("Expected ErrExpiredToken, got %v", err)
+	}
+}
+
+func TestParseTokenRejectsMalformed(t *testing.T) {
+	for _, token := range []string{"", "abc", "a.b.c", "jwt_token_here"} {
+		if _, err := ParseToken(token, "secret"); err == nil {
+			t.Errorf("Expected error for token %q", token)
+		}
+	}
+}
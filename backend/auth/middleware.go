@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"sms-app-backend/common"
+)
+
+// Gin context keys set by the middlewares
+const (
+	// ContextUserIDKey holds the authenticated user ID
+	ContextUserIDKey = "user_id"
+	// ContextUserRoleKey holds the authenticated user's role
+	ContextUserRoleKey = "user_role"
+	// ContextTenantIDKey holds the tenant named in the token, if any
+	ContextTenantIDKey = "tenant_id"
+	// ContextClaimsKey holds the *Claims of the validated token
+	ContextClaimsKey = "claims"
+)
+
+// RoleAdmin is the role granted to support and operations staff
+const RoleAdmin = "admin"
+
+// Middleware requires a valid bearer token and stores the user ID in the context
+func Middleware(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			appErr := common.NewUnauthorizedError("Authorization header required")
+			c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		if !authenticate(c, token, secret) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// OptionalMiddleware authenticates the request when a bearer token is present
+// and lets anonymous requests through
+func OptionalMiddleware(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := bearerToken(c); token != "" && !authenticate(c, token, secret) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// authenticate validates the token and stores its user ID, aborting with 401 on failure
+func authenticate(c *gin.Context, token, secret string) bool {
+	claims, err := ParseToken(token, secret)
+	if err != nil {
+		appErr := common.NewUnauthorizedError("Invalid or expired token")
+		c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+		return false
+	}
+
+	SetCurrentUser(c, claims)
+	return true
+}
+
+// SetCurrentUser stores the claims of the authenticated user in the context,
+// along with the user ID, role and tenant keys read by the other middlewares
+func SetCurrentUser(c *gin.Context, claims *Claims) {
+	c.Set(ContextClaimsKey, claims)
+	c.Set(ContextUserIDKey, claims.UserID)
+	c.Set(ContextUserRoleKey, claims.Role)
+	if claims.TenantID != "" {
+		c.Set(ContextTenantIDKey, claims.TenantID)
+	}
+}
+
+// CurrentUser returns the claims of the authenticated user, or false for
+// anonymous requests
+func CurrentUser(c *gin.Context) (*Claims, bool) {
+	value, ok := c.Get(ContextClaimsKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+	if !ok || claims == nil || claims.UserID == "" {
+		return nil, false
+	}
+	return claims, true
+}
+
+// RequireRole rejects requests whose authenticated user lacks the role.
+// It must run after Middleware.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(ContextUserRoleKey) != role {
+			appErr := common.NewForbiddenError("This action requires the " + role + " role")
+			c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RevocationMiddleware rejects authenticated requests whose token revoked
+// reports as revoked, e.g. after the user changed their phone number.
+// Anonymous requests pass. It must run after Middleware or OptionalMiddleware.
+func RevocationMiddleware(revoked func(ctx context.Context, claims *Claims) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims, ok := CurrentUser(c); ok && revoked(c.Request.Context(), claims) {
+			appErr := common.NewUnauthorizedError("Session has been revoked, please sign in again")
+			c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+			return
+		}
+		c.Next()
+	}
+}
+
+// bearerToken extracts the token from the Authorization header
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/admin", Middleware("secret"), RequireRole(RoleAdmin), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	expires := time.Now().Add(time.Hour).Unix()
+	adminToken, _ := GenerateToken(Claims{UserID: "admin_1", Role: RoleAdmin, ExpiresAt: expires}, "secret")
+	userToken, _ := GenerateToken(Claims{UserID: "user_1", ExpiresAt: expires}, "secret")
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"non-admin", userToken, http.StatusForbidden},
+		{"admin", adminToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestRevocationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	revoked := func(ctx context.Context, claims *Claims) bool { return claims.UserID == "user_revoked" }
+	r.GET("/profile", OptionalMiddleware("secret"), RevocationMiddleware(revoked), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	activeToken, _ := GenerateToken(Claims{UserID: "user_1"}, "secret")
+	revokedToken, _ := GenerateToken(Claims{UserID: "user_revoked"}, "secret")
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"anonymous", "", http.StatusOK},
+		{"active", activeToken, http.StatusOK},
+		{"revoked", revokedToken, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestCurrentUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var current *Claims
+	var found bool
+	r := gin.New()
+	r.GET("/me", OptionalMiddleware("secret"), func(c *gin.Context) {
+		current, found = CurrentUser(c)
+		c.Status(http.StatusOK)
+	})
+
+	token, _ := GenerateToken(Claims{UserID: "user_1", Role: RoleAdmin, TenantID: "acme", ExpiresAt: time.Now().Add(time.Hour).Unix()}, "secret")
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !found || current.UserID != "user_1" || current.Role != RoleAdmin || current.TenantID != "acme" {
+		t.Errorf("Expected the token's claims, got %+v, %v", current, found)
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/me", nil))
+	if found || current != nil {
+		t.Errorf("Expected no user for an anonymous request, got %+v", current)
+	}
+}
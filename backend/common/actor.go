@@ -0,0 +1,32 @@
+package common
+
+import "context"
+
+// Kinds of actors recorded in the audit log
+const (
+	ActorUser   = "user"
+	ActorAdmin  = "admin"
+	ActorSystem = "system"
+)
+
+// Actor identifies who caused a state change: a user, an admin or the system
+// itself, e.g. a background routine or a provider's delivery report
+type Actor struct {
+	Type string
+	ID   string
+}
+
+type actorContextKey struct{}
+
+// WithActor returns a context carrying the actor of a request
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext returns the actor carried by ctx, or the system when there is none
+func ActorFromContext(ctx context.Context) Actor {
+	if actor, ok := ctx.Value(actorContextKey{}).(Actor); ok && actor.Type != "" {
+		return actor
+	}
+	return Actor{Type: ActorSystem}
+}
@@ -2,7 +2,11 @@ package common
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 )
 
 // AppError represents application-specific errors
@@ -10,6 +14,12 @@ type AppError struct {
 	Code       int    `json:"code"`
 	Message    string `json:"message"`
 	Details    string `json:"details,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+	// RemainingAttempts is how many more wrong OTP codes are accepted, set on
+	// verifications rejected by a lockout
+	RemainingAttempts *int `json:"remaining_attempts,omitempty"`
+	// Fields maps invalid request fields to what is wrong with them
+	Fields     map[string]string `json:"fields,omitempty"`
 	StatusCode int    `json:"-"`
 }
 
@@ -18,6 +28,18 @@ func (e AppError) Error() string {
 	return fmt.Sprintf("Error %d: %s", e.Code, e.Message)
 }
 
+// WithRetryAfter sets how long the client should wait before retrying, rounded up to whole seconds
+func (e *AppError) WithRetryAfter(d time.Duration) *AppError {
+	e.RetryAfter = int(math.Ceil(d.Seconds()))
+	return e
+}
+
+// WithRemainingAttempts sets how many more wrong OTP codes are accepted
+func (e *AppError) WithRemainingAttempts(n int) *AppError {
+	e.RemainingAttempts = &n
+	return e
+}
+
 // NewAppError creates a new application error
 func NewAppError(code int, message, details string) *AppError {
 	return &AppError{
@@ -38,6 +60,19 @@ func NewValidationError(message string) *AppError {
 	}
 }
 
+// NewValidationErrors creates a validation error describing each invalid field
+func NewValidationErrors(fields map[string]string) *AppError {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	appErr := NewValidationError("Invalid fields: " + strings.Join(names, ", "))
+	appErr.Fields = fields
+	return appErr
+}
+
 // NewNotFoundError creates a not found error
 func NewNotFoundError(resource string) *AppError {
 	return &AppError{
@@ -58,6 +93,13 @@ func NewUnauthorizedError(message string) *AppError {
 	}
 }
 
+// NewForbiddenError creates a forbidden error for authenticated users lacking permission
+func NewForbiddenError(message string) *AppError {
+	appErr := NewAppError(ErrCodeForbidden, "Forbidden", message)
+	appErr.StatusCode = http.StatusForbidden
+	return appErr
+}
+
 // NewInternalError creates an internal server error
 func NewInternalError(message string) *AppError {
 	return &AppError{
@@ -78,6 +120,42 @@ func NewServiceUnavailableError(service string) *AppError {
 	}
 }
 
+// NewProviderError creates an error for messages the upstream SMS provider failed to send
+func NewProviderError(provider string) *AppError {
+	appErr := NewAppError(ErrCodeProviderFailed, "Provider Error", fmt.Sprintf("%s provider failed to send the message", provider))
+	appErr.StatusCode = http.StatusBadGateway
+	return appErr
+}
+
+// NewRateLimitError creates a rate limit error
+func NewRateLimitError(message string) *AppError {
+	appErr := NewAppError(ErrCodeRateLimit, "Rate Limit Exceeded", message)
+	appErr.StatusCode = http.StatusTooManyRequests
+	return appErr
+}
+
+// NewMaxAttemptsError creates an error for a phone number locked out after too many
+// wrong OTP codes, telling the client how long until it can try again
+func NewMaxAttemptsError(retryAfter time.Duration) *AppError {
+	appErr := NewAppError(ErrCodeMaxAttempts, "Too Many Attempts", "Maximum verification attempts reached. Please try again later.")
+	appErr.StatusCode = http.StatusTooManyRequests
+	return appErr.WithRetryAfter(retryAfter)
+}
+
+// NewConflictError creates a conflict error for requests that clash with the current resource state
+func NewConflictError(message string) *AppError {
+	appErr := NewAppError(ErrCodeConflict, "Conflict", message)
+	appErr.StatusCode = http.StatusConflict
+	return appErr
+}
+
+// NewOptedOutError creates an error for sends to a phone number on the suppression list
+func NewOptedOutError() *AppError {
+	appErr := NewAppError(ErrCodeOptedOut, "Recipient Opted Out", "This phone number has opted out of receiving messages")
+	appErr.StatusCode = http.StatusForbidden
+	return appErr
+}
+
 // Common error codes
 const (
 	ErrCodeValidation        = 1001
@@ -89,4 +167,8 @@ const (
 	ErrCodeOTPInvalid       = 1007
 	ErrCodeMaxAttempts      = 1008
 	ErrCodeRateLimit        = 1009
+	ErrCodeConflict         = 1010
+	ErrCodeOptedOut         = 1011
+	ErrCodeForbidden        = 1012
+	ErrCodeProviderFailed   = 1013
 ) 
\ No newline at end of file
@@ -0,0 +1,142 @@
+package common
+
+import "strings"
+
+// MaskPhone hides all but the last four digits of a phone number
+func MaskPhone(phone string) string {
+	prefix := ""
+	digits := phone
+	if strings.HasPrefix(digits, "+") {
+		prefix = "+"
+		digits = digits[1:]
+	}
+
+	if len(digits) <= 4 {
+		return prefix + strings.Repeat("*", len(digits))
+	}
+	return prefix + strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+}
+
+// PhoneLast4 returns the last four characters of a phone number
+func PhoneLast4(phone string) string {
+	if len(phone) <= 4 {
+		return phone
+	}
+	return phone[len(phone)-4:]
+}
+
+// NormalizePhone strips formatting from a phone number so the same number
+// always maps to the same E.164 key: spaces, dashes, dots and parentheses are
+// removed and a leading international "00" prefix becomes "+". The result is
+// not validated.
+func NormalizePhone(phone string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(phone) {
+		switch r {
+		case ' ', '-', '.', '(', ')', '\t':
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	normalized := b.String()
+	if strings.HasPrefix(normalized, "00") {
+		normalized = "+" + normalized[2:]
+	}
+	return normalized
+}
+
+// regionCallingCodes maps ISO 3166-1 alpha-2 region codes to their country
+// calling code
+var regionCallingCodes = map[string]string{
+	"US": "1", "CA": "1", "GB": "44", "IE": "353", "FR": "33", "DE": "49",
+	"ES": "34", "IT": "39", "NL": "31", "BE": "32", "CH": "41", "AT": "43",
+	"SE": "46", "NO": "47", "DK": "45", "FI": "358", "PL": "48", "PT": "351",
+	"RU": "7", "TR": "90", "IL": "972", "AE": "971", "SA": "966", "EG": "20",
+	"ZA": "27", "NG": "234", "KE": "254", "IN": "91", "PK": "92", "BD": "880",
+	"LK": "94", "CN": "86", "HK": "852", "JP": "81", "KR": "82", "SG": "65",
+	"MY": "60", "ID": "62", "PH": "63", "TH": "66", "VN": "84", "AU": "61",
+	"NZ": "64", "MX": "52", "BR": "55", "AR": "54", "CL": "56", "CO": "57",
+	"PE": "51",
+}
+
+// trunkZeroRegions keep the leading "0" of national numbers after the calling
+// code, e.g. "06 1234 5678" in Italy is "+390612345678"
+var trunkZeroRegions = map[string]bool{"IT": true}
+
+// ValidPhoneRegion reports whether region is a supported ISO 3166-1 alpha-2
+// code for NormalizePhoneForRegion
+func ValidPhoneRegion(region string) bool {
+	_, ok := regionCallingCodes[strings.ToUpper(region)]
+	return ok
+}
+
+// NormalizePhoneForRegion normalizes a phone number like NormalizePhone and
+// turns a national number without "+" into E.164 using the calling code of
+// region, e.g. "(555) 123-4567" becomes "+15551234567" for "US" and
+// "020 7946 0958" becomes "+442079460958" for "GB". A single leading trunk
+// "0", or "1" for 11-digit North American numbers, is dropped, except in
+// regions that keep it such as Italy. International numbers dialled with "00",
+// or "011" in North America, are kept as they are. Numbers are left without
+// "+" when region is empty or unknown, so validation rejects them.
+func NormalizePhoneForRegion(phone, region string) string {
+	normalized := NormalizePhone(phone)
+	region = strings.ToUpper(region)
+	code, ok := regionCallingCodes[region]
+	if !ok || normalized == "" || strings.HasPrefix(normalized, "+") {
+		return normalized
+	}
+	for _, r := range normalized {
+		if r < '0' || r > '9' {
+			return normalized
+		}
+	}
+
+	national := normalized
+	switch {
+	case code == "1" && strings.HasPrefix(national, "011"):
+		return "+" + national[3:]
+	case code == "1" && len(national) == 11 && national[0] == '1':
+		national = national[1:]
+	case national[0] == '0' && !trunkZeroRegions[region]:
+		national = national[1:]
+	}
+	return "+" + code + national
+}
+
+// shortCallingCodes are the one and two digit country calling codes; every
+// other E.164 calling code has three digits
+var shortCallingCodes = map[string]bool{
+	"1": true, "7": true,
+	"20": true, "27": true, "30": true, "31": true, "32": true, "33": true, "34": true,
+	"36": true, "39": true, "40": true, "41": true, "43": true, "44": true, "45": true,
+	"46": true, "47": true, "48": true, "49": true, "51": true, "52": true, "53": true,
+	"54": true, "55": true, "56": true, "57": true, "58": true, "60": true, "61": true,
+	"62": true, "63": true, "64": true, "65": true, "66": true, "81": true, "82": true,
+	"84": true, "86": true, "90": true, "91": true, "92": true, "93": true, "94": true,
+	"95": true, "98": true,
+}
+
+// CallingCode returns the country calling code of an E.164 phone number, e.g.
+// "44" for "+442079460958", or "" when the number isn't in E.164 form
+func CallingCode(phone string) string {
+	if !strings.HasPrefix(phone, "+") || len(phone) < 4 {
+		return ""
+	}
+	digits := phone[1:]
+	if digits[0] == '0' {
+		return ""
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return ""
+		}
+	}
+
+	for length := 1; length <= 2; length++ {
+		if shortCallingCodes[digits[:length]] {
+			return digits[:length]
+		}
+	}
+	return digits[:3]
+}
@@ -0,0 +1,77 @@
+package common
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"+15551234567", "+15551234567"},
+		{"+1 555 123 4567", "+15551234567"},
+		{" +1 (555) 123-4567 ", "+15551234567"},
+		{"+44.20.7946.0958", "+442079460958"},
+		{"0044 20 7946 0958", "+442079460958"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizePhone(tt.input); got != tt.expected {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestCallingCode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"+15551234567", "1"},
+		{"+79161234567", "7"},
+		{"+442079460958", "44"},
+		{"+919876543210", "91"},
+		{"+353851234567", "353"},
+		{"+971501234567", "971"},
+		{"15551234567", ""},
+		{"+0123456789", ""},
+		{"+1-555", ""},
+	}
+
+	for _, tt := range tests {
+		if got := CallingCode(tt.input); got != tt.expected {
+			t.Errorf("CallingCode(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestNormalizePhoneForRegion(t *testing.T) {
+	tests := []struct {
+		input    string
+		region   string
+		expected string
+	}{
+		{"5551234567", "US", "+15551234567"},
+		{"(555) 123-4567", "us", "+15551234567"},
+		{"1 555 123 4567", "US", "+15551234567"},
+		{"020 7946 0958", "GB", "+442079460958"},
+		{"98765 43210", "IN", "+919876543210"},
+		{"+44 20 7946 0958", "US", "+442079460958"},
+		{"0044 20 7946 0958", "US", "+442079460958"},
+		{"00 39 06 1234 5678", "GB", "+390612345678"},
+		{"011 44 20 7946 0958", "US", "+442079460958"},
+		{"011 44 20 7946 0958", "CA", "+442079460958"},
+		{"06 1234 5678", "IT", "+390612345678"},
+		{"06 1234 5678", "it", "+390612345678"},
+		{"312 345 6789", "IT", "+393123456789"},
+		{"0612345678", "FR", "+33612345678"},
+		{"5551234567", "", "5551234567"},
+		{"5551234567", "XX", "5551234567"},
+		{"555-CALL", "US", "555CALL"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizePhoneForRegion(tt.input, tt.region); got != tt.expected {
+			t.Errorf("NormalizePhoneForRegion(%q, %q) = %q, want %q", tt.input, tt.region, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the ID of a request set by the load balancer
+const RequestIDHeader = "X-Request-ID"
+
+// RecoveryMiddleware turns a panic in a later handler into a 500 AppError
+// JSON response. The panic and its stack are logged with the request ID,
+// which is the only detail passed on to the client so reports can be matched
+// to the log.
+func RecoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			requestID := c.GetHeader(RequestIDHeader)
+			if requestID == "" {
+				requestID = newRequestID()
+			}
+			log.Printf("Panic handling %s %s (request %s): %v\n%s", c.Request.Method, c.Request.URL.Path, requestID, recovered, debug.Stack())
+
+			// Part of the response may already be on its way to the client
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			appErr := NewInternalError("An unexpected error occurred (request " + requestID + ")")
+			c.Header(RequestIDHeader, requestID)
+			c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+		}()
+		c.Next()
+	}
+}
+
+// newRequestID returns a random ID for requests that arrived without one
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
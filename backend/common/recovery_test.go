@@ -0,0 +1,53 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RecoveryMiddleware())
+	r.GET("/panic", func(c *gin.Context) {
+		panic("database password is hunter2")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.Header.Set(RequestIDHeader, "req-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("Expected a JSON response, got %q", contentType)
+	}
+
+	var appErr AppError
+	if err := json.Unmarshal(w.Body.Bytes(), &appErr); err != nil {
+		t.Fatalf("Expected an AppError body, got %s", w.Body.String())
+	}
+	if appErr.Code != ErrCodeInternal || appErr.Message != "Internal Server Error" {
+		t.Errorf("Expected an internal error, got %+v", appErr)
+	}
+	if !strings.Contains(appErr.Details, "req-123") {
+		t.Errorf("Expected the details to reference the request ID, got %q", appErr.Details)
+	}
+	if strings.Contains(w.Body.String(), "hunter2") || strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("Expected the panic and stack not to be exposed, got %s", w.Body.String())
+	}
+
+	// Requests without an ID get one generated
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if w.Code != http.StatusInternalServerError || w.Header().Get(RequestIDHeader) == "" {
+		t.Errorf("Expected a 500 with a generated request ID, got %d %q", w.Code, w.Header().Get(RequestIDHeader))
+	}
+}
@@ -0,0 +1,96 @@
+package common
+
+import "unicode/utf16"
+
+// SMS encodings
+const (
+	EncodingGSM7 = "GSM-7"
+	EncodingUCS2 = "UCS-2"
+)
+
+// Segment sizes for single and multipart (concatenated) messages
+const (
+	gsm7SingleSegment = 160
+	gsm7MultiSegment  = 153
+	ucs2SingleSegment = 70
+	ucs2MultiSegment  = 67
+)
+
+// gsm7Basic is the GSM 03.38 basic character set
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extended holds characters that need an escape and take two septets
+const gsm7Extended = "^{}\\[~]|€\f"
+
+var (
+	gsm7BasicSet    = runeSet(gsm7Basic)
+	gsm7ExtendedSet = runeSet(gsm7Extended)
+)
+
+// SegmentCount detects whether a message fits the GSM-7 alphabet and returns the
+// number of SMS segments it will be billed as, along with the encoding used.
+func SegmentCount(message string) (segments int, encoding string) {
+	length, encoding := MessageLength(message)
+	if encoding == EncodingGSM7 {
+		return countSegments(length, gsm7SingleSegment, gsm7MultiSegment), encoding
+	}
+	return countSegments(length, ucs2SingleSegment, ucs2MultiSegment), encoding
+}
+
+// MessageLength returns the length of a message in characters of its encoding:
+// GSM-7 septets (extended characters count twice) or UCS-2 code units (emoji count twice).
+func MessageLength(message string) (length int, encoding string) {
+	if length, ok := gsm7Length(message); ok {
+		return length, EncodingGSM7
+	}
+	return len(utf16.Encode([]rune(message))), EncodingUCS2
+}
+
+// MaxMessageLength returns how many characters fit in the given number of segments
+func MaxMessageLength(encoding string, segments int) int {
+	single, multi := gsm7SingleSegment, gsm7MultiSegment
+	if encoding == EncodingUCS2 {
+		single, multi = ucs2SingleSegment, ucs2MultiSegment
+	}
+
+	if segments <= 1 {
+		return single
+	}
+	return segments * multi
+}
+
+// gsm7Length returns the number of septets needed to encode a message in GSM-7
+func gsm7Length(message string) (int, bool) {
+	length := 0
+	for _, char := range message {
+		switch {
+		case gsm7BasicSet[char]:
+			length++
+		case gsm7ExtendedSet[char]:
+			length += 2
+		default:
+			return 0, false
+		}
+	}
+	return length, true
+}
+
+// countSegments splits a message length into single or multipart segments
+func countSegments(length, single, multi int) int {
+	if length == 0 {
+		return 0
+	}
+	if length <= single {
+		return 1
+	}
+	return (length + multi - 1) / multi
+}
+
+func runeSet(chars string) map[rune]bool {
+	set := make(map[rune]bool, len(chars))
+	for _, char := range chars {
+		set[char] = true
+	}
+	return set
+}
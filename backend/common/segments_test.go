@@ -0,0 +1,55 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSegmentCount(t *testing.T) {
+	tests := []struct {
+		name             string
+		message          string
+		expectedSegments int
+		expectedEncoding string
+	}{
+		{"empty", "", 0, EncodingGSM7},
+		{"short GSM", "Hello World", 1, EncodingGSM7},
+		{"single GSM segment limit", strings.Repeat("a", 160), 1, EncodingGSM7},
+		{"multipart GSM", strings.Repeat("a", 161), 2, EncodingGSM7},
+		{"three GSM segments", strings.Repeat("a", 307), 3, EncodingGSM7},
+		{"extended chars take two septets", strings.Repeat("€", 80), 1, EncodingGSM7},
+		{"extended chars overflow", strings.Repeat("€", 81), 2, EncodingGSM7},
+		{"short unicode", "Привет", 1, EncodingUCS2},
+		{"single UCS-2 segment limit", strings.Repeat("я", 70), 1, EncodingUCS2},
+		{"multipart UCS-2", strings.Repeat("я", 71), 2, EncodingUCS2},
+		{"emoji counts as two units", strings.Repeat("😀", 35), 1, EncodingUCS2},
+		{"emoji overflow", strings.Repeat("😀", 36), 2, EncodingUCS2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, encoding := SegmentCount(tt.message)
+			if segments != tt.expectedSegments {
+				t.Errorf("Expected %d segments, got %d", tt.expectedSegments, segments)
+			}
+			if encoding != tt.expectedEncoding {
+				t.Errorf("Expected encoding %s, got %s", tt.expectedEncoding, encoding)
+			}
+		})
+	}
+}
+
+func TestMaxMessageLength(t *testing.T) {
+	if max := MaxMessageLength(EncodingGSM7, 1); max != 160 {
+		t.Errorf("Expected 160, got %d", max)
+	}
+	if max := MaxMessageLength(EncodingGSM7, 10); max != 1530 {
+		t.Errorf("Expected 1530, got %d", max)
+	}
+	if max := MaxMessageLength(EncodingUCS2, 1); max != 70 {
+		t.Errorf("Expected 70, got %d", max)
+	}
+	if max := MaxMessageLength(EncodingUCS2, 10); max != 670 {
+		t.Errorf("Expected 670, got %d", max)
+	}
+}
@@ -0,0 +1,94 @@
+// Package config loads application settings from environment variables.
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+)
+
+// Defaults used when the corresponding CORS_* variables are unset
+var (
+	defaultCORSOrigins = []string{"http://localhost:3000"}
+	defaultCORSMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	defaultCORSHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+)
+
+// LoadCORS builds the CORS configuration from the environment:
+//   - CORS_ORIGIN: an extra allowed origin, added to http://localhost:3000
+//   - ADDITIONAL_CORS_ORIGINS: comma-separated origins, only when ENVIRONMENT=production
+//   - CORS_ALLOWED_METHODS / CORS_ALLOWED_HEADERS: comma-separated overrides
+//   - CORS_ALLOW_CREDENTIALS: whether cookies and auth headers are allowed (default true)
+//
+// Browsers reject credentials combined with a "*" origin, so when credentials
+// are enabled a wildcard origin is dropped with a warning.
+func LoadCORS() cors.Config {
+	config := cors.DefaultConfig()
+
+	origins := append([]string{}, defaultCORSOrigins...)
+	origins = append(origins, os.Getenv("CORS_ORIGIN"))
+	if os.Getenv("ENVIRONMENT") == "production" {
+		origins = append(origins, splitList(os.Getenv("ADDITIONAL_CORS_ORIGINS"))...)
+	}
+
+	config.AllowCredentials = true
+	if value := os.Getenv("CORS_ALLOW_CREDENTIALS"); value != "" {
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Warning: invalid value %q for CORS_ALLOW_CREDENTIALS, using default true", value)
+		} else {
+			config.AllowCredentials = allow
+		}
+	}
+
+	config.AllowOrigins = uniqueOrigins(origins, config.AllowCredentials)
+	config.AllowMethods = listOrDefault(os.Getenv("CORS_ALLOWED_METHODS"), defaultCORSMethods)
+	config.AllowHeaders = listOrDefault(os.Getenv("CORS_ALLOWED_HEADERS"), defaultCORSHeaders)
+	config.MaxAge = 12 * time.Hour
+
+	return config
+}
+
+// uniqueOrigins removes duplicates and empty entries, and drops the "*" origin
+// when credentials are allowed
+func uniqueOrigins(origins []string, allowCredentials bool) []string {
+	unique := make([]string, 0, len(origins))
+	seen := make(map[string]bool)
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		if origin == "" || seen[origin] {
+			continue
+		}
+		seen[origin] = true
+
+		if origin == "*" && allowCredentials {
+			log.Println("Warning: ignoring CORS origin \"*\", it cannot be combined with CORS_ALLOW_CREDENTIALS=true")
+			continue
+		}
+		unique = append(unique, origin)
+	}
+	return unique
+}
+
+// listOrDefault parses a comma-separated list, falling back to def when empty
+func listOrDefault(value string, def []string) []string {
+	if list := splitList(value); len(list) > 0 {
+		return list
+	}
+	return append([]string{}, def...)
+}
+
+// splitList splits a comma-separated value, trimming spaces and dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
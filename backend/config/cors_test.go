@@ -0,0 +1,74 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadCORSDefaults(t *testing.T) {
+	t.Setenv("CORS_ORIGIN", "")
+	t.Setenv("ENVIRONMENT", "")
+	t.Setenv("CORS_ALLOWED_METHODS", "")
+	t.Setenv("CORS_ALLOWED_HEADERS", "")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "")
+
+	config := LoadCORS()
+
+	if !reflect.DeepEqual(config.AllowOrigins, []string{"http://localhost:3000"}) {
+		t.Errorf("Unexpected origins %v", config.AllowOrigins)
+	}
+	if !reflect.DeepEqual(config.AllowMethods, defaultCORSMethods) {
+		t.Errorf("Unexpected methods %v", config.AllowMethods)
+	}
+	if !reflect.DeepEqual(config.AllowHeaders, defaultCORSHeaders) {
+		t.Errorf("Unexpected headers %v", config.AllowHeaders)
+	}
+	if !config.AllowCredentials {
+		t.Errorf("Expected credentials to be allowed by default")
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected a valid config, got %v", err)
+	}
+}
+
+func TestLoadCORSOverrides(t *testing.T) {
+	t.Setenv("CORS_ORIGIN", "https://app.example.com")
+	t.Setenv("ENVIRONMENT", "production")
+	t.Setenv("ADDITIONAL_CORS_ORIGINS", "https://admin.example.com, https://app.example.com,")
+	t.Setenv("CORS_ALLOWED_METHODS", "GET, POST")
+	t.Setenv("CORS_ALLOWED_HEADERS", "Content-Type,X-Request-ID")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "false")
+
+	config := LoadCORS()
+
+	expectedOrigins := []string{"http://localhost:3000", "https://app.example.com", "https://admin.example.com"}
+	if !reflect.DeepEqual(config.AllowOrigins, expectedOrigins) {
+		t.Errorf("Expected origins %v, got %v", expectedOrigins, config.AllowOrigins)
+	}
+	if !reflect.DeepEqual(config.AllowMethods, []string{"GET", "POST"}) {
+		t.Errorf("Unexpected methods %v", config.AllowMethods)
+	}
+	if !reflect.DeepEqual(config.AllowHeaders, []string{"Content-Type", "X-Request-ID"}) {
+		t.Errorf("Unexpected headers %v", config.AllowHeaders)
+	}
+	if config.AllowCredentials {
+		t.Errorf("Expected credentials to be disabled")
+	}
+}
+
+func TestLoadCORSWildcard(t *testing.T) {
+	t.Setenv("CORS_ORIGIN", "*")
+	t.Setenv("ENVIRONMENT", "")
+	t.Setenv("CORS_ALLOWED_METHODS", "")
+	t.Setenv("CORS_ALLOWED_HEADERS", "")
+
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	if config := LoadCORS(); !reflect.DeepEqual(config.AllowOrigins, []string{"http://localhost:3000"}) {
+		t.Errorf("Expected wildcard to be dropped with credentials, got %v", config.AllowOrigins)
+	}
+
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "false")
+	if config := LoadCORS(); !reflect.DeepEqual(config.AllowOrigins, []string{"http://localhost:3000", "*"}) {
+		t.Errorf("Expected wildcard to be kept without credentials, got %v", config.AllowOrigins)
+	}
+}
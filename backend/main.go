@@ -1,21 +1,32 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 	"github.com/swaggo/gin-swagger"
 	"github.com/swaggo/files"
+	"sms-app-backend/auth"
+	"sms-app-backend/common"
+	"sms-app-backend/config"
 	_ "sms-app-backend/docs"
+	"sms-app-backend/metrics"
+	"sms-app-backend/repository"
 	"sms-app-backend/repository/mongo"
 	"sms-app-backend/sms_service"
 	"sms-app-backend/sms_service/transport"
+	"sms-app-backend/webhook"
 )
 
 // @title SMS App Backend API
@@ -40,47 +51,20 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Initialize router
-	r := gin.Default()
+	// Initialize router; panics are answered with a JSON error instead of gin's plain 500
+	r := gin.New()
+	r.Use(gin.Logger(), common.RecoveryMiddleware())
 
 	// CORS configuration
-	config := cors.DefaultConfig()
-	corsOrigins := []string{"http://localhost:3000"}
+	corsConfig := config.LoadCORS()
+	r.Use(cors.New(corsConfig))
 	
-	// Add CORS_ORIGIN if set
-	if corsOrigin := os.Getenv("CORS_ORIGIN"); corsOrigin != "" {
-		corsOrigins = append(corsOrigins, corsOrigin)
-	}
-	
-	// Allow additional origins in production
-	if os.Getenv("ENVIRONMENT") == "production" {
-		if additionalOrigins := os.Getenv("ADDITIONAL_CORS_ORIGINS"); additionalOrigins != "" {
-			origins := strings.Split(additionalOrigins, ",")
-			corsOrigins = append(corsOrigins, origins...)
-		}
-	}
-	
-	// Remove duplicates and empty strings
-	uniqueOrigins := make([]string, 0)
-	seen := make(map[string]bool)
-	for _, origin := range corsOrigins {
-		if origin != "" && !seen[origin] {
-			uniqueOrigins = append(uniqueOrigins, origin)
-			seen[origin] = true
-		}
-	}
-	
-	config.AllowOrigins = uniqueOrigins
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
-	config.AllowCredentials = true
-	config.MaxAge = 12 * time.Hour
-	
-	// Apply CORS middleware
-	r.Use(cors.New(config))
+	// Record the latency of every request by route and status code
+	requestLatency := metrics.NewRequestLatency(nil)
+	r.Use(metrics.LatencyMiddleware(requestLatency, time.Duration(getEnvInt("REQUEST_LOG_THRESHOLD_MS", 0))*time.Millisecond))
 	
 	// Log CORS configuration for debugging
-	log.Printf("CORS configured with origins: %v", uniqueOrigins)
+	log.Printf("CORS configured with origins: %v", corsConfig.AllowOrigins)
 	log.Printf("Environment: %s", os.Getenv("ENVIRONMENT"))
 
 	// Initialize MongoDB repository
@@ -89,56 +73,383 @@ func main() {
 		mongoURI = "mongodb://localhost:27017"
 	}
 	
-	repo, err := mongo.NewRepository(mongoURI, "sms_app")
-	if err != nil {
+	mongoTimeout := time.Duration(getEnvInt("MONGODB_OP_TIMEOUT_SECONDS", int(mongo.DefaultTimeout/time.Second))) * time.Second
+	smsIndexes := mongo.DefaultSMSIndexes()
+	if extra := os.Getenv("MONGODB_SMS_EXTRA_INDEXES"); extra != "" {
+		if indexes, err := mongo.ParseIndexKeys(extra); err != nil {
+			log.Printf("Warning: invalid MONGODB_SMS_EXTRA_INDEXES: %v", err)
+		} else {
+			smsIndexes = append(smsIndexes, indexes...)
+		}
+	}
+	repo, err := mongo.NewRepository(mongoURI, "sms_app", mongoTimeout,
+		mongo.WithPoolSize(uint64(getEnvInt("MONGODB_MIN_POOL_SIZE", 0)), uint64(getEnvInt("MONGODB_MAX_POOL_SIZE", 0))),
+		mongo.WithConnectTimeout(time.Duration(getEnvInt("MONGODB_CONNECT_TIMEOUT_SECONDS", 10))*time.Second),
+		mongo.WithSocketTimeout(time.Duration(getEnvInt("MONGODB_SOCKET_TIMEOUT_SECONDS", 0))*time.Second),
+		mongo.WithRetryWrites(getEnvBool("MONGODB_RETRY_WRITES", true)),
+		mongo.WithReconnect(time.Duration(getEnvInt("MONGODB_RECONNECT_INTERVAL_SECONDS", 10))*time.Second),
+		mongo.WithTLSFiles(os.Getenv("MONGODB_TLS_CA_FILE"), os.Getenv("MONGODB_TLS_CERT_FILE"), os.Getenv("MONGODB_TLS_KEY_FILE")),
+		mongo.WithSMSIndexes(smsIndexes...),
+	)
+	if repo == nil {
 		log.Printf("Warning: MongoDB not connected: %v", err)
 		log.Println("SMS functionality will be limited")
-		repo = nil
+	} else if err != nil {
+		log.Printf("Warning: MongoDB not reachable yet, retrying in the background: %v", err)
+	}
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Println("Warning: JWT_SECRET not configured, authenticated endpoints will reject all tokens")
 	}
 
 	// Initialize SMS service components
-	var smsClient transport.SMSClient
 	plivoAuthID := os.Getenv("PLIVO_AUTH_ID")
 	plivoAuthToken := os.Getenv("PLIVO_AUTH_TOKEN")
-	plivoFrom := os.Getenv("PLIVO_FROM_NUMBER")
-	
-	if plivoAuthID != "" && plivoAuthToken != "" && plivoFrom != "" {
-		smsClient = transport.NewPlivoClient(plivoAuthID, plivoAuthToken, plivoFrom)
-	} else {
-		log.Println("Warning: Plivo credentials not configured, using mock client")
-		smsClient = transport.NewMockClient("mock")
+	// Provider API calls share one HTTP client, going through the configured proxy
+	providerHTTPClient, err := transport.NewHTTPClient(transport.HTTPClientConfig{
+		Timeout:             time.Duration(getEnvInt("PROVIDER_HTTP_TIMEOUT_SECONDS", 0)) * time.Second,
+		MaxIdleConns:        getEnvInt("PROVIDER_HTTP_MAX_IDLE_CONNS", 0),
+		MaxIdleConnsPerHost: getEnvInt("PROVIDER_HTTP_MAX_IDLE_CONNS_PER_HOST", 0),
+		ProxyURL:            os.Getenv("PROVIDER_PROXY_URL"),
+	})
+	if err != nil {
+		log.Fatalf("Invalid provider HTTP client configuration: %v", err)
+	}
+	smsClient, err := transport.NewClientFromEnv(providerHTTPClient)
+	if err != nil {
+		log.Fatalf("Invalid SMS provider configuration: %v", err)
 	}
+	if smsClient.GetProvider() == transport.ProviderMock {
+		log.Println("Warning: SMS provider credentials not configured, using mock client")
+	}
+	// Providers that can place calls also read out voice OTPs and hang up callbacks
+	voiceClient, _ := smsClient.(transport.VoiceClient)
 	
+	serviceOpts := []sms_service.Option{}
+	additionalClients, err := transport.NewAdditionalClientsFromEnv(providerHTTPClient, smsClient.GetProvider())
+	if err != nil {
+		log.Fatalf("Invalid SMS_ADDITIONAL_PROVIDERS configuration: %v", err)
+	}
+	if len(additionalClients) > 0 {
+		serviceOpts = append(serviceOpts, sms_service.WithProviders(additionalClients...))
+	}
+	whatsAppFrom := os.Getenv("PLIVO_WHATSAPP_FROM")
+	whatsAppTemplate := os.Getenv("PLIVO_WHATSAPP_TEMPLATE")
+	if plivoAuthID != "" && plivoAuthToken != "" && whatsAppFrom != "" && whatsAppTemplate != "" {
+		serviceOpts = append(serviceOpts, sms_service.WithWhatsAppClient(
+			transport.NewPlivoWhatsAppClient(plivoAuthID, plivoAuthToken, whatsAppFrom, whatsAppTemplate),
+		))
+	}
+	if voiceClient != nil {
+		serviceOpts = append(serviceOpts, sms_service.WithVoiceOTPClient(voiceClient))
+	}
+
 	var smsService sms_service.SMSService
+	var smsServiceImpl *sms_service.SMSServiceImpl
 	var callbackService sms_service.CallbackService
+	var callbackServiceImpl *sms_service.CallbackServiceImpl
 	var logsService sms_service.LogsService
+	var userService sms_service.UserService
 	
+	// SMS service configuration
+	serviceConfig := sms_service.DefaultConfig()
+	serviceConfig.OTPTTL = time.Duration(getEnvInt("OTP_TTL_SECONDS", int(serviceConfig.OTPTTL/time.Second))) * time.Second
+	serviceConfig.OTPMaxAttempts = getEnvInt("OTP_MAX_ATTEMPTS", serviceConfig.OTPMaxAttempts)
+	serviceConfig.OTPResendCooldown = time.Duration(getEnvInt("OTP_RESEND_COOLDOWN_SECONDS", int(serviceConfig.OTPResendCooldown/time.Second))) * time.Second
+	serviceConfig.OTPMaxResends = getEnvInt("OTP_MAX_RESENDS", serviceConfig.OTPMaxResends)
+	serviceConfig.OTPLockout = time.Duration(getEnvInt("OTP_LOCKOUT_SECONDS", int(serviceConfig.OTPLockout/time.Second))) * time.Second
+	serviceConfig.OTPVerifyGrace = time.Duration(getEnvInt("OTP_VERIFY_GRACE_SECONDS", int(serviceConfig.OTPVerifyGrace/time.Second))) * time.Second
+	serviceConfig.OTPVoiceEscalationDelay = time.Duration(getEnvInt("OTP_VOICE_ESCALATION_SECONDS", int(serviceConfig.OTPVoiceEscalationDelay/time.Second))) * time.Second
+	serviceConfig.MaxOTPLifetime = time.Duration(getEnvInt("OTP_MAX_LIFETIME_SECONDS", int(serviceConfig.MaxOTPLifetime/time.Second))) * time.Second
+	serviceConfig.ExposeOTP = getEnvBool("EXPOSE_OTP", false)
+	if serviceConfig.ExposeOTP && gin.Mode() == gin.ReleaseMode {
+		log.Println("Warning: EXPOSE_OTP is ignored in release mode, OTPs are never returned in production")
+		serviceConfig.ExposeOTP = false
+	}
+	serviceConfig.DailyOTPLimit = getEnvInt("OTP_DAILY_LIMIT", serviceConfig.DailyOTPLimit)
+	serviceConfig.DefaultMonthlySMSQuota = getEnvInt("SMS_MONTHLY_QUOTA", serviceConfig.DefaultMonthlySMSQuota)
+	serviceConfig.AnonymousMonthlySMSQuota = getEnvInt("SMS_ANONYMOUS_MONTHLY_QUOTA", serviceConfig.DefaultMonthlySMSQuota)
+	serviceConfig.MaxSMSSegments = getEnvInt("SMS_MAX_SEGMENTS", serviceConfig.MaxSMSSegments)
+	serviceConfig.MaxInFlightPerNumber = getEnvInt("SMS_MAX_IN_FLIGHT_PER_NUMBER", serviceConfig.MaxInFlightPerNumber)
+	serviceConfig.SMSDedupWindow = time.Duration(getEnvInt("SMS_DEDUP_WINDOW_SECONDS", int(serviceConfig.SMSDedupWindow/time.Second))) * time.Second
+	serviceConfig.MaxConcurrentSends = getEnvInt("SMS_MAX_CONCURRENT_SENDS", serviceConfig.MaxConcurrentSends)
+	if limits := os.Getenv("SMS_PROVIDER_CONCURRENCY"); limits != "" {
+		if parsed, err := sms_service.ParseProviderConcurrency(limits); err != nil {
+			log.Printf("Warning: %v, ignoring per-provider concurrency limits", err)
+		} else {
+			serviceConfig.ProviderConcurrency = parsed
+		}
+	}
+	serviceConfig.ProviderTimeout = time.Duration(getEnvInt("PROVIDER_TIMEOUT_SECONDS", int(serviceConfig.ProviderTimeout/time.Second))) * time.Second
+	serviceConfig.ProviderBreaker.MinRequests = getEnvInt("PROVIDER_BREAKER_MIN_REQUESTS", serviceConfig.ProviderBreaker.MinRequests)
+	if ratio := os.Getenv("PROVIDER_BREAKER_FAILURE_RATIO"); ratio != "" {
+		if parsed, err := strconv.ParseFloat(ratio, 64); err != nil || parsed < 0 || parsed > 1 {
+			log.Printf("Warning: invalid value %q for PROVIDER_BREAKER_FAILURE_RATIO, using default %g", ratio, serviceConfig.ProviderBreaker.FailureRatio)
+		} else {
+			serviceConfig.ProviderBreaker.FailureRatio = parsed
+		}
+	}
+	serviceConfig.ProviderBreaker.Window = time.Duration(getEnvInt("PROVIDER_BREAKER_WINDOW_SECONDS", int(serviceConfig.ProviderBreaker.Window/time.Second))) * time.Second
+	serviceConfig.ProviderBreaker.OpenFor = time.Duration(getEnvInt("PROVIDER_BREAKER_OPEN_SECONDS", int(serviceConfig.ProviderBreaker.OpenFor/time.Second))) * time.Second
+	serviceConfig.SMSRetry.MaxRetries = getEnvInt("SMS_MAX_RETRIES", serviceConfig.SMSRetry.MaxRetries)
+	serviceConfig.SMSRetry.Interval = time.Duration(getEnvInt("SMS_RETRY_INTERVAL_SECONDS", int(serviceConfig.SMSRetry.Interval/time.Second))) * time.Second
+	serviceConfig.SMSRetry.MaxAge = time.Duration(getEnvInt("SMS_RETRY_MAX_AGE_HOURS", int(serviceConfig.SMSRetry.MaxAge/time.Hour))) * time.Hour
+	serviceConfig.FailureAlertThreshold = getEnvInt("FAILURE_WEBHOOK_THRESHOLD", serviceConfig.FailureAlertThreshold)
+	serviceConfig.OTPStatusCacheTTL = time.Duration(getEnvInt("OTP_STATUS_CACHE_SECONDS", int(serviceConfig.OTPStatusCacheTTL/time.Second))) * time.Second
+	serviceConfig.ProviderHealthTTL = time.Duration(getEnvInt("PROVIDER_HEALTH_CACHE_SECONDS", int(serviceConfig.ProviderHealthTTL/time.Second))) * time.Second
+	serviceConfig.StatusPollInterval = time.Duration(getEnvInt("SMS_STATUS_POLL_INTERVAL_SECONDS", int(serviceConfig.StatusPollInterval/time.Second))) * time.Second
+	serviceConfig.StatusPollMaxAge = time.Duration(getEnvInt("SMS_STATUS_POLL_MAX_AGE_HOURS", int(serviceConfig.StatusPollMaxAge/time.Hour))) * time.Hour
+	serviceConfig.RetentionPeriod = time.Duration(getEnvInt("DATA_RETENTION_DAYS", 0)) * 24 * time.Hour
+	serviceConfig.RetentionPurgeInterval = time.Duration(getEnvInt("DATA_RETENTION_PURGE_INTERVAL_HOURS", int(serviceConfig.RetentionPurgeInterval/time.Hour))) * time.Hour
+	serviceConfig.JobTimeout = time.Duration(getEnvInt("BACKGROUND_JOB_TIMEOUT_SECONDS", int(serviceConfig.JobTimeout/time.Second))) * time.Second
+	if value := os.Getenv("OTP_VERIFY_RATE_LIMIT"); value != "" {
+		if limit, err := sms_service.ParseRateLimit(value); err != nil {
+			log.Printf("Warning: %v, using default", err)
+		} else {
+			serviceConfig.VerifyRateLimit = limit
+		}
+	}
+	if value := os.Getenv("OTP_VERIFY_GLOBAL_RATE_LIMIT"); value != "" {
+		if limit, err := sms_service.ParseRateLimit(value); err != nil {
+			log.Printf("Warning: %v, using default", err)
+		} else {
+			serviceConfig.GlobalVerifyRateLimit = limit
+		}
+	}
+	serviceConfig.VerifyFailureBackoff.Threshold = getEnvInt("OTP_VERIFY_FAILURE_THRESHOLD", serviceConfig.VerifyFailureBackoff.Threshold)
+	serviceConfig.BruteForce.Failures = getEnvInt("OTP_BRUTE_FORCE_FAILURES", serviceConfig.BruteForce.Failures)
+	serviceConfig.BruteForce.Window = time.Duration(getEnvInt("OTP_BRUTE_FORCE_WINDOW_SECONDS", int(serviceConfig.BruteForce.Window/time.Second))) * time.Second
+	serviceConfig.BruteForce.BlockFor = time.Duration(getEnvInt("OTP_BRUTE_FORCE_BLOCK_SECONDS", int(serviceConfig.BruteForce.BlockFor/time.Second))) * time.Second
+	if value := os.Getenv("OTP_VERIFY_PURPOSE_RATE_LIMITS"); value != "" {
+		if limits, err := sms_service.ParsePurposeRateLimits(value); err != nil {
+			log.Printf("Warning: %v, ignoring purpose rate limits", err)
+		} else {
+			serviceConfig.PurposeVerifyRateLimits = limits
+		}
+	}
+
+	// Per-segment prices for cost estimates
+	if rate := os.Getenv("SMS_DEFAULT_SEGMENT_RATE"); rate != "" {
+		if parsed, err := strconv.ParseFloat(rate, 64); err != nil || parsed < 0 {
+			log.Printf("Warning: invalid value %q for SMS_DEFAULT_SEGMENT_RATE, using default %g", rate, serviceConfig.DefaultSegmentRate)
+		} else {
+			serviceConfig.DefaultSegmentRate = parsed
+		}
+	}
+	if rates := os.Getenv("SMS_SEGMENT_RATES"); rates != "" {
+		if parsed, err := sms_service.ParseSegmentRates(rates); err != nil {
+			log.Printf("Warning: %v, ignoring per-country segment rates", err)
+		} else {
+			serviceConfig.SegmentRates = parsed
+		}
+	}
+	if currency := os.Getenv("SMS_RATE_CURRENCY"); currency != "" {
+		serviceConfig.RateCurrency = currency
+	}
+
+	// Destination countries for SMS and OTP sends (all allowed when unset). An
+	// invalid list stops startup, since ignoring it would allow every country.
+	if serviceConfig.AllowedCallingCodes, err = sms_service.ParseCallingCodes(os.Getenv("SMS_ALLOWED_COUNTRY_CODES")); err != nil {
+		log.Fatalf("Invalid SMS_ALLOWED_COUNTRY_CODES: %v", err)
+	}
+	if serviceConfig.BlockedCallingCodes, err = sms_service.ParseCallingCodes(os.Getenv("SMS_BLOCKED_COUNTRY_CODES")); err != nil {
+		log.Fatalf("Invalid SMS_BLOCKED_COUNTRY_CODES: %v", err)
+	}
+
+	// Sender IDs sends may ask for; none when unset
+	if serviceConfig.AllowedSenderIDs, err = sms_service.ParseSenderIDs(os.Getenv("SMS_ALLOWED_SENDER_IDS")); err != nil {
+		log.Fatalf("Invalid SMS_ALLOWED_SENDER_IDS: %v", err)
+	}
+
+	// OTP texts to the listed countries come from the registered brand name
+	serviceConfig.OTPSenderName = strings.TrimSpace(os.Getenv("OTP_SENDER_NAME"))
+	if !transport.IsValidSenderID(serviceConfig.OTPSenderName) {
+		log.Fatalf("Invalid OTP_SENDER_NAME %q: expected up to 11 letters and digits or an E.164 number", serviceConfig.OTPSenderName)
+	}
+	if serviceConfig.OTPSenderCallingCodes, err = sms_service.ParseCallingCodes(os.Getenv("OTP_SENDER_COUNTRY_CODES")); err != nil {
+		log.Fatalf("Invalid OTP_SENDER_COUNTRY_CODES: %v", err)
+	}
+	if serviceConfig.OTPSenderName != "" && len(serviceConfig.OTPSenderCallingCodes) == 0 {
+		log.Fatalf("OTP_SENDER_NAME is set without OTP_SENDER_COUNTRY_CODES")
+	}
+
+	// Verification rate limits are counted per replica unless they share Redis
+	serviceConfig.RateLimitFailOpen = getEnvBool("RATE_LIMIT_FAIL_OPEN", false)
+	switch backend := os.Getenv("RATE_LIMIT_BACKEND"); backend {
+	case "", "memory":
+	case "redis":
+		if store := newRedisRateLimitStore(os.Getenv("REDIS_URL")); store != nil {
+			serviceOpts = append(serviceOpts, sms_service.WithRateLimitStore(store))
+		} else {
+			warnRateLimitsInMemory()
+		}
+	default:
+		log.Printf("Warning: unknown RATE_LIMIT_BACKEND %q", backend)
+		warnRateLimitsInMemory()
+	}
+
+	// Localized OTP texts, merged over the built-in catalog
+	if path := os.Getenv("OTP_MESSAGES_FILE"); path != "" {
+		if catalog, err := sms_service.LoadOTPMessageCatalog(path); err != nil {
+			log.Printf("Warning: %v, using the built-in OTP messages", err)
+		} else {
+			serviceConfig.OTPMessages = catalog
+		}
+	}
+
+	// Prohibited content must never go out, so a configured blocklist that
+	// can't be loaded stops the server
+	if path := os.Getenv("SMS_CONTENT_BLOCKLIST_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read SMS_CONTENT_BLOCKLIST_FILE: %v", err)
+		}
+		patterns, err := sms_service.ParseContentBlocklist(string(data))
+		if err != nil {
+			log.Fatalf("Failed to load SMS_CONTENT_BLOCKLIST_FILE: %v", err)
+		}
+		serviceConfig.BlockedContent = patterns
+		log.Printf("Loaded %d content blocklist patterns", len(patterns))
+	}
+
+	// Outbound webhooks for SMS status events
+	webhookDestinations, err := webhook.ParseDestinations(os.Getenv("WEBHOOK_DESTINATIONS"))
+	if err != nil {
+		log.Printf("Warning: %v, webhook forwarding disabled", err)
+	}
+	webhookSender := webhook.NewSender(webhookDestinations)
+
+	// Alerts for sends that failed for good, e.g. to a PagerDuty integration
+	var failureDestinations []webhook.Destination
+	if failureURL := os.Getenv("FAILURE_WEBHOOK_URL"); failureURL != "" {
+		if secret := os.Getenv("FAILURE_WEBHOOK_SECRET"); secret != "" {
+			failureDestinations = append(failureDestinations, webhook.Destination{URL: failureURL, Secret: secret})
+		} else {
+			log.Println("Warning: FAILURE_WEBHOOK_SECRET is not set, send failure alerts disabled")
+		}
+	}
+
+	// Registered tenants named by the token's tenant claim, or by an admin's
+	// X-Tenant-ID header, get their own database on the same MongoDB
+	// deployment. The request's user or admin is recorded as the actor of the
+	// changes it makes in the audit log.
+	smsMiddleware := []gin.HandlerFunc{auth.OptionalMiddleware(jwtSecret), transport.ActorMiddleware()}
+	var webhookMiddleware []gin.HandlerFunc
+	var tenants *mongo.TenantFactory
 	if repo != nil {
-		smsService = sms_service.NewSMSService(repo, smsClient)
-		callbackService = sms_service.NewCallbackService(repo)
+		serviceOpts = append(serviceOpts,
+			sms_service.WithConfig(serviceConfig),
+			sms_service.WithWebhookSender(webhookSender),
+			sms_service.WithFailureAlerts(webhook.NewSender(failureDestinations)),
+		)
+		if getEnvBool("MULTI_TENANT", false) {
+			tenantIDs, err := repository.ParseTenantIDs(os.Getenv("TENANT_IDS"))
+			if err != nil {
+				log.Fatalf("Invalid TENANT_IDS: %v", err)
+			}
+			if len(tenantIDs) == 0 {
+				log.Fatal("MULTI_TENANT requires the registered tenants in TENANT_IDS")
+			}
+			tenants = mongo.NewTenantFactory(repo, "sms_app", tenantIDs...)
+			serviceOpts = append(serviceOpts, sms_service.WithTenantRepositories(tenants))
+			smsMiddleware = append(smsMiddleware, transport.TenantMiddleware(tenants))
+		}
+		smsServiceImpl = sms_service.NewSMSService(repo, smsClient, serviceOpts...)
+		smsService = smsServiceImpl
+		var callbackOpts []sms_service.CallbackOption
+		if voiceClient != nil {
+			callbackOpts = append(callbackOpts, sms_service.WithVoiceClient(voiceClient))
+		}
+		callbackServiceImpl = sms_service.NewCallbackService(repo, callbackOpts...)
+		callbackService = callbackServiceImpl
 		logsService = sms_service.NewLogsService(repo)
+		userServiceImpl := sms_service.NewUserService(repo, sms_service.WithPhoneVerifier(smsServiceImpl))
+		userService = userServiceImpl
+		// Tokens issued before a user's sessions were revoked, e.g. by a phone
+		// change, are rejected; this runs after the tenant is picked
+		smsMiddleware = append(smsMiddleware, auth.RevocationMiddleware(func(ctx context.Context, claims *auth.Claims) bool {
+			return userServiceImpl.SessionRevoked(ctx, claims.UserID, claims.IssuedAt)
+		}))
 	} else {
 		log.Println("Warning: Repository not available, SMS service disabled")
 	}
 	
-	// Create a combined service for the HTTP handler
-	combinedService := struct {
-		sms_service.SMSService
-		sms_service.CallbackService
-		sms_service.LogsService
-	}{
-		smsService,
-		callbackService,
-		logsService,
+	// Create a combined service for the HTTP handler.
+	// Without a repository the handler gets a nil service and answers with 503.
+	var handlerService interface{}
+	if smsService != nil {
+		handlerService = struct {
+			sms_service.SMSService
+			sms_service.CallbackService
+			sms_service.LogsService
+			sms_service.UserService
+		}{
+			smsService,
+			callbackService,
+			logsService,
+			userService,
+		}
 	}
 	
-	smsHandler := transport.NewHTTPHandler(combinedService)
+	// Without a repository the service stays down until a restart; a repository
+	// still waiting for its server recovers on its own
+	unavailableReason := "the database is not reachable, check MONGODB_URI and restart the server"
+	databaseConnected := func() bool { return true }
+	if repo != nil {
+		unavailableReason = "the database is not reachable yet, reconnecting in the background"
+		databaseConnected = repo.Connected
+	}
 
-	// Health check
+	handlerOpts := []transport.HandlerOption{
+		transport.WithListLimits(getEnvInt("LIST_DEFAULT_LIMIT", 0), getEnvInt("LIST_MAX_LIMIT", 0)),
+		transport.WithAdminMiddleware(auth.Middleware(jwtSecret), auth.RequireRole(auth.RoleAdmin)),
+		transport.WithUnavailableReason(unavailableReason),
+		transport.WithAvailabilityCheck(databaseConnected),
+		transport.WithVoiceSettings(transport.VoiceSettings{
+			Language:    os.Getenv("VOICE_LANGUAGE"),
+			Voice:       os.Getenv("VOICE_NAME"),
+			RedirectURL: os.Getenv("VOICE_ANSWER_REDIRECT_URL"),
+		}),
+	}
+	// Numbers entered without a country code are read as local to this region
+	if region := os.Getenv("DEFAULT_PHONE_REGION"); region != "" {
+		if common.ValidPhoneRegion(region) {
+			handlerOpts = append(handlerOpts, transport.WithDefaultPhoneRegion(region))
+		} else {
+			log.Printf("Warning: unsupported DEFAULT_PHONE_REGION %q, numbers without a country code will be rejected", region)
+		}
+	}
+	// Plivo webhooks must carry a valid X-Plivo-Signature-V3, otherwise anyone
+	// could post fake inbound messages or delivery reports
+	if getEnvBool("PLIVO_VERIFY_SIGNATURES", true) {
+		if plivoAuthToken == "" {
+			log.Println("Warning: PLIVO_AUTH_TOKEN not configured, Plivo webhooks will be rejected")
+		}
+		webhookMiddleware = append(webhookMiddleware,
+			transport.PlivoSignatureMiddleware(plivoAuthToken, os.Getenv("PLIVO_WEBHOOK_BASE_URL")),
+		)
+	} else {
+		log.Println("Warning: PLIVO_VERIFY_SIGNATURES=false, Plivo webhooks are accepted without a signature")
+	}
+	// Webhooks reach a tenant through the tenant query parameter of the URL
+	// configured with the provider, e.g. /api/sms/delivery-report?tenant=acme
+	if tenants != nil {
+		webhookMiddleware = append(webhookMiddleware, transport.WebhookTenantMiddleware(tenants))
+	}
+	handlerOpts = append(handlerOpts, transport.WithWebhookMiddleware(webhookMiddleware...))
+	smsHandler := transport.NewHTTPHandler(handlerService, handlerOpts...)
+
+	// Health check; SMS routes stay registered but answer 503 while degraded
 	r.GET("/health", func(c *gin.Context) {
+		status, sms := "ok", "ok"
+		if handlerService == nil || !databaseConnected() {
+			status, sms = "degraded", "unavailable"
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"status":  "ok",
+			"status":  status,
 			"service": "sms-backend",
+			"sms":     sms,
 		})
 	})
 
@@ -160,7 +471,6 @@ func main() {
 		{
 			users.POST("/register", registerUser)
 			users.POST("/login", loginUser)
-			users.GET("/profile", authMiddleware(), getUserProfile)
 		}
 
 		// AI Service integration
@@ -170,12 +480,13 @@ func main() {
 			ai.POST("/summarize", summarizeMessages)
 		}
 
-		// SMS Service endpoints
-		if smsService != nil {
-			smsHandler.RegisterRoutes(api)
-		}
+		// SMS Service endpoints (authentication is optional and attributes sends to the user)
+		smsHandler.RegisterRoutes(api.Group("", smsMiddleware...))
 	}
 
+	// Prometheus metrics
+	r.GET("/metrics", metrics.Handler(requestLatency))
+
 	// Swagger documentation
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -185,10 +496,103 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
 	}
+
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// Wait for an interrupt, then shut down gracefully: stop accepting requests,
+	// flush pending async work and finally close MongoDB
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownTimeout := time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second
+	log.Printf("Shutting down (timeout %v)", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	if smsServiceImpl != nil {
+		smsServiceImpl.Shutdown(shutdownCtx)
+		callbackServiceImpl.Shutdown(shutdownCtx)
+	}
+	if repo != nil {
+		if err := repo.Close(); err != nil {
+			log.Printf("Failed to close MongoDB connection: %v", err)
+		}
+	}
+	log.Println("Server stopped")
+}
+
+// newRedisRateLimitStore connects to the Redis server at url, returning nil
+// and logging why when it is not configured or can't be reached
+func newRedisRateLimitStore(url string) *sms_service.RedisRateLimitStore {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		log.Printf("Warning: invalid REDIS_URL: %v", err)
+		return nil
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.Printf("Warning: Redis not reachable: %v", err)
+		client.Close()
+		return nil
+	}
+	log.Println("Counting rate limits in Redis")
+	return sms_service.NewRedisRateLimitStore(client, "sms_app:ratelimit:")
+}
+
+// warnRateLimitsInMemory warns that Redis was asked for but rate limits fall
+// back to per-replica counters, which multiply the limits by the replica count
+func warnRateLimitsInMemory() {
+	log.Println("Warning: ==================================================================")
+	log.Println("Warning: RATE LIMITS ARE COUNTED IN MEMORY, NOT IN REDIS.")
+	log.Println("Warning: Every replica counts on its own, so N replicas allow N times the")
+	log.Println("Warning: configured OTP verification limits. Fix RATE_LIMIT_BACKEND/REDIS_URL.")
+	log.Println("Warning: ==================================================================")
+}
+
+// getEnvInt reads an integer environment variable, falling back to def when unset or invalid
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using default %d", value, key, def)
+		return def
+	}
+	return parsed
+}
+
+// getEnvBool reads a boolean environment variable, falling back to def when unset or invalid
+func getEnvBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using default %t", value, key, def)
+		return def
+	}
+	return parsed
 }
 
 // Message handlers
@@ -286,14 +690,6 @@ func loginUser(c *gin.Context) {
 	})
 }
 
-func getUserProfile(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"id":    "user_123",
-		"email": "user@example.com",
-		"name":  "John Doe",
-	})
-}
-
 // AI Service handlers
 func analyzeMessage(c *gin.Context) {
 	var request struct {
@@ -333,19 +729,4 @@ func summarizeMessages(c *gin.Context) {
 			"Key point 2",
 		},
 	})
-}
-
-// Middleware
-func authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		token := c.GetHeader("Authorization")
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
-			return
-		}
-
-		// TODO: Implement JWT validation
-		c.Next()
-	}
 } 
\ No newline at end of file
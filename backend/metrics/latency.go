@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the request
+// latency histogram
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestDurationMetric is the name of the exported latency histogram
+const requestDurationMetric = "http_request_duration_seconds"
+
+// RequestLatency records request durations per method, route and status code
+// as a Prometheus histogram in its own registry
+type RequestLatency struct {
+	registry  *prometheus.Registry
+	durations *prometheus.HistogramVec
+}
+
+// NewRequestLatency creates an empty latency recorder. Nil buckets use
+// DefaultLatencyBuckets.
+func NewRequestLatency(buckets []float64) *RequestLatency {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    requestDurationMetric,
+		Help:    "Duration of HTTP requests by route and status code.",
+		Buckets: sorted,
+	}, []string{"method", "route", "status"})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(durations)
+	return &RequestLatency{registry: registry, durations: durations}
+}
+
+// Observe records the duration of one request
+func (l *RequestLatency) Observe(method, route string, status int, d time.Duration) {
+	l.durations.WithLabelValues(method, route, strconv.Itoa(status)).Observe(d.Seconds())
+}
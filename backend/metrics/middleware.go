@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ServerTimingHeader carries the handler duration to the client
+const ServerTimingHeader = "Server-Timing"
+
+// unmatchedRoute labels requests that matched no route, so unknown paths
+// don't each create a series
+const unmatchedRoute = "unmatched"
+
+// LatencyMiddleware measures every request, records it in latency labeled by
+// route template and status code, logs requests taking at least logThreshold
+// (0 disables logging) and adds a Server-Timing header with the time spent
+// until the response headers were sent.
+func LatencyMiddleware(latency *RequestLatency, logThreshold time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		writer := &timingWriter{ResponseWriter: c.Writer, start: start}
+		c.Writer = writer
+
+		c.Next()
+
+		// Responses without a body only send their headers after the middleware returns
+		if !writer.Written() {
+			writer.setTiming()
+		}
+
+		duration := time.Since(start)
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := writer.Status()
+		latency.Observe(c.Request.Method, route, status, duration)
+		if logThreshold > 0 && duration >= logThreshold {
+			log.Printf("%s %s -> %d in %v", c.Request.Method, route, status, duration)
+		}
+	}
+}
+
+// Handler serves the recorded latencies in the Prometheus exposition format
+func Handler(latency *RequestLatency) gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(latency.registry, promhttp.HandlerOpts{}))
+}
+
+// timingWriter adds the Server-Timing header just before the response
+// headers are written
+type timingWriter struct {
+	gin.ResponseWriter
+	start time.Time
+	timed bool
+}
+
+func (w *timingWriter) setTiming() {
+	if w.timed {
+		return
+	}
+	w.timed = true
+	elapsed := float64(time.Since(w.start).Microseconds()) / 1000
+	w.Header().Set(ServerTimingHeader, fmt.Sprintf("app;dur=%.3f", elapsed))
+}
+
+func (w *timingWriter) WriteHeaderNow() {
+	w.setTiming()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timingWriter) Write(data []byte) (int, error) {
+	w.setTiming()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timingWriter) WriteString(s string) (int, error) {
+	w.setTiming()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *timingWriter) Flush() {
+	w.setTiming()
+	w.ResponseWriter.Flush()
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLatencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	latency := NewRequestLatency([]float64{0.1, 1})
+
+	r := gin.New()
+	r.Use(LatencyMiddleware(latency, time.Hour))
+	r.GET("/items/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+	})
+	r.DELETE("/items/:id", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/metrics", Handler(latency))
+
+	requests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/items/1", http.StatusOK},
+		{http.MethodGet, "/items/2", http.StatusOK},
+		{http.MethodDelete, "/items/1", http.StatusNoContent},
+		{http.MethodGet, "/missing", http.StatusNotFound},
+	}
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(req.method, req.path, nil))
+		if w.Code != req.want {
+			t.Errorf("%s %s: expected %d, got %d", req.method, req.path, req.want, w.Code)
+		}
+		if timing := w.Header().Get(ServerTimingHeader); !strings.HasPrefix(timing, "app;dur=") {
+			t.Errorf("%s %s: expected a Server-Timing header, got %q", req.method, req.path, timing)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE http_request_duration_seconds histogram",
+		`http_request_duration_seconds_bucket{method="GET",route="/items/:id",status="200",le="+Inf"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/items/:id",status="200"} 2`,
+		`http_request_duration_seconds_count{method="DELETE",route="/items/:id",status="204"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestRequestLatencyBuckets(t *testing.T) {
+	latency := NewRequestLatency([]float64{1, 0.1})
+	latency.Observe(http.MethodGet, "/", http.StatusOK, 50*time.Millisecond)
+	latency.Observe(http.MethodGet, "/", http.StatusOK, 500*time.Millisecond)
+	latency.Observe(http.MethodGet, "/", http.StatusOK, 5*time.Second)
+
+	r := gin.New()
+	r.GET("/metrics", Handler(latency))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`le="0.1"} 1`,
+		`le="1"} 2`,
+		`le="+Inf"} 3`,
+		`_sum{method="GET",route="/",status="200"} 5.55`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected cumulative buckets to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestLatencyMiddlewareLogThreshold(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	for _, threshold := range []time.Duration{0, time.Hour} {
+		r := gin.New()
+		r.Use(LatencyMiddleware(NewRequestLatency(nil), threshold))
+		r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if logged.Len() != 0 {
+		t.Errorf("Expected no request to be logged, got %q", logged.String())
+	}
+
+	r := gin.New()
+	r.Use(LatencyMiddleware(NewRequestLatency(nil), time.Nanosecond))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(logged.String(), "GET / -> 200") {
+		t.Errorf("Expected the request to be logged, got %q", logged.String())
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,35 +13,172 @@ type User struct {
 	Phone     string            `bson:"phone" json:"phone"`
 	Email     string            `bson:"email,omitempty" json:"email,omitempty"`
 	Name      string            `bson:"name,omitempty" json:"name,omitempty"`
+	MonthlySMSQuota int         `bson:"monthly_sms_quota,omitempty" json:"monthly_sms_quota,omitempty"`
+	// PreviousPhones lists the numbers the user changed away from, so their
+	// records are erased with the account
+	PreviousPhones []string     `bson:"previous_phones,omitempty" json:"-"`
+	// SessionsRevokedAt rejects the tokens issued to the user before it, e.g.
+	// after a phone number change
+	SessionsRevokedAt time.Time  `bson:"sessions_revoked_at,omitempty" json:"-"`
 	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time         `bson:"updated_at" json:"updated_at"`
 }
 
+// UserProfile is the part of a user account that is safe to show, e.g. to support staff
+type UserProfile struct {
+	ID        string    `json:"id"`
+	Phone     string    `json:"phone"`
+	Email     string    `json:"email,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserProfileUpdate changes the editable fields of the current user's profile;
+// fields left out keep their value. The phone number can't be changed this way.
+type UserProfileUpdate struct {
+	Name  *string `json:"name,omitempty" binding:"omitempty,max=100"`
+	Email *string `json:"email,omitempty" binding:"omitempty,email"`
+	Phone *string `json:"phone,omitempty" swaggerignore:"true"`
+}
+
+// PhoneChangeRequest starts or confirms moving the current user to a new phone number
+type PhoneChangeRequest struct {
+	// @Description New phone number in international format (e.g., +1234567890)
+	PhoneNumber string `json:"phone_number" binding:"required" example:"+1234567890"`
+	// @Description OTP sent to the new number; required to confirm the change
+	OTP         string `json:"otp,omitempty" example:"123456"`
+}
+
+// AccountDeletionRequest confirms the deletion of the current user's account
+type AccountDeletionRequest struct {
+	// @Description OTP sent to the user's phone number with purpose delete_account
+	OTP string `json:"otp" binding:"required" example:"123456"`
+}
+
+// AccountDeletionSummary describes the records removed with a user account
+type AccountDeletionSummary struct {
+	UserID      string    `json:"user_id"`
+	OTPsDeleted int64     `json:"otps_deleted"`
+	SMSDeleted  int64     `json:"sms_deleted"`
+	CallbacksDeleted    int64 `json:"callbacks_deleted"`
+	AuditRecordsDeleted int64 `json:"audit_records_deleted"`
+	DeletedAt   time.Time `json:"deleted_at"`
+}
+
+// Profile returns the user's profile
+func (u *User) Profile() *UserProfile {
+	return &UserProfile{
+		ID:        u.ID.Hex(),
+		Phone:     u.Phone,
+		Email:     u.Email,
+		Name:      u.Name,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
 // OTP represents an OTP record
 type OTP struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Phone      string            `bson:"phone" json:"phone"`
+	PhoneLast4 string            `bson:"phone_last4,omitempty" json:"-"`
+	Purpose    string            `bson:"purpose,omitempty" json:"purpose,omitempty"`
+	Channel    string            `bson:"channel,omitempty" json:"channel,omitempty"`
+	// Channels lists every channel the code was sent over, in order, e.g. sms then voice
+	Channels   []string          `bson:"channels,omitempty" json:"channels,omitempty"`
+	// Language of the OTP text, reused when the code is resent
+	Language   string            `bson:"language,omitempty" json:"language,omitempty"`
 	Code       string            `bson:"code" json:"code"`
 	ExpiresAt  time.Time         `bson:"expires_at" json:"expires_at"`
 	Attempts   int               `bson:"attempts" json:"attempts"`
 	MaxAttempts int              `bson:"max_attempts" json:"max_attempts"`
+	// LockedUntil blocks verification and new OTPs for the phone number after MaxAttempts wrong codes
+	LockedUntil time.Time        `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+	// Verified marks an OTP that was used successfully; it is kept until ExpiresAt
+	// so a retried verification with the same code from the same client succeeds again
+	Verified   bool              `bson:"verified,omitempty" json:"verified,omitempty"`
+	VerifiedAt time.Time         `bson:"verified_at,omitempty" json:"verified_at,omitempty"`
+	// VerifiedBy is a hash of the ClientID that verified the OTP
+	VerifiedBy string            `bson:"verified_by,omitempty" json:"-"`
+	// ResendCount is how many times the same code was sent again, LastSentAt when it last went out
+	ResendCount int              `bson:"resend_count" json:"resend_count"`
+	LastSentAt time.Time         `bson:"last_sent_at,omitempty" json:"last_sent_at,omitempty"`
 	CreatedAt  time.Time         `bson:"created_at" json:"created_at"`
 	UpdatedAt  time.Time         `bson:"updated_at" json:"updated_at"`
 }
 
+// OTPSendCounter tracks how many OTPs were sent to a phone number on a given UTC day
+type OTPSendCounter struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Phone     string             `bson:"phone" json:"phone"`
+	Day       string             `bson:"day" json:"day"`
+	Count     int                `bson:"count" json:"count"`
+	Verified  int                `bson:"verified" json:"verified"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// SMSQuotaCounter tracks how many SMS a quota owner, a user ID or the
+// anonymous senders, sent in a UTC month ("2006-01")
+type SMSQuotaCounter struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Owner     string             `bson:"owner" json:"owner"`
+	Month     string             `bson:"month" json:"month"`
+	Count     int                `bson:"count" json:"count"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Suppression records a phone number that opted out of receiving messages
+type Suppression struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Phone     string             `bson:"phone" json:"phone"`
+	Reason    string             `bson:"reason" json:"reason"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// FlaggedPhone records a phone number flagged for a suspected brute-force
+// attack on its OTPs, kept for review. Verification for the number is blocked
+// until BlockedUntil.
+type FlaggedPhone struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Phone         string             `bson:"phone" json:"phone"`
+	// Failures is how many wrong codes were submitted within WindowSeconds, across OTPs
+	Failures      int                `bson:"failures" json:"failures"`
+	WindowSeconds int                `bson:"window_seconds" json:"window_seconds"`
+	FlaggedAt     time.Time          `bson:"flagged_at" json:"flagged_at"`
+	BlockedUntil  time.Time          `bson:"blocked_until" json:"blocked_until"`
+}
+
 // SMS represents an SMS message record
 type SMS struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      string            `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Direction   string            `bson:"direction" json:"direction"`
 	From        string            `bson:"from" json:"from"`
+	SenderID    string            `bson:"sender_id,omitempty" json:"sender_id,omitempty"`
 	To          string            `bson:"to" json:"to"`
+	ToLast4     string            `bson:"to_last4,omitempty" json:"-"`
 	Message     string            `bson:"message" json:"message"`
-	Status      string            `bson:"status" json:"status"`
+	Segments    int               `bson:"segments" json:"segments"`
+	Encoding    string            `bson:"encoding,omitempty" json:"encoding,omitempty"`
+	Status      Status            `bson:"status" json:"status"`
+	FailedReason string           `bson:"failed_reason,omitempty" json:"failed_reason,omitempty"`
+	RetryCount  int               `bson:"retry_count" json:"retry_count"`
 	Provider    string            `bson:"provider" json:"provider"`
 	ProviderID  string            `bson:"provider_id,omitempty" json:"provider_id,omitempty"`
+	Metadata    map[string]string `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	ValiditySeconds int           `bson:"validity_seconds,omitempty" json:"validity_seconds,omitempty"`
+	// MediaURLs are the attachments of a message sent as MMS
+	MediaURLs   []string          `bson:"media_urls,omitempty" json:"media_urls,omitempty"`
 	SentAt      time.Time         `bson:"sent_at" json:"sent_at"`
 	DeliveredAt *time.Time        `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
 	CreatedAt   time.Time         `bson:"created_at" json:"created_at"`
 	UpdatedAt   time.Time         `bson:"updated_at" json:"updated_at"`
+	// DeletedAt is set once the message is soft-deleted; it stays stored for
+	// the audit history but is left out of lookups and listings
+	DeletedAt   *time.Time        `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
 }
 
 // SMSRequest represents the request structure for sending SMS
@@ -48,8 +186,22 @@ type SMS struct {
 type SMSRequest struct {
 	// @Description Phone number in international format (e.g., +1234567890)
 	PhoneNumber string `json:"phone_number" binding:"required" example:"+1234567890"`
-	// @Description SMS message content (1-160 characters)
+	// @Description SMS message content (GSM-7 or Unicode, split into multiple segments when long)
 	Message     string `json:"message" binding:"required" example:"Hello World"`
+	// @Description Optional sender: an alphanumeric ID of up to 11 characters or an E.164 number; defaults to the configured number
+	SenderID    string `json:"sender_id,omitempty" example:"ACMEBANK"`
+	// @Description Optional SMS provider to route through (e.g., plivo); defaults to the configured provider
+	Provider    string `json:"provider,omitempty" example:"plivo"`
+	// @Description Optional key-value tags (e.g., campaign, tenant) stored with the message for filtering
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// @Description Optional validity period in seconds (5 to 10800); the provider drops the message if it can't be delivered in time
+	ValiditySeconds int `json:"validity_seconds,omitempty" example:"600"`
+	// @Description Optional HTTPS URLs of images or other media (up to 10); the message is then sent as MMS
+	MediaURLs   []string `json:"media_urls,omitempty" example:"https://example.com/promo.jpg"`
+	// @Description Optional: return the earlier message instead of sending again when the same text and media went to the same number within the configured dedup window
+	Dedup       bool `json:"dedup,omitempty" example:"true"`
+	// UserID is the authenticated sender, taken from the JWT claims
+	UserID      string `json:"-"`
 }
 
 // OTPRequest represents the request structure for sending OTP
@@ -57,12 +209,27 @@ type SMSRequest struct {
 type OTPRequest struct {
 	// @Description Phone number in international format (e.g., +1234567890)
 	PhoneNumber string `json:"phone_number" binding:"required" example:"+1234567890"`
+	// @Description What the OTP is used for (e.g., login, payment); defaults to "default"
+	Purpose     string `json:"purpose,omitempty" example:"login"`
+	// @Description Delivery channel: "sms" (default) or "whatsapp"
+	Channel     string `json:"channel,omitempty" binding:"omitempty,oneof=sms whatsapp" example:"sms"`
+	// @Description Optional SMS provider to route through (e.g., plivo); defaults to the configured provider
+	Provider    string `json:"provider,omitempty" example:"plivo"`
+	// @Description Language of the OTP text (e.g., es, pt-BR); unknown languages get English
+	Language    string `json:"language,omitempty" binding:"omitempty,max=16" example:"es"`
 }
 
 // OTPResponse represents the response structure for OTP operations
 type OTPResponse struct {
 	Success   bool      `json:"success"`
 	Message  string    `json:"message"`
+	// PhoneNumber is the normalized E.164 number the OTP was sent to
+	PhoneNumber string `json:"phone_number,omitempty"`
+	// NormalizedPhone is the E.164 form of the requested number, as stored
+	NormalizedPhone string `json:"normalized_phone"`
+	// Channel is the channel the code went out on
+	Channel  string    `json:"channel,omitempty"`
+	// OTP is only set when the service is configured to expose codes (development and tests)
 	OTP      string    `json:"otp,omitempty"`
 	ExpiresAt time.Time `json:"expires_at,omitempty"`
 }
@@ -74,36 +241,207 @@ type VerifyOTPRequest struct {
 	PhoneNumber string `json:"phone_number" binding:"required" example:"+1234567890"`
 	// @Description 6-digit OTP code
 	OTP         string `json:"otp" binding:"required" example:"123456"`
+	// @Description What the OTP is used for (e.g., login, payment); defaults to "default"
+	Purpose     string `json:"purpose,omitempty" example:"login"`
+	// ClientID identifies the calling client, taken from its IP address and user agent
+	ClientID    string `json:"-"`
+}
+
+// RevokeOTPResponse represents the response structure for revoking an active OTP
+type RevokeOTPResponse struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	PhoneNumber string `json:"phone_number"`
+	Deleted     bool   `json:"deleted"`
+}
+
+// DeleteSMSResponse represents the response structure for deleting an SMS message
+type DeleteSMSResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	ID      string `json:"id"`
+}
+
+// ResetOTPAttemptsResponse represents the response structure for resetting OTP attempts
+type ResetOTPAttemptsResponse struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	PhoneNumber string `json:"phone_number"`
 }
 
 // VerifyOTPResponse represents the response structure for OTP verification
 type VerifyOTPResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	// PhoneNumber is the normalized E.164 number that was verified
+	PhoneNumber string `json:"phone_number,omitempty"`
 	Valid   bool   `json:"valid"`
+	// RemainingAttempts is how many more wrong codes the OTP accepts before
+	// lockout, set on failed verifications of an existing OTP
+	RemainingAttempts *int `json:"remaining_attempts,omitempty"`
+	// Code is ErrCodeMaxAttempts once no attempts remain
+	Code int `json:"code,omitempty"`
 }
 
 // SMSResponse represents the response structure for SMS operations
 type SMSResponse struct {
 	Success   bool      `json:"success"`
 	Message  string    `json:"message"`
+	// Code is the error code when the message was stored but could not be sent
+	Code     int       `json:"code,omitempty"`
 	ID       string    `json:"id,omitempty"`
+	// PhoneNumber is the normalized E.164 recipient
+	PhoneNumber string `json:"phone_number,omitempty"`
+	// NormalizedPhone is the E.164 form of the requested recipient, as stored
+	NormalizedPhone string `json:"normalized_phone"`
+	Status   Status    `json:"status,omitempty"`
+	Segments int       `json:"segments,omitempty"`
+	Encoding string    `json:"encoding,omitempty"`
+	// Duplicate marks a deduplicated send: the earlier message is returned and nothing was sent
+	Duplicate bool     `json:"duplicate,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// BatchStatusRequest represents a request for the status of several SMS messages
+// @Description Request structure for checking the status of several SMS messages
+type BatchStatusRequest struct {
+	// @Description SMS message IDs to look up
+	IDs []string `json:"ids" binding:"required,min=1" example:"665f1c2e8b3e4a0012345678"`
+}
+
+// MessageStatus represents the delivery status of a single SMS message
+type MessageStatus struct {
+	Status      Status     `json:"status"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// BatchStatusResponse maps SMS message IDs to their delivery status
+type BatchStatusResponse struct {
+	Statuses map[string]MessageStatus `json:"statuses"`
+	// NotFound lists requested IDs with no stored message
+	NotFound []string `json:"not_found,omitempty"`
+}
+
+// SMSEstimateRequest represents a request to estimate the cost of a bulk send
+// @Description Request structure for estimating the cost of sending a message to several recipients
+type SMSEstimateRequest struct {
+	// @Description Recipient phone numbers in E.164 format
+	Recipients []string `json:"recipients" binding:"required,min=1" example:"+1234567890"`
+	// @Description Message content
+	Message string `json:"message" binding:"required" example:"Our spring sale starts tomorrow!"`
+}
+
+// CountryEstimate is the estimated cost of the recipients sharing a country calling code
+type CountryEstimate struct {
+	CallingCode string  `json:"calling_code"`
+	Recipients  int     `json:"recipients"`
+	Segments    int     `json:"segments"`
+	Rate        float64 `json:"rate_per_segment"`
+	Cost        float64 `json:"cost"`
+}
+
+// SMSEstimateResponse is the estimated cost of a bulk send
+type SMSEstimateResponse struct {
+	Recipients         int               `json:"recipients"`
+	Encoding           string            `json:"encoding"`
+	SegmentsPerMessage int               `json:"segments_per_message"`
+	TotalSegments      int               `json:"total_segments"`
+	TotalCost          float64           `json:"total_cost"`
+	Currency           string            `json:"currency"`
+	Countries          []CountryEstimate `json:"countries"`
+}
+
+// SMSQuota represents a user's monthly SMS quota usage
+type SMSQuota struct {
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
 // OTPStatus represents the status of an OTP
 type OTPStatus struct {
 	PhoneNumber string    `json:"phone_number"`
 	HasActiveOTP bool     `json:"has_active_otp"`
 	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
 	Attempts    int       `json:"attempts"`
+	DailySendCount int    `json:"daily_send_count"`
+	DailySendLimit int    `json:"daily_send_limit"`
+}
+
+// ProviderHealth reports whether an SMS provider is reachable with the configured credentials
+type ProviderHealth struct {
+	Provider  string    `json:"provider"`
+	Healthy   bool      `json:"healthy"`
+	// Balance is the remaining account credit, when the provider reports one
+	Balance   *float64  `json:"balance,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Circuit is the state of the provider's circuit breaker
+	Circuit   string    `json:"circuit,omitempty"`
+}
+
+// SMSStats represents aggregate SMS service statistics
+type SMSStats struct {
+	// Phone is the number the message counts are restricted to, if any
+	Phone        string         `json:"phone,omitempty"`
+	// Total is the number of messages counted in StatusCounts
+	Total        int            `json:"total"`
+	// StatusCounts counts messages per status; pending, sent, delivered and failed are always present
+	StatusCounts map[Status]int `json:"status_counts"`
+	// TimeToVerify measures how long users take from OTP send to successful verification
+	TimeToVerify DurationStats `json:"time_to_verify"`
+}
+
+// DurationStats summarizes a distribution of durations
+type DurationStats struct {
+	Count          int              `json:"count"`
+	AverageSeconds float64          `json:"average_seconds"`
+	Distribution   []DurationBucket `json:"distribution"`
+}
+
+// DurationBucket counts observations less than or equal to LE (cumulative)
+type DurationBucket struct {
+	LE    string `json:"le"`
+	Count int    `json:"count"`
+}
+
+// OptOutRequest represents the request structure for opting a phone number out of or back into messages
+type OptOutRequest struct {
+	// @Description Phone number in international format (e.g., +1234567890)
+	PhoneNumber string `json:"phone_number" binding:"required" example:"+1234567890"`
+}
+
+// OptOutResponse represents the response structure for opt-out and opt-in requests
+type OptOutResponse struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	PhoneNumber string `json:"phone_number"`
+	OptedOut    bool   `json:"opted_out"`
+}
+
+// InboundMessage represents an incoming (MO) message posted by Plivo
+type InboundMessage struct {
+	From        string `form:"From" json:"From" binding:"required"`
+	To          string `form:"To" json:"To"`
+	Text        string `form:"Text" json:"Text"`
+	MessageUUID string `form:"MessageUUID" json:"MessageUUID"`
+}
+
+// DeliveryReport is a message status posted by a provider's delivery report webhook
+type DeliveryReport struct {
+	MessageUUID string
+	Status      Status
+	// ErrorCode is the provider's reason for a failed delivery, if any
+	ErrorCode   string
 }
 
 // CallbackRequest represents the request structure for requesting a callback
 type CallbackRequest struct {
 	PhoneNumber string `json:"phone_number" binding:"required" example:"+1234567890"`
 	Message     string `json:"message,omitempty" example:"Please call me back"`
-	Priority    string `json:"priority,omitempty" example:"high"`
+	// Priority is low, normal or high, in any case; empty means normal
+	Priority    string `json:"priority,omitempty" example:"high" enums:"low,normal,high"`
 }
 
 // CallbackResponse represents the response structure for callback requests
@@ -111,7 +449,9 @@ type CallbackResponse struct {
 	Success   bool      `json:"success"`
 	Message   string    `json:"message"`
 	RequestID string    `json:"request_id"`
-	Status    string    `json:"status"`
+	// PhoneNumber is the normalized E.164 number that will be called back
+	PhoneNumber string  `json:"phone_number,omitempty"`
+	Status    Status    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
@@ -119,14 +459,244 @@ type CallbackResponse struct {
 type Callback struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	PhoneNumber string            `bson:"phone_number" json:"phone_number"`
+	PhoneLast4  string            `bson:"phone_last4,omitempty" json:"-"`
 	Message     string            `bson:"message,omitempty" json:"message"`
 	Priority    string            `bson:"priority,omitempty" json:"priority"`
-	Status      string            `bson:"status" json:"status"`
+	// PriorityRank orders the callback queue, see CallbackPriorityRank
+	PriorityRank int              `bson:"priority_rank" json:"-"`
+	Status      Status            `bson:"status" json:"status"`
+	CallUUID    string            `bson:"call_uuid,omitempty" json:"call_uuid,omitempty"`
 	RequestedAt time.Time         `bson:"requested_at" json:"requested_at"`
 	CreatedAt   time.Time         `bson:"created_at" json:"created_at"`
 	UpdatedAt   time.Time         `bson:"updated_at" json:"updated_at"`
 }
 
+// DailyCount is one UTC day of an aggregated collection. Succeeded and Failed
+// count the records that reached a successful or failed final status.
+type DailyCount struct {
+	Day       string `bson:"_id" json:"day"`
+	Total     int    `bson:"total" json:"total"`
+	Succeeded int    `bson:"succeeded" json:"succeeded"`
+	Failed    int    `bson:"failed" json:"failed"`
+}
+
+// DailyAnalytics represents the activity of a single UTC day
+type DailyAnalytics struct {
+	Day                    string  `json:"day"`
+	OTPsSent               int     `json:"otps_sent"`
+	OTPsVerified           int     `json:"otps_verified"`
+	OTPVerifyRate          float64 `json:"otp_verify_rate"`
+	SMSSent                int     `json:"sms_sent"`
+	SMSDelivered           int     `json:"sms_delivered"`
+	SMSFailed              int     `json:"sms_failed"`
+	SMSDeliveryRate        float64 `json:"sms_delivery_rate"`
+	CallbacksRequested     int     `json:"callbacks_requested"`
+	CallbacksCompleted     int     `json:"callbacks_completed"`
+	CallbackCompletionRate float64 `json:"callback_completion_rate"`
+}
+
+// DailyAnalyticsResponse is a daily time series covering From to To inclusive
+type DailyAnalyticsResponse struct {
+	From string           `json:"from"`
+	To   string           `json:"to"`
+	Days []DailyAnalytics `json:"days"`
+}
+
+// PhoneSearchMatch represents a single record matched by a partial phone search
+type PhoneSearchMatch struct {
+	ID        string    `json:"id"`
+	Phone     string    `json:"phone"`
+	Status    Status    `json:"status,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PaginatedResponse is one page of an offset-paginated list
+type PaginatedResponse struct {
+	Data    interface{} `json:"data"`
+	Limit   int         `json:"limit"`
+	Offset  int         `json:"offset"`
+	// Total counts every record of the list, not just this page
+	Total   int64       `json:"total"`
+	HasMore bool        `json:"has_more"`
+}
+
+// NewPaginatedResponse describes returned records read at offset from a list
+// of total records
+func NewPaginatedResponse(data interface{}, returned, limit, offset int, total int64) *PaginatedResponse {
+	return &PaginatedResponse{
+		Data:    data,
+		Limit:   limit,
+		Offset:  offset,
+		Total:   total,
+		HasMore: int64(offset+returned) < total,
+	}
+}
+
+// LogSection is one section of the activity logs: a page of the newest records
+// created before the request's cursor. Total counts the records before the
+// cursor; NextCursor continues after the last record while HasMore is set.
+type LogSection struct {
+	PaginatedResponse
+	Count      int     `json:"count"`
+	NextCursor *string `json:"next_cursor"`
+	// Error is set when the section couldn't be read; the other sections are still returned
+	Error      string  `json:"error,omitempty"`
+}
+
+// LogCursor marks the last record of a logs page, which is sorted newest first
+// and then by descending ID. The next page holds the records older than At, or
+// as old with a smaller ID, so records sharing a timestamp aren't skipped. A
+// zero cursor starts at the newest record, and one without an ID, from older
+// clients, pages by time alone.
+type LogCursor struct {
+	At time.Time
+	ID primitive.ObjectID
+}
+
+// IsZero reports whether the cursor is unset
+func (c LogCursor) IsZero() bool {
+	return c.At.IsZero()
+}
+
+// Precedes reports whether a record with timestamp at and ID id belongs after
+// the cursor; every record does for a zero cursor
+func (c LogCursor) Precedes(at time.Time, id primitive.ObjectID) bool {
+	if c.IsZero() || at.Before(c.At) {
+		return true
+	}
+	return !c.ID.IsZero() && at.Equal(c.At) && id.Hex() < c.ID.Hex()
+}
+
+// String formats the cursor as a next_cursor: the RFC 3339 timestamp and the
+// hex ID joined by an underscore
+func (c LogCursor) String() string {
+	cursor := c.At.UTC().Format(time.RFC3339Nano)
+	if !c.ID.IsZero() {
+		cursor += "_" + c.ID.Hex()
+	}
+	return cursor
+}
+
+// ParseLogCursor reads a cursor formatted by LogCursor.String, or a bare RFC
+// 3339 timestamp
+func ParseLogCursor(cursor string) (LogCursor, error) {
+	at, id, hasID := strings.Cut(cursor, "_")
+	parsed, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return LogCursor{}, err
+	}
+	result := LogCursor{At: parsed}
+	if hasID {
+		if result.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return LogCursor{}, err
+		}
+	}
+	return result, nil
+}
+
+// SMSLogSection is the SMS section of the activity logs
+type SMSLogSection struct {
+	LogSection
+	ByDirection map[string]int `json:"by_direction"`
+	DeadCount   int            `json:"dead_count"`
+}
+
+// Audit records one state change of an OTP, SMS message or callback request.
+// Records are only ever appended, so replaying them in order rebuilds the
+// history of a record. The phone number is stored masked.
+type Audit struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	// Type is the kind of record and the state it moved to, e.g. "sms.delivered"
+	Type       string    `bson:"type" json:"type" example:"sms.delivered"`
+	TargetID   string    `bson:"target_id,omitempty" json:"target_id,omitempty"`
+	Phone      string    `bson:"phone,omitempty" json:"phone,omitempty" example:"+******7890"`
+	// Actor is user, admin or system, with the ID of the user or admin
+	Actor      string    `bson:"actor" json:"actor" example:"system"`
+	ActorID    string    `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	FromStatus string    `bson:"from_status,omitempty" json:"from_status,omitempty" example:"sent"`
+	ToStatus   string    `bson:"to_status" json:"to_status" example:"delivered"`
+	Reason     string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	// Purpose is the purpose of an OTP, e.g. "login"
+	Purpose    string    `bson:"purpose,omitempty" json:"purpose,omitempty" example:"login"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// AuditFilter selects audit records created within [From, To); zero bounds
+// are open-ended. Type matches exactly, or by kind when it has no state, so
+// "sms" matches "sms.sent" and "sms.failed".
+type AuditFilter struct {
+	From time.Time
+	To   time.Time
+	Type string
+}
+
+// Matches reports whether an audit record passes the filter
+func (f AuditFilter) Matches(audit *Audit) bool {
+	if !f.From.IsZero() && audit.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !audit.CreatedAt.Before(f.To) {
+		return false
+	}
+	return f.Type == "" || audit.Type == f.Type || strings.HasPrefix(audit.Type, f.Type+".")
+}
+
+// Kinds of records in the audit log
+const (
+	AuditKindOTP      = "otp"
+	AuditKindSMS      = "sms"
+	AuditKindCallback = "callback"
+)
+
+// OTP states recorded in the audit log. OTPs have no stored status, these
+// describe what happened to them.
+const (
+	OTPStateSent     = "sent"
+	OTPStateVerified = "verified"
+	OTPStateLocked   = "locked"
+	OTPStateRevoked  = "revoked"
+	OTPStateExpired  = "expired"
+	// An OTP sent again, read out in a voice call or given its attempts back
+	// keeps its state; these record that it happened
+	OTPStateResent    = "resent"
+	OTPStateEscalated = "escalated"
+	OTPStateReset     = "reset"
+)
+
+// SMSAuditDeleted is recorded in the audit log when an SMS message is
+// soft-deleted, which leaves its status unchanged
+const SMSAuditDeleted = "deleted"
+
+// PhoneSearchResult represents partial phone search matches grouped by record type
+type PhoneSearchResult struct {
+	Query     string             `json:"query"`
+	Match     string             `json:"match"`
+	OTPs      []PhoneSearchMatch `json:"otps"`
+	SMS       []PhoneSearchMatch `json:"sms"`
+	Callbacks []PhoneSearchMatch `json:"callbacks"`
+	Total     int                `json:"total"`
+	Limit     int                `json:"limit"`
+}
+
+// TimelineEntry is one record of a phone number's activity timeline. Type is
+// a log type and Record the OTP, SMS or callback it refers to.
+type TimelineEntry struct {
+	Type      string      `json:"type" example:"sms"`
+	Timestamp time.Time   `json:"timestamp"`
+	Record    interface{} `json:"record"`
+}
+
+// PhoneTimeline is the activity of one phone number across OTPs, SMS and
+// callbacks, newest first
+type PhoneTimeline struct {
+	Phone   string          `json:"phone"`
+	Entries []TimelineEntry `json:"entries"`
+	Count   int             `json:"count"`
+	Limit   int             `json:"limit"`
+	// HasMore reports that older entries within the range were left out
+	HasMore bool            `json:"has_more"`
+}
+
 // PlivoCredentials represents Plivo API credentials
 type PlivoCredentials struct {
 	AuthID    string `json:"auth_id"`
@@ -141,16 +711,126 @@ type PlivoResponse struct {
 	Error      string   `json:"error"`
 }
 
-// Status constants
+// SMS directions
+const (
+	DirectionOutbound = "outbound"
+	DirectionInbound  = "inbound"
+)
+
+// Suppression reasons
+const (
+	SuppressionReasonKeyword = "keyword"
+	SuppressionReasonRequest = "request"
+)
+
+// Log types accepted by the logs export
+const (
+	LogTypeSMS      = "sms"
+	LogTypeOTP      = "otp"
+	LogTypeCallback = "callback"
+)
+
+// SMSLogFilter narrows the SMS section of the activity logs
+type SMSLogFilter struct {
+	// Direction only returns inbound or outbound messages
+	Direction string
+	// MetadataKey and MetadataValue only return messages tagged with that pair
+	MetadataKey   string
+	MetadataValue string
+	// UserID only returns messages sent by that user
+	UserID string
+	// Before only returns messages after this cursor, when set
+	Before LogCursor
+}
+
+// Phone search match modes
+const (
+	PhoneMatchPrefix = "prefix"
+	PhoneMatchSuffix = "suffix"
+)
+
+// OTP purposes
+const (
+	PurposeDefault = "default"
+	PurposeLogin   = "login"
+	PurposePayment = "payment"
+	// PurposeChangePhone verifies the new number of a user changing phone numbers
+	PurposeChangePhone = "change_phone"
+	// PurposeDeleteAccount confirms a user deleting their account
+	PurposeDeleteAccount = "delete_account"
+)
+
+// IsValidPurpose reports whether an OTP purpose is a short lowercase identifier
+func IsValidPurpose(purpose string) bool {
+	if len(purpose) == 0 || len(purpose) > 32 {
+		return false
+	}
+	for _, char := range purpose {
+		if (char < 'a' || char > 'z') && (char < '0' || char > '9') && char != '_' && char != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// OTP delivery channels
+const (
+	ChannelSMS      = "sms"
+	ChannelWhatsApp = "whatsapp"
+	// ChannelVoice reads the code out in a phone call; it is only used to
+	// escalate an OTP that wasn't verified after being sent by SMS
+	ChannelVoice = "voice"
+)
+
+// Callback priorities, from least to most urgent
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// ParseCallbackPriority normalizes the case of a callback priority, defaulting
+// an empty one to normal. It reports false for unknown priorities.
+func ParseCallbackPriority(priority string) (string, bool) {
+	priority = strings.ToLower(strings.TrimSpace(priority))
+	switch priority {
+	case "":
+		return PriorityNormal, true
+	case PriorityLow, PriorityNormal, PriorityHigh:
+		return priority, true
+	default:
+		return "", false
+	}
+}
+
+// CallbackPriorityRank maps a callback priority to the number the callback
+// queue is sorted by, higher first. Priorities stored before they were
+// validated rank as normal unless they name a known priority in another case.
+func CallbackPriorityRank(priority string) int {
+	switch strings.ToLower(strings.TrimSpace(priority)) {
+	case PriorityLow:
+		return 1
+	case PriorityHigh:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// SMS validity period bounds in seconds, as accepted by the provider
+const (
+	MinSMSValiditySeconds = 5
+	MaxSMSValiditySeconds = 10800
+)
+
+// MaxMediaURLs caps the attachments of an MMS message, as accepted by the providers
+const MaxMediaURLs = 10
+
+// Provider circuit breaker states
 const (
-	StatusPending   = "pending"
-	StatusSent      = "sent"
-	StatusDelivered = "delivered"
-	StatusFailed    = "failed"
-	StatusRequested = "requested"
-	StatusInProgress = "in_progress"
-	StatusCompleted = "completed"
-	StatusCancelled = "cancelled"
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
 )
 
 // Provider constants
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Status is the lifecycle status of an SMS message or callback request
+type Status string
+
+// Status constants
+const (
+	StatusPending    Status = "pending"
+	StatusSent       Status = "sent"
+	StatusDelivered  Status = "delivered"
+	StatusFailed     Status = "failed"
+	StatusRequested  Status = "requested"
+	StatusInProgress Status = "in_progress"
+	StatusCompleted  Status = "completed"
+	StatusCancelled  Status = "cancelled"
+	StatusReceived   Status = "received"
+	StatusDead       Status = "dead"
+	StatusRetrying   Status = "retrying"
+)
+
+var validStatuses = map[Status]bool{
+	StatusPending:    true,
+	StatusSent:       true,
+	StatusDelivered:  true,
+	StatusFailed:     true,
+	StatusRequested:  true,
+	StatusInProgress: true,
+	StatusCompleted:  true,
+	StatusCancelled:  true,
+	StatusReceived:   true,
+	StatusDead:       true,
+	StatusRetrying:   true,
+}
+
+// Valid reports whether s is one of the defined statuses
+func (s Status) Valid() bool {
+	return validStatuses[s]
+}
+
+func (s Status) String() string {
+	return string(s)
+}
+
+// ParseStatus converts a string into a Status, rejecting unknown values
+func ParseStatus(value string) (Status, error) {
+	status := Status(value)
+	if !status.Valid() {
+		return "", fmt.Errorf("invalid status %q", value)
+	}
+	return status, nil
+}
+
+// ErrInvalidTransition is returned when a record can't move from its current
+// status to the requested one
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// Transitions lists the statuses each status may move to. Statuses without an
+// entry are final. Setting the current status again is always allowed.
+type Transitions map[Status][]Status
+
+// SMSTransitions are the legal moves of outbound SMS messages. Failed and
+// dead messages can be resent: a resend claims them as retrying, and moves
+// them to sent, back to failed, or to dead.
+var SMSTransitions = Transitions{
+	StatusPending:  {StatusSent, StatusDelivered, StatusFailed, StatusDead},
+	StatusSent:     {StatusDelivered, StatusFailed, StatusDead},
+	StatusFailed:   {StatusRetrying, StatusSent, StatusDead},
+	StatusDead:     {StatusRetrying, StatusSent, StatusFailed},
+	StatusRetrying: {StatusSent, StatusDelivered, StatusFailed, StatusDead},
+}
+
+// CallbackTransitions are the legal moves of callback requests
+var CallbackTransitions = Transitions{
+	StatusRequested:  {StatusInProgress, StatusCancelled, StatusFailed},
+	StatusInProgress: {StatusCompleted, StatusFailed, StatusCancelled},
+}
+
+// Allowed reports whether a record may move from one status to another
+func (t Transitions) Allowed(from, to Status) bool {
+	if from == to {
+		return true
+	}
+	for _, next := range t[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources returns every status that may move to the given one, itself included
+func (t Transitions) Sources(to Status) []Status {
+	sources := []Status{to}
+	for from, nexts := range t {
+		for _, next := range nexts {
+			if next == to && from != to {
+				sources = append(sources, from)
+			}
+		}
+	}
+	return sources
+}
+
+// Check returns an error wrapping ErrInvalidTransition when the move is not allowed
+func (t Transitions) Check(from, to Status) error {
+	if !t.Allowed(from, to) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, from, to)
+	}
+	return nil
+}
+
+// UnmarshalJSON rejects unknown statuses. An empty string leaves the status unset.
+func (s *Status) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return s.set(value)
+}
+
+// UnmarshalBSONValue rejects unknown statuses. A null or empty string leaves
+// the status unset.
+func (s *Status) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.Null {
+		*s = ""
+		return nil
+	}
+	value, ok := bson.RawValue{Type: t, Value: data}.StringValueOK()
+	if !ok {
+		return fmt.Errorf("status must be a string, got BSON %s", t)
+	}
+	return s.set(value)
+}
+
+func (s *Status) set(value string) error {
+	if value == "" {
+		*s = ""
+		return nil
+	}
+	status, err := ParseStatus(value)
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStatusJSON(t *testing.T) {
+	var msg MessageStatus
+	if err := json.Unmarshal([]byte(`{"status":"delivered"}`), &msg); err != nil || msg.Status != StatusDelivered {
+		t.Errorf("Expected delivered, got %q, %v", msg.Status, err)
+	}
+	if err := json.Unmarshal([]byte(`{"status":"in-progress"}`), &msg); err == nil {
+		t.Error("Expected an unknown status to be rejected")
+	}
+
+	data, _ := json.Marshal(MessageStatus{Status: StatusInProgress})
+	if string(data) != `{"status":"in_progress"}` {
+		t.Errorf("Expected the status to marshal as a plain string, got %s", data)
+	}
+}
+
+func TestStatusBSON(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"status": "cancelled"})
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	var callback Callback
+	if err := bson.Unmarshal(data, &callback); err != nil || callback.Status != StatusCancelled {
+		t.Errorf("Expected cancelled, got %q, %v", callback.Status, err)
+	}
+
+	data, _ = bson.Marshal(bson.M{"status": "canceled"})
+	if err := bson.Unmarshal(data, &callback); err == nil {
+		t.Error("Expected an unknown status to be rejected")
+	}
+
+	data, _ = bson.Marshal(bson.M{"status": 3})
+	if err := bson.Unmarshal(data, &callback); err == nil {
+		t.Error("Expected a non-string status to be rejected")
+	}
+
+	stored, _ := bson.Marshal(SMS{Status: StatusSent})
+	var sms SMS
+	if err := bson.Unmarshal(stored, &sms); err != nil || sms.Status != StatusSent {
+		t.Errorf("Expected the status to round-trip, got %q, %v", sms.Status, err)
+	}
+}
+
+func TestStatusValid(t *testing.T) {
+	if !StatusDead.Valid() || Status("").Valid() || Status("unknown").Valid() {
+		t.Error("Expected only defined statuses to be valid")
+	}
+	if _, err := ParseStatus("requested"); err != nil {
+		t.Errorf("Expected requested to parse, got %v", err)
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		name        string
+		transitions Transitions
+		from, to    Status
+		allowed     bool
+	}{
+		{"callback starts", CallbackTransitions, StatusRequested, StatusInProgress, true},
+		{"callback completes", CallbackTransitions, StatusInProgress, StatusCompleted, true},
+		{"callback fails", CallbackTransitions, StatusInProgress, StatusFailed, true},
+		{"callback cancelled before the call", CallbackTransitions, StatusRequested, StatusCancelled, true},
+		{"callback unchanged", CallbackTransitions, StatusCompleted, StatusCompleted, true},
+		{"completed callback reopened", CallbackTransitions, StatusCompleted, StatusRequested, false},
+		{"callback completes without a call", CallbackTransitions, StatusRequested, StatusCompleted, false},
+		{"cancelled callback resumed", CallbackTransitions, StatusCancelled, StatusInProgress, false},
+		{"sms sent", SMSTransitions, StatusPending, StatusSent, true},
+		{"sms delivered", SMSTransitions, StatusSent, StatusDelivered, true},
+		{"failed sms resent", SMSTransitions, StatusFailed, StatusSent, true},
+		{"dead sms resent", SMSTransitions, StatusDead, StatusSent, true},
+		{"failed sms claimed for retry", SMSTransitions, StatusFailed, StatusRetrying, true},
+		{"retried sms fails again", SMSTransitions, StatusRetrying, StatusFailed, true},
+		{"sent sms claimed for retry", SMSTransitions, StatusSent, StatusRetrying, false},
+		{"delivered sms fails", SMSTransitions, StatusDelivered, StatusFailed, false},
+		{"sent sms back to pending", SMSTransitions, StatusSent, StatusPending, false},
+		{"inbound sms sent", SMSTransitions, StatusReceived, StatusSent, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.transitions.Allowed(tt.from, tt.to); got != tt.allowed {
+				t.Errorf("Expected Allowed(%s, %s) to be %v", tt.from, tt.to, tt.allowed)
+			}
+			err := tt.transitions.Check(tt.from, tt.to)
+			if tt.allowed != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidTransition)) {
+				t.Errorf("Expected Check(%s, %s) to match Allowed, got %v", tt.from, tt.to, err)
+			}
+		})
+	}
+
+	sources := SMSTransitions.Sources(StatusSent)
+	if len(sources) != 5 {
+		t.Errorf("Expected sent to be reachable from itself, pending, failed, dead and retrying, got %v", sources)
+	}
+}
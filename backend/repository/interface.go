@@ -15,27 +15,151 @@ type OTPRepository interface {
 	Delete(ctx context.Context, id string) error
 	DeleteByPhone(ctx context.Context, phone string) error
 	FindExpired(ctx context.Context) ([]*models.OTP, error)
-	IncrementAttempts(ctx context.Context, phone string) error
-	FindAll(ctx context.Context, limit int) ([]*models.OTP, error)
+	// IncrementAttempts atomically counts an attempt for the OTP of phone
+	// unless it already used MaxAttempts. It returns the new attempts and true,
+	// or false, leaving the OTP unchanged, when no attempts were left or phone
+	// has no OTP.
+	IncrementAttempts(ctx context.Context, phone string) (int, bool, error)
+	// ResetAttempts sets the attempts of the OTP of phone back to 0 and lifts
+	// its lockout; ErrNotFound when phone has no OTP
+	ResetAttempts(ctx context.Context, phone string) error
+	// ReserveResend atomically counts a resend of the OTP id and sets its
+	// LastSentAt to now, unless it is verified, was already resent maxResends
+	// times or was last sent (or created, when never resent) after sentBefore.
+	// It returns false, leaving the OTP unchanged, in those cases.
+	ReserveResend(ctx context.Context, id string, maxResends int, sentBefore, now time.Time) (bool, error)
+	// ReleaseResend takes back a resend reserved by ReserveResend that was not
+	// made, restoring lastSentAt
+	ReleaseResend(ctx context.Context, id string, lastSentAt time.Time) error
+	// FindAll finds OTPs, newest first, after the cursor (all when it is zero)
+	FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.OTP, error)
+	// Count counts OTPs after the cursor (all when it is zero)
+	Count(ctx context.Context, before models.LogCursor) (int64, error)
+	Stream(ctx context.Context, from, to time.Time, fn func(*models.OTP) error) error
+	SearchByPhone(ctx context.Context, query string, suffix bool, limit int) ([]*models.OTP, error)
+	// DeleteOlderThan deletes OTPs created before t and returns how many were deleted
+	DeleteOlderThan(ctx context.Context, t time.Time) (int64, error)
 }
 
-// SMSRepository defines the interface for SMS storage operations
+// OTPSendRepository defines the interface for daily OTP send counters
+type OTPSendRepository interface {
+	Increment(ctx context.Context, phone, day string) (int, error)
+	// IncrementBelow atomically increments the counter unless it already
+	// reached limit. It returns the new count and true, or the count and
+	// false, leaving the counter unchanged, when the limit was reached.
+	IncrementBelow(ctx context.Context, phone, day string, limit int) (int, bool, error)
+	// Decrement takes back a send counted by Increment or IncrementBelow
+	Decrement(ctx context.Context, phone, day string) error
+	Count(ctx context.Context, phone, day string) (int, error)
+	// IncrementVerified counts a successful verification for a phone number on a day
+	IncrementVerified(ctx context.Context, phone, day string) error
+	// DailyTotals sums the send counters per day from fromDay to toDay inclusive;
+	// Total is the number sent and Succeeded the number verified
+	DailyTotals(ctx context.Context, fromDay, toDay string) ([]models.DailyCount, error)
+	// DeleteByPhones deletes the counters of phones and returns how many were deleted
+	DeleteByPhones(ctx context.Context, phones []string) (int64, error)
+	// DeleteOlderThan deletes the counters of UTC days before the day of t and
+	// returns how many were deleted
+	DeleteOlderThan(ctx context.Context, t time.Time) (int64, error)
+}
+
+// SMSQuotaRepository defines the interface for monthly SMS quota counters
+type SMSQuotaRepository interface {
+	// Reserve atomically counts a send for owner in month unless it already
+	// reached limit. It returns the new count and true, or the count and false,
+	// leaving the counter unchanged, when the limit was reached.
+	Reserve(ctx context.Context, owner, month string, limit int) (int, bool, error)
+	// Release takes back a send counted by Reserve
+	Release(ctx context.Context, owner, month string) error
+	Count(ctx context.Context, owner, month string) (int, error)
+}
+
+// SuppressionRepository defines the interface for the opt-out suppression list
+type SuppressionRepository interface {
+	Add(ctx context.Context, phone, reason string) error
+	Remove(ctx context.Context, phone string) (bool, error)
+	IsSuppressed(ctx context.Context, phone string) (bool, error)
+}
+
+// FlaggedPhoneRepository defines the interface for phone numbers flagged for brute-force attacks
+type FlaggedPhoneRepository interface {
+	Create(ctx context.Context, flagged *models.FlaggedPhone) error
+	// FindActive finds the flag of phone blocking verification at now
+	FindActive(ctx context.Context, phone string, now time.Time) (*models.FlaggedPhone, error)
+	// List finds flags, newest first, skipping the first offset
+	List(ctx context.Context, offset, limit int) ([]*models.FlaggedPhone, error)
+	Count(ctx context.Context) (int64, error)
+	// DeleteByPhones deletes the flags of phones and returns how many were deleted
+	DeleteByPhones(ctx context.Context, phones []string) (int64, error)
+}
+
+// SMSRepository defines the interface for SMS storage operations. Lookups,
+// listings and streams leave out soft-deleted messages unless the context
+// comes from IncludeDeleted; status updates and counts cover them.
 type SMSRepository interface {
 	Create(ctx context.Context, sms *models.SMS) error
+	// CreateMany stores messages in a single write and sets their IDs; nothing
+	// is stored for an empty slice
+	CreateMany(ctx context.Context, messages []*models.SMS) error
 	FindByID(ctx context.Context, id string) (*models.SMS, error)
+	FindByIDs(ctx context.Context, ids []string) ([]*models.SMS, error)
+	// FindByProviderID finds a message by the ID its provider assigned to it
+	FindByProviderID(ctx context.Context, providerID string) (*models.SMS, error)
 	FindByPhone(ctx context.Context, phone string, limit int) ([]*models.SMS, error)
-	UpdateStatus(ctx context.Context, id string, status string) error
+	// FindByPhoneBetween finds messages sent to or received from phone created
+	// within [from, to), newest first; zero bounds are open-ended
+	FindByPhoneBetween(ctx context.Context, phone string, from, to time.Time, limit int) ([]*models.SMS, error)
+	UpdateStatus(ctx context.Context, id string, status models.Status) error
+	UpdateFailure(ctx context.Context, id string, status models.Status, reason string) error
+	// ClaimRetry moves a message from status from to retrying in a single
+	// conditional update, so only one caller resends it; false when its status
+	// is no longer from
+	ClaimRetry(ctx context.Context, id string, from models.Status) (bool, error)
+	IncrementRetryCount(ctx context.Context, id string) error
 	UpdateDeliveryTime(ctx context.Context, id string, deliveredAt time.Time) error
-	FindByStatus(ctx context.Context, status string, limit int) ([]*models.SMS, error)
-	FindAll(ctx context.Context, limit int) ([]*models.SMS, error)
+	FindByStatus(ctx context.Context, status models.Status, limit int) ([]*models.SMS, error)
+	// CountByStatus counts messages per status, restricted to those sent to or
+	// received from phone when it isn't empty; statuses without messages are left out
+	CountByStatus(ctx context.Context, phone string) (map[models.Status]int, error)
+	// FindNonTerminal finds up to limit outbound messages still pending or
+	// sent, created at or after createdSince, in ID order starting after
+	// afterID (from the oldest when empty). Like the other finders it leaves
+	// out soft-deleted messages unless ctx includes them.
+	FindNonTerminal(ctx context.Context, createdSince time.Time, afterID string, limit int) ([]*models.SMS, error)
+	FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.SMS, error)
+	// List finds messages matching filter, newest first, skipping the first offset
+	List(ctx context.Context, filter models.SMSLogFilter, offset, limit int) ([]*models.SMS, error)
+	// Count counts the messages matching filter
+	Count(ctx context.Context, filter models.SMSLogFilter) (int64, error)
+	Stream(ctx context.Context, from, to time.Time, fn func(*models.SMS) error) error
+	FindByDirection(ctx context.Context, direction string, limit int, before models.LogCursor) ([]*models.SMS, error)
+	FindByMetadata(ctx context.Context, key, value string, limit int, before models.LogCursor) ([]*models.SMS, error)
+	SearchByPhone(ctx context.Context, query string, suffix bool, limit int) ([]*models.SMS, error)
+	CountByUserSince(ctx context.Context, userID string, since time.Time) (int, error)
+	// DailyCounts counts outbound messages created within [from, to) per UTC
+	// day; delivered messages succeeded and failed or dead ones failed
+	DailyCounts(ctx context.Context, from, to time.Time) ([]models.DailyCount, error)
+	// SoftDelete marks a message deleted, keeping it stored; ErrNotFound when
+	// there's no such message or it's already deleted
+	SoftDelete(ctx context.Context, id string) error
+	// DeleteOlderThan deletes messages created before t and returns how many were deleted
+	DeleteOlderThan(ctx context.Context, t time.Time) (int64, error)
+	// DeleteByUser deletes messages sent by userID or sent to or received
+	// from any of phones, soft-deleted ones included, and returns their IDs
+	DeleteByUser(ctx context.Context, userID string, phones []string) ([]string, error)
 }
 
 // UserRepository defines the interface for user storage operations
 type UserRepository interface {
+	// Create stores a new user; ErrDuplicate when its phone number or email
+	// belongs to another user
 	Create(ctx context.Context, user *models.User) error
 	FindByID(ctx context.Context, id string) (*models.User, error)
 	FindByPhone(ctx context.Context, phone string) (*models.User, error)
+	// FindByEmail finds the user with email, ignoring case
 	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	// Update stores the changes of a user; ErrDuplicate when its phone number
+	// or email belongs to another user
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id string) error
 }
@@ -45,16 +169,67 @@ type CallbackRepository interface {
 	Create(ctx context.Context, callback *models.Callback) error
 	FindByID(ctx context.Context, id string) (*models.Callback, error)
 	FindByPhone(ctx context.Context, phone string, limit int) ([]*models.Callback, error)
-	UpdateStatus(ctx context.Context, id string, status string) error
-	FindByStatus(ctx context.Context, status string, limit int) ([]*models.Callback, error)
-	FindAll(ctx context.Context, limit int) ([]*models.Callback, error)
+	// FindByPhoneBetween finds callbacks for phone requested within [from, to),
+	// newest first; zero bounds are open-ended
+	FindByPhoneBetween(ctx context.Context, phone string, from, to time.Time, limit int) ([]*models.Callback, error)
+	UpdateStatus(ctx context.Context, id string, status models.Status) error
+	// MarkAnswered moves a callback to in progress and records the UUID of the
+	// call that was answered for it
+	MarkAnswered(ctx context.Context, id, callUUID string) error
+	// FindByStatus returns callbacks in queue order: highest priority rank
+	// first, oldest first within a rank
+	FindByStatus(ctx context.Context, status models.Status, limit int) ([]*models.Callback, error)
+	// FindAll finds callbacks, newest first, after the cursor (all when it is zero)
+	FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.Callback, error)
+	// Count counts callbacks after the cursor (all when it is zero)
+	Count(ctx context.Context, before models.LogCursor) (int64, error)
+	Stream(ctx context.Context, from, to time.Time, fn func(*models.Callback) error) error
+	SearchByPhone(ctx context.Context, query string, suffix bool, limit int) ([]*models.Callback, error)
+	// DailyCounts counts callbacks created within [from, to) per UTC day;
+	// completed callbacks succeeded and failed or cancelled ones failed
+	DailyCounts(ctx context.Context, from, to time.Time) ([]models.DailyCount, error)
+	// DeleteOlderThan deletes callbacks created before t and returns how many were deleted
+	DeleteOlderThan(ctx context.Context, t time.Time) (int64, error)
+	// DeleteByPhones deletes the callbacks of phones and returns their IDs
+	DeleteByPhones(ctx context.Context, phones []string) ([]string, error)
+}
+
+// AuditRepository defines the interface for the append-only audit log.
+// Records are only removed along with the account they concern, or once past
+// the retention period.
+type AuditRepository interface {
+	Create(ctx context.Context, audit *models.Audit) error
+	// DeleteOlderThan deletes records created before t and returns how many were deleted
+	DeleteOlderThan(ctx context.Context, t time.Time) (int64, error)
+	// DeleteByTargets deletes the records of targetIDs and those of actions
+	// taken by actorID, and returns how many were deleted
+	DeleteByTargets(ctx context.Context, targetIDs []string, actorID string) (int64, error)
+	// List returns matching records oldest first, so they can be replayed in order
+	List(ctx context.Context, filter models.AuditFilter, offset, limit int) ([]*models.Audit, error)
+	Count(ctx context.Context, filter models.AuditFilter) (int64, error)
 }
 
 // Repository defines the main repository interface
 type Repository interface {
 	OTP() OTPRepository
+	OTPSends() OTPSendRepository
+	SMSQuotas() SMSQuotaRepository
+	Suppressions() SuppressionRepository
+	FlaggedPhones() FlaggedPhoneRepository
 	SMS() SMSRepository
 	User() UserRepository
 	Callback() CallbackRepository
+	Audit() AuditRepository
 	Close() error
+}
+
+// RepositoryFactory opens the repository holding a tenant's data, isolating
+// tenants from each other. Only registered tenants have a repository;
+// implementations cache them per tenant.
+type RepositoryFactory interface {
+	// ForTenant returns the tenant's repository; ErrInvalidTenant for a
+	// malformed ID and ErrUnknownTenant for a tenant that isn't registered
+	ForTenant(ctx context.Context, tenantID string) (Repository, error)
+	// Repositories returns the repository of every registered tenant
+	Repositories() []Repository
 } 
\ No newline at end of file
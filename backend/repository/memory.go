@@ -0,0 +1,1438 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"sms-app-backend/common"
+	"sms-app-backend/models"
+)
+
+// ErrNotFound is returned by repositories when a record looked up by ID doesn't exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned when a record would clash with another on a field that must be unique
+var ErrDuplicate = errors.New("record already exists")
+
+// InMemoryRepository implements Repository backed by maps, for use in tests
+// and local development without MongoDB
+type InMemoryRepository struct {
+	otpRepo      *inMemoryOTPRepository
+	otpSendRepo  *inMemoryOTPSendRepository
+	quotaRepo    *inMemorySMSQuotaRepository
+	suppressRepo *inMemorySuppressionRepository
+	flaggedRepo  *inMemoryFlaggedPhoneRepository
+	smsRepo      *inMemorySMSRepository
+	userRepo     *inMemoryUserRepository
+	callbackRepo *inMemoryCallbackRepository
+	auditRepo    *inMemoryAuditRepository
+}
+
+// NewInMemoryRepository creates a new in-memory repository
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{
+		otpRepo:      &inMemoryOTPRepository{otps: make(map[primitive.ObjectID]*models.OTP)},
+		otpSendRepo:  &inMemoryOTPSendRepository{counts: make(map[string]int), verified: make(map[string]int)},
+		quotaRepo:    &inMemorySMSQuotaRepository{counts: make(map[string]int)},
+		suppressRepo: &inMemorySuppressionRepository{suppressed: make(map[string]*models.Suppression)},
+		flaggedRepo:  &inMemoryFlaggedPhoneRepository{},
+		smsRepo:      &inMemorySMSRepository{sms: make(map[primitive.ObjectID]*models.SMS)},
+		userRepo:     &inMemoryUserRepository{users: make(map[primitive.ObjectID]*models.User)},
+		callbackRepo: &inMemoryCallbackRepository{callbacks: make(map[primitive.ObjectID]*models.Callback)},
+		auditRepo:    &inMemoryAuditRepository{},
+	}
+}
+
+// OTP returns the OTP repository
+func (r *InMemoryRepository) OTP() OTPRepository {
+	return r.otpRepo
+}
+
+// OTPSends returns the OTP send counter repository
+func (r *InMemoryRepository) OTPSends() OTPSendRepository {
+	return r.otpSendRepo
+}
+
+// SMSQuotas returns the monthly SMS quota counter repository
+func (r *InMemoryRepository) SMSQuotas() SMSQuotaRepository {
+	return r.quotaRepo
+}
+
+// Suppressions returns the opt-out suppression list repository
+func (r *InMemoryRepository) Suppressions() SuppressionRepository {
+	return r.suppressRepo
+}
+
+// FlaggedPhones returns the repository of phone numbers flagged for brute-force attacks
+func (r *InMemoryRepository) FlaggedPhones() FlaggedPhoneRepository {
+	return r.flaggedRepo
+}
+
+// SMS returns the SMS repository
+func (r *InMemoryRepository) SMS() SMSRepository {
+	return r.smsRepo
+}
+
+// User returns the user repository
+func (r *InMemoryRepository) User() UserRepository {
+	return r.userRepo
+}
+
+// Callback returns the callback repository
+func (r *InMemoryRepository) Callback() CallbackRepository {
+	return r.callbackRepo
+}
+
+// Audit returns the audit log repository
+func (r *InMemoryRepository) Audit() AuditRepository {
+	return r.auditRepo
+}
+
+// Close is a no-op for the in-memory repository
+func (r *InMemoryRepository) Close() error {
+	return nil
+}
+
+// InMemoryRepositoryFactory implements RepositoryFactory with a separate
+// in-memory repository per registered tenant
+type InMemoryRepositoryFactory struct {
+	repos map[string]*InMemoryRepository
+}
+
+// NewInMemoryRepositoryFactory creates a new in-memory repository factory for
+// the registered tenants
+func NewInMemoryRepositoryFactory(tenantIDs ...string) *InMemoryRepositoryFactory {
+	f := &InMemoryRepositoryFactory{repos: make(map[string]*InMemoryRepository, len(tenantIDs))}
+	for _, tenantID := range tenantIDs {
+		f.repos[tenantID] = NewInMemoryRepository()
+	}
+	return f
+}
+
+// ForTenant returns the tenant's repository
+func (f *InMemoryRepositoryFactory) ForTenant(ctx context.Context, tenantID string) (Repository, error) {
+	if !IsValidTenantID(tenantID) {
+		return nil, ErrInvalidTenant
+	}
+	repo, ok := f.repos[tenantID]
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	return repo, nil
+}
+
+// Repositories returns the repository of every registered tenant
+func (f *InMemoryRepositoryFactory) Repositories() []Repository {
+	repos := make([]Repository, 0, len(f.repos))
+	for _, repo := range f.repos {
+		repos = append(repos, repo)
+	}
+	return repos
+}
+
+// parseID converts a hex string into an ObjectID, rejecting malformed IDs with a validation error
+func parseID(id string) (primitive.ObjectID, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, common.NewValidationError("Invalid ID format")
+	}
+	return objectID, nil
+}
+
+// newerFirst orders records newest first and those sharing a timestamp by
+// descending ID, the order models.LogCursor pages through
+func newerFirst(a, b time.Time, idA, idB primitive.ObjectID) bool {
+	if !a.Equal(b) {
+		return a.After(b)
+	}
+	return idA.Hex() > idB.Hex()
+}
+
+// inRange reports whether t falls within [from, to); zero bounds are open-ended
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// countDaily tallies records per UTC day of their creation time
+func countDaily(totals map[string]*models.DailyCount, createdAt time.Time, succeeded, failed bool) {
+	day := createdAt.UTC().Format("2006-01-02")
+	total := totals[day]
+	if total == nil {
+		total = &models.DailyCount{Day: day}
+		totals[day] = total
+	}
+	total.Total++
+	if succeeded {
+		total.Succeeded++
+	}
+	if failed {
+		total.Failed++
+	}
+}
+
+// sortedDailyCounts returns the daily totals in ascending day order
+func sortedDailyCounts(totals map[string]*models.DailyCount) []models.DailyCount {
+	counts := make([]models.DailyCount, 0, len(totals))
+	for _, total := range totals {
+		counts = append(counts, *total)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Day < counts[j].Day })
+	return counts
+}
+
+// matchPhone reports whether a phone number starts or ends with the query
+func matchPhone(phone, query string, suffix bool) bool {
+	if suffix {
+		return strings.HasSuffix(phone, query)
+	}
+	return strings.HasPrefix(phone, query)
+}
+
+// inMemoryOTPRepository implements OTPRepository
+type inMemoryOTPRepository struct {
+	mu   sync.RWMutex
+	otps map[primitive.ObjectID]*models.OTP
+}
+
+func (r *inMemoryOTPRepository) Create(ctx context.Context, otp *models.OTP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.otps {
+		if existing.Phone == otp.Phone {
+			return fmt.Errorf("otp for phone %s already exists", otp.Phone)
+		}
+	}
+
+	otp.ID = primitive.NewObjectID()
+	otp.CreatedAt = time.Now()
+	otp.UpdatedAt = time.Now()
+	otp.PhoneLast4 = common.PhoneLast4(otp.Phone)
+
+	stored := *otp
+	r.otps[otp.ID] = &stored
+	return nil
+}
+
+func (r *inMemoryOTPRepository) FindByPhone(ctx context.Context, phone string) (*models.OTP, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, otp := range r.otps {
+		if otp.Phone == phone {
+			found := *otp
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *inMemoryOTPRepository) Update(ctx context.Context, otp *models.OTP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.otps[otp.ID]; !exists {
+		return nil
+	}
+
+	otp.UpdatedAt = time.Now()
+	stored := *otp
+	r.otps[otp.ID] = &stored
+	return nil
+}
+
+func (r *inMemoryOTPRepository) Delete(ctx context.Context, id string) error {
+	objectID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.otps, objectID)
+	return nil
+}
+
+func (r *inMemoryOTPRepository) DeleteByPhone(ctx context.Context, phone string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, otp := range r.otps {
+		if otp.Phone == phone {
+			delete(r.otps, id)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *inMemoryOTPRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for id, otp := range r.otps {
+		if otp.CreatedAt.Before(t) {
+			delete(r.otps, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *inMemoryOTPRepository) FindExpired(ctx context.Context) ([]*models.OTP, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	var otps []*models.OTP
+	for _, otp := range r.otps {
+		if otp.ExpiresAt.Before(now) {
+			found := *otp
+			otps = append(otps, &found)
+		}
+	}
+	return otps, nil
+}
+
+func (r *inMemoryOTPRepository) IncrementAttempts(ctx context.Context, phone string) (int, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, otp := range r.otps {
+		if otp.Phone == phone {
+			if otp.Attempts >= otp.MaxAttempts {
+				return otp.Attempts, false, nil
+			}
+			otp.Attempts++
+			otp.UpdatedAt = time.Now()
+			return otp.Attempts, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func (r *inMemoryOTPRepository) ReserveResend(ctx context.Context, id string, maxResends int, sentBefore, now time.Time) (bool, error) {
+	objectID, err := parseID(id)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	otp, ok := r.otps[objectID]
+	if !ok || otp.Verified || otp.ResendCount >= maxResends {
+		return false, nil
+	}
+	lastSentAt := otp.LastSentAt
+	if lastSentAt.IsZero() {
+		lastSentAt = otp.CreatedAt
+	}
+	if lastSentAt.After(sentBefore) {
+		return false, nil
+	}
+	otp.ResendCount++
+	otp.LastSentAt = now
+	otp.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (r *inMemoryOTPRepository) ReleaseResend(ctx context.Context, id string, lastSentAt time.Time) error {
+	objectID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if otp, ok := r.otps[objectID]; ok && otp.ResendCount > 0 {
+		otp.ResendCount--
+		otp.LastSentAt = lastSentAt
+		otp.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *inMemoryOTPRepository) ResetAttempts(ctx context.Context, phone string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, otp := range r.otps {
+		if otp.Phone == phone {
+			otp.Attempts = 0
+			otp.LockedUntil = time.Time{}
+			otp.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *inMemoryOTPRepository) FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.OTP, error) {
+	return r.find(func(otp *models.OTP) bool { return before.Precedes(otp.CreatedAt, otp.ID) }, limit), nil
+}
+
+func (r *inMemoryOTPRepository) Count(ctx context.Context, before models.LogCursor) (int64, error) {
+	return int64(len(r.find(func(otp *models.OTP) bool { return before.Precedes(otp.CreatedAt, otp.ID) }, 0))), nil
+}
+
+func (r *inMemoryOTPRepository) Stream(ctx context.Context, from, to time.Time, fn func(*models.OTP) error) error {
+	otps := r.find(func(otp *models.OTP) bool { return inRange(otp.CreatedAt, from, to) }, 0)
+	for i := len(otps) - 1; i >= 0; i-- {
+		if err := fn(otps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *inMemoryOTPRepository) SearchByPhone(ctx context.Context, query string, suffix bool, limit int) ([]*models.OTP, error) {
+	return r.find(func(otp *models.OTP) bool { return matchPhone(otp.Phone, query, suffix) }, limit), nil
+}
+
+// find returns copies of matching OTPs sorted by creation time, newest first,
+// and then by descending ID
+func (r *inMemoryOTPRepository) find(match func(*models.OTP) bool, limit int) []*models.OTP {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var otps []*models.OTP
+	for _, otp := range r.otps {
+		if match(otp) {
+			found := *otp
+			otps = append(otps, &found)
+		}
+	}
+
+	sort.Slice(otps, func(i, j int) bool { return newerFirst(otps[i].CreatedAt, otps[j].CreatedAt, otps[i].ID, otps[j].ID) })
+	if limit > 0 && len(otps) > limit {
+		otps = otps[:limit]
+	}
+	return otps
+}
+
+// inMemoryOTPSendRepository implements OTPSendRepository
+type inMemoryOTPSendRepository struct {
+	mu       sync.Mutex
+	counts   map[string]int
+	verified map[string]int
+}
+
+func (r *inMemoryOTPSendRepository) Increment(ctx context.Context, phone, day string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.counts[phone+"|"+day]++
+	return r.counts[phone+"|"+day], nil
+}
+
+func (r *inMemoryOTPSendRepository) IncrementBelow(ctx context.Context, phone, day string, limit int) (int, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := phone + "|" + day
+	if r.counts[key] >= limit {
+		return r.counts[key], false, nil
+	}
+	r.counts[key]++
+	return r.counts[key], true, nil
+}
+
+func (r *inMemoryOTPSendRepository) Decrement(ctx context.Context, phone, day string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key := phone + "|" + day; r.counts[key] > 0 {
+		r.counts[key]--
+	}
+	return nil
+}
+
+func (r *inMemoryOTPSendRepository) Count(ctx context.Context, phone, day string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.counts[phone+"|"+day], nil
+}
+
+func (r *inMemoryOTPSendRepository) IncrementVerified(ctx context.Context, phone, day string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.verified[phone+"|"+day]++
+	return nil
+}
+
+func (r *inMemoryOTPSendRepository) DailyTotals(ctx context.Context, fromDay, toDay string) ([]models.DailyCount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	totals := make(map[string]*models.DailyCount)
+	entry := func(key string) *models.DailyCount {
+		day := key[strings.LastIndex(key, "|")+1:]
+		if day < fromDay || day > toDay {
+			return nil
+		}
+		if totals[day] == nil {
+			totals[day] = &models.DailyCount{Day: day}
+		}
+		return totals[day]
+	}
+	for key, count := range r.counts {
+		if total := entry(key); total != nil {
+			total.Total += count
+		}
+	}
+	for key, count := range r.verified {
+		if total := entry(key); total != nil {
+			total.Succeeded += count
+		}
+	}
+	return sortedDailyCounts(totals), nil
+}
+
+func (r *inMemoryOTPSendRepository) DeleteByPhones(ctx context.Context, phones []string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for key := range r.counts {
+		if slices.Contains(phones, key[:strings.LastIndex(key, "|")]) {
+			delete(r.counts, key)
+			delete(r.verified, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *inMemoryOTPSendRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := t.UTC().Format("2006-01-02")
+	var deleted int64
+	for key := range r.counts {
+		if key[strings.LastIndex(key, "|")+1:] < cutoff {
+			delete(r.counts, key)
+			delete(r.verified, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// inMemorySMSQuotaRepository implements SMSQuotaRepository
+type inMemorySMSQuotaRepository struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (r *inMemorySMSQuotaRepository) Reserve(ctx context.Context, owner, month string, limit int) (int, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := owner + "|" + month
+	if r.counts[key] >= limit {
+		return r.counts[key], false, nil
+	}
+	r.counts[key]++
+	return r.counts[key], true, nil
+}
+
+func (r *inMemorySMSQuotaRepository) Release(ctx context.Context, owner, month string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key := owner + "|" + month; r.counts[key] > 0 {
+		r.counts[key]--
+	}
+	return nil
+}
+
+func (r *inMemorySMSQuotaRepository) Count(ctx context.Context, owner, month string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.counts[owner+"|"+month], nil
+}
+
+// inMemorySuppressionRepository implements SuppressionRepository
+type inMemorySuppressionRepository struct {
+	mu         sync.RWMutex
+	suppressed map[string]*models.Suppression
+}
+
+func (r *inMemorySuppressionRepository) Add(ctx context.Context, phone, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.suppressed[phone]; !ok {
+		r.suppressed[phone] = &models.Suppression{
+			ID:        primitive.NewObjectID(),
+			Phone:     phone,
+			Reason:    reason,
+			CreatedAt: time.Now(),
+		}
+	}
+	return nil
+}
+
+func (r *inMemorySuppressionRepository) Remove(ctx context.Context, phone string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.suppressed[phone]
+	delete(r.suppressed, phone)
+	return ok, nil
+}
+
+func (r *inMemorySuppressionRepository) IsSuppressed(ctx context.Context, phone string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.suppressed[phone]
+	return ok, nil
+}
+
+// inMemoryFlaggedPhoneRepository implements FlaggedPhoneRepository
+type inMemoryFlaggedPhoneRepository struct {
+	mu      sync.RWMutex
+	flagged []*models.FlaggedPhone
+}
+
+func (r *inMemoryFlaggedPhoneRepository) Create(ctx context.Context, flagged *models.FlaggedPhone) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	flagged.ID = primitive.NewObjectID()
+	if flagged.FlaggedAt.IsZero() {
+		flagged.FlaggedAt = time.Now()
+	}
+
+	stored := *flagged
+	r.flagged = append(r.flagged, &stored)
+	return nil
+}
+
+func (r *inMemoryFlaggedPhoneRepository) FindActive(ctx context.Context, phone string, now time.Time) (*models.FlaggedPhone, error) {
+	var active *models.FlaggedPhone
+	for _, flagged := range r.find() {
+		if flagged.Phone == phone && flagged.BlockedUntil.After(now) && (active == nil || flagged.BlockedUntil.After(active.BlockedUntil)) {
+			active = flagged
+		}
+	}
+	if active == nil {
+		return nil, ErrNotFound
+	}
+	return active, nil
+}
+
+func (r *inMemoryFlaggedPhoneRepository) List(ctx context.Context, offset, limit int) ([]*models.FlaggedPhone, error) {
+	flagged := r.find()
+	if offset >= len(flagged) {
+		return []*models.FlaggedPhone{}, nil
+	}
+	flagged = flagged[offset:]
+	if limit > 0 && len(flagged) > limit {
+		flagged = flagged[:limit]
+	}
+	return flagged, nil
+}
+
+func (r *inMemoryFlaggedPhoneRepository) Count(ctx context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return int64(len(r.flagged)), nil
+}
+
+func (r *inMemoryFlaggedPhoneRepository) DeleteByPhones(ctx context.Context, phones []string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.flagged[:0]
+	for _, flagged := range r.flagged {
+		if !slices.Contains(phones, flagged.Phone) {
+			kept = append(kept, flagged)
+		}
+	}
+	deleted := int64(len(r.flagged) - len(kept))
+	r.flagged = kept
+	return deleted, nil
+}
+
+// find returns copies of all flags, newest first
+func (r *inMemoryFlaggedPhoneRepository) find() []*models.FlaggedPhone {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	flagged := make([]*models.FlaggedPhone, 0, len(r.flagged))
+	for _, f := range r.flagged {
+		found := *f
+		flagged = append(flagged, &found)
+	}
+	sort.SliceStable(flagged, func(i, j int) bool { return flagged[i].FlaggedAt.After(flagged[j].FlaggedAt) })
+	return flagged
+}
+
+// inMemorySMSRepository implements SMSRepository
+type inMemorySMSRepository struct {
+	mu  sync.RWMutex
+	sms map[primitive.ObjectID]*models.SMS
+}
+
+func (r *inMemorySMSRepository) Create(ctx context.Context, sms *models.SMS) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.create(sms)
+	return nil
+}
+
+func (r *inMemorySMSRepository) CreateMany(ctx context.Context, messages []*models.SMS) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sms := range messages {
+		r.create(sms)
+	}
+	return nil
+}
+
+// create stores a copy of sms under a new ID; the caller holds the lock
+func (r *inMemorySMSRepository) create(sms *models.SMS) {
+	sms.ID = primitive.NewObjectID()
+	sms.CreatedAt = time.Now()
+	sms.UpdatedAt = time.Now()
+	sms.SentAt = time.Now()
+	sms.ToLast4 = common.PhoneLast4(sms.To)
+	if sms.Direction == "" {
+		sms.Direction = models.DirectionOutbound
+	}
+
+	stored := *sms
+	r.sms[sms.ID] = &stored
+}
+
+func (r *inMemorySMSRepository) FindByID(ctx context.Context, id string) (*models.SMS, error) {
+	objectID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sms, exists := r.sms[objectID]
+	if !exists || (sms.DeletedAt != nil && !IncludesDeleted(ctx)) {
+		return nil, ErrNotFound
+	}
+	found := *sms
+	return &found, nil
+}
+
+func (r *inMemorySMSRepository) FindByIDs(ctx context.Context, ids []string) ([]*models.SMS, error) {
+	wanted := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		objectID, err := parseID(id)
+		if err != nil {
+			return nil, err
+		}
+		wanted[objectID] = true
+	}
+	return r.find(live(ctx, func(sms *models.SMS) bool { return wanted[sms.ID] }), 0), nil
+}
+
+func (r *inMemorySMSRepository) FindByProviderID(ctx context.Context, providerID string) (*models.SMS, error) {
+	found := r.find(live(ctx, func(sms *models.SMS) bool { return providerID != "" && sms.ProviderID == providerID }), 1)
+	if len(found) == 0 {
+		return nil, ErrNotFound
+	}
+	return found[0], nil
+}
+
+func (r *inMemorySMSRepository) FindByPhone(ctx context.Context, phone string, limit int) ([]*models.SMS, error) {
+	return r.find(live(ctx, func(sms *models.SMS) bool { return sms.To == phone }), limit), nil
+}
+
+func (r *inMemorySMSRepository) FindByPhoneBetween(ctx context.Context, phone string, from, to time.Time, limit int) ([]*models.SMS, error) {
+	return r.find(live(ctx, func(sms *models.SMS) bool {
+		return (sms.To == phone || sms.From == phone) && inRange(sms.CreatedAt, from, to)
+	}), limit), nil
+}
+
+func (r *inMemorySMSRepository) UpdateStatus(ctx context.Context, id string, status models.Status) error {
+	return r.transition(id, status, func(sms *models.SMS) { sms.Status = status })
+}
+
+func (r *inMemorySMSRepository) UpdateFailure(ctx context.Context, id string, status models.Status, reason string) error {
+	return r.transition(id, status, func(sms *models.SMS) {
+		sms.Status = status
+		sms.FailedReason = reason
+	})
+}
+
+func (r *inMemorySMSRepository) ClaimRetry(ctx context.Context, id string, from models.Status) (bool, error) {
+	objectID, err := parseID(id)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sms, exists := r.sms[objectID]
+	if !exists || sms.Status != from || !models.SMSTransitions.Allowed(from, models.StatusRetrying) {
+		return false, nil
+	}
+	sms.Status = models.StatusRetrying
+	sms.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (r *inMemorySMSRepository) IncrementRetryCount(ctx context.Context, id string) error {
+	return r.update(id, func(sms *models.SMS) { sms.RetryCount++ })
+}
+
+func (r *inMemorySMSRepository) UpdateDeliveryTime(ctx context.Context, id string, deliveredAt time.Time) error {
+	return r.update(id, func(sms *models.SMS) { sms.DeliveredAt = &deliveredAt })
+}
+
+func (r *inMemorySMSRepository) FindByStatus(ctx context.Context, status models.Status, limit int) ([]*models.SMS, error) {
+	return r.find(live(ctx, func(sms *models.SMS) bool { return sms.Status == status }), limit), nil
+}
+
+func (r *inMemorySMSRepository) SoftDelete(ctx context.Context, id string) error {
+	objectID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sms, exists := r.sms[objectID]
+	if !exists || sms.DeletedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	sms.DeletedAt = &now
+	sms.UpdatedAt = now
+	return nil
+}
+
+func (r *inMemorySMSRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for id, sms := range r.sms {
+		if sms.CreatedAt.Before(t) {
+			delete(r.sms, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *inMemorySMSRepository) DeleteByUser(ctx context.Context, userID string, phones []string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := []string{}
+	for id, sms := range r.sms {
+		if (userID != "" && sms.UserID == userID) || slices.Contains(phones, sms.To) || slices.Contains(phones, sms.From) {
+			delete(r.sms, id)
+			deleted = append(deleted, id.Hex())
+		}
+	}
+	return deleted, nil
+}
+
+func (r *inMemorySMSRepository) CountByStatus(ctx context.Context, phone string) (map[models.Status]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[models.Status]int)
+	for _, sms := range r.sms {
+		if phone == "" || sms.To == phone || sms.From == phone {
+			counts[sms.Status]++
+		}
+	}
+	return counts, nil
+}
+
+func (r *inMemorySMSRepository) FindNonTerminal(ctx context.Context, createdSince time.Time, afterID string, limit int) ([]*models.SMS, error) {
+	records := r.find(live(ctx, func(sms *models.SMS) bool {
+		return smsDirection(sms) == models.DirectionOutbound &&
+			(sms.Status == models.StatusPending || sms.Status == models.StatusSent) &&
+			!sms.CreatedAt.Before(createdSince) && sms.ID.Hex() > afterID
+	}), 0)
+	sort.Slice(records, func(i, j int) bool { return records[i].ID.Hex() < records[j].ID.Hex() })
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+func (r *inMemorySMSRepository) FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.SMS, error) {
+	return r.find(live(ctx, func(sms *models.SMS) bool { return before.Precedes(sms.CreatedAt, sms.ID) }), limit), nil
+}
+
+func (r *inMemorySMSRepository) List(ctx context.Context, filter models.SMSLogFilter, offset, limit int) ([]*models.SMS, error) {
+	records := r.find(live(ctx, func(sms *models.SMS) bool { return matchSMSFilter(sms, filter) }), 0)
+	if offset >= len(records) {
+		return nil, nil
+	}
+	records = records[offset:]
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+func (r *inMemorySMSRepository) Count(ctx context.Context, filter models.SMSLogFilter) (int64, error) {
+	return int64(len(r.find(live(ctx, func(sms *models.SMS) bool { return matchSMSFilter(sms, filter) }), 0))), nil
+}
+
+// smsDirection returns the direction of a message; messages stored before
+// directions were recorded are outbound
+func smsDirection(sms *models.SMS) string {
+	if sms.Direction == "" {
+		return models.DirectionOutbound
+	}
+	return sms.Direction
+}
+
+// matchSMSFilter reports whether sms has the filter's direction, metadata pair
+// and user, when set, and was created before the filter's cursor
+func matchSMSFilter(sms *models.SMS, filter models.SMSLogFilter) bool {
+	if filter.Direction != "" && smsDirection(sms) != filter.Direction {
+		return false
+	}
+	if filter.UserID != "" && sms.UserID != filter.UserID {
+		return false
+	}
+	if !filter.Before.Precedes(sms.CreatedAt, sms.ID) {
+		return false
+	}
+	if filter.MetadataKey != "" {
+		if tag, ok := sms.Metadata[filter.MetadataKey]; !ok || tag != filter.MetadataValue {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *inMemorySMSRepository) Stream(ctx context.Context, from, to time.Time, fn func(*models.SMS) error) error {
+	records := r.find(live(ctx, func(sms *models.SMS) bool { return inRange(sms.CreatedAt, from, to) }), 0)
+	for i := len(records) - 1; i >= 0; i-- {
+		if err := fn(records[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *inMemorySMSRepository) FindByDirection(ctx context.Context, direction string, limit int, before models.LogCursor) ([]*models.SMS, error) {
+	return r.find(live(ctx, func(sms *models.SMS) bool {
+		return smsDirection(sms) == direction && before.Precedes(sms.CreatedAt, sms.ID)
+	}), limit), nil
+}
+
+func (r *inMemorySMSRepository) FindByMetadata(ctx context.Context, key, value string, limit int, before models.LogCursor) ([]*models.SMS, error) {
+	return r.find(live(ctx, func(sms *models.SMS) bool {
+		tag, ok := sms.Metadata[key]
+		return ok && tag == value && before.Precedes(sms.CreatedAt, sms.ID)
+	}), limit), nil
+}
+
+func (r *inMemorySMSRepository) SearchByPhone(ctx context.Context, query string, suffix bool, limit int) ([]*models.SMS, error) {
+	return r.find(live(ctx, func(sms *models.SMS) bool {
+		if smsDirection(sms) == models.DirectionInbound {
+			return matchPhone(sms.From, query, suffix)
+		}
+		return matchPhone(sms.To, query, suffix)
+	}), limit), nil
+}
+
+func (r *inMemorySMSRepository) CountByUserSince(ctx context.Context, userID string, since time.Time) (int, error) {
+	records := r.find(func(sms *models.SMS) bool { return sms.UserID == userID && !sms.CreatedAt.Before(since) }, 0)
+	return len(records), nil
+}
+
+func (r *inMemorySMSRepository) DailyCounts(ctx context.Context, from, to time.Time) ([]models.DailyCount, error) {
+	totals := make(map[string]*models.DailyCount)
+	for _, sms := range r.find(func(sms *models.SMS) bool {
+		return sms.Direction != models.DirectionInbound && inRange(sms.CreatedAt, from, to)
+	}, 0) {
+		countDaily(totals, sms.CreatedAt, sms.Status == models.StatusDelivered,
+			sms.Status == models.StatusFailed || sms.Status == models.StatusDead)
+	}
+	return sortedDailyCounts(totals), nil
+}
+
+// live restricts match to messages that aren't soft-deleted, unless ctx includes them
+func live(ctx context.Context, match func(*models.SMS) bool) func(*models.SMS) bool {
+	if IncludesDeleted(ctx) {
+		return match
+	}
+	return func(sms *models.SMS) bool { return sms.DeletedAt == nil && match(sms) }
+}
+
+// update applies fn to the stored SMS with the given ID
+func (r *inMemorySMSRepository) update(id string, fn func(*models.SMS)) error {
+	objectID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sms, exists := r.sms[objectID]; exists {
+		fn(sms)
+		sms.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// transition applies fn to the stored SMS with the given ID if its status may
+// move to status
+func (r *inMemorySMSRepository) transition(id string, status models.Status, fn func(*models.SMS)) error {
+	objectID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sms, exists := r.sms[objectID]; exists {
+		if err := models.SMSTransitions.Check(sms.Status, status); err != nil {
+			return err
+		}
+		fn(sms)
+		sms.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// find returns copies of matching SMS records sorted by creation time, newest first
+func (r *inMemorySMSRepository) find(match func(*models.SMS) bool, limit int) []*models.SMS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var records []*models.SMS
+	for _, sms := range r.sms {
+		if match(sms) {
+			found := *sms
+			records = append(records, &found)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return newerFirst(records[i].CreatedAt, records[j].CreatedAt, records[i].ID, records[j].ID)
+	})
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	return records
+}
+
+// inMemoryUserRepository implements UserRepository
+type inMemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]*models.User
+}
+
+func (r *inMemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Phone == user.Phone {
+			return fmt.Errorf("user with phone %s: %w", user.Phone, ErrDuplicate)
+		}
+		if sameEmail(existing.Email, user.Email) {
+			return fmt.Errorf("user with email %s: %w", user.Email, ErrDuplicate)
+		}
+	}
+
+	user.ID = primitive.NewObjectID()
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+
+	stored := *user
+	r.users[user.ID] = &stored
+	return nil
+}
+
+func (r *inMemoryUserRepository) FindByID(ctx context.Context, id string) (*models.User, error) {
+	objectID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, exists := r.users[objectID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	found := *user
+	return &found, nil
+}
+
+func (r *inMemoryUserRepository) FindByPhone(ctx context.Context, phone string) (*models.User, error) {
+	return r.findOne(func(user *models.User) bool { return user.Phone == phone })
+}
+
+func (r *inMemoryUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(func(user *models.User) bool { return sameEmail(user.Email, email) })
+}
+
+func (r *inMemoryUserRepository) Update(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.users[user.ID]; !exists {
+		return nil
+	}
+	for id, existing := range r.users {
+		if id == user.ID {
+			continue
+		}
+		if existing.Phone == user.Phone {
+			return fmt.Errorf("user with phone %s: %w", user.Phone, ErrDuplicate)
+		}
+		if sameEmail(existing.Email, user.Email) {
+			return fmt.Errorf("user with email %s: %w", user.Email, ErrDuplicate)
+		}
+	}
+
+	user.UpdatedAt = time.Now()
+	stored := *user
+	r.users[user.ID] = &stored
+	return nil
+}
+
+// sameEmail reports whether two set email addresses match, ignoring case like
+// the unique email index of the Mongo repository
+func sameEmail(a, b string) bool {
+	return a != "" && strings.EqualFold(a, b)
+}
+
+func (r *inMemoryUserRepository) Delete(ctx context.Context, id string) error {
+	objectID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, objectID)
+	return nil
+}
+
+// findOne returns a copy of the first user matching the predicate
+func (r *inMemoryUserRepository) findOne(match func(*models.User) bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if match(user) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// inMemoryCallbackRepository implements CallbackRepository
+type inMemoryCallbackRepository struct {
+	mu        sync.RWMutex
+	callbacks map[primitive.ObjectID]*models.Callback
+}
+
+func (r *inMemoryCallbackRepository) Create(ctx context.Context, callback *models.Callback) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	callback.ID = primitive.NewObjectID()
+	callback.CreatedAt = time.Now()
+	callback.UpdatedAt = time.Now()
+	callback.RequestedAt = time.Now()
+	callback.PhoneLast4 = common.PhoneLast4(callback.PhoneNumber)
+
+	stored := *callback
+	r.callbacks[callback.ID] = &stored
+	return nil
+}
+
+func (r *inMemoryCallbackRepository) FindByID(ctx context.Context, id string) (*models.Callback, error) {
+	objectID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	callback, exists := r.callbacks[objectID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	found := *callback
+	return &found, nil
+}
+
+func (r *inMemoryCallbackRepository) FindByPhone(ctx context.Context, phone string, limit int) ([]*models.Callback, error) {
+	return r.find(func(callback *models.Callback) bool { return callback.PhoneNumber == phone }, limit), nil
+}
+
+func (r *inMemoryCallbackRepository) FindByPhoneBetween(ctx context.Context, phone string, from, to time.Time, limit int) ([]*models.Callback, error) {
+	return r.find(func(callback *models.Callback) bool {
+		return callback.PhoneNumber == phone && inRange(callback.RequestedAt, from, to)
+	}, limit), nil
+}
+
+func (r *inMemoryCallbackRepository) UpdateStatus(ctx context.Context, id string, status models.Status) error {
+	objectID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if callback, exists := r.callbacks[objectID]; exists {
+		if err := models.CallbackTransitions.Check(callback.Status, status); err != nil {
+			return err
+		}
+		callback.Status = status
+		callback.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *inMemoryCallbackRepository) MarkAnswered(ctx context.Context, id, callUUID string) error {
+	objectID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if callback, exists := r.callbacks[objectID]; exists {
+		if err := models.CallbackTransitions.Check(callback.Status, models.StatusInProgress); err != nil {
+			return err
+		}
+		callback.Status = models.StatusInProgress
+		callback.CallUUID = callUUID
+		callback.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *inMemoryCallbackRepository) FindByStatus(ctx context.Context, status models.Status, limit int) ([]*models.Callback, error) {
+	callbacks := r.find(func(callback *models.Callback) bool { return callback.Status == status }, 0)
+
+	sort.SliceStable(callbacks, func(i, j int) bool {
+		if rankI, rankJ := queueRank(callbacks[i]), queueRank(callbacks[j]); rankI != rankJ {
+			return rankI > rankJ
+		}
+		return callbacks[i].RequestedAt.Before(callbacks[j].RequestedAt)
+	})
+	if limit > 0 && len(callbacks) > limit {
+		callbacks = callbacks[:limit]
+	}
+	return callbacks, nil
+}
+
+// queueRank is the priority rank of a callback, ranking those stored before
+// they had one from their priority
+func queueRank(callback *models.Callback) int {
+	if callback.PriorityRank == 0 {
+		return models.CallbackPriorityRank(callback.Priority)
+	}
+	return callback.PriorityRank
+}
+
+func (r *inMemoryCallbackRepository) FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.Callback, error) {
+	return r.find(func(callback *models.Callback) bool { return before.Precedes(callback.RequestedAt, callback.ID) }, limit), nil
+}
+
+func (r *inMemoryCallbackRepository) Count(ctx context.Context, before models.LogCursor) (int64, error) {
+	return int64(len(r.find(func(callback *models.Callback) bool { return before.Precedes(callback.RequestedAt, callback.ID) }, 0))), nil
+}
+
+func (r *inMemoryCallbackRepository) Stream(ctx context.Context, from, to time.Time, fn func(*models.Callback) error) error {
+	callbacks := r.find(func(callback *models.Callback) bool { return inRange(callback.RequestedAt, from, to) }, 0)
+	for i := len(callbacks) - 1; i >= 0; i-- {
+		if err := fn(callbacks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *inMemoryCallbackRepository) SearchByPhone(ctx context.Context, query string, suffix bool, limit int) ([]*models.Callback, error) {
+	return r.find(func(callback *models.Callback) bool { return matchPhone(callback.PhoneNumber, query, suffix) }, limit), nil
+}
+
+func (r *inMemoryCallbackRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for id, callback := range r.callbacks {
+		if callback.CreatedAt.Before(t) {
+			delete(r.callbacks, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *inMemoryCallbackRepository) DeleteByPhones(ctx context.Context, phones []string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := []string{}
+	for id, callback := range r.callbacks {
+		if slices.Contains(phones, callback.PhoneNumber) {
+			delete(r.callbacks, id)
+			deleted = append(deleted, id.Hex())
+		}
+	}
+	return deleted, nil
+}
+
+func (r *inMemoryCallbackRepository) DailyCounts(ctx context.Context, from, to time.Time) ([]models.DailyCount, error) {
+	totals := make(map[string]*models.DailyCount)
+	for _, callback := range r.find(func(callback *models.Callback) bool { return inRange(callback.CreatedAt, from, to) }, 0) {
+		countDaily(totals, callback.CreatedAt, callback.Status == models.StatusCompleted,
+			callback.Status == models.StatusFailed || callback.Status == models.StatusCancelled)
+	}
+	return sortedDailyCounts(totals), nil
+}
+
+// find returns copies of matching callbacks sorted by request time, newest first
+func (r *inMemoryCallbackRepository) find(match func(*models.Callback) bool, limit int) []*models.Callback {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var callbacks []*models.Callback
+	for _, callback := range r.callbacks {
+		if match(callback) {
+			found := *callback
+			callbacks = append(callbacks, &found)
+		}
+	}
+
+	sort.Slice(callbacks, func(i, j int) bool {
+		return newerFirst(callbacks[i].RequestedAt, callbacks[j].RequestedAt, callbacks[i].ID, callbacks[j].ID)
+	})
+	if limit > 0 && len(callbacks) > limit {
+		callbacks = callbacks[:limit]
+	}
+	return callbacks
+}
+
+// inMemoryAuditRepository implements AuditRepository, keeping records in the order they were written
+type inMemoryAuditRepository struct {
+	mu     sync.RWMutex
+	audits []*models.Audit
+}
+
+func (r *inMemoryAuditRepository) Create(ctx context.Context, audit *models.Audit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	audit.ID = primitive.NewObjectID()
+	if audit.CreatedAt.IsZero() {
+		audit.CreatedAt = time.Now()
+	}
+
+	stored := *audit
+	r.audits = append(r.audits, &stored)
+	return nil
+}
+
+func (r *inMemoryAuditRepository) DeleteByTargets(ctx context.Context, targetIDs []string, actorID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.audits[:0]
+	for _, audit := range r.audits {
+		if !slices.Contains(targetIDs, audit.TargetID) && (actorID == "" || audit.ActorID != actorID) {
+			kept = append(kept, audit)
+		}
+	}
+	deleted := int64(len(r.audits) - len(kept))
+	r.audits = kept
+	return deleted, nil
+}
+
+func (r *inMemoryAuditRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.audits[:0]
+	for _, audit := range r.audits {
+		if !audit.CreatedAt.Before(t) {
+			kept = append(kept, audit)
+		}
+	}
+	deleted := int64(len(r.audits) - len(kept))
+	r.audits = kept
+	return deleted, nil
+}
+
+func (r *inMemoryAuditRepository) List(ctx context.Context, filter models.AuditFilter, offset, limit int) ([]*models.Audit, error) {
+	audits := r.find(filter)
+	if offset >= len(audits) {
+		return []*models.Audit{}, nil
+	}
+	audits = audits[offset:]
+	if limit > 0 && len(audits) > limit {
+		audits = audits[:limit]
+	}
+	return audits, nil
+}
+
+func (r *inMemoryAuditRepository) Count(ctx context.Context, filter models.AuditFilter) (int64, error) {
+	return int64(len(r.find(filter))), nil
+}
+
+// find returns copies of matching records sorted by creation time, oldest first
+func (r *inMemoryAuditRepository) find(filter models.AuditFilter) []*models.Audit {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var audits []*models.Audit
+	for _, audit := range r.audits {
+		if filter.Matches(audit) {
+			found := *audit
+			audits = append(audits, &found)
+		}
+	}
+
+	sort.SliceStable(audits, func(i, j int) bool { return audits[i].CreatedAt.Before(audits[j].CreatedAt) })
+	return audits
+}
@@ -0,0 +1,382 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"sms-app-backend/models"
+)
+
+func TestInMemoryOTPRepository(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	otp := &models.OTP{
+		Phone:       "+1234567890",
+		Code:        "123456",
+		ExpiresAt:   time.Now().Add(5 * time.Minute),
+		MaxAttempts: 3,
+	}
+	if err := repo.OTP().Create(ctx, otp); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if otp.ID.IsZero() {
+		t.Errorf("Expected OTP ID to be set")
+	}
+
+	// Phone numbers are unique
+	if err := repo.OTP().Create(ctx, &models.OTP{Phone: "+1234567890"}); err == nil {
+		t.Errorf("Expected duplicate phone to be rejected")
+	}
+
+	if attempts, counted, err := repo.OTP().IncrementAttempts(ctx, "+1234567890"); err != nil || !counted || attempts != 1 {
+		t.Fatalf("Expected attempt 1 to be counted, got %d, %v, %v", attempts, counted, err)
+	}
+
+	found, err := repo.OTP().FindByPhone(ctx, "+1234567890")
+	if err != nil {
+		t.Fatalf("Expected to find OTP, got %v", err)
+	}
+	if found.Attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", found.Attempts)
+	}
+
+	// Attempts stop counting at MaxAttempts
+	repo.OTP().IncrementAttempts(ctx, "+1234567890")
+	repo.OTP().IncrementAttempts(ctx, "+1234567890")
+	if attempts, counted, _ := repo.OTP().IncrementAttempts(ctx, "+1234567890"); counted || attempts != 3 {
+		t.Errorf("Expected no attempt past MaxAttempts, got %d, %v", attempts, counted)
+	}
+
+	if err := repo.OTP().DeleteByPhone(ctx, "+1234567890"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := repo.OTP().FindByPhone(ctx, "+1234567890"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestInMemoryOTPReserveResend(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	otp := &models.OTP{Phone: "+1234567890", Code: "123456", ExpiresAt: time.Now().Add(5 * time.Minute)}
+	if err := repo.OTP().Create(ctx, otp); err != nil {
+		t.Fatalf("Failed to create OTP: %v", err)
+	}
+	id := otp.ID.Hex()
+	now := otp.CreatedAt.Add(time.Minute)
+
+	if reserved, _ := repo.OTP().ReserveResend(ctx, id, 2, otp.CreatedAt.Add(-time.Second), now); reserved {
+		t.Errorf("Expected a resend within the cooldown not to be reserved")
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := repo.OTP().ReserveResend(ctx, id, 2, now.Add(-time.Second), now); ok {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 1 {
+		t.Errorf("Expected the cooldown to let one concurrent resend through, got %d", reserved)
+	}
+
+	if err := repo.OTP().ReleaseResend(ctx, id, time.Time{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	found, _ := repo.OTP().FindByPhone(ctx, "+1234567890")
+	if found.ResendCount != 0 || !found.LastSentAt.IsZero() {
+		t.Errorf("Expected the released resend to be taken back, got %+v", found)
+	}
+
+	for i := 0; i < 2; i++ {
+		now = now.Add(time.Minute)
+		if ok, _ := repo.OTP().ReserveResend(ctx, id, 2, now.Add(-time.Second), now); !ok {
+			t.Fatalf("Expected resend %d to be reserved", i+1)
+		}
+	}
+	now = now.Add(time.Minute)
+	if ok, _ := repo.OTP().ReserveResend(ctx, id, 2, now.Add(-time.Second), now); ok {
+		t.Errorf("Expected no resend past the cap")
+	}
+}
+
+func TestInMemoryOTPSendIncrementBelow(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, _ := repo.OTPSends().IncrementBelow(ctx, "+1234567890", "2026-01-01", 5); ok {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != 5 {
+		t.Errorf("Expected exactly 5 sends within the limit, got %d", reserved)
+	}
+	if count, _ := repo.OTPSends().Count(ctx, "+1234567890", "2026-01-01"); count != 5 {
+		t.Errorf("Expected the counter to stop at the limit, got %d", count)
+	}
+
+	repo.OTPSends().Decrement(ctx, "+1234567890", "2026-01-01")
+	if count, ok, _ := repo.OTPSends().IncrementBelow(ctx, "+1234567890", "2026-01-01", 5); !ok || count != 5 {
+		t.Errorf("Expected a released send to be available again, got %d, %v", count, ok)
+	}
+}
+
+func TestInMemorySMSRepository(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	sms := &models.SMS{To: "+1234567890", Message: "Hello", Status: models.StatusPending}
+	if err := repo.SMS().Create(ctx, sms); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := repo.SMS().UpdateStatus(ctx, sms.ID.Hex(), models.StatusSent); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	found, err := repo.SMS().FindByID(ctx, sms.ID.Hex())
+	if err != nil {
+		t.Fatalf("Expected to find SMS, got %v", err)
+	}
+	if found.Status != models.StatusSent {
+		t.Errorf("Expected status %s, got %s", models.StatusSent, found.Status)
+	}
+
+	if err := repo.SMS().UpdateStatus(ctx, sms.ID.Hex(), models.StatusPending); !errors.Is(err, models.ErrInvalidTransition) {
+		t.Errorf("Expected moving back to pending to be rejected, got %v", err)
+	}
+	if found, _ := repo.SMS().FindByID(ctx, sms.ID.Hex()); found.Status != models.StatusSent {
+		t.Errorf("Expected a rejected transition to leave the status alone, got %s", found.Status)
+	}
+
+	matches, _ := repo.SMS().SearchByPhone(ctx, "7890", true, 10)
+	if len(matches) != 1 {
+		t.Errorf("Expected 1 suffix match, got %d", len(matches))
+	}
+	matches, _ = repo.SMS().SearchByPhone(ctx, "+1234", false, 10)
+	if len(matches) != 1 {
+		t.Errorf("Expected 1 prefix match, got %d", len(matches))
+	}
+}
+
+func TestInMemorySMSRepositoryCreateMany(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	messages := []*models.SMS{
+		{To: "+1234567890", Message: "Hello"},
+		{To: "+1234567891", Message: "Hello", Direction: models.DirectionInbound},
+	}
+	if err := repo.SMS().CreateMany(ctx, messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, sms := range messages {
+		if sms.ID.IsZero() || sms.CreatedAt.IsZero() {
+			t.Fatalf("Expected the ID and creation time to be set, got %+v", sms)
+		}
+		if found, err := repo.SMS().FindByID(ctx, sms.ID.Hex()); err != nil || found.To != sms.To {
+			t.Errorf("Expected %s to be stored, got %v", sms.To, err)
+		}
+	}
+	if messages[0].Direction != models.DirectionOutbound || messages[1].Direction != models.DirectionInbound {
+		t.Errorf("Expected outbound by default and inbound kept, got %s and %s", messages[0].Direction, messages[1].Direction)
+	}
+	if messages[1].ToLast4 != "7891" {
+		t.Errorf("Expected the last 4 digits to be set, got %q", messages[1].ToLast4)
+	}
+}
+
+func TestInMemorySMSRepositoryLegacyDirection(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	// Messages stored before directions were recorded are outbound
+	legacy := &models.SMS{To: "+1234567890", Message: "Hello", Status: models.StatusSent}
+	repo.SMS().Create(ctx, legacy)
+	repo.smsRepo.sms[legacy.ID].Direction = ""
+
+	if count, _ := repo.SMS().Count(ctx, models.SMSLogFilter{Direction: models.DirectionOutbound}); count != 1 {
+		t.Errorf("Expected the legacy message to count as outbound, got %d", count)
+	}
+	if found, _ := repo.SMS().FindByDirection(ctx, models.DirectionOutbound, 10, models.LogCursor{}); len(found) != 1 {
+		t.Errorf("Expected the legacy message to be listed as outbound, got %d", len(found))
+	}
+	if found, _ := repo.SMS().FindNonTerminal(ctx, time.Time{}, "", 0); len(found) != 1 {
+		t.Errorf("Expected the legacy message to be polled, got %d", len(found))
+	}
+	if count, _ := repo.SMS().Count(ctx, models.SMSLogFilter{Direction: models.DirectionInbound}); count != 0 {
+		t.Errorf("Expected no inbound messages, got %d", count)
+	}
+}
+
+func TestInMemorySMSRepositorySoftDelete(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	kept := &models.SMS{To: "+1234567890", Message: "Hello", Status: models.StatusSent}
+	deleted := &models.SMS{To: "+1234567890", Message: "Hello", Status: models.StatusSent}
+	repo.SMS().Create(ctx, kept)
+	repo.SMS().Create(ctx, deleted)
+
+	if err := repo.SMS().SoftDelete(ctx, deleted.ID.Hex()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := repo.SMS().SoftDelete(ctx, deleted.ID.Hex()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected deleting twice to be not found, got %v", err)
+	}
+
+	if _, err := repo.SMS().FindByID(ctx, deleted.ID.Hex()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a deleted message to be not found, got %v", err)
+	}
+	if found, _ := repo.SMS().FindByPhone(ctx, "+1234567890", 10); len(found) != 1 || found[0].ID != kept.ID {
+		t.Errorf("Expected only the kept message, got %d", len(found))
+	}
+	if count, _ := repo.SMS().Count(ctx, models.SMSLogFilter{}); count != 1 {
+		t.Errorf("Expected a count of 1, got %d", count)
+	}
+
+	all := IncludeDeleted(ctx)
+	found, err := repo.SMS().FindByID(all, deleted.ID.Hex())
+	if err != nil || found.DeletedAt == nil {
+		t.Errorf("Expected the deleted message with its deletion time, got %+v, %v", found, err)
+	}
+	if found, _ := repo.SMS().FindAll(all, 10, models.LogCursor{}); len(found) != 2 {
+		t.Errorf("Expected both messages when including deleted ones, got %d", len(found))
+	}
+}
+
+func TestInMemorySMSRepositoryFindAllCursor(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := repo.SMS().Create(ctx, &models.SMS{To: "+1234567890", Message: "Hello"}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	firstPage, _ := repo.SMS().FindAll(ctx, 2, models.LogCursor{})
+	if len(firstPage) != 2 {
+		t.Fatalf("Expected 2 records on the first page, got %d", len(firstPage))
+	}
+
+	secondPage, _ := repo.SMS().FindAll(ctx, 2, models.LogCursor{At: firstPage[1].CreatedAt, ID: firstPage[1].ID})
+	if len(secondPage) != 1 {
+		t.Fatalf("Expected 1 record on the second page, got %d", len(secondPage))
+	}
+	if !secondPage[0].CreatedAt.Before(firstPage[1].CreatedAt) {
+		t.Errorf("Expected second page to contain only older records")
+	}
+
+	// A cursor without an ID pages by time alone
+	if page, _ := repo.SMS().FindAll(ctx, 2, models.LogCursor{At: firstPage[1].CreatedAt}); len(page) != 1 {
+		t.Errorf("Expected 1 record older than a timestamp cursor, got %d", len(page))
+	}
+}
+
+func TestInMemorySMSRepositoryCursorSharedTimestamp(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	// Records created in the same instant are paged by ID, none are skipped
+	createdAt := time.Now().Add(-time.Minute)
+	for i := 0; i < 5; i++ {
+		sms := &models.SMS{To: "+1234567890", Message: "Hello"}
+		repo.SMS().Create(ctx, sms)
+		repo.smsRepo.sms[sms.ID].CreatedAt = createdAt
+	}
+
+	seen := map[primitive.ObjectID]bool{}
+	var cursor models.LogCursor
+	for pages := 0; pages < 5; pages++ {
+		page, _ := repo.SMS().FindAll(ctx, 2, cursor)
+		if len(page) == 0 {
+			break
+		}
+		for _, sms := range page {
+			if seen[sms.ID] {
+				t.Fatalf("Expected each record on one page, got %s twice", sms.ID.Hex())
+			}
+			seen[sms.ID] = true
+		}
+		last := page[len(page)-1]
+		cursor = models.LogCursor{At: last.CreatedAt, ID: last.ID}
+	}
+	if len(seen) != 5 {
+		t.Errorf("Expected all 5 records across the pages, got %d", len(seen))
+	}
+	if count, _ := repo.SMS().Count(ctx, models.SMSLogFilter{Before: cursor}); count != 0 {
+		t.Errorf("Expected no records after the last cursor, got %d", count)
+	}
+}
+
+func TestInMemoryCallbackQueueOrder(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	requestedAt := time.Now().Add(-time.Hour)
+	var ids []string
+	for i, priority := range []string{models.PriorityNormal, models.PriorityHigh, models.PriorityNormal, models.PriorityLow} {
+		callback := &models.Callback{
+			PhoneNumber:  "+1234567890",
+			Priority:     priority,
+			PriorityRank: models.CallbackPriorityRank(priority),
+			Status:       models.StatusRequested,
+		}
+		if err := repo.Callback().Create(ctx, callback); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		repo.callbackRepo.callbacks[callback.ID].RequestedAt = requestedAt.Add(time.Duration(i) * time.Minute)
+		ids = append(ids, callback.ID.Hex())
+	}
+
+	// A callback stored before ranks existed is ranked from its priority
+	legacy := &models.Callback{PhoneNumber: "+1234567890", Priority: "HIGH", Status: models.StatusRequested}
+	if err := repo.Callback().Create(ctx, legacy); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	repo.callbackRepo.callbacks[legacy.ID].RequestedAt = requestedAt.Add(time.Hour)
+
+	queue, err := repo.Callback().FindByStatus(ctx, models.StatusRequested, 4)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := []string{ids[1], legacy.ID.Hex(), ids[0], ids[2]}
+	if len(queue) != len(want) {
+		t.Fatalf("Expected %d callbacks, got %d", len(want), len(queue))
+	}
+	for i, callback := range queue {
+		if callback.ID.Hex() != want[i] {
+			t.Errorf("Expected %s at position %d, got %s (%s)", want[i], i, callback.ID.Hex(), callback.Priority)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package mongo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Phases of establishing the MongoDB connection, reported by ConnectError
+const (
+	// PhaseConnect covers parsing the URI and creating the client
+	PhaseConnect = "connect"
+	// PhasePing covers reaching a server with the created client
+	PhasePing = "ping"
+	// PhaseTLS covers loading the TLS CA and client certificate files
+	PhaseTLS = "tls"
+)
+
+// ConnectError reports which phase of opening the repository failed
+type ConnectError struct {
+	Phase string
+	Err   error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("mongodb %s failed: %v", e.Phase, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// ClientConfig holds the connection settings of the MongoDB client
+type ClientConfig struct {
+	// MaxPoolSize caps the connections per server; 0 keeps the driver default
+	MaxPoolSize uint64
+	// MinPoolSize is the number of idle connections kept open per server
+	MinPoolSize uint64
+	// ConnectTimeout bounds opening each connection, and the initial connect and ping
+	ConnectTimeout time.Duration
+	// SocketTimeout bounds each read or write on a connection; 0 disables it
+	SocketTimeout time.Duration
+	// RetryWrites retries supported writes once after a network error or failover
+	RetryWrites bool
+	// ReconnectInterval is how often an unreachable server is pinged again after
+	// the initial ping fails; 0 gives up instead and returns no repository
+	ReconnectInterval time.Duration
+	// TLSCAFile is a PEM bundle of the CAs trusted to sign the server certificate;
+	// empty uses the system roots
+	TLSCAFile string
+	// TLSCertFile and TLSKeyFile are the PEM client certificate and private key
+	// presented to the server, as required for X.509 authentication
+	TLSCertFile string
+	TLSKeyFile  string
+	// SMSIndexes are created on the sms collection of every database opened;
+	// nil creates DefaultSMSIndexes
+	SMSIndexes []mongo.IndexModel
+}
+
+// DefaultClientConfig returns the default MongoDB client configuration
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		ConnectTimeout: 10 * time.Second,
+		RetryWrites:    true,
+	}
+}
+
+// ClientOption configures the MongoDB client
+type ClientOption func(*ClientConfig)
+
+// WithPoolSize sets the minimum and maximum connection pool sizes
+func WithPoolSize(min, max uint64) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.MinPoolSize = min
+		cfg.MaxPoolSize = max
+	}
+}
+
+// WithConnectTimeout overrides the connect timeout. Non-positive values keep the default.
+func WithConnectTimeout(timeout time.Duration) ClientOption {
+	return func(cfg *ClientConfig) {
+		if timeout > 0 {
+			cfg.ConnectTimeout = timeout
+		}
+	}
+}
+
+// WithSocketTimeout bounds each read or write on a connection
+func WithSocketTimeout(timeout time.Duration) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.SocketTimeout = timeout
+	}
+}
+
+// WithRetryWrites enables or disables retryable writes
+func WithRetryWrites(retry bool) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.RetryWrites = retry
+	}
+}
+
+// WithReconnect keeps the repository when the initial ping fails and pings
+// again every interval until the server answers
+func WithReconnect(interval time.Duration) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.ReconnectInterval = interval
+	}
+}
+
+// WithTLSFiles enables TLS with the given CA bundle and client certificate and
+// key files. Empty paths are skipped; TLS stays off when all three are empty.
+func WithTLSFiles(caFile, certFile, keyFile string) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.TLSCAFile = caFile
+		cfg.TLSCertFile = certFile
+		cfg.TLSKeyFile = keyFile
+	}
+}
+
+// WithSMSIndexes replaces the indexes created on the sms collection, e.g. to
+// add indexes for query patterns of a deployment on top of DefaultSMSIndexes.
+// Indexes created before are left in place.
+func WithSMSIndexes(indexes ...mongo.IndexModel) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.SMSIndexes = indexes
+	}
+}
+
+// clientOptions converts the configuration into driver options
+func (cfg ClientConfig) clientOptions(uri string) (*options.ClientOptions, error) {
+	opts := options.Client().
+		ApplyURI(uri).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetRetryWrites(cfg.RetryWrites)
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 {
+		opts.SetMinPoolSize(cfg.MinPoolSize)
+	}
+	if cfg.SocketTimeout > 0 {
+		opts.SetSocketTimeout(cfg.SocketTimeout)
+	}
+	return opts, nil
+}
+
+// tlsConfig loads the configured TLS files, returning nil when none are set
+func (cfg ClientConfig) tlsConfig() (*tls.Config, error) {
+	if cfg.TLSCAFile == "" && cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		return nil, nil
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, errors.New("TLS client certificate and key files must be set together")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSCAFile != "" {
+		if err := checkFile("CA", cfg.TLSCAFile); err != nil {
+			return nil, err
+		}
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS CA file %s contains no PEM certificates", cfg.TLSCAFile)
+		}
+		config.RootCAs = roots
+	}
+	if cfg.TLSCertFile != "" {
+		if err := checkFile("certificate", cfg.TLSCertFile); err != nil {
+			return nil, err
+		}
+		if err := checkFile("key", cfg.TLSKeyFile); err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS client certificate %s with key %s: %w", cfg.TLSCertFile, cfg.TLSKeyFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// checkFile reports a missing or unreadable TLS file by its role and path
+func checkFile(role, path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("TLS %s file %s does not exist", role, path)
+	}
+	if err != nil {
+		return fmt.Errorf("TLS %s file: %w", role, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("TLS %s file %s is a directory", role, path)
+	}
+	return nil
+}
+
+// ping checks that a server answers within the connect timeout
+func ping(client *mongo.Client, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return client.Ping(ctx, nil)
+}
+
+// reconnect pings the server every interval until it answers or the repository
+// is closed, then creates the indexes that could not be created at startup.
+// The driver reconnects on its own once a server has been reached.
+func (r *Repository) reconnect(interval, pingTimeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-ticker.C:
+		}
+
+		if err := ping(r.client, pingTimeout); err != nil {
+			log.Printf("MongoDB still unreachable: %v", err)
+			continue
+		}
+
+		r.setupIndexes()
+		r.connected.Store(true)
+		log.Println("MongoDB connection established")
+		return
+	}
+}
+
+// Connected reports whether the server has answered a ping. It is false only
+// while a repository opened WithReconnect is still waiting for its server.
+func (r *Repository) Connected() bool {
+	return r.connected.Load()
+}
@@ -0,0 +1,164 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoDB error codes returned when creating indexes
+const (
+	// codeIndexAlreadyExists: an index with the same name and options exists
+	codeIndexAlreadyExists = 68
+	// codeIndexOptionsConflict: an index on the same keys exists with other options
+	codeIndexOptionsConflict = 85
+	// codeIndexKeySpecsConflict: an index with the same name exists on other keys
+	codeIndexKeySpecsConflict = 86
+)
+
+// createIndex creates an index, treating an equivalent existing index as
+// success. Any other failure, including an existing index on the same keys
+// with different options, is returned.
+func createIndex(ctx context.Context, collection *mongo.Collection, model mongo.IndexModel) error {
+	_, err := collection.Indexes().CreateOne(ctx, model)
+	if err != nil && !hasErrorCode(err, codeIndexAlreadyExists) {
+		return fmt.Errorf("index %v on %s: %w", model.Keys, collection.Name(), err)
+	}
+	return nil
+}
+
+// createIndexSet creates every index of a collection, returning all failures
+// rather than stopping at the first one
+func createIndexSet(ctx context.Context, collection *mongo.Collection, models ...mongo.IndexModel) error {
+	var errs []error
+	for _, model := range models {
+		errs = append(errs, createIndex(ctx, collection, model))
+	}
+	return errors.Join(errs...)
+}
+
+// ensureIndex creates an index, re-creating it when an index on the same keys
+// exists with options that drifted, e.g. a changed TTL. Only the index on
+// exactly these keys is dropped, never a compound index that merely starts
+// with them.
+func ensureIndex(ctx context.Context, collection *mongo.Collection, model mongo.IndexModel) error {
+	err := createIndex(ctx, collection, model)
+	if !hasErrorCode(err, codeIndexOptionsConflict, codeIndexKeySpecsConflict) {
+		return err
+	}
+
+	keys, ok := model.Keys.(bson.D)
+	if !ok {
+		return err
+	}
+	name, findErr := indexNameByKeys(ctx, collection, keys)
+	if findErr != nil {
+		return fmt.Errorf("index %v on %s has drifted and could not be looked up: %w", model.Keys, collection.Name(), findErr)
+	}
+	if name != "" {
+		if _, dropErr := collection.Indexes().DropOne(ctx, name); dropErr != nil {
+			return fmt.Errorf("index %s on %s has drifted and could not be dropped: %w", name, collection.Name(), dropErr)
+		}
+	}
+	return createIndex(ctx, collection, model)
+}
+
+// ParseIndexKeys parses a semicolon-separated list of index key patterns, each
+// a comma-separated list of field:direction pairs with a direction of 1 or -1,
+// e.g. "to:1,created_at:-1;status:1,created_at:-1"
+func ParseIndexKeys(value string) ([]mongo.IndexModel, error) {
+	var indexes []mongo.IndexModel
+	for _, pattern := range strings.Split(value, ";") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		var keys bson.D
+		for _, pair := range strings.Split(pattern, ",") {
+			field, direction, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			field = strings.TrimSpace(field)
+			if !ok || field == "" {
+				return nil, fmt.Errorf("invalid index key %q, expected field:direction", pair)
+			}
+			switch strings.TrimSpace(direction) {
+			case "1":
+				keys = append(keys, bson.E{Key: field, Value: 1})
+			case "-1":
+				keys = append(keys, bson.E{Key: field, Value: -1})
+			default:
+				return nil, fmt.Errorf("invalid direction of index key %q, expected 1 or -1", pair)
+			}
+		}
+		indexes = append(indexes, mongo.IndexModel{Keys: keys})
+	}
+	return indexes, nil
+}
+
+// indexNameByKeys finds the name of the index on exactly keys, or "" when there is none
+func indexNameByKeys(ctx context.Context, collection *mongo.Collection, keys bson.D) (string, error) {
+	specs, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, spec := range specs {
+		var specKeys bson.D
+		if err := bson.Unmarshal(spec.KeysDocument, &specKeys); err != nil {
+			continue
+		}
+		if sameIndexKeys(specKeys, keys) {
+			return spec.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// sameIndexKeys reports whether two key patterns index the same fields in the
+// same order and direction. The server may return directions as any numeric
+// type, so they are compared by value.
+func sameIndexKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || fmt.Sprint(indexDirection(a[i].Value)) != fmt.Sprint(indexDirection(b[i].Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexDirection normalizes a numeric key direction to a float64; other
+// values, such as "text", are returned unchanged
+func indexDirection(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return value
+	}
+}
+
+// hasErrorCode reports whether err is a server error with any of codes
+func hasErrorCode(err error, codes ...int) bool {
+	var serverErr mongo.ServerError
+	if err == nil || !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range codes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
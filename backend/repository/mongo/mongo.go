@@ -2,6 +2,12 @@ package mongo
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -9,6 +15,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"sms-app-backend/common"
 	"sms-app-backend/models"
 	"sms-app-backend/repository"
 )
@@ -18,41 +25,184 @@ type Repository struct {
 	client       *mongo.Client
 	database     *mongo.Database
 	otpRepo      *OTPRepository
+	otpSendRepo  *OTPSendRepository
+	quotaRepo    *SMSQuotaRepository
+	suppressRepo *SuppressionRepository
+	flaggedRepo  *FlaggedPhoneRepository
 	smsRepo      *SMSRepository
 	userRepo     *UserRepository
 	callbackRepo *CallbackRepository
+	auditRepo    *AuditRepository
+	// timeout bounds every database operation, including index creation and disconnecting
+	timeout      time.Duration
+	// shared repositories use another repository's client and leave closing it to the owner
+	shared       bool
+	// connected is false while the server has not answered a ping yet
+	connected    atomic.Bool
+	closed       chan struct{}
+	closeOnce    sync.Once
 }
 
-// NewRepository creates a new MongoDB repository
-func NewRepository(uri, dbName string) (*Repository, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// DefaultTimeout is the operation timeout used when none is configured
+const DefaultTimeout = 5 * time.Second
+
+// NewRepository creates a new MongoDB repository. Every operation is bounded by
+// timeout (DefaultTimeout when non-positive) on top of the caller's context.
+// Failures are reported as a *ConnectError naming the phase that failed. When
+// the server can't be reached and WithReconnect is set, the repository is
+// returned along with the error and keeps retrying in the background.
+func NewRepository(uri, dbName string, timeout time.Duration, opts ...ClientOption) (*Repository, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	cfg := DefaultClientConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	clientOpts, err := cfg.clientOptions(uri)
 	if err != nil {
-		return nil, err
+		return nil, &ConnectError{Phase: PhaseTLS, Err: err}
 	}
 
-	// Test the connection
-	err = client.Ping(ctx, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
-		return nil, err
+		return nil, &ConnectError{Phase: PhaseConnect, Err: err}
+	}
+
+	if err := ping(client, cfg.ConnectTimeout); err != nil {
+		if cfg.ReconnectInterval <= 0 {
+			client.Disconnect(ctx)
+			return nil, &ConnectError{Phase: PhasePing, Err: err}
+		}
+
+		repo := newRepository(client, dbName, timeout, cfg.SMSIndexes)
+		repo.connected.Store(false)
+		go repo.reconnect(cfg.ReconnectInterval, cfg.ConnectTimeout)
+		return repo, &ConnectError{Phase: PhasePing, Err: err}
 	}
 
+	repo := newRepository(client, dbName, timeout, cfg.SMSIndexes)
+	repo.setupIndexes()
+	return repo, nil
+}
+
+// newRepository creates a repository bound to one database of a connected
+// client, creating smsIndexes on its sms collection (nil for the defaults)
+func newRepository(client *mongo.Client, dbName string, timeout time.Duration, smsIndexes []mongo.IndexModel) *Repository {
 	database := client.Database(dbName)
 
 	repo := &Repository{
 		client:   client,
 		database: database,
+		timeout:  timeout,
+		closed:   make(chan struct{}),
+	}
+	repo.connected.Store(true)
+
+	// Initialize sub-repositories; indexes are created separately so a
+	// repository can be built before its server is reachable
+	repo.otpRepo = &OTPRepository{collection: database.Collection("otps"), timeout: timeout}
+	repo.otpSendRepo = &OTPSendRepository{collection: database.Collection("otp_sends"), timeout: timeout}
+	repo.quotaRepo = &SMSQuotaRepository{collection: database.Collection("sms_quotas"), timeout: timeout}
+	repo.suppressRepo = &SuppressionRepository{collection: database.Collection("suppressions"), timeout: timeout}
+	repo.flaggedRepo = &FlaggedPhoneRepository{collection: database.Collection("flagged_phones"), timeout: timeout}
+	repo.smsRepo = &SMSRepository{collection: database.Collection("sms"), timeout: timeout, indexes: smsIndexes}
+	repo.userRepo = &UserRepository{collection: database.Collection("users"), timeout: timeout}
+	repo.callbackRepo = &CallbackRepository{collection: database.Collection("callbacks"), timeout: timeout}
+	repo.auditRepo = &AuditRepository{collection: database.Collection("audit"), timeout: timeout}
+
+	return repo
+}
+
+// createIndexes creates the indexes of every collection, returning every failure
+func (r *Repository) createIndexes() error {
+	return errors.Join(
+		r.otpRepo.createIndexes(),
+		r.otpSendRepo.createIndexes(),
+		r.quotaRepo.createIndexes(),
+		r.suppressRepo.createIndexes(),
+		r.flaggedRepo.createIndexes(),
+		r.smsRepo.createIndexes(),
+		r.userRepo.createIndexes(),
+		r.callbackRepo.createIndexes(),
+		r.auditRepo.createIndexes(),
+	)
+}
+
+// setupIndexes creates the indexes of every collection and reports every
+// index that could not be created, since queries relying on it fall back to
+// scanning the whole collection
+func (r *Repository) setupIndexes() {
+	if err := r.createIndexes(); err != nil {
+		log.Printf("Warning: index setup of database %s failed, affected queries will scan whole collections:\n%v", r.database.Name(), err)
 	}
+}
 
-	// Initialize sub-repositories
-	repo.otpRepo = NewOTPRepository(database)
-	repo.smsRepo = NewSMSRepository(database)
-	repo.userRepo = NewUserRepository(database)
-	repo.callbackRepo = NewCallbackRepository(database)
+// TenantFactory implements repository.RepositoryFactory with a database per
+// registered tenant, named "<prefix>_<tenant ID>", all sharing one client
+// connection
+type TenantFactory struct {
+	base    *Repository
+	prefix  string
+	tenants map[string]bool
 
-	return repo, nil
+	mu    sync.Mutex
+	repos map[string]*Repository
+}
+
+// NewTenantFactory creates a factory opening the databases of the registered
+// tenants on base's client. Other tenant IDs are rejected, so requests can't
+// create databases.
+func NewTenantFactory(base *Repository, prefix string, tenantIDs ...string) *TenantFactory {
+	tenants := make(map[string]bool, len(tenantIDs))
+	for _, tenantID := range tenantIDs {
+		tenants[tenantID] = true
+	}
+	return &TenantFactory{base: base, prefix: prefix, tenants: tenants, repos: make(map[string]*Repository)}
+}
+
+// ForTenant returns the tenant's repository, creating its indexes on first use
+func (f *TenantFactory) ForTenant(ctx context.Context, tenantID string) (repository.Repository, error) {
+	if !repository.IsValidTenantID(tenantID) {
+		return nil, repository.ErrInvalidTenant
+	}
+	if !f.tenants[tenantID] {
+		return nil, repository.ErrUnknownTenant
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open(tenantID), nil
+}
+
+// Repositories returns the repository of every registered tenant, opening
+// those not used since startup
+func (f *TenantFactory) Repositories() []repository.Repository {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	repos := make([]repository.Repository, 0, len(f.tenants))
+	for tenantID := range f.tenants {
+		repos = append(repos, f.open(tenantID))
+	}
+	return repos
+}
+
+// open returns the cached repository of a registered tenant, opening it on
+// first use; the caller holds the lock
+func (f *TenantFactory) open(tenantID string) *Repository {
+	repo, ok := f.repos[tenantID]
+	if !ok {
+		repo = newRepository(f.base.client, f.prefix+"_"+tenantID, f.base.timeout, f.base.smsRepo.indexes)
+		repo.shared = true
+		repo.setupIndexes()
+		f.repos[tenantID] = repo
+	}
+	return repo
 }
 
 // OTP returns the OTP repository
@@ -60,6 +210,26 @@ func (r *Repository) OTP() repository.OTPRepository {
 	return r.otpRepo
 }
 
+// OTPSends returns the OTP send counter repository
+func (r *Repository) OTPSends() repository.OTPSendRepository {
+	return r.otpSendRepo
+}
+
+// SMSQuotas returns the monthly SMS quota counter repository
+func (r *Repository) SMSQuotas() repository.SMSQuotaRepository {
+	return r.quotaRepo
+}
+
+// Suppressions returns the opt-out suppression list repository
+func (r *Repository) Suppressions() repository.SuppressionRepository {
+	return r.suppressRepo
+}
+
+// FlaggedPhones returns the repository of phone numbers flagged for brute-force attacks
+func (r *Repository) FlaggedPhones() repository.FlaggedPhoneRepository {
+	return r.flaggedRepo
+}
+
 // SMS returns the SMS repository
 func (r *Repository) SMS() repository.SMSRepository {
 	return r.smsRepo
@@ -75,51 +245,104 @@ func (r *Repository) Callback() repository.CallbackRepository {
 	return r.callbackRepo
 }
 
-// Close closes the MongoDB connection
+// Audit returns the audit log repository
+func (r *Repository) Audit() repository.AuditRepository {
+	return r.auditRepo
+}
+
+// Close closes the MongoDB connection, unless it is shared with the repository that owns it
 func (r *Repository) Close() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if r.shared {
+		return nil
+	}
+	r.closeOnce.Do(func() { close(r.closed) })
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
 	return r.client.Disconnect(ctx)
 }
 
+// phoneSearchFilter builds a filter matching phone numbers by prefix or suffix.
+// Prefix searches use an anchored regex so the phone index can be used; suffix
+// searches go through the indexed last-4 field since Mongo can't index a trailing match;
+// without a last-4 field they fall back to a trailing regex.
+func phoneSearchFilter(phoneField, last4Field, query string, suffix bool) bson.M {
+	if !suffix {
+		return bson.M{phoneField: bson.M{"$regex": "^" + regexp.QuoteMeta(query)}}
+	}
+
+	if last4Field == "" {
+		return bson.M{phoneField: bson.M{"$regex": regexp.QuoteMeta(query) + "$"}}
+	}
+	if len(query) < 4 {
+		return bson.M{last4Field: bson.M{"$regex": regexp.QuoteMeta(query) + "$"}}
+	}
+	return bson.M{
+		last4Field: common.PhoneLast4(query),
+		phoneField: bson.M{"$regex": regexp.QuoteMeta(query) + "$"},
+	}
+}
+
 // OTPRepository implements repository.OTPRepository
 type OTPRepository struct {
 	collection *mongo.Collection
+	timeout    time.Duration
 }
 
 // NewOTPRepository creates a new OTP repository
-func NewOTPRepository(db *mongo.Database) *OTPRepository {
-	collection := db.Collection("otps")
-	
-	// Create indexes
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func NewOTPRepository(db *mongo.Database, timeout time.Duration) *OTPRepository {
+	repo := &OTPRepository{collection: db.Collection("otps"), timeout: timeout}
+	if err := repo.createIndexes(); err != nil {
+		log.Printf("Warning: index setup failed: %v", err)
+	}
+	return repo
+}
+
+// createIndexes creates the indexes of the otps collection, re-creating a TTL
+// index whose options drifted, and returns every index that could not be created
+func (r *OTPRepository) createIndexes() error {
+	collection := r.collection
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
 	
-	// Index on phone number
-	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "phone", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
-	if err != nil {
-		// Index might already exist
-	}
+	err := createIndexSet(ctx, collection,
+		// Index on phone number
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "phone", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		// Index on the last four digits for suffix search
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "phone_last4", Value: 1}},
+		},
+	)
 
-	// Index on expiry for cleanup
-	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+	// Expire OTPs at their expires_at; an index left with another expiry,
+	// e.g. by an older release, is re-created so OTPs don't outlive it
+	ttlErr := ensureIndex(ctx, collection, mongo.IndexModel{
 		Keys: bson.D{{Key: "expires_at", Value: 1}},
 		Options: options.Index().SetExpireAfterSeconds(0),
 	})
-	if err != nil {
-		// Index might already exist
+
+	// The TTL index skips documents without an expires_at date; those are
+	// only removed by the OTP lifetime sweep
+	missing, countErr := collection.CountDocuments(ctx, bson.M{"expires_at": bson.M{"$not": bson.M{"$type": "date"}}})
+	if countErr != nil {
+		log.Printf("Failed to check OTPs for an expires_at date: %v", countErr)
+	} else if missing > 0 {
+		log.Printf("Warning: %d OTPs have no expires_at date and never expire through the TTL index", missing)
 	}
 
-	return &OTPRepository{collection: collection}
+	return errors.Join(err, ttlErr)
 }
 
 // Create stores a new OTP
 func (r *OTPRepository) Create(ctx context.Context, otp *models.OTP) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	otp.CreatedAt = time.Now()
 	otp.UpdatedAt = time.Now()
+	otp.PhoneLast4 = common.PhoneLast4(otp.Phone)
 	
 	result, err := r.collection.InsertOne(ctx, otp)
 	if err != nil {
@@ -132,8 +355,14 @@ func (r *OTPRepository) Create(ctx context.Context, otp *models.OTP) error {
 
 // FindByPhone finds an OTP by phone number
 func (r *OTPRepository) FindByPhone(ctx context.Context, phone string) (*models.OTP, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	var otp models.OTP
 	err := r.collection.FindOne(ctx, bson.M{"phone": phone}).Decode(&otp)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +371,9 @@ func (r *OTPRepository) FindByPhone(ctx context.Context, phone string) (*models.
 
 // Update updates an existing OTP
 func (r *OTPRepository) Update(ctx context.Context, otp *models.OTP) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	otp.UpdatedAt = time.Now()
 	
 	_, err := r.collection.UpdateOne(
@@ -154,60 +386,62 @@ func (r *OTPRepository) Update(ctx context.Context, otp *models.OTP) error {
 
 // Delete deletes an OTP by ID
 func (r *OTPRepository) Delete(ctx context.Context, id string) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return err
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
 	}
 	
-	_, err = r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
 	return err
 }
 
 // CallbackRepository implements repository.CallbackRepository
 type CallbackRepository struct {
 	collection *mongo.Collection
+	timeout    time.Duration
 }
 
 // NewCallbackRepository creates a new callback repository
-func NewCallbackRepository(db *mongo.Database) *CallbackRepository {
-	collection := db.Collection("callbacks")
-	
-	// Create indexes
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	
-	// Index on phone number
-	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "phone_number", Value: 1}},
-	})
-	if err != nil {
-		// Index might already exist
-	}
-
-	// Index on status
-	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "status", Value: 1}},
-	})
-	if err != nil {
-		// Index might already exist
-	}
-
-	// Index on requested_at for sorting
-	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "requested_at", Value: -1}},
-	})
-	if err != nil {
-		// Index might already exist
+func NewCallbackRepository(db *mongo.Database, timeout time.Duration) *CallbackRepository {
+	repo := &CallbackRepository{collection: db.Collection("callbacks"), timeout: timeout}
+	if err := repo.createIndexes(); err != nil {
+		log.Printf("Warning: index setup failed: %v", err)
 	}
+	return repo
+}
 
-	return &CallbackRepository{collection: collection}
+// createIndexes creates the indexes of the callbacks collection, returning every
+// index that could not be created
+func (r *CallbackRepository) createIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	
+	return createIndexSet(ctx, r.collection,
+		// Index on phone number
+		mongo.IndexModel{Keys: bson.D{{Key: "phone_number", Value: 1}}},
+		// Index on status
+		mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}}},
+		// Index on requested_at and ID for sorting and cursor paging
+		mongo.IndexModel{Keys: newestFirst("requested_at")},
+		// Index on the last four digits for suffix search
+		mongo.IndexModel{Keys: bson.D{{Key: "phone_last4", Value: 1}}},
+		// Index on status in queue order for FindByStatus
+		mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "priority_rank", Value: -1}, {Key: "requested_at", Value: 1}}},
+	)
 }
 
 // Create stores a new callback request
 func (r *CallbackRepository) Create(ctx context.Context, callback *models.Callback) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	callback.CreatedAt = time.Now()
 	callback.UpdatedAt = time.Now()
 	callback.RequestedAt = time.Now()
+	callback.PhoneLast4 = common.PhoneLast4(callback.PhoneNumber)
 	
 	result, err := r.collection.InsertOne(ctx, callback)
 	if err != nil {
@@ -220,13 +454,19 @@ func (r *CallbackRepository) Create(ctx context.Context, callback *models.Callba
 
 // FindByID finds a callback by ID
 func (r *CallbackRepository) FindByID(ctx context.Context, id string) (*models.Callback, error) {
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return nil, appErr
 	}
 	
 	var callback models.Callback
-	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&callback)
+	err := r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&callback)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -235,6 +475,9 @@ func (r *CallbackRepository) FindByID(ctx context.Context, id string) (*models.C
 
 // FindByPhone finds callback requests by phone number
 func (r *CallbackRepository) FindByPhone(ctx context.Context, phone string, limit int) ([]*models.Callback, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	opts := options.Find().SetSort(bson.D{{Key: "requested_at", Value: -1}}).SetLimit(int64(limit))
 	
 	cursor, err := r.collection.Find(ctx, bson.M{"phone_number": phone}, opts)
@@ -251,26 +494,102 @@ func (r *CallbackRepository) FindByPhone(ctx context.Context, phone string, limi
 	return callbacks, nil
 }
 
-// UpdateStatus updates the status of a callback
-func (r *CallbackRepository) UpdateStatus(ctx context.Context, id string, status string) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
+// FindByPhoneBetween finds callback requests for phone requested within [from, to), newest first
+func (r *CallbackRepository) FindByPhoneBetween(ctx context.Context, phone string, from, to time.Time, limit int) ([]*models.Callback, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	filter := rangeFilter("requested_at", from, to)
+	filter["phone_number"] = phone
+	opts := options.Find().SetSort(bson.D{{Key: "requested_at", Value: -1}}).SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	
-	_, err = r.collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objectID},
-		bson.M{"$set": bson.M{"status": status, "updated_at": time.Now()}},
-	)
-	return err
+	defer cursor.Close(ctx)
+
+	var callbacks []*models.Callback
+	if err = cursor.All(ctx, &callbacks); err != nil {
+		return nil, err
+	}
+	return callbacks, nil
 }
 
-// FindByStatus finds callback requests by status
-func (r *CallbackRepository) FindByStatus(ctx context.Context, status string, limit int) ([]*models.Callback, error) {
-	opts := options.Find().SetSort(bson.D{{Key: "requested_at", Value: -1}}).SetLimit(int64(limit))
+// DeleteOlderThan deletes callback requests created before t and returns how many were deleted
+func (r *CallbackRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"created_at": bson.M{"$lt": t}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// DeleteByPhones deletes the callbacks of phones and returns their IDs
+func (r *CallbackRepository) DeleteByPhones(ctx context.Context, phones []string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return deleteReturningIDs(ctx, r.collection, bson.M{"phone_number": bson.M{"$in": phones}})
+}
+
+// UpdateStatus updates the status of a callback
+func (r *CallbackRepository) UpdateStatus(ctx context.Context, id string, status models.Status) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
+	}
 	
-	cursor, err := r.collection.Find(ctx, bson.M{"status": status}, opts)
+	return updateStatus(ctx, r.collection, objectID, models.CallbackTransitions, status,
+		bson.M{"status": status, "updated_at": time.Now()})
+}
+
+// MarkAnswered moves a callback request to in progress and stores the UUID of its call
+func (r *CallbackRepository) MarkAnswered(ctx context.Context, id, callUUID string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
+	}
+
+	return updateStatus(ctx, r.collection, objectID, models.CallbackTransitions, models.StatusInProgress,
+		bson.M{"status": models.StatusInProgress, "call_uuid": callUUID, "updated_at": time.Now()})
+}
+
+// FindByStatus finds callback requests by status in queue order: highest
+// priority first, oldest first within a priority. Callbacks stored before
+// they had a rank are ranked from their priority, as CallbackPriorityRank does.
+func (r *CallbackRepository) FindByStatus(ctx context.Context, status models.Status, limit int) ([]*models.Callback, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	priority := bson.M{"$toLower": bson.M{"$trim": bson.M{"input": bson.M{"$ifNull": bson.A{"$priority", ""}}}}}
+	rank := bson.M{"$ifNull": bson.A{"$priority_rank", bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": bson.M{"$eq": bson.A{priority, models.PriorityHigh}}, "then": models.CallbackPriorityRank(models.PriorityHigh)},
+			bson.M{"case": bson.M{"$eq": bson.A{priority, models.PriorityLow}}, "then": models.CallbackPriorityRank(models.PriorityLow)},
+		},
+		"default": models.CallbackPriorityRank(models.PriorityNormal),
+	}}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": status}}},
+		{{Key: "$addFields", Value: bson.M{"queue_rank": rank}}},
+		{{Key: "$sort", Value: bson.D{{Key: "queue_rank", Value: -1}, {Key: "requested_at", Value: 1}}}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.M{"queue_rank": 0}}})
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -285,11 +604,45 @@ func (r *CallbackRepository) FindByStatus(ctx context.Context, status string, li
 	return callbacks, nil
 }
 
-// FindAll finds all callback requests with a limit
-func (r *CallbackRepository) FindAll(ctx context.Context, limit int) ([]*models.Callback, error) {
+// DailyCounts counts callback requests created within [from, to) per UTC day
+func (r *CallbackRepository) DailyCounts(ctx context.Context, from, to time.Time) ([]models.DailyCount, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return aggregateDaily(ctx, r.collection, dailyPipeline(rangeFilter("created_at", from, to),
+		[]models.Status{models.StatusCompleted},
+		[]models.Status{models.StatusFailed, models.StatusCancelled},
+	))
+}
+
+// SearchByPhone finds callback requests whose phone number starts or ends with the query
+func (r *CallbackRepository) SearchByPhone(ctx context.Context, query string, suffix bool, limit int) ([]*models.Callback, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	opts := options.Find().SetSort(bson.D{{Key: "requested_at", Value: -1}}).SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, phoneSearchFilter("phone_number", "phone_last4", query, suffix), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var callbacks []*models.Callback
+	if err = cursor.All(ctx, &callbacks); err != nil {
+		return nil, err
+	}
+	return callbacks, nil
+}
+
+// FindAll finds callback requests, newest first, after the cursor (if set)
+func (r *CallbackRepository) FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.Callback, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(newestFirst("requested_at")).SetLimit(int64(limit))
 	
-	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	cursor, err := r.collection.Find(ctx, beforeFilter("requested_at", before), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -302,14 +655,62 @@ func (r *CallbackRepository) FindAll(ctx context.Context, limit int) ([]*models.
 	return callbacks, nil
 }
 
+// Count counts callback requests after the cursor (if set)
+func (r *CallbackRepository) Count(ctx context.Context, before models.LogCursor) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.collection.CountDocuments(ctx, beforeFilter("requested_at", before))
+}
+
+// Stream calls fn for each OTP created within the range, oldest first
+func (r *OTPRepository) Stream(ctx context.Context, from, to time.Time, fn func(*models.OTP) error) error {
+	return stream(ctx, r.collection, r.timeout, "created_at", rangeFilter("created_at", from, to), func(cursor *mongo.Cursor) error {
+		var otp models.OTP
+		if err := cursor.Decode(&otp); err != nil {
+			return err
+		}
+		return fn(&otp)
+	})
+}
+
+// Stream calls fn for each callback requested within the range, oldest first
+func (r *CallbackRepository) Stream(ctx context.Context, from, to time.Time, fn func(*models.Callback) error) error {
+	return stream(ctx, r.collection, r.timeout, "requested_at", rangeFilter("requested_at", from, to), func(cursor *mongo.Cursor) error {
+		var callback models.Callback
+		if err := cursor.Decode(&callback); err != nil {
+			return err
+		}
+		return fn(&callback)
+	})
+}
+
+// DeleteOlderThan deletes OTPs created before t and returns how many were deleted
+func (r *OTPRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"created_at": bson.M{"$lt": t}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
 // DeleteByPhone deletes an OTP by phone number
 func (r *OTPRepository) DeleteByPhone(ctx context.Context, phone string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	_, err := r.collection.DeleteOne(ctx, bson.M{"phone": phone})
 	return err
 }
 
 // FindExpired finds all expired OTPs
 func (r *OTPRepository) FindExpired(ctx context.Context) ([]*models.OTP, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	cursor, err := r.collection.Find(ctx, bson.M{"expires_at": bson.M{"$lt": time.Now()}})
 	if err != nil {
 		return nil, err
@@ -324,11 +725,14 @@ func (r *OTPRepository) FindExpired(ctx context.Context) ([]*models.OTP, error)
 	return otps, nil
 }
 
-// FindAll finds all OTPs with a limit
-func (r *OTPRepository) FindAll(ctx context.Context, limit int) ([]*models.OTP, error) {
-	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+// FindAll finds OTPs, newest first, after the cursor (if set)
+func (r *OTPRepository) FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.OTP, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(newestFirst("created_at")).SetLimit(int64(limit))
 	
-	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	cursor, err := r.collection.Find(ctx, beforeFilter("created_at", before), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -341,83 +745,1067 @@ func (r *OTPRepository) FindAll(ctx context.Context, limit int) ([]*models.OTP,
 	return otps, nil
 }
 
-// IncrementAttempts increments the attempt counter for a phone number
-func (r *OTPRepository) IncrementAttempts(ctx context.Context, phone string) error {
-	_, err := r.collection.UpdateOne(
-		ctx,
-		bson.M{"phone": phone},
-		bson.M{"$inc": bson.M{"attempts": 1}, "$set": bson.M{"updated_at": time.Now()}},
-	)
-	return err
-}
+// Count counts OTPs after the cursor (if set)
+func (r *OTPRepository) Count(ctx context.Context, before models.LogCursor) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
 
-// SMSRepository implements repository.SMSRepository
-type SMSRepository struct {
-	collection *mongo.Collection
+	return r.collection.CountDocuments(ctx, beforeFilter("created_at", before))
 }
 
-// NewSMSRepository creates a new SMS repository
-func NewSMSRepository(db *mongo.Database) *SMSRepository {
-	collection := db.Collection("sms")
-	
-	// Create indexes
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// SearchByPhone finds OTPs whose phone number starts or ends with the query
+func (r *OTPRepository) SearchByPhone(ctx context.Context, query string, suffix bool, limit int) ([]*models.OTP, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
 	defer cancel()
-	
-	// Index on phone numbers
-	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "to", Value: 1}},
-	})
-	if err != nil {
-		// Index might already exist
-	}
 
-	// Index on status
-	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "status", Value: 1}},
-	})
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, phoneSearchFilter("phone", "phone_last4", query, suffix), opts)
 	if err != nil {
-		// Index might already exist
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	return &SMSRepository{collection: collection}
+	var otps []*models.OTP
+	if err = cursor.All(ctx, &otps); err != nil {
+		return nil, err
+	}
+	return otps, nil
 }
 
-// Create stores a new SMS
-func (r *SMSRepository) Create(ctx context.Context, sms *models.SMS) error {
-	sms.CreatedAt = time.Now()
-	sms.UpdatedAt = time.Now()
-	sms.SentAt = time.Now()
-	
-	result, err := r.collection.InsertOne(ctx, sms)
+// IncrementAttempts increments the attempt counter for a phone number unless
+// the OTP used all its attempts. The filter only matches an OTP with attempts
+// left, so concurrent verifications can't count past MaxAttempts.
+func (r *OTPRepository) IncrementAttempts(ctx context.Context, phone string) (int, bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var otp models.OTP
+	err := r.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"phone": phone,
+			"$expr": bson.M{"$lt": bson.A{"$attempts", "$max_attempts"}},
+		},
+		bson.M{"$inc": bson.M{"attempts": 1}, "$set": bson.M{"updated_at": time.Now()}},
+		opts,
+	).Decode(&otp)
+	if err == mongo.ErrNoDocuments {
+		return 0, false, nil
+	}
 	if err != nil {
-		return err
+		return 0, false, err
 	}
-	
-	sms.ID = result.InsertedID.(primitive.ObjectID)
-	return nil
+	return otp.Attempts, true, nil
 }
 
-// FindByID finds an SMS by ID
-func (r *SMSRepository) FindByID(ctx context.Context, id string) (*models.SMS, error) {
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
+// ReserveResend counts a resend of an OTP and records when it was sent. The
+// filter only matches an unverified OTP with resends left whose last send is
+// at least as old as sentBefore, so concurrent resends can't pass the cap or
+// the cooldown.
+func (r *OTPRepository) ReserveResend(ctx context.Context, id string, maxResends int, sentBefore, now time.Time) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return false, appErr
 	}
-	
-	var sms models.SMS
-	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&sms)
+
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":          objectID,
+			"verified":     bson.M{"$ne": true},
+			"resend_count": bson.M{"$lt": maxResends},
+			"$or": bson.A{
+				bson.M{"last_sent_at": bson.M{"$lte": sentBefore}},
+				bson.M{"last_sent_at": bson.M{"$exists": false}, "created_at": bson.M{"$lte": sentBefore}},
+			},
+		},
+		bson.M{
+			"$inc": bson.M{"resend_count": 1},
+			"$set": bson.M{"last_sent_at": now, "updated_at": time.Now()},
+		},
+	)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	return &sms, nil
+	return result.ModifiedCount > 0, nil
 }
 
-// FindByPhone finds SMS messages by phone number
-func (r *SMSRepository) FindByPhone(ctx context.Context, phone string, limit int) ([]*models.SMS, error) {
+// ReleaseResend takes back a resend counted by ReserveResend and restores the
+// previous send time, removing it when the OTP had never been resent
+func (r *OTPRepository) ReleaseResend(ctx context.Context, id string, lastSentAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"resend_count": -1},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+	if lastSentAt.IsZero() {
+		update["$unset"] = bson.M{"last_sent_at": ""}
+	} else {
+		update["$set"] = bson.M{"last_sent_at": lastSentAt, "updated_at": time.Now()}
+	}
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": objectID, "resend_count": bson.M{"$gt": 0}}, update)
+	return err
+}
+
+// ResetAttempts sets the attempts of the OTP of a phone number back to 0 and lifts its lockout
+func (r *OTPRepository) ResetAttempts(ctx context.Context, phone string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"phone": phone},
+		bson.M{
+			"$set":   bson.M{"attempts": 0, "updated_at": time.Now()},
+			"$unset": bson.M{"locked_until": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// otpSendRetention is how long daily OTP send counters are kept. They back the
+// per-phone daily limit and a year of daily analytics.
+const otpSendRetention = 400 * 24 * time.Hour
+
+// OTPSendRepository implements repository.OTPSendRepository
+type OTPSendRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// NewOTPSendRepository creates a new OTP send counter repository
+func NewOTPSendRepository(db *mongo.Database, timeout time.Duration) *OTPSendRepository {
+	repo := &OTPSendRepository{collection: db.Collection("otp_sends"), timeout: timeout}
+	if err := repo.createIndexes(); err != nil {
+		log.Printf("Warning: index setup failed: %v", err)
+	}
+	return repo
+}
+
+// createIndexes creates the indexes of the otp_sends collection, returning every
+// index that could not be created
+func (r *OTPSendRepository) createIndexes() error {
+	collection := r.collection
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	// One counter document per phone number and day
+	err := createIndex(ctx, collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "phone", Value: 1}, {Key: "day", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	// Expire old daily buckets once they fall out of the analytics range
+	expireAfter := int32(otpSendRetention.Seconds())
+	ttlErr := createIndex(ctx, collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(expireAfter),
+	})
+	if hasErrorCode(ttlErr, codeIndexOptionsConflict) {
+		// The index exists with the former two-day expiry; update it in place
+		ttlErr = collection.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection.Name()},
+			{Key: "index", Value: bson.D{
+				{Key: "keyPattern", Value: bson.D{{Key: "created_at", Value: 1}}},
+				{Key: "expireAfterSeconds", Value: expireAfter},
+			}},
+		}).Err()
+		if ttlErr != nil {
+			ttlErr = fmt.Errorf("updating the expiry of index created_at_1 on %s: %w", collection.Name(), ttlErr)
+		}
+	}
+
+	return errors.Join(err, ttlErr)
+}
+
+// Increment atomically increments the send counter for a phone number on a day and returns the new count
+func (r *OTPSendRepository) Increment(ctx context.Context, phone, day string) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter models.OTPSendCounter
+	err := r.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"phone": phone, "day": day},
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$set":         bson.M{"updated_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		opts,
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Count, nil
+}
+
+// IncrementBelow atomically increments the send counter for a phone number on
+// a day unless it already reached limit. The filter only matches a counter
+// below the limit, so concurrent sends can't push it past the limit; at the
+// limit the upsert collides with the existing counter on the unique index.
+func (r *OTPSendRepository) IncrementBelow(ctx context.Context, phone, day string, limit int) (int, bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter models.OTPSendCounter
+	err := r.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"phone": phone, "day": day, "count": bson.M{"$lt": limit}},
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$set":         bson.M{"updated_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		opts,
+	).Decode(&counter)
+	if mongo.IsDuplicateKeyError(err) {
+		count, err := r.Count(ctx, phone, day)
+		return count, false, err
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return counter.Count, true, nil
+}
+
+// Decrement takes back a send counted for a phone number on a day
+func (r *OTPSendRepository) Decrement(ctx context.Context, phone, day string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"phone": phone, "day": day, "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+	return err
+}
+
+// Count returns the send counter for a phone number on a day
+func (r *OTPSendRepository) Count(ctx context.Context, phone, day string) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var counter models.OTPSendCounter
+	err := r.collection.FindOne(ctx, bson.M{"phone": phone, "day": day}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return counter.Count, nil
+}
+
+// IncrementVerified counts a successful verification for a phone number on a day
+func (r *OTPSendRepository) IncrementVerified(ctx context.Context, phone, day string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	now := time.Now()
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"phone": phone, "day": day},
+		bson.M{
+			"$inc":         bson.M{"verified": 1},
+			"$set":         bson.M{"updated_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// DailyTotals sums the send and verification counters per day from fromDay to toDay inclusive
+func (r *OTPSendRepository) DailyTotals(ctx context.Context, fromDay, toDay string) ([]models.DailyCount, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"day": bson.M{"$gte": fromDay, "$lte": toDay}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$day"},
+			{Key: "total", Value: bson.M{"$sum": "$count"}},
+			{Key: "succeeded", Value: bson.M{"$sum": "$verified"}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+	return aggregateDaily(ctx, r.collection, pipeline)
+}
+
+// DeleteByPhones deletes the send counters of phones and returns how many were deleted
+func (r *OTPSendRepository) DeleteByPhones(ctx context.Context, phones []string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"phone": bson.M{"$in": phones}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// DeleteOlderThan deletes the counters of UTC days before the day of t
+func (r *OTPSendRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"day": bson.M{"$lt": t.UTC().Format("2006-01-02")}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// smsQuotaRetention is how long monthly SMS quota counters are kept; only the
+// current month's counter is read
+const smsQuotaRetention = 62 * 24 * time.Hour
+
+// SMSQuotaRepository implements repository.SMSQuotaRepository
+type SMSQuotaRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// createIndexes creates the indexes of the sms_quotas collection, returning
+// every index that could not be created
+func (r *SMSQuotaRepository) createIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	return createIndexSet(ctx, r.collection,
+		// One counter document per owner and month
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "month", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		// Expire counters of past months
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(smsQuotaRetention.Seconds())),
+		},
+	)
+}
+
+// Reserve atomically counts a send for owner in month unless it already
+// reached limit. As in OTPSendRepository.IncrementBelow, the filter only
+// matches a counter below the limit and at the limit the upsert collides with
+// the existing counter on the unique index.
+func (r *SMSQuotaRepository) Reserve(ctx context.Context, owner, month string, limit int) (int, bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter models.SMSQuotaCounter
+	err := r.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"owner": owner, "month": month, "count": bson.M{"$lt": limit}},
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$set":         bson.M{"updated_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		opts,
+	).Decode(&counter)
+	if mongo.IsDuplicateKeyError(err) {
+		count, err := r.Count(ctx, owner, month)
+		return count, false, err
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return counter.Count, true, nil
+}
+
+// Release takes back a send counted for owner in month
+func (r *SMSQuotaRepository) Release(ctx context.Context, owner, month string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"owner": owner, "month": month, "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+	return err
+}
+
+// Count returns the sends counted for owner in month
+func (r *SMSQuotaRepository) Count(ctx context.Context, owner, month string) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var counter models.SMSQuotaCounter
+	err := r.collection.FindOne(ctx, bson.M{"owner": owner, "month": month}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return counter.Count, nil
+}
+
+// SuppressionRepository implements repository.SuppressionRepository
+type SuppressionRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// NewSuppressionRepository creates a new suppression list repository
+func NewSuppressionRepository(db *mongo.Database, timeout time.Duration) *SuppressionRepository {
+	repo := &SuppressionRepository{collection: db.Collection("suppressions"), timeout: timeout}
+	if err := repo.createIndexes(); err != nil {
+		log.Printf("Warning: index setup failed: %v", err)
+	}
+	return repo
+}
+
+// createIndexes creates the indexes of the suppressions collection, returning every
+// index that could not be created
+func (r *SuppressionRepository) createIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	return createIndex(ctx, r.collection, mongo.IndexModel{
+		Keys:    bson.D{{Key: "phone", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+}
+
+// Add puts a phone number on the suppression list, keeping the original entry if already present
+func (r *SuppressionRepository) Add(ctx context.Context, phone, reason string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"phone": phone},
+		bson.M{"$setOnInsert": bson.M{"phone": phone, "reason": reason, "created_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Remove takes a phone number off the suppression list and reports whether it was on it
+func (r *SuppressionRepository) Remove(ctx context.Context, phone string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"phone": phone})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// IsSuppressed reports whether a phone number is on the suppression list
+func (r *SuppressionRepository) IsSuppressed(ctx context.Context, phone string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	count, err := r.collection.CountDocuments(ctx, bson.M{"phone": phone}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// FlaggedPhoneRepository implements repository.FlaggedPhoneRepository
+type FlaggedPhoneRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// createIndexes creates the indexes of the flagged phones collection, returning every
+// index that could not be created
+func (r *FlaggedPhoneRepository) createIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	return createIndexSet(ctx, r.collection,
+		mongo.IndexModel{Keys: bson.D{{Key: "phone", Value: 1}, {Key: "blocked_until", Value: -1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "flagged_at", Value: -1}}},
+	)
+}
+
+// Create stores a flagged phone number
+func (r *FlaggedPhoneRepository) Create(ctx context.Context, flagged *models.FlaggedPhone) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if flagged.FlaggedAt.IsZero() {
+		flagged.FlaggedAt = time.Now()
+	}
+
+	result, err := r.collection.InsertOne(ctx, flagged)
+	if err != nil {
+		return err
+	}
+
+	flagged.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// FindActive finds the flag of a phone number that blocks verification the longest at now
+func (r *FlaggedPhoneRepository) FindActive(ctx context.Context, phone string, now time.Time) (*models.FlaggedPhone, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "blocked_until", Value: -1}})
+
+	var flagged models.FlaggedPhone
+	err := r.collection.FindOne(ctx, bson.M{"phone": phone, "blocked_until": bson.M{"$gt": now}}, opts).Decode(&flagged)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &flagged, nil
+}
+
+// List finds flagged phone numbers, newest first, skipping the first offset
+func (r *FlaggedPhoneRepository) List(ctx context.Context, offset, limit int) ([]*models.FlaggedPhone, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "flagged_at", Value: -1}}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var flagged []*models.FlaggedPhone
+	if err = cursor.All(ctx, &flagged); err != nil {
+		return nil, err
+	}
+	return flagged, nil
+}
+
+// Count counts all flagged phone numbers
+func (r *FlaggedPhoneRepository) Count(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.collection.CountDocuments(ctx, bson.M{})
+}
+
+// DeleteByPhones deletes the flags of phones and returns how many were deleted
+func (r *FlaggedPhoneRepository) DeleteByPhones(ctx context.Context, phones []string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"phone": bson.M{"$in": phones}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// SMSRepository implements repository.SMSRepository
+type SMSRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+	// indexes are created on the collection; nil creates DefaultSMSIndexes
+	indexes    []mongo.IndexModel
+}
+
+// NewSMSRepository creates a new SMS repository
+func NewSMSRepository(db *mongo.Database, timeout time.Duration) *SMSRepository {
+	repo := &SMSRepository{collection: db.Collection("sms"), timeout: timeout}
+	if err := repo.createIndexes(); err != nil {
+		log.Printf("Warning: index setup failed: %v", err)
+	}
+	return repo
+}
+
+// DefaultSMSIndexes returns the indexes created on the sms collection unless
+// overridden with WithSMSIndexes. Listings by phone number or status sort by
+// creation time, so those fields are indexed together with created_at.
+func DefaultSMSIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		// Index on creation time and ID for the cursor paged logs
+		{Keys: newestFirst("created_at")},
+		// Index on phone numbers, newest first, for FindByPhone and phone filters
+		{Keys: bson.D{{Key: "to", Value: 1}, {Key: "created_at", Value: -1}}},
+		// Index on senders of inbound messages, newest first, for phone timelines
+		{Keys: bson.D{{Key: "from", Value: 1}, {Key: "created_at", Value: -1}}},
+		// Index on status, newest first, for status listings and the retry routine
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		// Index on the last four digits for suffix search
+		{Keys: bson.D{{Key: "to_last4", Value: 1}}},
+		// Index on sender and creation time for quota counting
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		// Index on direction and creation time for inbound/outbound listings
+		{Keys: bson.D{{Key: "direction", Value: 1}, {Key: "created_at", Value: -1}}},
+		// Wildcard index on metadata, whose keys vary by sender
+		{Keys: bson.D{{Key: "metadata.$**", Value: 1}}},
+		// Sparse index on the provider's message ID, for delivery reports
+		{
+			Keys:    bson.D{{Key: "provider_id", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+	}
+}
+
+// createIndexes creates the indexes of the sms collection, returning every
+// index that could not be created
+func (r *SMSRepository) createIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	indexes := r.indexes
+	if indexes == nil {
+		indexes = DefaultSMSIndexes()
+	}
+	return createIndexSet(ctx, r.collection, indexes...)
+}
+
+// Create stores a new SMS
+func (r *SMSRepository) Create(ctx context.Context, sms *models.SMS) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	prepareSMS(sms, time.Now())
+	
+	result, err := r.collection.InsertOne(ctx, sms)
+	if err != nil {
+		return err
+	}
+	
+	sms.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// CreateMany inserts messages with a single InsertMany and sets their IDs.
+// When the insert fails none of the IDs are set, though earlier messages of
+// the batch may have been stored.
+func (r *SMSRepository) CreateMany(ctx context.Context, messages []*models.SMS) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	now := time.Now()
+	docs := make([]interface{}, len(messages))
+	for i, sms := range messages {
+		prepareSMS(sms, now)
+		docs[i] = sms
+	}
+
+	result, err := r.collection.InsertMany(ctx, docs)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range result.InsertedIDs {
+		messages[i].ID = id.(primitive.ObjectID)
+	}
+	return nil
+}
+
+// prepareSMS sets the fields stored with every new message
+func prepareSMS(sms *models.SMS, now time.Time) {
+	sms.CreatedAt = now
+	sms.UpdatedAt = now
+	sms.SentAt = now
+	sms.ToLast4 = common.PhoneLast4(sms.To)
+	if sms.Direction == "" {
+		sms.Direction = models.DirectionOutbound
+	}
+}
+
+// FindByID finds an SMS by ID
+func (r *SMSRepository) FindByID(ctx context.Context, id string) (*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return nil, appErr
+	}
+	
+	var sms models.SMS
+	err := r.collection.FindOne(ctx, liveFilter(ctx, bson.M{"_id": objectID})).Decode(&sms)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sms, nil
+}
+
+// FindByProviderID finds an SMS by the ID its provider assigned to it
+func (r *SMSRepository) FindByProviderID(ctx context.Context, providerID string) (*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var sms models.SMS
+	err := r.collection.FindOne(ctx, liveFilter(ctx, bson.M{"provider_id": providerID})).Decode(&sms)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sms, nil
+}
+
+// FindByIDs finds the SMS messages with the given IDs in a single query
+func (r *SMSRepository) FindByIDs(ctx context.Context, ids []string) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		objectID, appErr := parseObjectID(id)
+		if appErr != nil {
+			return nil, appErr
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	cursor, err := r.collection.Find(ctx, liveFilter(ctx, bson.M{"_id": bson.M{"$in": objectIDs}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sms []*models.SMS
+	if err = cursor.All(ctx, &sms); err != nil {
+		return nil, err
+	}
+	return sms, nil
+}
+
+// FindByPhone finds SMS messages by phone number
+func (r *SMSRepository) FindByPhone(ctx context.Context, phone string, limit int) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+	
+	cursor, err := r.collection.Find(ctx, liveFilter(ctx, bson.M{"to": phone}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sms []*models.SMS
+	if err = cursor.All(ctx, &sms); err != nil {
+		return nil, err
+	}
+	
+	return sms, nil
+}
+
+// FindByPhoneBetween finds messages sent to or received from phone created within [from, to), newest first
+func (r *SMSRepository) FindByPhoneBetween(ctx context.Context, phone string, from, to time.Time, limit int) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	filter := liveFilter(ctx, rangeFilter("created_at", from, to))
+	filter["$or"] = []bson.M{{"to": phone}, {"from": phone}}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sms []*models.SMS
+	if err = cursor.All(ctx, &sms); err != nil {
+		return nil, err
+	}
+	return sms, nil
+}
+
+// UpdateStatus updates the status of an SMS
+func (r *SMSRepository) UpdateStatus(ctx context.Context, id string, status models.Status) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
+	}
+	
+	return updateStatus(ctx, r.collection, objectID, models.SMSTransitions, status,
+		bson.M{"status": status, "updated_at": time.Now()})
+}
+
+// UpdateFailure updates the status of an SMS along with the reason it failed
+func (r *SMSRepository) UpdateFailure(ctx context.Context, id string, status models.Status, reason string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
+	}
+
+	return updateStatus(ctx, r.collection, objectID, models.SMSTransitions, status,
+		bson.M{"status": status, "failed_reason": reason, "updated_at": time.Now()})
+}
+
+// ClaimRetry moves an SMS from status from to retrying, matching on the status
+// so that of concurrent callers only one claims it
+func (r *SMSRepository) ClaimRetry(ctx context.Context, id string, from models.Status) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return false, appErr
+	}
+	if !models.SMSTransitions.Allowed(from, models.StatusRetrying) {
+		return false, nil
+	}
+
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "status": from},
+		bson.M{"$set": bson.M{"status": models.StatusRetrying, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// IncrementRetryCount increments the number of resend attempts of an SMS
+func (r *SMSRepository) IncrementRetryCount(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
+	}
+
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$inc": bson.M{"retry_count": 1}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+	return err
+}
+
+// UpdateDeliveryTime updates the delivery time of an SMS
+func (r *SMSRepository) UpdateDeliveryTime(ctx context.Context, id string, deliveredAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
+	}
+	
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"delivered_at": deliveredAt, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// FindByStatus finds SMS messages by status
+func (r *SMSRepository) FindByStatus(ctx context.Context, status models.Status, limit int) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
 	
-	cursor, err := r.collection.Find(ctx, bson.M{"to": phone}, opts)
+	cursor, err := r.collection.Find(ctx, liveFilter(ctx, bson.M{"status": status}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sms []*models.SMS
+	if err = cursor.All(ctx, &sms); err != nil {
+		return nil, err
+	}
+	
+	return sms, nil
+}
+
+// SoftDelete marks an SMS message deleted, keeping it stored
+func (r *SMSRepository) SoftDelete(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
+	}
+
+	now := time.Now()
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": objectID, "deleted_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deleted_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// liveFilter leaves soft-deleted messages out of filter, unless ctx includes them
+func liveFilter(ctx context.Context, filter bson.M) bson.M {
+	if !repository.IncludesDeleted(ctx) {
+		filter["deleted_at"] = bson.M{"$exists": false}
+	}
+	return filter
+}
+
+// DeleteOlderThan deletes SMS messages created before t and returns how many were deleted
+func (r *SMSRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"created_at": bson.M{"$lt": t}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// DeleteByUser deletes SMS messages sent by userID or sent to or received
+// from any of phones, soft-deleted ones included, and returns their IDs
+func (r *SMSRepository) DeleteByUser(ctx context.Context, userID string, phones []string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	match := []bson.M{{"to": bson.M{"$in": phones}}, {"from": bson.M{"$in": phones}}}
+	if userID != "" {
+		match = append(match, bson.M{"user_id": userID})
+	}
+	return deleteReturningIDs(ctx, r.collection, bson.M{"$or": match})
+}
+
+// deleteReturningIDs deletes the documents of collection matching filter and
+// returns their IDs
+func deleteReturningIDs(ctx context.Context, collection *mongo.Collection, filter bson.M) ([]string, error) {
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(docs))
+	objectIDs := make([]primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID.Hex())
+		objectIDs = append(objectIDs, doc.ID)
+	}
+	if len(objectIDs) == 0 {
+		return ids, nil
+	}
+	if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs}}); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// CountByStatus counts SMS messages per status with a single aggregation,
+// restricted to messages sent to or received from phone when it isn't empty
+func (r *SMSRepository) CountByStatus(ctx context.Context, phone string) (map[models.Status]int, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	match := bson.M{}
+	if phone != "" {
+		match["$or"] = []bson.M{{"to": phone}, {"from": phone}}
+	}
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Status models.Status `bson:"_id"`
+		Count  int           `bson:"count"`
+	}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[models.Status]int, len(groups))
+	for _, group := range groups {
+		counts[group.Status] = group.Count
+	}
+	return counts, nil
+}
+
+// FindNonTerminal finds up to limit outbound SMS messages still pending or
+// sent, created at or after createdSince, in ID order starting after afterID
+func (r *SMSRepository) FindNonTerminal(ctx context.Context, createdSince time.Time, afterID string, limit int) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	filter := bson.M{
+		"direction":  directionFilter(models.DirectionOutbound),
+		"status":     bson.M{"$in": []models.Status{models.StatusPending, models.StatusSent}},
+		"created_at": bson.M{"$gte": createdSince},
+	}
+	if afterID != "" {
+		objectID, appErr := parseObjectID(afterID)
+		if appErr != nil {
+			return nil, appErr
+		}
+		filter["_id"] = bson.M{"$gt": objectID}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, liveFilter(ctx, filter), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -427,45 +1815,72 @@ func (r *SMSRepository) FindByPhone(ctx context.Context, phone string, limit int
 	if err = cursor.All(ctx, &sms); err != nil {
 		return nil, err
 	}
-	
 	return sms, nil
 }
 
-// UpdateStatus updates the status of an SMS
-func (r *SMSRepository) UpdateStatus(ctx context.Context, id string, status string) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
+// SearchByPhone finds SMS messages whose recipient starts or ends with the query
+func (r *SMSRepository) SearchByPhone(ctx context.Context, query string, suffix bool, limit int) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+
+	// Inbound messages are matched on the sender, the others on the recipient
+	inbound := phoneSearchFilter("from", "", query, suffix)
+	inbound["direction"] = models.DirectionInbound
+	outbound := phoneSearchFilter("to", "to_last4", query, suffix)
+	outbound["direction"] = directionFilter(models.DirectionOutbound)
+
+	cursor, err := r.collection.Find(ctx, liveFilter(ctx, bson.M{"$or": bson.A{inbound, outbound}}), opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	
-	_, err = r.collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objectID},
-		bson.M{"$set": bson.M{"status": status, "updated_at": time.Now()}},
-	)
-	return err
+	defer cursor.Close(ctx)
+
+	var sms []*models.SMS
+	if err = cursor.All(ctx, &sms); err != nil {
+		return nil, err
+	}
+	return sms, nil
 }
 
-// UpdateDeliveryTime updates the delivery time of an SMS
-func (r *SMSRepository) UpdateDeliveryTime(ctx context.Context, id string, deliveredAt time.Time) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
+// CountByUserSince counts SMS messages sent by a user since the given time
+func (r *SMSRepository) CountByUserSince(ctx context.Context, userID string, since time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	count, err := r.collection.CountDocuments(ctx, bson.M{
+		"user_id":    userID,
+		"created_at": bson.M{"$gte": since},
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
-	
-	_, err = r.collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objectID},
-		bson.M{"$set": bson.M{"delivered_at": deliveredAt, "updated_at": time.Now()}},
-	)
-	return err
+	return int(count), nil
 }
 
-// FindByStatus finds SMS messages by status
-func (r *SMSRepository) FindByStatus(ctx context.Context, status string, limit int) ([]*models.SMS, error) {
-	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+// DailyCounts counts outbound SMS messages created within [from, to) per UTC day
+func (r *SMSRepository) DailyCounts(ctx context.Context, from, to time.Time) ([]models.DailyCount, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	match := rangeFilter("created_at", from, to)
+	// Messages stored before directions were recorded are outbound
+	match["direction"] = bson.M{"$ne": models.DirectionInbound}
+	return aggregateDaily(ctx, r.collection, dailyPipeline(match,
+		[]models.Status{models.StatusDelivered},
+		[]models.Status{models.StatusFailed, models.StatusDead},
+	))
+}
+
+// FindAll finds SMS messages, newest first, after the cursor (if set)
+func (r *SMSRepository) FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(newestFirst("created_at")).SetLimit(int64(limit))
 	
-	cursor, err := r.collection.Find(ctx, bson.M{"status": status}, opts)
+	cursor, err := r.collection.Find(ctx, liveFilter(ctx, beforeFilter("created_at", before)), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -475,15 +1890,17 @@ func (r *SMSRepository) FindByStatus(ctx context.Context, status string, limit i
 	if err = cursor.All(ctx, &sms); err != nil {
 		return nil, err
 	}
-	
 	return sms, nil
 }
 
-// FindAll finds all SMS messages with a limit
-func (r *SMSRepository) FindAll(ctx context.Context, limit int) ([]*models.SMS, error) {
-	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
-	
-	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+// List finds SMS messages matching filter, newest first, skipping the first offset
+func (r *SMSRepository) List(ctx context.Context, filter models.SMSLogFilter, offset, limit int) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(newestFirst("created_at")).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, liveFilter(ctx, smsFilter(filter)), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -496,45 +1913,149 @@ func (r *SMSRepository) FindAll(ctx context.Context, limit int) ([]*models.SMS,
 	return sms, nil
 }
 
-// UserRepository implements repository.UserRepository
-type UserRepository struct {
-	collection *mongo.Collection
+// Count counts the SMS messages matching filter
+func (r *SMSRepository) Count(ctx context.Context, filter models.SMSLogFilter) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.collection.CountDocuments(ctx, liveFilter(ctx, smsFilter(filter)))
 }
 
-// NewUserRepository creates a new user repository
-func NewUserRepository(db *mongo.Database) *UserRepository {
-	collection := db.Collection("users")
-	
-	// Create indexes
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	
-	// Index on phone number
-	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "phone", Value: 1}},
-		Options: options.Index().SetUnique(true),
+// directionFilter matches messages of a direction. Messages stored before
+// directions were recorded have none and are outbound.
+func directionFilter(direction string) interface{} {
+	if direction == models.DirectionOutbound {
+		return bson.M{"$in": bson.A{models.DirectionOutbound, "", nil}}
+	}
+	return direction
+}
+
+// smsFilter matches the direction, metadata pair, user and cursor of filter, when set
+func smsFilter(filter models.SMSLogFilter) bson.M {
+	query := beforeFilter("created_at", filter.Before)
+	if filter.Direction != "" {
+		query["direction"] = directionFilter(filter.Direction)
+	}
+	if filter.UserID != "" {
+		query["user_id"] = filter.UserID
+	}
+	if filter.MetadataKey != "" {
+		query["metadata."+filter.MetadataKey] = filter.MetadataValue
+	}
+	return query
+}
+
+// Stream calls fn for each SMS message created within the range, oldest first
+func (r *SMSRepository) Stream(ctx context.Context, from, to time.Time, fn func(*models.SMS) error) error {
+	return stream(ctx, r.collection, r.timeout, "created_at", liveFilter(ctx, rangeFilter("created_at", from, to)), func(cursor *mongo.Cursor) error {
+		var sms models.SMS
+		if err := cursor.Decode(&sms); err != nil {
+			return err
+		}
+		return fn(&sms)
 	})
+}
+
+// FindByDirection finds inbound or outbound SMS messages, newest first, after the cursor (if set)
+func (r *SMSRepository) FindByDirection(ctx context.Context, direction string, limit int, before models.LogCursor) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(newestFirst("created_at")).SetLimit(int64(limit))
+
+	filter := liveFilter(ctx, beforeFilter("created_at", before))
+	filter["direction"] = directionFilter(direction)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
-		// Index might already exist
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	// Index on email
-	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "email", Value: 1}},
-	})
+	var sms []*models.SMS
+	if err = cursor.All(ctx, &sms); err != nil {
+		return nil, err
+	}
+	return sms, nil
+}
+
+// FindByMetadata finds SMS messages tagged with the metadata pair, newest first, after the cursor (if set)
+func (r *SMSRepository) FindByMetadata(ctx context.Context, key, value string, limit int, before models.LogCursor) ([]*models.SMS, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(newestFirst("created_at")).SetLimit(int64(limit))
+
+	filter := liveFilter(ctx, beforeFilter("created_at", before))
+	filter["metadata."+key] = value
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
-		// Index might already exist
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sms []*models.SMS
+	if err = cursor.All(ctx, &sms); err != nil {
+		return nil, err
+	}
+	return sms, nil
+}
+
+// UserRepository implements repository.UserRepository
+type UserRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// NewUserRepository creates a new user repository
+func NewUserRepository(db *mongo.Database, timeout time.Duration) *UserRepository {
+	repo := &UserRepository{collection: db.Collection("users"), timeout: timeout}
+	if err := repo.createIndexes(); err != nil {
+		log.Printf("Warning: index setup failed: %v", err)
 	}
+	return repo
+}
 
-	return &UserRepository{collection: collection}
+// createIndexes creates the indexes of the users collection, returning every
+// index that could not be created
+func (r *UserRepository) createIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	
+	return createIndexSet(ctx, r.collection,
+		// Index on phone number
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "phone", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		// Emails are unique regardless of case; users without one are left out
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().
+				SetName("email_unique_ci").
+				SetUnique(true).
+				SetCollation(emailCollation).
+				SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+		},
+	)
 }
 
+// emailCollation compares emails ignoring case, matching the unique email index
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
 // Create stores a new user
 func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 	
 	result, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return repository.ErrDuplicate
+	}
 	if err != nil {
 		return err
 	}
@@ -545,13 +2066,19 @@ func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 
 // FindByID finds a user by ID
 func (r *UserRepository) FindByID(ctx context.Context, id string) (*models.User, error) {
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return nil, appErr
 	}
 	
 	var user models.User
-	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	err := r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -560,8 +2087,14 @@ func (r *UserRepository) FindByID(ctx context.Context, id string) (*models.User,
 
 // FindByPhone finds a user by phone number
 func (r *UserRepository) FindByPhone(ctx context.Context, phone string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	var user models.User
 	err := r.collection.FindOne(ctx, bson.M{"phone": phone}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -570,8 +2103,17 @@ func (r *UserRepository) FindByPhone(ctx context.Context, phone string) (*models
 
 // FindByEmail finds a user by email
 func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	// The collation of the unique email index, so the lookup ignores case and uses it
+	opts := options.FindOne().SetCollation(emailCollation)
+
 	var user models.User
-	err := r.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	err := r.collection.FindOne(ctx, bson.M{"email": email}, opts).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, repository.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -580,6 +2122,9 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models
 
 // Update updates an existing user
 func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
 	user.UpdatedAt = time.Now()
 	
 	_, err := r.collection.UpdateOne(
@@ -587,16 +2132,276 @@ func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
 		bson.M{"_id": user.ID},
 		bson.M{"$set": user},
 	)
+	if mongo.IsDuplicateKeyError(err) {
+		return repository.ErrDuplicate
+	}
 	return err
 }
 
 // Delete deletes a user by ID
 func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, appErr := parseObjectID(id)
+	if appErr != nil {
+		return appErr
+	}
+	
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	return err
+}
+
+// beforeFilter matches records after the cursor in newestFirst order: older
+// than its timestamp, or as old with a smaller ID. A zero cursor matches
+// everything. Paging on an indexed timestamp keeps deep pages as fast as the
+// first, unlike SetSkip.
+func beforeFilter(field string, before models.LogCursor) bson.M {
+	if before.IsZero() {
+		return bson.M{}
+	}
+	if before.ID.IsZero() {
+		return bson.M{field: bson.M{"$lt": before.At}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{"$lt": before.At}},
+		bson.M{field: before.At, "_id": bson.M{"$lt": before.ID}},
+	}}
+}
+
+// newestFirst sorts by the timestamp field, newest first, and records sharing
+// a timestamp by descending ID, the order beforeFilter pages through
+func newestFirst(field string) bson.D {
+	return bson.D{{Key: field, Value: -1}, {Key: "_id", Value: -1}}
+}
+
+// rangeFilter restricts field to [from, to); zero bounds are left open
+func rangeFilter(field string, from, to time.Time) bson.M {
+	bounds := bson.M{}
+	if !from.IsZero() {
+		bounds["$gte"] = from
+	}
+	if !to.IsZero() {
+		bounds["$lt"] = to
+	}
+	if len(bounds) == 0 {
+		return bson.M{}
+	}
+	return bson.M{field: bounds}
+}
+
+// updateStatus sets fields on the document with the given ID only while its
+// status may move to status, so concurrent updates can't make an illegal move.
+// A missing document is not an error.
+func updateStatus(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, transitions models.Transitions, status models.Status, fields bson.M) error {
+	result, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": transitions.Sources(status)}},
+		bson.M{"$set": fields},
+	)
+	if err != nil || result.MatchedCount > 0 {
+		return err
+	}
+
+	var current struct {
+		Status models.Status `bson:"status"`
+	}
+	err = collection.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"status": 1})).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return transitions.Check(current.Status, status)
+}
+
+// dailyPipeline groups the matched documents by the UTC day of created_at,
+// counting those whose status is one of succeeded or failed
+func dailyPipeline(match bson.M, succeeded, failed []models.Status) mongo.Pipeline {
+	countStatus := func(statuses []models.Status) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$in": bson.A{"$status", statuses}}, 1, 0}}}
+	}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at", "timezone": "UTC"}}},
+			{Key: "total", Value: bson.M{"$sum": 1}},
+			{Key: "succeeded", Value: countStatus(succeeded)},
+			{Key: "failed", Value: countStatus(failed)},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+}
+
+// aggregateDaily runs a pipeline producing one document per day
+func aggregateDaily(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline) ([]models.DailyCount, error) {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := []models.DailyCount{}
+	if err = cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// stream iterates the documents matching filter in ascending order of field,
+// handing each to decode without loading the result set into memory
+func stream(ctx context.Context, collection *mongo.Collection, timeout time.Duration, field string, filter bson.M, decode func(*mongo.Cursor) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: field, Value: 1}})
+
+	// Only opening the cursor is bounded by the operation timeout; iterating a
+	// large result set is bounded by the caller's context instead
+	findCtx, cancel := withTimeout(ctx, timeout)
+	cursor, err := collection.Find(findCtx, filter, opts)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		if err := decode(cursor); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// withTimeout derives an operation context bounded by timeout. A caller
+// deadline that expires sooner still applies.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// parseObjectID converts a hex string into an ObjectID, rejecting malformed IDs
+// with a validation error so callers can tell them apart from missing records
+func parseObjectID(id string) (primitive.ObjectID, *common.AppError) {
 	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, common.NewValidationError("Invalid ID format")
+	}
+	return objectID, nil
+}
+
+// AuditRepository implements repository.AuditRepository
+type AuditRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// createIndexes creates the indexes of the audit collection, returning every
+// index that could not be created
+func (r *AuditRepository) createIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	// Listing filters by type and pages through records in creation order
+	return createIndexSet(ctx, r.collection,
+		mongo.IndexModel{Keys: bson.D{{Key: "created_at", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "type", Value: 1}, {Key: "created_at", Value: 1}}},
+	)
+}
+
+// Create appends a record to the audit log, keeping the time of the change when set
+func (r *AuditRepository) Create(ctx context.Context, audit *models.Audit) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if audit.CreatedAt.IsZero() {
+		audit.CreatedAt = time.Now()
+	}
+
+	result, err := r.collection.InsertOne(ctx, audit)
 	if err != nil {
 		return err
 	}
-	
-	_, err = r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
-	return err
-} 
\ No newline at end of file
+
+	audit.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// DeleteByTargets deletes the audit records of targetIDs and those of actions
+// taken by actorID, and returns how many were deleted
+func (r *AuditRepository) DeleteByTargets(ctx context.Context, targetIDs []string, actorID string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	match := []bson.M{{"target_id": bson.M{"$in": targetIDs}}}
+	if actorID != "" {
+		match = append(match, bson.M{"actor_id": actorID})
+	}
+	result, err := r.collection.DeleteMany(ctx, bson.M{"$or": match})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// DeleteOlderThan deletes audit records created before t
+func (r *AuditRepository) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"created_at": bson.M{"$lt": t}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// List finds audit records matching the filter, oldest first
+func (r *AuditRepository) List(ctx context.Context, filter models.AuditFilter, offset, limit int) ([]*models.Audit, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, auditFilter(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	audits := []*models.Audit{}
+	if err = cursor.All(ctx, &audits); err != nil {
+		return nil, err
+	}
+	return audits, nil
+}
+
+// Count counts the audit records matching the filter
+func (r *AuditRepository) Count(ctx context.Context, filter models.AuditFilter) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.collection.CountDocuments(ctx, auditFilter(filter))
+}
+
+// auditFilter builds the query of an audit filter; a type without a state
+// matches every state of that kind
+func auditFilter(filter models.AuditFilter) bson.M {
+	query := bson.M{}
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lt"] = filter.To
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+	if filter.Type != "" {
+		query["type"] = bson.M{"$regex": "^" + regexp.QuoteMeta(filter.Type) + `(\.|$)`}
+	}
+	return query
+}
@@ -2,10 +2,26 @@ package mongo
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"sms-app-backend/models"
 )
@@ -29,6 +45,8 @@ func (m *MockMongoClient) CreateOTP(otp *models.OTP) error {
 	if otp.ID.IsZero() {
 		otp.ID = primitive.NewObjectID()
 	}
+	otp.CreatedAt = time.Now()
+	otp.UpdatedAt = time.Now()
 	m.otps[otp.ID.Hex()] = otp
 	return nil
 }
@@ -56,6 +74,9 @@ func (m *MockMongoClient) CreateSMS(sms *models.SMS) error {
 	if sms.ID.IsZero() {
 		sms.ID = primitive.NewObjectID()
 	}
+	sms.CreatedAt = time.Now()
+	sms.UpdatedAt = time.Now()
+	sms.SentAt = time.Now()
 	m.sms[sms.ID.Hex()] = sms
 	return nil
 }
@@ -71,6 +92,8 @@ func (m *MockMongoClient) CreateUser(user *models.User) error {
 	if user.ID.IsZero() {
 		user.ID = primitive.NewObjectID()
 	}
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
 	m.users[user.ID.Hex()] = user
 	return nil
 }
@@ -87,7 +110,6 @@ func (m *MockMongoClient) GetUserByPhone(phone string) (*models.User, error) {
 // Test functions
 func TestOTPRepository_Create(t *testing.T) {
 	mockClient := NewMockMongoClient()
-	repo := &OTPRepository{}
 
 	otp := &models.OTP{
 		Phone:      "+1234567890",
@@ -273,4 +295,298 @@ func TestUserRepository_FindByPhone(t *testing.T) {
 	if foundUser.Email != "test@example.com" {
 		t.Errorf("Expected email test@example.com, got %s", foundUser.Email)
 	}
-} 
\ No newline at end of file
+} 
+func TestPhoneSearchFilter_Prefix(t *testing.T) {
+	filter := phoneSearchFilter("to", "to_last4", "+1555", false)
+
+	expected := bson.M{"to": bson.M{"$regex": `^\+1555`}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected %v, got %v", expected, filter)
+	}
+}
+
+func TestPhoneSearchFilter_Suffix(t *testing.T) {
+	filter := phoneSearchFilter("to", "to_last4", "234567", true)
+
+	expected := bson.M{
+		"to_last4": "4567",
+		"to":       bson.M{"$regex": "234567$"},
+	}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected %v, got %v", expected, filter)
+	}
+}
+
+func TestPhoneSearchFilter_ShortSuffix(t *testing.T) {
+	filter := phoneSearchFilter("phone", "phone_last4", "567", true)
+
+	expected := bson.M{"phone_last4": bson.M{"$regex": "567$"}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected %v, got %v", expected, filter)
+	}
+}
+
+func TestPhoneSearchFilter_SuffixWithoutLast4(t *testing.T) {
+	filter := phoneSearchFilter("from", "", "567", true)
+
+	expected := bson.M{"from": bson.M{"$regex": "567$"}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected %v, got %v", expected, filter)
+	}
+}
+
+func TestBeforeFilter(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	id := primitive.NewObjectID()
+
+	if filter := beforeFilter("created_at", models.LogCursor{}); len(filter) != 0 {
+		t.Errorf("Expected an empty filter for a zero cursor, got %v", filter)
+	}
+
+	expected := bson.M{"created_at": bson.M{"$lt": at}}
+	if filter := beforeFilter("created_at", models.LogCursor{At: at}); !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected %v, got %v", expected, filter)
+	}
+
+	// Records sharing the cursor's timestamp continue by ID
+	expected = bson.M{"$or": bson.A{
+		bson.M{"created_at": bson.M{"$lt": at}},
+		bson.M{"created_at": at, "_id": bson.M{"$lt": id}},
+	}}
+	if filter := beforeFilter("created_at", models.LogCursor{At: at, ID: id}); !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected %v, got %v", expected, filter)
+	}
+}
+
+func TestParseObjectID(t *testing.T) {
+	id := primitive.NewObjectID()
+	parsed, appErr := parseObjectID(id.Hex())
+	if appErr != nil || parsed != id {
+		t.Errorf("Expected %s, got %s (%v)", id.Hex(), parsed.Hex(), appErr)
+	}
+
+	if _, appErr := parseObjectID("not-an-id"); appErr == nil || appErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a 400 validation error for a malformed ID, got %v", appErr)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := withTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("Expected a deadline within a minute, got %v", deadline)
+	}
+
+	// A sooner caller deadline wins
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Second)
+	defer parentCancel()
+	ctx, cancel = withTimeout(parent, time.Minute)
+	defer cancel()
+	if deadline, _ := ctx.Deadline(); time.Until(deadline) > time.Second {
+		t.Errorf("Expected the caller's deadline to apply, got %v", deadline)
+	}
+
+	ctx, cancel = withTimeout(context.Background(), 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Expected no deadline when the timeout is disabled")
+	}
+}
+
+func TestNewRepositoryConnectErrors(t *testing.T) {
+	_, err := NewRepository("not-a-mongo-uri", "sms_app", time.Second)
+	var connectErr *ConnectError
+	if !errors.As(err, &connectErr) || connectErr.Phase != PhaseConnect {
+		t.Errorf("Expected a connect phase error, got %v", err)
+	}
+
+	// Nothing listens on port 1, so the ping can't succeed
+	repo, err := NewRepository("mongodb://127.0.0.1:1", "sms_app", time.Second, WithConnectTimeout(100*time.Millisecond))
+	if repo != nil || !errors.As(err, &connectErr) || connectErr.Phase != PhasePing {
+		t.Errorf("Expected a ping phase error and no repository, got %v", err)
+	}
+
+	repo, err = NewRepository("mongodb://127.0.0.1:1", "sms_app", time.Second,
+		WithConnectTimeout(100*time.Millisecond), WithReconnect(time.Hour))
+	if repo == nil || !errors.As(err, &connectErr) || connectErr.Phase != PhasePing {
+		t.Fatalf("Expected a reconnecting repository with a ping phase error, got %v", err)
+	}
+	if repo.Connected() {
+		t.Error("Expected the repository to report it is not connected")
+	}
+	repo.Close()
+}
+
+// writeTestCertificate writes a self-signed certificate and its key as PEM files
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sms-app"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestNewRepositoryTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	notPEM := filepath.Join(dir, "ca.txt")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0600)
+	missing := filepath.Join(dir, "missing.pem")
+
+	tests := []struct {
+		name              string
+		caFile, cert, key string
+		wantErr           string
+	}{
+		{"missing CA file", missing, "", "", "TLS CA file " + missing + " does not exist"},
+		{"CA file without certificates", notPEM, "", "", "contains no PEM certificates"},
+		{"certificate without key", "", certFile, "", "must be set together"},
+		{"missing key file", "", certFile, missing, "TLS key file " + missing + " does not exist"},
+		{"directory as certificate", "", dir, keyFile, "is a directory"},
+		{"mismatched certificate and key", "", certFile, notPEM, "loading TLS client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRepository("mongodb://127.0.0.1:1", "sms_app", time.Second, WithTLSFiles(tt.caFile, tt.cert, tt.key))
+			var connectErr *ConnectError
+			if !errors.As(err, &connectErr) || connectErr.Phase != PhaseTLS || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected a tls phase error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	cfg := DefaultClientConfig()
+	WithTLSFiles(certFile, certFile, keyFile)(&cfg)
+	opts, err := cfg.clientOptions("mongodb://127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("Expected valid TLS files to load, got %v", err)
+	}
+	if opts.TLSConfig == nil || opts.TLSConfig.RootCAs == nil || len(opts.TLSConfig.Certificates) != 1 {
+		t.Errorf("Expected the CA and client certificate to be configured, got %+v", opts.TLSConfig)
+	}
+
+	if opts, _ := DefaultClientConfig().clientOptions("mongodb://127.0.0.1:1"); opts.TLSConfig != nil {
+		t.Error("Expected TLS to stay off without TLS files")
+	}
+}
+
+func TestSameIndexKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b bson.D
+		want bool
+	}{
+		{"same", bson.D{{Key: "expires_at", Value: int32(1)}}, bson.D{{Key: "expires_at", Value: 1}}, true},
+		{"double direction", bson.D{{Key: "expires_at", Value: 1.0}}, bson.D{{Key: "expires_at", Value: 1}}, true},
+		{"other direction", bson.D{{Key: "expires_at", Value: int32(-1)}}, bson.D{{Key: "expires_at", Value: 1}}, false},
+		{"compound", bson.D{{Key: "expires_at", Value: 1}, {Key: "phone", Value: 1}}, bson.D{{Key: "expires_at", Value: 1}}, false},
+		{"order", bson.D{{Key: "phone", Value: 1}, {Key: "day", Value: 1}}, bson.D{{Key: "day", Value: 1}, {Key: "phone", Value: 1}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameIndexKeys(tt.a, tt.b); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHasErrorCode(t *testing.T) {
+	exists := fmt.Errorf("index on otps: %w", mongo.CommandError{Code: codeIndexAlreadyExists, Message: "index already exists"})
+	conflict := mongo.CommandError{Code: codeIndexOptionsConflict, Message: "index options conflict"}
+
+	if !hasErrorCode(exists, codeIndexAlreadyExists) {
+		t.Error("Expected a wrapped already exists error to be recognized")
+	}
+	if hasErrorCode(conflict, codeIndexAlreadyExists) || !hasErrorCode(conflict, codeIndexKeySpecsConflict, codeIndexOptionsConflict) {
+		t.Error("Expected an options conflict to be told apart from an existing index")
+	}
+	if hasErrorCode(nil, codeIndexAlreadyExists) || hasErrorCode(errors.New("connection refused"), codeIndexAlreadyExists) {
+		t.Error("Expected errors without a server code not to match")
+	}
+}
+
+func TestParseIndexKeys(t *testing.T) {
+	indexes, err := ParseIndexKeys(" sender_id:1, created_at:-1 ;status:1;")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(indexes) != 2 {
+		t.Fatalf("Expected 2 indexes, got %d", len(indexes))
+	}
+	want := bson.D{{Key: "sender_id", Value: 1}, {Key: "created_at", Value: -1}}
+	if !sameIndexKeys(indexes[0].Keys.(bson.D), want) {
+		t.Errorf("Expected keys %v, got %v", want, indexes[0].Keys)
+	}
+
+	for _, value := range []string{"to", ":1", "to:2", "to:1,created_at"} {
+		if _, err := ParseIndexKeys(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+// BenchmarkSMSRepositoryCreateMany compares storing a batch of messages with
+// one InsertOne each against a single InsertMany. It needs a MongoDB server
+// at MONGODB_TEST_URI, e.g. mongodb://localhost:27017.
+func BenchmarkSMSRepositoryCreateMany(b *testing.B) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		b.Skip("MONGODB_TEST_URI not set")
+	}
+	repo, err := NewRepository(uri, "sms_app_bench", 30*time.Second)
+	if err != nil {
+		b.Fatalf("Failed to connect: %v", err)
+	}
+	defer repo.Close()
+	defer repo.database.Drop(context.Background())
+
+	const batchSize = 1000
+	batch := func() []*models.SMS {
+		messages := make([]*models.SMS, batchSize)
+		for i := range messages {
+			messages[i] = &models.SMS{To: fmt.Sprintf("+1555%07d", i), Message: "Campaign message", Status: models.StatusPending}
+		}
+		return messages
+	}
+	ctx := context.Background()
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, sms := range batch() {
+				if err := repo.SMS().Create(ctx, sms); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("many", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := repo.SMS().CreateMany(ctx, batch()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
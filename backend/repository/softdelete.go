@@ -0,0 +1,17 @@
+package repository
+
+import "context"
+
+type includeDeletedContextKey struct{}
+
+// IncludeDeleted returns a context whose SMS lookups and listings also return
+// soft-deleted messages
+func IncludeDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, includeDeletedContextKey{}, true)
+}
+
+// IncludesDeleted reports whether ctx comes from IncludeDeleted
+func IncludesDeleted(ctx context.Context) bool {
+	include, _ := ctx.Value(includeDeletedContextKey{}).(bool)
+	return include
+}
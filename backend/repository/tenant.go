@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidTenant is returned by repository factories for malformed tenant IDs
+var ErrInvalidTenant = errors.New("invalid tenant ID")
+
+// ErrUnknownTenant is returned by repository factories for tenants that
+// aren't registered with them
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// maxTenantIDLength keeps tenant database names well under MongoDB's 64 byte limit
+const maxTenantIDLength = 32
+
+// IsValidTenantID reports whether id can name a tenant: 1-32 letters, digits, '_' or '-'
+func IsValidTenantID(id string) bool {
+	if id == "" || len(id) > maxTenantIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTenantIDs reads a comma-separated list of tenant IDs, e.g. "acme,globex"
+func ParseTenantIDs(value string) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !IsValidTenantID(id) {
+			return nil, fmt.Errorf("invalid tenant ID %q, expected 1-%d letters, digits, underscores or hyphens", id, maxTenantIDLength)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+type repositoryContextKey struct{}
+
+// WithRepository returns a context carrying the repository to use for a request
+func WithRepository(ctx context.Context, repo Repository) context.Context {
+	return context.WithValue(ctx, repositoryContextKey{}, repo)
+}
+
+// FromContext returns the repository carried by ctx, or fallback when there is none
+func FromContext(ctx context.Context, fallback Repository) Repository {
+	if repo, ok := ctx.Value(repositoryContextKey{}).(Repository); ok && repo != nil {
+		return repo
+	}
+	return fallback
+}
@@ -0,0 +1,74 @@
+package sms_service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"sms-app-backend/common"
+)
+
+// Kinds of sends reported to the failure webhook
+const (
+	failedSendSMS = "sms"
+	failedSendOTP = "otp"
+)
+
+// failureStreaks counts the sends to each provider that failed for good since
+// its last successful call
+type failureStreaks struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newFailureStreaks() *failureStreaks {
+	return &failureStreaks{counts: make(map[string]int)}
+}
+
+// Fail counts a failed send to provider and returns the length of its streak
+func (f *failureStreaks) Fail(provider string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[provider]++
+	return f.counts[provider]
+}
+
+// Reset ends the streak of provider after a successful call
+func (f *failureStreaks) Reset(provider string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.counts, provider)
+}
+
+// alertSendFailure reports a send that won't be retried to the failure
+// webhook once FailureAlertThreshold sends to the provider failed in a row.
+// The webhook is posted asynchronously and carries the masked phone number.
+func (s *SMSServiceImpl) alertSendFailure(kind, id, provider, phone string, sendErr error) {
+	streak := s.failureStreaks.Fail(provider)
+	if !s.failureAlerts.Enabled() || streak < s.config.FailureAlertThreshold {
+		return
+	}
+
+	event := map[string]interface{}{
+		"kind":                 kind,
+		"id":                   id,
+		"phone":                common.MaskPhone(phone),
+		"provider":             provider,
+		"error":                sendErr.Error(),
+		"consecutive_failures": streak,
+		"failed_at":            s.now(),
+	}
+
+	queued := s.async.Go(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		if err := s.failureAlerts.Send(ctx, "send.failed", event); err != nil {
+			log.Printf("Failed to post send failure alert for %s %s: %v", kind, id, err)
+		}
+	})
+	if !queued {
+		log.Printf("Dropped send failure alert for %s %s, service is shutting down", kind, id)
+	}
+}
@@ -0,0 +1,118 @@
+package sms_service
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"sms-app-backend/common"
+	"sms-app-backend/models"
+)
+
+const (
+	// defaultAnalyticsDays is the length of the series when no from day is given
+	defaultAnalyticsDays = 30
+	// maxAnalyticsDays caps the length of a daily analytics series
+	maxAnalyticsDays = 366
+	// analyticsDayFormat is the layout of the days in a series
+	analyticsDayFormat = "2006-01-02"
+)
+
+// GetDailyAnalytics returns the daily OTP, SMS and callback activity for the
+// UTC days from to to inclusive. A zero to means today and a zero from the 30
+// days ending at to. Every day in the range is present, with zeros where
+// nothing happened, so the series can be charted as is. Counting is done by
+// the repository so records are never loaded here.
+func (s *LogsServiceImpl) GetDailyAnalytics(ctx context.Context, from, to time.Time) (*models.DailyAnalyticsResponse, error) {
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	to = startOfDay(to)
+	if from.IsZero() {
+		from = to.AddDate(0, 0, 1-defaultAnalyticsDays)
+	}
+	from = startOfDay(from)
+
+	if from.After(to) {
+		return nil, common.NewValidationErrors(map[string]string{"from": "must not be after to"})
+	}
+	days := int(to.Sub(from)/(24*time.Hour)) + 1
+	if days > maxAnalyticsDays {
+		return nil, common.NewValidationErrors(map[string]string{"from": "range must not exceed 366 days"})
+	}
+
+	fromDay, toDay := from.Format(analyticsDayFormat), to.Format(analyticsDayFormat)
+	log.Printf("Retrieving daily analytics from %s to %s", fromDay, toDay)
+
+	end := to.AddDate(0, 0, 1)
+	otps, err := s.repoFor(ctx).OTPSends().DailyTotals(ctx, fromDay, toDay)
+	if err != nil {
+		log.Printf("Failed to aggregate OTP sends: %v", err)
+		return nil, common.NewInternalError("Failed to aggregate OTP activity")
+	}
+	sms, err := s.repoFor(ctx).SMS().DailyCounts(ctx, from, end)
+	if err != nil {
+		log.Printf("Failed to aggregate SMS: %v", err)
+		return nil, common.NewInternalError("Failed to aggregate SMS activity")
+	}
+	callbacks, err := s.repoFor(ctx).Callback().DailyCounts(ctx, from, end)
+	if err != nil {
+		log.Printf("Failed to aggregate callbacks: %v", err)
+		return nil, common.NewInternalError("Failed to aggregate callback activity")
+	}
+
+	series := make([]models.DailyAnalytics, days)
+	index := make(map[string]*models.DailyAnalytics, days)
+	for i := range series {
+		series[i].Day = from.AddDate(0, 0, i).Format(analyticsDayFormat)
+		index[series[i].Day] = &series[i]
+	}
+	for _, count := range otps {
+		if day, ok := index[count.Day]; ok {
+			day.OTPsSent = count.Total
+			day.OTPsVerified = count.Succeeded
+		}
+	}
+	for _, count := range sms {
+		if day, ok := index[count.Day]; ok {
+			day.SMSSent = count.Total
+			day.SMSDelivered = count.Succeeded
+			day.SMSFailed = count.Failed
+		}
+	}
+	for _, count := range callbacks {
+		if day, ok := index[count.Day]; ok {
+			day.CallbacksRequested = count.Total
+			day.CallbacksCompleted = count.Succeeded
+		}
+	}
+	for i := range series {
+		day := &series[i]
+		day.OTPVerifyRate = successRate(day.OTPsVerified, day.OTPsSent)
+		day.SMSDeliveryRate = successRate(day.SMSDelivered, day.SMSSent)
+		day.CallbackCompletionRate = successRate(day.CallbacksCompleted, day.CallbacksRequested)
+	}
+
+	return &models.DailyAnalyticsResponse{
+		From: fromDay,
+		To:   toDay,
+		Days: series,
+	}, nil
+}
+
+// startOfDay truncates t to midnight UTC
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// successRate returns succeeded as a fraction of total rounded to four places,
+// or 0 when there is nothing to rate. Verifications counted on a later day than
+// their send can lift a day's rate above 1, so it is capped.
+func successRate(succeeded, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Min(1, math.Round(float64(succeeded)/float64(total)*1e4)/1e4)
+}
@@ -0,0 +1,76 @@
+package sms_service
+
+import (
+	"context"
+	"sync"
+)
+
+// asyncQueue runs background tasks and lets shutdown wait for them to finish.
+// Tasks receive a context that is cancelled if shutdown gives up on them.
+type asyncQueue struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closed  bool
+	pending int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newAsyncQueue() *asyncQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &asyncQueue{ctx: ctx, cancel: cancel}
+}
+
+// Go runs task in the background. It returns false, without running the task,
+// once the queue has been drained.
+func (q *asyncQueue) Go(task func(ctx context.Context)) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+
+	q.pending++
+	q.wg.Add(1)
+	go func() {
+		defer q.done()
+		task(q.ctx)
+	}()
+	return true
+}
+
+func (q *asyncQueue) done() {
+	q.mu.Lock()
+	q.pending--
+	q.mu.Unlock()
+	q.wg.Done()
+}
+
+// Drain stops accepting tasks and waits for pending ones until ctx expires, then
+// cancels whatever is still running. It reports how many tasks finished during
+// the drain and how many were dropped.
+func (q *asyncQueue) Drain(ctx context.Context) (flushed, dropped int) {
+	q.mu.Lock()
+	q.closed = true
+	queued := q.pending
+	q.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+	}
+	q.cancel()
+
+	q.mu.Lock()
+	dropped = q.pending
+	q.mu.Unlock()
+	return queued - dropped, dropped
+}
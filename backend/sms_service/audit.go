@@ -0,0 +1,139 @@
+package sms_service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sms-app-backend/common"
+	"sms-app-backend/models"
+	"sms-app-backend/repository"
+)
+
+// auditAttempts is how many times an audit record is written before it is dropped
+const auditAttempts = 3
+
+// auditRetryDelay is the wait before the second write attempt, growing with every attempt
+var auditRetryDelay = time.Second
+
+// recordAudit appends a state change to the audit log of repo. The record is
+// written in the background so the change never waits on the audit log, and
+// retried when the write fails. The actor is taken from ctx and the phone
+// number is masked.
+func recordAudit(ctx context.Context, queue *asyncQueue, repo repository.Repository, audit models.Audit) {
+	actor := common.ActorFromContext(ctx)
+	audit.Actor, audit.ActorID = actor.Type, actor.ID
+	audit.Phone = common.MaskPhone(audit.Phone)
+	if audit.CreatedAt.IsZero() {
+		audit.CreatedAt = time.Now()
+	}
+
+	queued := queue.Go(func(ctx context.Context) {
+		for attempt := 1; ; attempt++ {
+			writeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			err := repo.Audit().Create(writeCtx, &audit)
+			cancel()
+			if err == nil {
+				return
+			}
+			if attempt == auditAttempts {
+				log.Printf("Dropped audit record %s of %s after %d attempts: %v", audit.Type, audit.TargetID, attempt, err)
+				return
+			}
+
+			select {
+			case <-time.After(time.Duration(attempt) * auditRetryDelay):
+			case <-ctx.Done():
+				log.Printf("Dropped audit record %s of %s, service is shutting down: %v", audit.Type, audit.TargetID, err)
+				return
+			}
+		}
+	})
+	if !queued {
+		log.Printf("Dropped audit record %s of %s, service is shutting down", audit.Type, audit.TargetID)
+	}
+}
+
+// smsCounterparty returns the other party of a message: the sender of an
+// inbound message, the recipient of any other
+func smsCounterparty(sms *models.SMS) string {
+	if sms.Direction == models.DirectionInbound {
+		return sms.From
+	}
+	return sms.To
+}
+
+// auditSMS records an SMS message moving from one status to its current one;
+// an empty from status records a newly stored message
+func (s *SMSServiceImpl) auditSMS(ctx context.Context, sms *models.SMS, from models.Status) {
+	phone := smsCounterparty(sms)
+	audit := models.Audit{
+		Type:       models.AuditKindSMS + "." + string(sms.Status),
+		TargetID:   sms.ID.Hex(),
+		Phone:      phone,
+		FromStatus: string(from),
+		ToStatus:   string(sms.Status),
+		CreatedAt:  s.now(),
+	}
+	if sms.Status == models.StatusFailed || sms.Status == models.StatusDead {
+		audit.Reason = sms.FailedReason
+	}
+	recordAudit(ctx, s.async, s.repoFor(ctx), audit)
+}
+
+// auditSMSDeleted records an SMS message being soft-deleted by the actor of ctx
+func (s *SMSServiceImpl) auditSMSDeleted(ctx context.Context, sms *models.SMS) {
+	phone := smsCounterparty(sms)
+	recordAudit(ctx, s.async, s.repoFor(ctx), models.Audit{
+		Type:       models.AuditKindSMS + "." + models.SMSAuditDeleted,
+		TargetID:   sms.ID.Hex(),
+		Phone:      phone,
+		FromStatus: string(sms.Status),
+		ToStatus:   models.SMSAuditDeleted,
+		CreatedAt:  s.now(),
+	})
+}
+
+// auditOTP records an OTP moving to a new state
+func (s *SMSServiceImpl) auditOTP(ctx context.Context, otp *models.OTP, from, to string) {
+	recordAudit(ctx, s.async, s.repoFor(ctx), models.Audit{
+		Type:       models.AuditKindOTP + "." + to,
+		TargetID:   otp.ID.Hex(),
+		Phone:      otp.Phone,
+		FromStatus: from,
+		ToStatus:   to,
+		Purpose:    otp.Purpose,
+		CreatedAt:  s.now(),
+	})
+}
+
+// otpState describes the state of a stored OTP for the audit log
+func (s *SMSServiceImpl) otpState(otp *models.OTP) string {
+	switch {
+	case otp.LockedUntil.After(s.now()):
+		return models.OTPStateLocked
+	case otp.Verified:
+		return models.OTPStateVerified
+	default:
+		return models.OTPStateSent
+	}
+}
+
+// auditCallback records a callback request moving from one status to another;
+// an empty from status records a new request
+func (s *CallbackServiceImpl) auditCallback(ctx context.Context, callback *models.Callback, from, to models.Status) {
+	recordAudit(ctx, s.async, s.repoFor(ctx), models.Audit{
+		Type:       models.AuditKindCallback + "." + string(to),
+		TargetID:   callback.ID.Hex(),
+		Phone:      callback.PhoneNumber,
+		FromStatus: string(from),
+		ToStatus:   string(to),
+	})
+}
+
+// Shutdown flushes pending audit records, giving up on whatever is still
+// being written when ctx expires
+func (s *CallbackServiceImpl) Shutdown(ctx context.Context) {
+	flushed, dropped := s.async.Drain(ctx)
+	log.Printf("Callback service shut down: flushed %d pending tasks, dropped %d", flushed, dropped)
+}
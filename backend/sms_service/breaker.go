@@ -0,0 +1,173 @@
+package sms_service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"sms-app-backend/models"
+)
+
+// errCircuitOpen is returned by callProvider without calling a provider whose
+// circuit is open
+var errCircuitOpen = errors.New("provider circuit is open")
+
+// circuitBreakers tracks the outcome of calls per provider and stops calling
+// a provider that keeps failing, so slow or failing calls don't pile up
+type circuitBreakers struct {
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state string
+	// requests and failures are counted since windowStart while closed
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	// probing is set while the single half-open call is running
+	probing bool
+}
+
+func newCircuitBreakers() *circuitBreakers {
+	return &circuitBreakers{circuits: make(map[string]*circuit)}
+}
+
+// Allow reports whether provider may be called. Once an open circuit has
+// waited OpenFor it lets a single probe call through; its outcome, recorded
+// with Success, Failure or Cancel, closes or reopens the circuit.
+func (b *circuitBreakers) Allow(provider string, policy BreakerPolicy, now time.Time) error {
+	if !policy.enabled() {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(provider, now)
+	switch c.state {
+	case models.CircuitOpen:
+		if now.Sub(c.openedAt) < policy.OpenFor {
+			return errCircuitOpen
+		}
+		c.state = models.CircuitHalfOpen
+		c.probing = true
+		return nil
+	case models.CircuitHalfOpen:
+		if c.probing {
+			return errCircuitOpen
+		}
+		c.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Ready reports whether Allow would let a call through, without claiming the
+// half-open probe
+func (b *circuitBreakers) Ready(provider string, policy BreakerPolicy, now time.Time) bool {
+	if !policy.enabled() {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(provider, now)
+	switch c.state {
+	case models.CircuitOpen:
+		return now.Sub(c.openedAt) >= policy.OpenFor
+	case models.CircuitHalfOpen:
+		return !c.probing
+	default:
+		return true
+	}
+}
+
+// Success records a successful call, closing a half-open circuit
+func (b *circuitBreakers) Success(provider string, policy BreakerPolicy, now time.Time) {
+	if !policy.enabled() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(provider, now)
+	if c.state == models.CircuitHalfOpen {
+		*c = circuit{state: models.CircuitClosed, windowStart: now}
+		return
+	}
+	b.rollWindow(c, policy, now)
+	c.requests++
+}
+
+// Failure records a failed call. The circuit opens when the failed probe of a
+// half-open circuit or, while closed, FailureRatio of at least MinRequests
+// calls in the current window failed.
+func (b *circuitBreakers) Failure(provider string, policy BreakerPolicy, now time.Time) {
+	if !policy.enabled() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(provider, now)
+	if c.state == models.CircuitHalfOpen {
+		c.state, c.openedAt, c.probing = models.CircuitOpen, now, false
+		return
+	}
+	b.rollWindow(c, policy, now)
+	c.requests++
+	c.failures++
+	if c.requests >= policy.MinRequests && float64(c.failures)/float64(c.requests) >= policy.FailureRatio {
+		c.state, c.openedAt = models.CircuitOpen, now
+	}
+}
+
+// Cancel records a call abandoned by its caller, which says nothing about the
+// provider; a half-open circuit lets the next call probe instead
+func (b *circuitBreakers) Cancel(provider string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.circuits[provider]; ok {
+		c.probing = false
+	}
+}
+
+// State returns the circuit state of provider; an open circuit that waited
+// OpenFor is reported as half-open since the next call probes the provider
+func (b *circuitBreakers) State(provider string, policy BreakerPolicy, now time.Time) string {
+	if !policy.enabled() {
+		return models.CircuitClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(provider, now)
+	if c.state == models.CircuitOpen && now.Sub(c.openedAt) >= policy.OpenFor {
+		return models.CircuitHalfOpen
+	}
+	return c.state
+}
+
+func (b *circuitBreakers) circuit(provider string, now time.Time) *circuit {
+	c, ok := b.circuits[provider]
+	if !ok {
+		c = &circuit{state: models.CircuitClosed, windowStart: now}
+		b.circuits[provider] = c
+	}
+	return c
+}
+
+// rollWindow starts counting afresh once the window of a closed circuit ended
+func (b *circuitBreakers) rollWindow(c *circuit, policy BreakerPolicy, now time.Time) {
+	if now.Sub(c.windowStart) >= policy.Window {
+		c.requests, c.failures, c.windowStart = 0, 0, now
+	}
+}
@@ -0,0 +1,109 @@
+package sms_service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"sms-app-backend/common"
+	"sms-app-backend/models"
+	"sms-app-backend/repository"
+)
+
+// checkBruteForceBlock rejects verification of a phone number flagged for a
+// brute-force attack until its block ends. A failing lookup lets the attempt
+// through, like an unreachable rate limit store.
+func (s *SMSServiceImpl) checkBruteForceBlock(ctx context.Context, phone string, now time.Time) error {
+	if !s.config.BruteForce.enabled() {
+		return nil
+	}
+
+	flagged, err := s.repoFor(ctx).FlaggedPhones().FindActive(ctx, phone, now)
+	if err == repository.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to check brute-force block for %s: %v", phone, err)
+		return nil
+	}
+
+	log.Printf("Verification for %s blocked after a suspected brute-force attack", phone)
+	return common.NewRateLimitError("Too many failed verification attempts. Please try again later.").
+		WithRetryAfter(flagged.BlockedUntil.Sub(now))
+}
+
+// detectBruteForce counts a wrong code for phone across all of its OTPs. Once
+// the count within the policy window reaches the threshold, the phone number is
+// flagged for review, blocked from verification and reported to the failure
+// alert webhook.
+func (s *SMSServiceImpl) detectBruteForce(ctx context.Context, phone string, now time.Time) {
+	policy := s.config.BruteForce
+	if !policy.enabled() {
+		return
+	}
+
+	failures, err := s.verifyLimiter.Record(ctx, "bruteforce|"+phone, policy.Window, now)
+	if err != nil {
+		log.Printf("Rate limit store unavailable, not counting failure of %s: %v", phone, err)
+		return
+	}
+	if failures < float64(policy.Failures) {
+		return
+	}
+
+	flagged := &models.FlaggedPhone{
+		Phone:         phone,
+		Failures:      int(failures),
+		WindowSeconds: int(policy.Window / time.Second),
+		FlaggedAt:     now,
+		BlockedUntil:  now.Add(policy.BlockFor),
+	}
+	if err := s.repoFor(ctx).FlaggedPhones().Create(ctx, flagged); err != nil {
+		log.Printf("Failed to flag %s for a suspected brute-force attack: %v", phone, err)
+		return
+	}
+	log.Printf("Flagged %s for a suspected brute-force attack: %d failed verifications within %v, blocked until %v",
+		phone, flagged.Failures, policy.Window, flagged.BlockedUntil)
+
+	if !s.failureAlerts.Enabled() {
+		return
+	}
+	event := map[string]interface{}{
+		"phone":          common.MaskPhone(phone),
+		"failures":       flagged.Failures,
+		"window_seconds": flagged.WindowSeconds,
+		"flagged_at":     flagged.FlaggedAt,
+		"blocked_until":  flagged.BlockedUntil,
+	}
+	queued := s.async.Go(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		if err := s.failureAlerts.Send(ctx, "otp.brute_force", event); err != nil {
+			log.Printf("Failed to post brute-force alert for %s: %v", common.MaskPhone(phone), err)
+		}
+	})
+	if !queued {
+		log.Printf("Dropped brute-force alert for %s, service is shutting down", common.MaskPhone(phone))
+	}
+}
+
+// ListFlaggedPhones returns a page of phone numbers flagged for brute-force
+// attacks, newest first, for review
+func (s *SMSServiceImpl) ListFlaggedPhones(ctx context.Context, offset, limit int) (*models.PaginatedResponse, error) {
+	flagged, err := s.repoFor(ctx).FlaggedPhones().List(ctx, offset, limit)
+	if err != nil {
+		log.Printf("Failed to list flagged phone numbers: %v", err)
+		return nil, common.NewInternalError("Failed to retrieve flagged phone numbers")
+	}
+	total, err := s.repoFor(ctx).FlaggedPhones().Count(ctx)
+	if err != nil {
+		log.Printf("Failed to count flagged phone numbers: %v", err)
+		return nil, common.NewInternalError("Failed to retrieve flagged phone numbers")
+	}
+
+	if flagged == nil {
+		flagged = []*models.FlaggedPhone{}
+	}
+	return models.NewPaginatedResponse(flagged, len(flagged), limit, offset, total), nil
+}
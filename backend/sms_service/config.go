@@ -0,0 +1,569 @@
+package sms_service
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sms-app-backend/common"
+	"sms-app-backend/models"
+	"sms-app-backend/repository"
+	"sms-app-backend/sms_service/transport"
+	"sms-app-backend/webhook"
+)
+
+// Config holds the tunable settings of the SMS service
+type Config struct {
+	// ExposeOTP includes generated OTP codes in send responses. Only for
+	// development and tests; never enable it in production.
+	ExposeOTP bool
+	// OTPTTL is how long a new OTP stays valid
+	OTPTTL time.Duration
+	// OTPMaxAttempts is how many wrong codes an OTP accepts before it is used up
+	OTPMaxAttempts int
+	// OTPResendCooldown is the minimum time between two OTPs sent to the same
+	// phone number, measured from when the previous OTP was sent
+	OTPResendCooldown time.Duration
+	// OTPMaxResends caps how often the same OTP can be sent again through
+	// ResendOTP; after that a new OTP has to be requested
+	OTPMaxResends int
+	// OTPLockout is how long verification and new OTPs are blocked for a phone
+	// number once its OTP reached MaxAttempts wrong codes (0 disables the lockout)
+	OTPLockout time.Duration
+	// OTPVerifyGrace is how long a verified OTP is kept so that the client that
+	// verified it can re-submit the same code, e.g. when it retries after a lost
+	// response, and succeed again. Each retry counts as an attempt. Keep it
+	// short: it is how long an intercepted code can be replayed from the same
+	// client (0 deletes OTPs as soon as they are verified)
+	OTPVerifyGrace time.Duration
+	// OTPVoiceEscalationDelay is how long after an OTP was sent it may be
+	// escalated to a voice call when it still isn't verified
+	OTPVoiceEscalationDelay time.Duration
+	// MaxOTPLifetime is how long after it was created the OTP cleanup deletes an
+	// OTP even when it hasn't expired, a safeguard for OTPs stored without a
+	// usable expiry (0 disables the sweep)
+	MaxOTPLifetime time.Duration
+	// OTPMessages holds the OTP text per language; nil uses DefaultOTPMessages
+	OTPMessages OTPMessageCatalog
+	// DailyOTPLimit caps how many OTPs a phone number can request per UTC day (0 disables the cap)
+	DailyOTPLimit int
+	// VerifyRateLimit is the default verification limit per phone number
+	VerifyRateLimit RateLimit
+	// PurposeVerifyRateLimits overrides VerifyRateLimit for phone numbers whose
+	// pending OTP has one of these purposes
+	PurposeVerifyRateLimits map[string]RateLimit
+	// GlobalVerifyRateLimit caps verification throughput across all phone numbers
+	GlobalVerifyRateLimit RateLimit
+	// RateLimitFailOpen lets verifications through unthrottled while the rate
+	// limit store is unreachable; by default they are refused
+	RateLimitFailOpen bool
+	// VerifyFailureBackoff locks a phone number out of verification after repeated
+	// wrong codes, regardless of how many OTPs were requested in between
+	VerifyFailureBackoff BackoffPolicy
+	// BruteForce flags and blocks phone numbers receiving an unusual number of
+	// wrong codes across OTPs, e.g. an attacker cycling through fresh codes
+	BruteForce BruteForcePolicy
+	// DefaultMonthlySMSQuota applies to users without their own quota (0 means unlimited)
+	DefaultMonthlySMSQuota int
+	// AnonymousMonthlySMSQuota is shared by every send without an authenticated
+	// user (0 means unlimited)
+	AnonymousMonthlySMSQuota int
+	// MaxSMSSegments caps how many segments a message may be split into; longer
+	// messages are rejected (0 disables the cap)
+	MaxSMSSegments int
+	// MaxInFlightPerNumber caps concurrent sends to the same destination number;
+	// additional simultaneous sends are rejected (0 disables the cap)
+	MaxInFlightPerNumber int
+	// SMSDedupWindow is how far back a send that asks for deduplication looks
+	// for the same message to the same number; a match is returned instead of
+	// sending again (0 disables deduplication)
+	SMSDedupWindow time.Duration
+	// MaxConcurrentSends caps concurrent calls to each provider; further sends
+	// wait for a free slot (0 disables the cap)
+	MaxConcurrentSends int
+	// ProviderTimeout bounds each provider call (0 only uses the request's deadline)
+	ProviderTimeout time.Duration
+	// ProviderBreaker stops calling a provider that keeps failing
+	ProviderBreaker BreakerPolicy
+	// ProviderConcurrency overrides MaxConcurrentSends for specific providers,
+	// keyed by provider name ("whatsapp" for WhatsApp OTPs)
+	ProviderConcurrency map[string]int
+	// SMSRetry controls the background resending of failed SMS messages
+	SMSRetry RetryPolicy
+	// FailureAlertThreshold is how many sends to a provider must fail in a row,
+	// after any retries, before each further failure is posted to the failure
+	// webhook set with WithFailureAlerts
+	FailureAlertThreshold int
+	// JobTimeout bounds each run of the background OTP cleanup, SMS retry,
+	// status poll and retention purge routines so a stalled database can't wedge them
+	JobTimeout time.Duration
+	// RetentionPeriod is how long SMS, OTP, callback and audit records and OTP
+	// send counters are kept before the purge routine deletes them (0 keeps them
+	// forever)
+	RetentionPeriod time.Duration
+	// RetentionPurgeInterval is how often records older than RetentionPeriod are purged
+	RetentionPurgeInterval time.Duration
+	// OTPStatusCacheTTL is how long an OTP status lookup is reused; sending,
+	// verifying or deleting the OTP drops it sooner (0 disables the cache)
+	OTPStatusCacheTTL time.Duration
+	// ProviderHealthTTL is how long a provider health check result is reused
+	ProviderHealthTTL time.Duration
+	// StatusPollInterval is how often pending and sent messages are checked with
+	// their provider for a delivery status (0 disables polling)
+	StatusPollInterval time.Duration
+	// StatusPollMaxAge stops polling messages that never reached a final status
+	StatusPollMaxAge time.Duration
+	// SegmentRates is the price of one SMS segment by destination country calling
+	// code (e.g. "44"), used by cost estimates
+	SegmentRates map[string]float64
+	// DefaultSegmentRate prices segments to countries missing from SegmentRates
+	DefaultSegmentRate float64
+	// RateCurrency is the currency of the segment rates
+	RateCurrency string
+	// AllowedCallingCodes restricts SMS and OTP destinations to these country
+	// calling codes (e.g. "1", "44"); empty allows every country
+	AllowedCallingCodes []string
+	// BlockedCallingCodes rejects destinations in these countries, even when allowed
+	BlockedCallingCodes []string
+	// AllowedSenderIDs are the sender IDs, brand names or purchased numbers, a
+	// send may ask for; sends asking for any other are rejected, so callers
+	// can't send as a brand that isn't ours. Empty rejects every sender ID.
+	AllowedSenderIDs []string
+	// OTPSenderName is the registered brand OTP texts are sent from in the
+	// countries of OTPSenderCallingCodes; elsewhere they come from the number
+	OTPSenderName string
+	// OTPSenderCallingCodes are the country calling codes OTPSenderName is used for
+	OTPSenderCallingCodes []string
+	// BlockedContent rejects messages matching any of these patterns, e.g. terms
+	// that may not be sent from our numbers; see ParseContentBlocklist
+	BlockedContent []*regexp.Regexp
+}
+
+// RateLimit allows Limit events within a rolling Window
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// BackoffPolicy locks a key for Base once Threshold consecutive failures are
+// reached, doubling the lockout for each further failure up to Max
+type BackoffPolicy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// BruteForcePolicy flags a phone number once Failures wrong codes were
+// submitted for it within a rolling Window, across any number of OTPs. Flagged
+// numbers are blocked from verification for BlockFor. A zero Failures, Window
+// or BlockFor disables detection.
+type BruteForcePolicy struct {
+	Failures int
+	Window   time.Duration
+	BlockFor time.Duration
+}
+
+func (p BruteForcePolicy) enabled() bool {
+	return p.Failures > 0 && p.Window > 0 && p.BlockFor > 0
+}
+
+// BreakerPolicy opens the circuit of a provider once FailureRatio of at least
+// MinRequests calls within Window failed. Calls to an open circuit fail fast
+// for OpenFor; then a single probe call closes the circuit again when it
+// succeeds. A zero MinRequests, FailureRatio or Window disables the breaker.
+type BreakerPolicy struct {
+	MinRequests  int
+	FailureRatio float64
+	Window       time.Duration
+	OpenFor      time.Duration
+}
+
+func (p BreakerPolicy) enabled() bool {
+	return p.MinRequests > 0 && p.FailureRatio > 0 && p.Window > 0
+}
+
+// RetryPolicy resends failed messages, waiting Interval after a failure and
+// twice as long after every further failed resend, until MaxRetries resends
+// have failed, then moves them to the dead status. Failures older than MaxAge
+// are dead-lettered without being resent. A zero MaxRetries or Interval
+// disables background retries.
+type RetryPolicy struct {
+	MaxRetries int
+	Interval   time.Duration
+	MaxAge     time.Duration
+}
+
+// enabled reports whether failed messages are resent in the background
+func (p RetryPolicy) enabled() bool {
+	return p.MaxRetries > 0 && p.Interval > 0
+}
+
+// backoff returns how long after its last failure a message that failed
+// retries resends is resent again: Interval, doubling per resend, up to a day
+func (p RetryPolicy) backoff(retries int) time.Duration {
+	wait := p.Interval
+	for i := 0; i < retries && wait > 0 && wait < 24*time.Hour; i++ {
+		wait *= 2
+	}
+	if wait > 24*time.Hour {
+		wait = 24 * time.Hour
+	}
+	return wait
+}
+
+// DefaultConfig returns the default service configuration
+func DefaultConfig() Config {
+	return Config{
+		OTPTTL:                  5 * time.Minute,
+		OTPMaxAttempts:          3,
+		OTPResendCooldown:       3 * time.Minute,
+		OTPMaxResends:           3,
+		OTPLockout:              15 * time.Minute,
+		OTPVerifyGrace:          30 * time.Second,
+		OTPVoiceEscalationDelay: time.Minute,
+		MaxOTPLifetime:          24 * time.Hour,
+		DailyOTPLimit:           10,
+		VerifyRateLimit:         RateLimit{Limit: 10, Window: 15 * time.Minute},
+		GlobalVerifyRateLimit:   RateLimit{Limit: 100, Window: time.Second},
+		VerifyFailureBackoff:    BackoffPolicy{Threshold: 5, Base: 30 * time.Second, Max: time.Hour},
+		BruteForce:              BruteForcePolicy{Failures: 20, Window: time.Hour, BlockFor: 6 * time.Hour},
+		MaxSMSSegments:          10,
+		MaxInFlightPerNumber:    1,
+		SMSDedupWindow:          2 * time.Minute,
+		MaxConcurrentSends:      20,
+		ProviderTimeout:         10 * time.Second,
+		ProviderBreaker:         BreakerPolicy{MinRequests: 10, FailureRatio: 0.5, Window: time.Minute, OpenFor: 30 * time.Second},
+		SMSRetry:                RetryPolicy{MaxRetries: 3, Interval: 5 * time.Minute, MaxAge: 24 * time.Hour},
+		FailureAlertThreshold:   1,
+		JobTimeout:              time.Minute,
+		RetentionPurgeInterval:  6 * time.Hour,
+		OTPStatusCacheTTL:       2 * time.Second,
+		ProviderHealthTTL:       time.Minute,
+		StatusPollInterval:      time.Minute,
+		StatusPollMaxAge:        24 * time.Hour,
+		DefaultSegmentRate:      0.0075,
+		RateCurrency:            "USD",
+	}
+}
+
+// destinationAllowed reports whether messages may be sent to a country calling code
+func (c Config) destinationAllowed(callingCode string) bool {
+	for _, blocked := range c.BlockedCallingCodes {
+		if callingCode == blocked {
+			return false
+		}
+	}
+	if len(c.AllowedCallingCodes) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedCallingCodes {
+		if callingCode == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// senderIDAllowed reports whether sends may ask for a sender ID
+func (c Config) senderIDAllowed(senderID string) bool {
+	return slices.Contains(c.AllowedSenderIDs, senderID)
+}
+
+// otpSender returns the sender of OTP texts to phone, or empty for the
+// provider's from-number
+func (c Config) otpSender(phone string) string {
+	if c.OTPSenderName == "" {
+		return ""
+	}
+	code := common.CallingCode(phone)
+	for _, senderCode := range c.OTPSenderCallingCodes {
+		if code == senderCode {
+			return c.OTPSenderName
+		}
+	}
+	return ""
+}
+
+// sendConcurrency returns the concurrent call limit of a provider
+func (c Config) sendConcurrency(provider string) int {
+	if limit, ok := c.ProviderConcurrency[provider]; ok {
+		return limit
+	}
+	return c.MaxConcurrentSends
+}
+
+// segmentRate returns the price of one segment sent to a country calling code
+func (c Config) segmentRate(callingCode string) float64 {
+	if rate, ok := c.SegmentRates[callingCode]; ok {
+		return rate
+	}
+	return c.DefaultSegmentRate
+}
+
+// verifyRateLimit returns the verification limit that applies to a purpose
+func (c Config) verifyRateLimit(purpose string) RateLimit {
+	if limit, ok := c.PurposeVerifyRateLimits[purpose]; ok {
+		return limit
+	}
+	return c.VerifyRateLimit
+}
+
+// ParseRateLimit parses a rate limit in the form "<limit>/<window>", e.g. "5/15m"
+func ParseRateLimit(value string) (RateLimit, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected <limit>/<window>", value)
+	}
+
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit count %q", parts[0])
+	}
+
+	window, err := time.ParseDuration(parts[1])
+	if err != nil || window <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit window %q", parts[1])
+	}
+
+	return RateLimit{Limit: limit, Window: window}, nil
+}
+
+// ParsePurposeRateLimits parses per-purpose rate limits in the form
+// "payment:3/30m,login:10/15m"
+func ParsePurposeRateLimits(value string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || !models.IsValidPurpose(parts[0]) {
+			return nil, fmt.Errorf("invalid purpose rate limit %q, expected <purpose>:<limit>/<window>", entry)
+		}
+
+		limit, err := ParseRateLimit(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		limits[parts[0]] = limit
+	}
+	return limits, nil
+}
+
+// ParseProviderConcurrency parses per-provider concurrency limits in the form
+// "plivo:10,whatsapp:5"
+func ParseProviderConcurrency(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid provider concurrency %q, expected <provider>:<limit>", entry)
+		}
+
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid provider concurrency limit %q", parts[1])
+		}
+		limits[parts[0]] = limit
+	}
+	return limits, nil
+}
+
+// ParseSegmentRates parses per-country segment rates in the form
+// "1:0.0075,44:0.04", keyed by country calling code
+func ParseSegmentRates(value string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || !isCallingCode(parts[0]) {
+			return nil, fmt.Errorf("invalid segment rate %q, expected <calling code>:<rate>", entry)
+		}
+
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid segment rate %q", parts[1])
+		}
+		rates[parts[0]] = rate
+	}
+	return rates, nil
+}
+
+// ParseCallingCodes parses a comma-separated list of country calling codes,
+// e.g. "1,44,91"; a leading "+" is accepted
+func ParseCallingCodes(value string) ([]string, error) {
+	var codes []string
+	for _, code := range strings.Split(value, ",") {
+		code = strings.TrimPrefix(strings.TrimSpace(code), "+")
+		if code == "" {
+			continue
+		}
+		if !isCallingCode(code) {
+			return nil, fmt.Errorf("invalid country calling code %q", code)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// ParseSenderIDs parses a comma-separated list of sender IDs, each up to 11
+// letters and digits or an E.164 number
+func ParseSenderIDs(value string) ([]string, error) {
+	var senderIDs []string
+	for _, senderID := range strings.Split(value, ",") {
+		senderID = strings.TrimSpace(senderID)
+		if senderID == "" {
+			continue
+		}
+		if !transport.IsValidSenderID(senderID) {
+			return nil, fmt.Errorf("invalid sender ID %q", senderID)
+		}
+		senderIDs = append(senderIDs, senderID)
+	}
+	return senderIDs, nil
+}
+
+// ParseContentBlocklist parses one regular expression per line; blank lines
+// and lines starting with # are skipped. Patterns match case-insensitively
+// anywhere in a message, so use \b to block whole words only, e.g. \bcasino\b.
+func ParseContentBlocklist(text string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, err := regexp.Compile("(?i)" + line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocklist pattern on line %d: %w", i+1, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// blockedContent returns the first BlockedContent pattern matching message
+func (c Config) blockedContent(message string) (*regexp.Regexp, bool) {
+	for _, pattern := range c.BlockedContent {
+		if pattern.MatchString(message) {
+			return pattern, true
+		}
+	}
+	return nil, false
+}
+
+// isCallingCode reports whether code is a complete country calling code, i.e.
+// the code a number starting with it would be attributed to
+func isCallingCode(code string) bool {
+	return common.CallingCode("+"+code+"000000000") == code
+}
+
+// Option configures an SMSServiceImpl
+type Option func(*SMSServiceImpl)
+
+// WithConfig overrides the default service configuration
+func WithConfig(cfg Config) Option {
+	return func(s *SMSServiceImpl) {
+		s.config = cfg
+	}
+}
+
+// WithProviders registers additional SMS clients that requests can select by
+// provider name. The default client is always registered.
+func WithProviders(clients ...transport.SMSClient) Option {
+	return func(s *SMSServiceImpl) {
+		for _, client := range clients {
+			s.registerProvider(client)
+		}
+	}
+}
+
+// WithOTPGenerator replaces the crypto-random generator of OTP codes, e.g.
+// with a TOTP-style generator or a deterministic one in tests
+func WithOTPGenerator(generator OTPGenerator) Option {
+	return func(s *SMSServiceImpl) {
+		s.otpGenerator = generator
+	}
+}
+
+// WithTenantRepositories runs the background jobs (OTP cleanup, SMS retries and
+// status polling) against every tenant repository as well as the default one.
+// Requests use the tenant repository carried by their context.
+func WithTenantRepositories(factory repository.RepositoryFactory) Option {
+	return func(s *SMSServiceImpl) {
+		s.tenants = factory
+	}
+}
+
+// WithWhatsAppClient enables OTP delivery over WhatsApp
+func WithWhatsAppClient(client transport.WhatsAppClient) Option {
+	return func(s *SMSServiceImpl) {
+		s.whatsApp = client
+	}
+}
+
+// WithVoiceOTPClient enables escalating unverified OTPs to a voice call
+func WithVoiceOTPClient(client transport.VoiceClient) Option {
+	return func(s *SMSServiceImpl) {
+		s.voice = client
+	}
+}
+
+// CallbackOption configures a CallbackServiceImpl
+type CallbackOption func(*CallbackServiceImpl)
+
+// WithVoiceClient lets the callback service hang up calls that were already placed
+func WithVoiceClient(client transport.VoiceClient) CallbackOption {
+	return func(s *CallbackServiceImpl) {
+		s.voiceClient = client
+	}
+}
+
+// UserOption configures a UserServiceImpl
+type UserOption func(*UserServiceImpl)
+
+// WithPhoneVerifier sends and checks the OTPs that confirm phone number
+// changes; without one phone numbers can't be changed
+func WithPhoneVerifier(verifier PhoneVerifier) UserOption {
+	return func(s *UserServiceImpl) {
+		s.verifier = verifier
+	}
+}
+
+// WithRateLimitStore counts verification rate limits in store instead of
+// process memory, e.g. a RedisRateLimitStore shared by all replicas
+func WithRateLimitStore(store RateLimitStore) Option {
+	return func(s *SMSServiceImpl) {
+		s.verifyLimiter = newSlidingWindowLimiter(store)
+	}
+}
+
+// WithWebhookSender forwards SMS status events to signed webhook destinations
+func WithWebhookSender(sender *webhook.Sender) Option {
+	return func(s *SMSServiceImpl) {
+		s.webhooks = sender
+	}
+}
+
+// WithFailureAlerts posts a signed "send.failed" event to sender for each SMS
+// or OTP send that failed for good, e.g. to page whoever is on call during a
+// provider outage, and an "otp.brute_force" event for each flagged phone number
+func WithFailureAlerts(sender *webhook.Sender) Option {
+	return func(s *SMSServiceImpl) {
+		s.failureAlerts = sender
+	}
+}
@@ -0,0 +1,134 @@
+package sms_service
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"sms-app-backend/common"
+	"sms-app-backend/models"
+)
+
+// exportFlushRows is how many CSV rows are buffered before flushing to the writer
+const exportFlushRows = 100
+
+var (
+	smsExportHeader      = []string{"id", "direction", "from", "to", "message", "segments", "encoding", "status", "provider", "provider_id", "user_id", "sent_at", "delivered_at", "created_at"}
+	otpExportHeader      = []string{"id", "phone", "purpose", "channel", "attempts", "max_attempts", "expires_at", "created_at"}
+	callbackExportHeader = []string{"id", "phone_number", "message", "priority", "status", "call_uuid", "requested_at", "created_at"}
+)
+
+// ExportLogs streams records of the given type created within [from, to) to w
+// as CSV, oldest first. Rows are written as they are read from the repository
+// so large exports are never held in memory. OTP codes are not exported.
+func (s *LogsServiceImpl) ExportLogs(ctx context.Context, logType string, from, to time.Time, w io.Writer) error {
+	log.Printf("Exporting %s logs from %v to %v", logType, from, to)
+
+	out := &csvExporter{writer: csv.NewWriter(w)}
+
+	var header []string
+	var stream func() error
+	switch logType {
+	case models.LogTypeSMS:
+		header = smsExportHeader
+		stream = func() error {
+			return s.repoFor(ctx).SMS().Stream(ctx, from, to, func(sms *models.SMS) error {
+				deliveredAt := ""
+				if sms.DeliveredAt != nil {
+					deliveredAt = formatExportTime(*sms.DeliveredAt)
+				}
+				return out.write([]string{
+					sms.ID.Hex(), sms.Direction, sms.From, sms.To, sms.Message,
+					strconv.Itoa(sms.Segments), sms.Encoding, sms.Status.String(), sms.Provider, sms.ProviderID, sms.UserID,
+					formatExportTime(sms.SentAt), deliveredAt, formatExportTime(sms.CreatedAt),
+				})
+			})
+		}
+	case models.LogTypeOTP:
+		header = otpExportHeader
+		stream = func() error {
+			return s.repoFor(ctx).OTP().Stream(ctx, from, to, func(otp *models.OTP) error {
+				return out.write([]string{
+					otp.ID.Hex(), otp.Phone, otp.Purpose, otp.Channel,
+					strconv.Itoa(otp.Attempts), strconv.Itoa(otp.MaxAttempts),
+					formatExportTime(otp.ExpiresAt), formatExportTime(otp.CreatedAt),
+				})
+			})
+		}
+	case models.LogTypeCallback:
+		header = callbackExportHeader
+		stream = func() error {
+			return s.repoFor(ctx).Callback().Stream(ctx, from, to, func(callback *models.Callback) error {
+				return out.write([]string{
+					callback.ID.Hex(), callback.PhoneNumber, callback.Message, callback.Priority, callback.Status.String(), callback.CallUUID,
+					formatExportTime(callback.RequestedAt), formatExportTime(callback.CreatedAt),
+				})
+			})
+		}
+	default:
+		return common.NewValidationError("Type must be sms, otp or callback")
+	}
+
+	err := out.writer.Write(header)
+	if err == nil {
+		err = stream()
+	}
+	if flushErr := out.flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		log.Printf("Failed to export %s logs after %d rows: %v", logType, out.rows, err)
+		return common.NewInternalError("Failed to export logs")
+	}
+
+	log.Printf("Exported %d %s log rows", out.rows, logType)
+	return nil
+}
+
+// csvExporter writes CSV rows, flushing every exportFlushRows rows so output
+// reaches the client while the export is still running
+type csvExporter struct {
+	writer *csv.Writer
+	rows   int
+}
+
+func (e *csvExporter) write(record []string) error {
+	for i, cell := range record {
+		record[i] = escapeCSVFormula(cell)
+	}
+	if err := e.writer.Write(record); err != nil {
+		return err
+	}
+	e.rows++
+	if e.rows%exportFlushRows == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *csvExporter) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// escapeCSVFormula prefixes cells that spreadsheets would evaluate as a
+// formula with a single quote, so a message body such as =HYPERLINK(...) is
+// shown as text when the export is opened
+func escapeCSVFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// formatExportTime renders a timestamp for CSV export, leaving zero times empty
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
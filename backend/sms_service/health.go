@@ -0,0 +1,48 @@
+package sms_service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sms-app-backend/models"
+	"sms-app-backend/sms_service/transport"
+)
+
+// providerHealthCache remembers the last health check per provider so
+// frequent polling doesn't hit the provider's API on every request
+type providerHealthCache struct {
+	mu      sync.Mutex
+	results map[string]*models.ProviderHealth
+}
+
+// newProviderHealthCache creates an empty provider health cache
+func newProviderHealthCache() *providerHealthCache {
+	return &providerHealthCache{results: make(map[string]*models.ProviderHealth)}
+}
+
+// Check returns the cached health of client when younger than ttl and runs a
+// fresh check otherwise. Failed checks are cached too, so an unreachable
+// provider isn't retried on every request. Checks for the same cache are
+// serialized so concurrent callers share a single provider call.
+func (c *providerHealthCache) Check(ctx context.Context, client transport.SMSClient, ttl time.Duration, now time.Time) *models.ProviderHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := client.GetProvider()
+	if cached, ok := c.results[name]; ok && now.Sub(cached.CheckedAt) < ttl {
+		return cached
+	}
+
+	health, err := client.ProviderStatus(ctx)
+	if err != nil {
+		health = &models.ProviderHealth{Provider: name, Error: err.Error()}
+	}
+	health.CheckedAt = now
+
+	// A check cut short by the caller says nothing about the provider
+	if ctx.Err() == nil {
+		c.results[name] = health
+	}
+	return health
+}
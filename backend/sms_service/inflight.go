@@ -0,0 +1,101 @@
+package sms_service
+
+import (
+	"context"
+	"sync"
+)
+
+// inFlightGuard caps how many sends to the same destination may run at once.
+// Unlike the rate limiters it is not time-windowed: a slot frees up as soon as
+// the send that holds it finishes.
+type inFlightGuard struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func newInFlightGuard() *inFlightGuard {
+	return &inFlightGuard{inFlight: make(map[string]int)}
+}
+
+// Acquire claims a slot for key if fewer than limit sends are in flight.
+// A limit of 0 or less disables the guard. Every successful Acquire must be
+// paired with a Release.
+func (g *inFlightGuard) Acquire(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.inFlight[key] >= limit {
+		return false
+	}
+	g.inFlight[key]++
+	return true
+}
+
+// Release frees a slot claimed by Acquire
+func (g *inFlightGuard) Release(key string, limit int) {
+	if limit <= 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.inFlight[key] <= 1 {
+		delete(g.inFlight, key)
+		return
+	}
+	g.inFlight[key]--
+}
+
+// sendSemaphore caps concurrent provider calls per provider so traffic spikes
+// don't trip the provider's own throttling. Unlike inFlightGuard, callers wait
+// for a free slot instead of being rejected.
+type sendSemaphore struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newSendSemaphore() *sendSemaphore {
+	return &sendSemaphore{slots: make(map[string]chan struct{})}
+}
+
+// Acquire waits until fewer than limit calls to provider are running or ctx is
+// done. A limit of 0 or less disables the semaphore. The limit of a provider is
+// fixed by its first Acquire. Every successful Acquire must be paired with a
+// Release.
+func (s *sendSemaphore) Acquire(ctx context.Context, provider string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	select {
+	case s.slotsFor(provider, limit) <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot claimed by Acquire
+func (s *sendSemaphore) Release(provider string, limit int) {
+	if limit <= 0 {
+		return
+	}
+	<-s.slotsFor(provider, limit)
+}
+
+func (s *sendSemaphore) slotsFor(provider string, limit int) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slots, ok := s.slots[provider]
+	if !ok {
+		slots = make(chan struct{}, limit)
+		s.slots[provider] = slots
+	}
+	return slots
+}
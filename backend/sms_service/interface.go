@@ -2,25 +2,67 @@ package sms_service
 
 import (
 	"context"
+	"io"
+	"time"
+
 	"sms-app-backend/models"
 )
 
 // SMSService defines the interface for SMS operations
 type SMSService interface {
-	SendSMS(ctx context.Context, req models.SMSRequest) error
+	SendSMS(ctx context.Context, req models.SMSRequest) (*models.SMSResponse, error)
+	GetSMS(ctx context.Context, id string) (*models.SMS, error)
+	DeleteSMS(ctx context.Context, id string) error
+	GetSMSStatuses(ctx context.Context, ids []string, userID string) (*models.BatchStatusResponse, error)
+	EstimateSMSCost(ctx context.Context, recipients []string, message string) (*models.SMSEstimateResponse, error)
+	RetrySMS(ctx context.Context, id string) (*models.SMS, error)
+	GetSMSQuota(ctx context.Context, userID string) (*models.SMSQuota, error)
 	SendOTP(ctx context.Context, req models.OTPRequest) (*models.OTPResponse, error)
+	ResendOTP(ctx context.Context, req models.OTPRequest) (*models.OTPResponse, error)
+	EscalateOTP(ctx context.Context, phone string) (*models.OTPResponse, error)
 	VerifyOTP(ctx context.Context, req models.VerifyOTPRequest) (*models.VerifyOTPResponse, error)
+	GetOTPStatus(ctx context.Context, phone string) (*models.OTPStatus, error)
+	RevokeOTP(ctx context.Context, phone string) error
+	ResetOTPAttempts(ctx context.Context, phone string) error
+	GetStats(ctx context.Context, phone string) (*models.SMSStats, error)
+	ProviderHealth(ctx context.Context, provider string) (*models.ProviderHealth, error)
+	OptOut(ctx context.Context, phone, reason string) error
+	OptIn(ctx context.Context, phone string) error
+	IsSuppressed(ctx context.Context, phone string) (bool, error)
+	HandleInboundMessage(ctx context.Context, msg models.InboundMessage) error
+	HandleDeliveryReport(ctx context.Context, report models.DeliveryReport) error
+	ListFlaggedPhones(ctx context.Context, offset, limit int) (*models.PaginatedResponse, error)
 	CleanupExpiredOTPs()
+	RetryFailedSMS()
+	PollDeliveryStatus()
 }
 
 // CallbackService defines the interface for callback operations
 type CallbackService interface {
 	RequestCallback(ctx context.Context, req models.CallbackRequest) (*models.CallbackResponse, error)
 	GetCallbackStatus(ctx context.Context, requestID string) (*models.Callback, error)
-	UpdateCallbackStatus(ctx context.Context, requestID, status string) error
+	UpdateCallbackStatus(ctx context.Context, requestID string, status models.Status) error
+	CancelCallback(ctx context.Context, requestID string) (*models.Callback, error)
+	AnswerCallback(ctx context.Context, requestID, callUUID string) (*models.Callback, error)
 }
 
 // LogsService defines the interface for logs operations
 type LogsService interface {
-	GetLogs(ctx context.Context, limit int) (map[string]interface{}, error)
-} 
\ No newline at end of file
+	GetLogs(ctx context.Context, limit int, before models.LogCursor, filter models.SMSLogFilter) (map[string]interface{}, error)
+	ListSMS(ctx context.Context, filter models.SMSLogFilter, offset, limit int) (*models.PaginatedResponse, error)
+	ListAudit(ctx context.Context, filter models.AuditFilter, offset, limit int) (*models.PaginatedResponse, error)
+	SearchByPhone(ctx context.Context, query, match string, limit int) (*models.PhoneSearchResult, error)
+	SearchLogs(ctx context.Context, phone string, from, to time.Time, limit int) (*models.PhoneTimeline, error)
+	ExportLogs(ctx context.Context, logType string, from, to time.Time, w io.Writer) error
+	GetDailyAnalytics(ctx context.Context, from, to time.Time) (*models.DailyAnalyticsResponse, error)
+}
+
+// UserService defines the interface for user account operations
+type UserService interface {
+	LookupUser(ctx context.Context, phone, email string) (*models.UserProfile, error)
+	GetProfile(ctx context.Context, userID string) (*models.UserProfile, error)
+	UpdateProfile(ctx context.Context, userID string, update models.UserProfileUpdate) (*models.UserProfile, error)
+	InitiatePhoneChange(ctx context.Context, userID, phone string) (*models.OTPResponse, error)
+	ConfirmPhoneChange(ctx context.Context, userID, phone, code string) (*models.UserProfile, error)
+	DeleteAccount(ctx context.Context, userID, code string) (*models.AccountDeletionSummary, error)
+}
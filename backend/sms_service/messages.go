@@ -0,0 +1,91 @@
+package sms_service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultOTPLanguage is used for requests without a language or with one
+// missing from the catalog
+const defaultOTPLanguage = "en"
+
+// OTPMessageCatalog maps language codes (e.g. "en", "pt-br") to the text of
+// the OTP SMS. Templates contain {code}, replaced by the OTP, and optionally
+// {minutes}, replaced by how many minutes the code stays valid.
+type OTPMessageCatalog map[string]string
+
+// DefaultOTPMessages is the built-in catalog
+var DefaultOTPMessages = OTPMessageCatalog{
+	"en": "Your OTP is: {code}. Valid for {minutes} minutes. Do not share this code.",
+	"es": "Tu código OTP es: {code}. Válido por {minutes} minutos. No compartas este código.",
+	"pt": "Seu código OTP é: {code}. Válido por {minutes} minutos. Não compartilhe este código.",
+	"fr": "Votre code OTP est : {code}. Valable {minutes} minutes. Ne partagez pas ce code.",
+	"de": "Ihr OTP lautet: {code}. Gültig für {minutes} Minuten. Teilen Sie diesen Code nicht.",
+	"hi": "आपका OTP है: {code}. {minutes} मिनट के लिए मान्य. यह कोड किसी के साथ साझा न करें.",
+}
+
+// LoadOTPMessageCatalog reads a catalog from a JSON object of language to
+// template, e.g. {"en": "Your code is {code}", "it": "Il tuo codice è {code}"}.
+// Languages missing from the file, English included, keep their built-in text.
+func LoadOTPMessageCatalog(path string) (OTPMessageCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates map[string]string
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("invalid OTP message catalog: %w", err)
+	}
+
+	catalog := make(OTPMessageCatalog, len(DefaultOTPMessages)+len(templates))
+	for language, template := range DefaultOTPMessages {
+		catalog[language] = template
+	}
+	for language, template := range templates {
+		if !strings.Contains(template, "{code}") {
+			return nil, fmt.Errorf("OTP message for %q doesn't contain {code}", language)
+		}
+		catalog[strings.ToLower(language)] = template
+	}
+	return catalog, nil
+}
+
+// Render returns the OTP text in language, falling back from a regional
+// variant such as "pt-BR" to "pt" and then to English
+func (c OTPMessageCatalog) Render(language, code string, validity time.Duration) string {
+	template := c.template(language)
+	minutes := int((validity + time.Minute - 1) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return strings.NewReplacer("{code}", code, "{minutes}", strconv.Itoa(minutes)).Replace(template)
+}
+
+func (c OTPMessageCatalog) template(language string) string {
+	language = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(language), "_", "-"))
+	if template, ok := c[language]; ok && language != "" {
+		return template
+	}
+	if base, _, found := strings.Cut(language, "-"); found {
+		if template, ok := c[base]; ok {
+			return template
+		}
+	}
+	if template, ok := c[defaultOTPLanguage]; ok {
+		return template
+	}
+	return DefaultOTPMessages[defaultOTPLanguage]
+}
+
+// otpMessages returns the configured OTP message catalog
+func (s *SMSServiceImpl) otpMessages() OTPMessageCatalog {
+	if s.config.OTPMessages != nil {
+		return s.config.OTPMessages
+	}
+	return DefaultOTPMessages
+}
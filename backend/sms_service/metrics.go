@@ -0,0 +1,70 @@
+package sms_service
+
+import (
+	"sync"
+	"time"
+
+	"sms-app-backend/models"
+)
+
+// verifyDurationBuckets are the upper bounds of the time-to-verify histogram
+var verifyDurationBuckets = []time.Duration{
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+}
+
+// durationHistogram records durations into cumulative buckets
+type durationHistogram struct {
+	mu      sync.Mutex
+	buckets []time.Duration
+	counts  []int
+	count   int
+	sum     time.Duration
+}
+
+func newDurationHistogram(buckets []time.Duration) *durationHistogram {
+	return &durationHistogram{
+		buckets: buckets,
+		counts:  make([]int, len(buckets)),
+	}
+}
+
+// Observe records a single duration
+func (h *durationHistogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if d <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += d
+}
+
+// Snapshot returns the recorded distribution and its average
+func (h *durationHistogram) Snapshot() models.DurationStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := models.DurationStats{
+		Count:        h.count,
+		Distribution: make([]models.DurationBucket, 0, len(h.buckets)+1),
+	}
+	if h.count > 0 {
+		stats.AverageSeconds = h.sum.Seconds() / float64(h.count)
+	}
+	for i, bound := range h.buckets {
+		stats.Distribution = append(stats.Distribution, models.DurationBucket{
+			LE:    bound.String(),
+			Count: h.counts[i],
+		})
+	}
+	stats.Distribution = append(stats.Distribution, models.DurationBucket{LE: "+Inf", Count: h.count})
+	return stats
+}
@@ -0,0 +1,49 @@
+package sms_service
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// otpLength is the number of digits in every OTP; verification only accepts codes of this length
+const otpLength = 6
+
+// OTPGenerator creates the codes sent by SendOTP
+type OTPGenerator interface {
+	// Generate returns a numeric code of exactly length digits
+	Generate(length int) (string, error)
+}
+
+// RandomOTPGenerator draws each digit from crypto/rand. It is the default generator.
+type RandomOTPGenerator struct{}
+
+// Generate returns length random digits
+func (RandomOTPGenerator) Generate(length int) (string, error) {
+	digits := make([]byte, length)
+	for i := range digits {
+		num, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate random number: %w", err)
+		}
+		digits[i] = byte('0' + num.Int64())
+	}
+	return string(digits), nil
+}
+
+// generateOTP creates a code with the configured generator and checks it can be verified
+func (s *SMSServiceImpl) generateOTP() (string, error) {
+	otp, err := s.otpGenerator.Generate(otpLength)
+	if err != nil {
+		return "", err
+	}
+	if len(otp) != otpLength {
+		return "", fmt.Errorf("generator returned a %d character OTP, expected %d", len(otp), otpLength)
+	}
+	for _, r := range otp {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("generator returned a non-numeric OTP")
+		}
+	}
+	return otp, nil
+}
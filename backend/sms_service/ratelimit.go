@@ -0,0 +1,184 @@
+package sms_service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// slidingWindowLimiter counts events per key within a rolling time window.
+// Events are counted per fixed window in a RateLimitStore; the count of the
+// previous window is weighted by how much of it still overlaps the rolling
+// window, which approximates a true sliding window without storing every event.
+type slidingWindowLimiter struct {
+	store RateLimitStore
+}
+
+// newSlidingWindowLimiter creates a sliding window limiter counting in store
+func newSlidingWindowLimiter(store RateLimitStore) *slidingWindowLimiter {
+	return &slidingWindowLimiter{store: store}
+}
+
+// Allow records an event for key and reports whether it stays within limit
+// events in the window. The event is counted with the store's atomic
+// increment and the decision uses the count it returns, so concurrent
+// replicas can't all slip under the limit. Rejected events count too, so a
+// client hammering a limited key stays limited. When the limit is exceeded it
+// returns false and how long until enough events left the window. Store
+// errors are returned for the caller to decide.
+func (l *slidingWindowLimiter) Allow(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	if limit.Limit <= 0 || limit.Window <= 0 {
+		return true, 0, nil
+	}
+
+	previous, current, elapsed, err := l.count(ctx, key, limit.Window, now)
+	if err != nil {
+		return false, 0, err
+	}
+
+	max := float64(limit.Limit)
+	if float64(previous)*(1-elapsed)+float64(current) > max {
+		// The next event is allowed once the weighted count leaves room for it
+		return false, retryAfter(float64(previous), float64(current), max-1, elapsed, limit.Window), nil
+	}
+	return true, 0, nil
+}
+
+// Record counts an event for key and returns how many events happened within
+// the rolling window, including this one
+func (l *slidingWindowLimiter) Record(ctx context.Context, key string, window time.Duration, now time.Time) (float64, error) {
+	previous, current, elapsed, err := l.count(ctx, key, window, now)
+	if err != nil {
+		return 0, err
+	}
+	return float64(previous)*(1-elapsed) + float64(current), nil
+}
+
+// Reset forgets the events counted for key in the rolling window, e.g. once
+// an admin lifted a lockout
+func (l *slidingWindowLimiter) Reset(ctx context.Context, key string, window time.Duration, now time.Time) error {
+	if window <= 0 {
+		return nil
+	}
+	previousKey, currentKey, _ := windowKeys(key, window, now)
+	return l.store.Delete(ctx, previousKey, currentKey)
+}
+
+// count increments the counter of key for the fixed window containing now. It
+// returns the count of the previous window, the incremented count of the
+// current one and the elapsed fraction of the current window.
+func (l *slidingWindowLimiter) count(ctx context.Context, key string, window time.Duration, now time.Time) (int64, int64, float64, error) {
+	previousKey, currentKey, elapsed := windowKeys(key, window, now)
+
+	previous, err := l.store.Get(ctx, previousKey)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	// The counter is still read as the previous window during the next one
+	current, err := l.store.Incr(ctx, currentKey, 2*window)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return previous, current, elapsed, nil
+}
+
+// windowKeys returns the store keys of the counters of key for the previous
+// and the current fixed window, and the elapsed fraction of the current one
+func windowKeys(key string, window time.Duration, now time.Time) (string, string, float64) {
+	index := now.UnixNano() / int64(window)
+	elapsed := float64(now.Sub(time.Unix(0, index*int64(window)))) / float64(window)
+	prefix := fmt.Sprintf("%s|%d|", key, int64(window))
+	return prefix + strconv.FormatInt(index-1, 10), prefix + strconv.FormatInt(index, 10), elapsed
+}
+
+// retryAfter estimates how long until the weighted count of a limited key
+// drops to max, given the counts of the previous and current window and
+// the elapsed fraction of the current one
+func retryAfter(previous, current, max, elapsed float64, window time.Duration) time.Duration {
+	var wait float64
+	if current < max {
+		// The previous window fades out within the current one
+		wait = 1 - (max-current)/previous - elapsed
+	} else {
+		// The current window has to fade out within the next one
+		wait = 1 - elapsed + 1 - max/current
+	}
+	if wait <= 0 {
+		return time.Millisecond
+	}
+	return time.Duration(wait * float64(window))
+}
+
+// failureBackoff locks a key out after repeated failures, doubling the lockout
+// with every further failure. Failures survive across OTPs, so requesting a new
+// code does not reset the budget; only a success does.
+type failureBackoff struct {
+	mu      sync.Mutex
+	entries map[string]*backoffEntry
+}
+
+type backoffEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// newFailureBackoff creates an empty failure backoff tracker
+func newFailureBackoff() *failureBackoff {
+	return &failureBackoff{entries: make(map[string]*backoffEntry)}
+}
+
+// Check reports whether key is currently locked out and for how long
+func (b *failureBackoff) Check(key string, now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.entries[key]
+	if !ok || !now.Before(entry.lockedUntil) {
+		return false, 0
+	}
+	return true, entry.lockedUntil.Sub(now)
+}
+
+// Fail records a failure for key. Once failures reach the policy threshold the
+// key is locked for Base, doubling per further failure up to Max. Failures older
+// than Max are forgotten.
+func (b *failureBackoff) Fail(key string, policy BackoffPolicy, now time.Time) {
+	if policy.Threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.entries[key]
+	if !ok || now.Sub(entry.lastFailure) > policy.Max {
+		entry = &backoffEntry{}
+		b.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures < policy.Threshold {
+		return
+	}
+
+	lockout := policy.Base
+	for i := policy.Threshold; i < entry.failures && lockout < policy.Max; i++ {
+		lockout *= 2
+	}
+	if lockout > policy.Max {
+		lockout = policy.Max
+	}
+	entry.lockedUntil = now.Add(lockout)
+}
+
+// Succeed clears the failure history for key
+func (b *failureBackoff) Succeed(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.entries, key)
+}
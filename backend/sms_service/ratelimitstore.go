@@ -0,0 +1,145 @@
+package sms_service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitStore holds the counters behind the verification rate limits.
+// Replicas behind a load balancer must share a store, such as
+// RedisRateLimitStore, for the limits to apply across all of them.
+type RateLimitStore interface {
+	// Incr adds one to the counter of key and returns the new count. A new
+	// counter expires after ttl.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Get returns the counter of key, 0 when it doesn't exist or has expired
+	Get(ctx context.Context, key string) (int64, error)
+	// Delete removes the counters of keys
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// memoryStoreSweepInterval is how often Incr removes expired counters, so keys
+// that are never used again don't pile up
+const memoryStoreSweepInterval = time.Minute
+
+// MemoryRateLimitStore keeps counters in process memory, so every replica
+// counts on its own
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	counters  map[string]*rateCounter
+	lastSweep time.Time
+}
+
+type rateCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{counters: make(map[string]*rateCounter)}
+}
+
+// Incr adds one to the counter of key
+func (s *MemoryRateLimitStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= memoryStoreSweepInterval {
+		s.sweep(now)
+	}
+
+	counter, ok := s.counters[key]
+	if !ok || !now.Before(counter.expiresAt) {
+		counter = &rateCounter{expiresAt: now.Add(ttl)}
+		s.counters[key] = counter
+	}
+	counter.count++
+	return counter.count, nil
+}
+
+// Get returns the counter of key
+func (s *MemoryRateLimitStore) Get(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok {
+		return 0, nil
+	}
+	if !time.Now().Before(counter.expiresAt) {
+		delete(s.counters, key)
+		return 0, nil
+	}
+	return counter.count, nil
+}
+
+// Delete removes the counters of keys
+func (s *MemoryRateLimitStore) Delete(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.counters, key)
+	}
+	return nil
+}
+
+// sweep removes expired counters; the caller holds s.mu
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, counter := range s.counters {
+		if !now.Before(counter.expiresAt) {
+			delete(s.counters, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// RedisRateLimitStore keeps counters in Redis so all replicas share them
+type RedisRateLimitStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisRateLimitStore creates a store on client. Keys are prefixed with
+// prefix, e.g. "sms_app:ratelimit:", to keep them apart from other data.
+func NewRedisRateLimitStore(client redis.UniversalClient, prefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client, prefix: prefix}
+}
+
+// Incr adds one to the counter of key. The expiry is only set on a new key, so
+// a busy counter still expires ttl after it was created.
+func (s *RedisRateLimitStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, s.prefix+key)
+		pipe.ExpireNX(ctx, s.prefix+key, ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// Get returns the counter of key
+func (s *RedisRateLimitStore) Get(ctx context.Context, key string) (int64, error) {
+	count, err := s.client.Get(ctx, s.prefix+key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+// Delete removes the counters of keys
+func (s *RedisRateLimitStore) Delete(ctx context.Context, keys ...string) error {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = s.prefix + key
+	}
+	return s.client.Del(ctx, prefixed...).Err()
+}
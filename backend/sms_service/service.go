@@ -2,27 +2,57 @@ package sms_service
 
 import (
 	"context"
-	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
 	"sms-app-backend/common"
 	"sms-app-backend/models"
 	"sms-app-backend/repository"
 	"sms-app-backend/sms_service/transport"
+	"sms-app-backend/webhook"
 )
 
 // SMSServiceImpl implements the SMSService interface
 type SMSServiceImpl struct {
-	repo        repository.Repository
-	smsClient   transport.SMSClient
+	repo          repository.Repository
+	tenants       repository.RepositoryFactory
+	smsClient     transport.SMSClient
+	otpGenerator  OTPGenerator
+	providers     map[string]transport.SMSClient
+	health        *providerHealthCache
+	otpStatus     *otpStatusCache
+	config        Config
+	verifyLimiter *slidingWindowLimiter
+	verifyBackoff *failureBackoff
+	webhooks      *webhook.Sender
+	failureAlerts *webhook.Sender
+	failureStreaks *failureStreaks
+	breakers      *circuitBreakers
+	whatsApp      transport.WhatsAppClient
+	voice         transport.VoiceClient
+	timeToVerify  *durationHistogram
+	inFlight      *inFlightGuard
+	sends         *sendSemaphore
+	async         *asyncQueue
+	stop          chan struct{}
+	now           func() time.Time
 }
 
 // CallbackServiceImpl implements the CallbackService interface
 type CallbackServiceImpl struct {
-	repo repository.Repository
+	repo        repository.Repository
+	voiceClient transport.VoiceClient
+	async       *asyncQueue
 }
 
 // LogsServiceImpl implements the LogsService interface
@@ -31,186 +61,1891 @@ type LogsServiceImpl struct {
 }
 
 // NewSMSService creates a new SMS service instance
-func NewSMSService(repo repository.Repository, smsClient transport.SMSClient) *SMSServiceImpl {
+func NewSMSService(repo repository.Repository, smsClient transport.SMSClient, opts ...Option) *SMSServiceImpl {
 	service := &SMSServiceImpl{
-		repo:      repo,
-		smsClient: smsClient,
+		repo:          repo,
+		smsClient:     smsClient,
+		config:        DefaultConfig(),
+		verifyLimiter: newSlidingWindowLimiter(NewMemoryRateLimitStore()),
+		verifyBackoff: newFailureBackoff(),
+		timeToVerify:  newDurationHistogram(verifyDurationBuckets),
+		inFlight:      newInFlightGuard(),
+		sends:         newSendSemaphore(),
+		health:        newProviderHealthCache(),
+		otpStatus:     newOTPStatusCache(),
+		async:         newAsyncQueue(),
+		failureStreaks: newFailureStreaks(),
+		breakers:      newCircuitBreakers(),
+		stop:          make(chan struct{}),
+		now:           time.Now,
+		otpGenerator:  RandomOTPGenerator{},
 	}
 
+	for _, opt := range opts {
+		opt(service)
+	}
+	service.registerProvider(smsClient)
+
 	// Start cleanup goroutine
 	go service.startCleanupRoutine()
 
+	// Start failed SMS retry goroutine
+	if service.config.SMSRetry.enabled() {
+		go service.startRetryRoutine()
+	}
+
+	// Start delivery status polling goroutine
+	if service.config.StatusPollInterval > 0 {
+		go service.startStatusPollRoutine()
+	}
+
+	// Start retention purge goroutine
+	if service.config.RetentionPeriod > 0 && service.config.RetentionPurgeInterval > 0 {
+		go service.startPurgeRoutine()
+	}
+
 	return service
 }
 
 // SendSMS sends a regular SMS message
-func (s *SMSServiceImpl) SendSMS(ctx context.Context, req models.SMSRequest) error {
+// The response carries the stored message's ID and status. When the record was
+// stored but the provider failed, both the failed response and an error are returned.
+func (s *SMSServiceImpl) SendSMS(ctx context.Context, req models.SMSRequest) (*models.SMSResponse, error) {
 	log.Printf("Sending SMS to %s: %s", req.PhoneNumber, req.Message)
-	
-	// Create SMS record
+
+	segments, encoding := common.SegmentCount(req.Message)
+
+	client, err := s.provider(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkMessageLength(req.Message); err != nil {
+		return nil, err
+	}
+	if err := s.checkContent(req.Message); err != nil {
+		return nil, err
+	}
+	if err := s.checkDestination(req.PhoneNumber); err != nil {
+		return nil, err
+	}
+	if err := s.checkSenderID(req.SenderID); err != nil {
+		return nil, err
+	}
+	if err := s.checkSuppressed(ctx, req.PhoneNumber); err != nil {
+		return nil, err
+	}
+
+	// Only one send to a handset at a time, so carriers don't flag bursts as spam
+	if err := s.acquireSendSlot(req.PhoneNumber); err != nil {
+		return nil, err
+	}
+	defer s.inFlight.Release(req.PhoneNumber, s.config.MaxInFlightPerNumber)
+
+	// A deduplicated send returns the same message sent moments ago instead of
+	// sending it twice, e.g. after a double-click
+	if req.Dedup && s.config.SMSDedupWindow > 0 {
+		duplicate, err := s.findDuplicateSMS(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if duplicate != nil {
+			log.Printf("Not sending duplicate of SMS %s to %s", duplicate.ID.Hex(), req.PhoneNumber)
+			response := smsResponse(duplicate)
+			response.Success = true
+			response.Duplicate = true
+			response.Message = "Identical SMS already sent recently, not sent again"
+			return response, nil
+		}
+	}
+
+	// Reserve a send from the sender's monthly quota; it is only given back
+	// when the message can't be stored
+	owner, month := quotaOwner(req.UserID), s.now().UTC().Format(quotaMonthFormat)
+	limit := s.quotaLimit(ctx, req.UserID)
+	if limit > 0 {
+		used, reserved, err := s.repoFor(ctx).SMSQuotas().Reserve(ctx, owner, month, limit)
+		if err != nil {
+			log.Printf("Failed to reserve SMS quota for %s: %v", owner, err)
+			return nil, common.NewInternalError("Failed to check SMS quota")
+		}
+		if !reserved {
+			log.Printf("Monthly SMS quota exhausted for %s (%d/%d)", owner, used, limit)
+			return nil, common.NewRateLimitError(fmt.Sprintf("Monthly SMS quota of %d messages exceeded", limit)).
+				WithRetryAfter(quotaResetsAt(s.now()).Sub(s.now()))
+		}
+	}
+
+	// Create SMS record; without a sender ID a client rotating over several
+	// numbers picks one here so the record shows it, others use their default
+	from := req.SenderID
+	if from == "" {
+		if rotator, ok := client.(transport.SenderRotator); ok {
+			from = rotator.NextSender()
+		}
+	}
+	recordedFrom := from
+	if recordedFrom == "" {
+		recordedFrom = client.GetProvider()
+	}
 	sms := &models.SMS{
-		From:     s.smsClient.GetProvider(),
-		To:       req.PhoneNumber,
-		Message:  req.Message,
-		Status:   models.StatusPending,
-		Provider: s.smsClient.GetProvider(),
+		UserID:    req.UserID,
+		Direction: models.DirectionOutbound,
+		From:      recordedFrom,
+		SenderID:  req.SenderID,
+		To:        req.PhoneNumber,
+		Message:   req.Message,
+		Segments:  segments,
+		Encoding:  encoding,
+		Status:    models.StatusPending,
+		Provider:  client.GetProvider(),
+		Metadata:  req.Metadata,
+		ValiditySeconds: req.ValiditySeconds,
+		MediaURLs: req.MediaURLs,
 	}
 
 	// Store SMS record
-	err := s.repo.SMS().Create(ctx, sms)
+	err = s.repoFor(ctx).SMS().Create(ctx, sms)
 	if err != nil {
 		log.Printf("Failed to store SMS record: %v", err)
-		return common.NewInternalError("Failed to store SMS record")
+		if limit > 0 {
+			if err := s.repoFor(ctx).SMSQuotas().Release(ctx, owner, month); err != nil {
+				log.Printf("Failed to release SMS quota for %s: %v", owner, err)
+			}
+		}
+		return nil, common.NewInternalError("Failed to store SMS record")
 	}
+	s.auditSMS(ctx, sms, "")
 
 	// Send SMS via provider
-	err = s.smsClient.SendSMS(ctx, req.PhoneNumber, req.Message)
+	err = s.callProvider(ctx, client.GetProvider(), func(ctx context.Context) error {
+		return client.SendSMS(ctx, from, req.PhoneNumber, req.Message, time.Duration(req.ValiditySeconds)*time.Second, req.MediaURLs)
+	})
 	if err != nil {
 		log.Printf("Failed to send SMS to %s: %v", req.PhoneNumber, err)
 		
-		// Update status to failed
-		s.repo.SMS().UpdateStatus(ctx, sms.ID.Hex(), models.StatusFailed)
+		// Update status to failed; the retry routine picks it up from here
+		s.repoFor(ctx).SMS().UpdateFailure(ctx, sms.ID.Hex(), models.StatusFailed, err.Error())
+		sms.Status = models.StatusFailed
+		sms.FailedReason = err.Error()
+		s.forwardStatus(sms)
+		s.auditSMS(ctx, sms, models.StatusPending)
+		if !s.config.SMSRetry.enabled() {
+			s.alertSendFailure(failedSendSMS, sms.ID.Hex(), sms.Provider, sms.To, err)
+		}
 		
-		return common.NewServiceUnavailableError("SMS provider")
+		appErr := common.NewProviderError(sms.Provider)
+		if errors.Is(err, errCircuitOpen) {
+			appErr = common.NewServiceUnavailableError(sms.Provider + " provider")
+		}
+		response := smsResponse(sms)
+		response.Message = appErr.Details
+		response.Code = appErr.Code
+		return response, appErr
 	}
 
 	// Update status to sent
-	err = s.repo.SMS().UpdateStatus(ctx, sms.ID.Hex(), models.StatusSent)
+	err = s.repoFor(ctx).SMS().UpdateStatus(ctx, sms.ID.Hex(), models.StatusSent)
 	if err != nil {
 		log.Printf("Failed to update SMS status: %v", err)
 	}
 
+	sms.Status = models.StatusSent
+	s.forwardStatus(sms)
+	s.auditSMS(ctx, sms, models.StatusPending)
+
 	log.Printf("SMS sent successfully to %s", req.PhoneNumber)
+	response := smsResponse(sms)
+	response.Success = true
+	response.Message = "SMS sent successfully"
+	return response, nil
+}
+
+// dedupScanLimit caps how many recent messages to a number are compared with a deduplicated send
+const dedupScanLimit = 50
+
+// findDuplicateSMS finds an outbound message with the same text and media
+// sent to the same number by the same user and sender ID within the dedup
+// window. Failed messages don't count, so a send that failed can be repeated.
+func (s *SMSServiceImpl) findDuplicateSMS(ctx context.Context, req models.SMSRequest) (*models.SMS, error) {
+	recent, err := s.repoFor(ctx).SMS().FindByPhoneBetween(ctx, req.PhoneNumber, s.now().Add(-s.config.SMSDedupWindow), time.Time{}, dedupScanLimit)
+	if err != nil {
+		log.Printf("Failed to look up recent SMS to %s: %v", req.PhoneNumber, err)
+		return nil, common.NewInternalError("Failed to check for duplicate SMS")
+	}
+
+	for _, sms := range recent {
+		if sms.Direction == models.DirectionInbound || sms.To != req.PhoneNumber || sms.Message != req.Message {
+			continue
+		}
+		// Another user's message isn't a duplicate, and returning it would leak it
+		if sms.UserID != req.UserID || sms.SenderID != req.SenderID {
+			continue
+		}
+		if sms.Status == models.StatusFailed || sms.Status == models.StatusDead {
+			continue
+		}
+		if !sameMediaURLs(sms.MediaURLs, req.MediaURLs) {
+			continue
+		}
+		return sms, nil
+	}
+	return nil, nil
+}
+
+// sameMediaURLs reports whether two messages carry the same attachments in the same order
+func sameMediaURLs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// smsResponse describes a stored SMS message for the send endpoint
+func smsResponse(sms *models.SMS) *models.SMSResponse {
+	return &models.SMSResponse{
+		ID:        sms.ID.Hex(),
+		PhoneNumber: sms.To,
+		NormalizedPhone: sms.To,
+		Status:    sms.Status,
+		Segments:  sms.Segments,
+		Encoding:  sms.Encoding,
+		Timestamp: sms.CreatedAt,
+	}
+}
+
+// RetrySMS resends a failed or dead-lettered SMS message on demand
+func (s *SMSServiceImpl) RetrySMS(ctx context.Context, id string) (*models.SMS, error) {
+	sms, err := s.repoFor(ctx).SMS().FindByID(ctx, id)
+	if err != nil {
+		return nil, lookupError(err, "SMS message")
+	}
+	if sms.Direction == models.DirectionInbound || (sms.Status != models.StatusFailed && sms.Status != models.StatusDead) {
+		return nil, common.NewConflictError("Only failed or dead outbound messages can be retried")
+	}
+
+	if err := s.resend(ctx, sms); err != nil {
+		return nil, err
+	}
+	return sms, nil
+}
+
+// retryBatchSize caps how many failed messages a single retry run picks up
+const retryBatchSize = 100
+
+// statusPollBatchSize is how many messages a delivery status poll loads at once
+const statusPollBatchSize = 100
+
+// retryClaimTimeout is how long a message may stay claimed by a resend before
+// the retry routine assumes the resend died with its process and frees it
+const retryClaimTimeout = 15 * time.Minute
+
+// RetryFailedSMS resends recently failed SMS messages, moving those that
+// exhausted their retries or are too old to the dead status
+func (s *SMSServiceImpl) RetryFailedSMS() {
+	s.runJob(s.retryFailedSMS)
+}
+
+func (s *SMSServiceImpl) retryFailedSMS(ctx context.Context) {
+	policy := s.config.SMSRetry
+
+	s.releaseStaleRetryClaims(ctx)
+
+	failed, err := s.repoFor(ctx).SMS().FindByStatus(ctx, models.StatusFailed, retryBatchSize)
+	if err != nil {
+		log.Printf("Failed to find failed SMS messages: %v", err)
+		return
+	}
+
+	for _, sms := range failed {
+		if sms.RetryCount >= policy.MaxRetries || (policy.MaxAge > 0 && s.now().Sub(sms.CreatedAt) > policy.MaxAge) {
+			s.deadLetter(ctx, sms, sms.FailedReason)
+			continue
+		}
+		// Wait longer after every failed resend instead of hitting the provider each run
+		if s.now().Before(sms.UpdatedAt.Add(policy.backoff(sms.RetryCount))) {
+			continue
+		}
+		if err := s.resend(ctx, sms); err != nil {
+			log.Printf("Retry %d of SMS %s failed: %v", sms.RetryCount, sms.ID.Hex(), err)
+		}
+	}
+}
+
+// releaseStaleRetryClaims moves messages left claimed by a resend that never
+// finished back to failed, so the retry routine picks them up again
+func (s *SMSServiceImpl) releaseStaleRetryClaims(ctx context.Context) {
+	claimed, err := s.repoFor(ctx).SMS().FindByStatus(ctx, models.StatusRetrying, retryBatchSize)
+	if err != nil {
+		log.Printf("Failed to find SMS messages being retried: %v", err)
+		return
+	}
+
+	for _, sms := range claimed {
+		if s.now().Sub(sms.UpdatedAt) < retryClaimTimeout {
+			continue
+		}
+		if err := s.repoFor(ctx).SMS().UpdateFailure(ctx, sms.ID.Hex(), models.StatusFailed, "retry interrupted"); err != nil {
+			log.Printf("Failed to release retry claim of SMS %s: %v", sms.ID.Hex(), err)
+		}
+	}
+}
+
+// PollDeliveryStatus asks providers for the delivery status of pending and sent
+// messages, for providers that don't push delivery reports
+func (s *SMSServiceImpl) PollDeliveryStatus() {
+	s.runJob(s.pollDeliveryStatus)
+}
+
+func (s *SMSServiceImpl) pollDeliveryStatus(ctx context.Context) {
+	var createdSince time.Time
+	if s.config.StatusPollMaxAge > 0 {
+		createdSince = s.now().Add(-s.config.StatusPollMaxAge)
+	}
+
+	// Messages are loaded a batch at a time, each batch starting after the
+	// last message of the previous one. Deleted messages are kept for the
+	// record, and their status tracked like delivery reports track it.
+	afterID := ""
+	for {
+		pending, err := s.repoFor(ctx).SMS().FindNonTerminal(repository.IncludeDeleted(ctx), createdSince, afterID, statusPollBatchSize)
+		if err != nil {
+			log.Printf("Failed to find SMS messages awaiting delivery: %v", err)
+			return
+		}
+
+		for _, sms := range pending {
+			if ctx.Err() != nil {
+				log.Printf("Delivery status polling stopped early: %v", ctx.Err())
+				return
+			}
+			// Without the provider's message ID there is nothing to look up
+			client, ok := s.providers[sms.Provider]
+			if sms.ProviderID == "" || !ok {
+				continue
+			}
+
+			status, err := client.FetchStatus(ctx, sms.ProviderID)
+			if err != nil {
+				log.Printf("Failed to fetch delivery status of SMS %s: %v", sms.ID.Hex(), err)
+				continue
+			}
+			s.applyDeliveryStatus(ctx, sms, status)
+		}
+
+		if len(pending) < statusPollBatchSize {
+			return
+		}
+		afterID = pending[len(pending)-1].ID.Hex()
+	}
+}
+
+// applyDeliveryStatus records a delivery status reported by the provider
+func (s *SMSServiceImpl) applyDeliveryStatus(ctx context.Context, sms *models.SMS, status models.Status) {
+	if status == sms.Status {
+		return
+	}
+
+	id := sms.ID.Hex()
+	var err error
+	switch status {
+	case models.StatusDelivered:
+		if err = s.repoFor(ctx).SMS().UpdateStatus(ctx, id, status); err == nil {
+			deliveredAt := s.now()
+			err = s.repoFor(ctx).SMS().UpdateDeliveryTime(ctx, id, deliveredAt)
+			sms.DeliveredAt = &deliveredAt
+		}
+	case models.StatusFailed:
+		// The provider accepted the message and gave up on it; resending could
+		// deliver it twice, so it is dead-lettered for an admin to retry
+		status = models.StatusDead
+		if status == sms.Status {
+			return
+		}
+		sms.FailedReason = "provider reported the message as undelivered"
+		err = s.repoFor(ctx).SMS().UpdateFailure(ctx, id, status, sms.FailedReason)
+	case models.StatusSent:
+		err = s.repoFor(ctx).SMS().UpdateStatus(ctx, id, status)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update delivery status of SMS %s: %v", id, err)
+		return
+	}
+
+	previous := sms.Status
+	sms.Status = status
+	s.forwardStatus(sms)
+	s.auditSMS(ctx, sms, previous)
+}
+
+// resend attempts delivery of a stored SMS again, counting the attempt and
+// dead-lettering the message once it has failed MaxRetries resends
+func (s *SMSServiceImpl) resend(ctx context.Context, sms *models.SMS) error {
+	id := sms.ID.Hex()
+
+	if err := s.checkSuppressed(ctx, sms.To); err != nil {
+		if appErr, ok := err.(*common.AppError); ok && appErr.Code == common.ErrCodeOptedOut {
+			s.deadLetter(ctx, sms, "recipient opted out")
+		}
+		return err
+	}
+
+	// A message past its validity period would be dropped by the provider anyway
+	validity, expired := remainingValidity(sms, s.now())
+	if expired {
+		s.deadLetter(ctx, sms, "validity period elapsed")
+		return common.NewConflictError("The message's validity period has elapsed")
+	}
+
+	// Claim the message so a concurrent retry run or manual retry doesn't
+	// resend it as well
+	claimedFrom := sms.Status
+	claimed, err := s.repoFor(ctx).SMS().ClaimRetry(ctx, id, claimedFrom)
+	if err != nil {
+		log.Printf("Failed to claim SMS %s for retry: %v", id, err)
+		return common.NewInternalError("Failed to claim SMS for retry")
+	}
+	if !claimed {
+		return common.NewConflictError("The message is already being retried")
+	}
+	// Exits that don't resend hand the message back as it was
+	release := func() {
+		if err := s.repoFor(ctx).SMS().UpdateStatus(ctx, id, claimedFrom); err != nil {
+			log.Printf("Failed to release retry claim of SMS %s: %v", id, err)
+		}
+	}
+
+	if err := s.acquireSendSlot(sms.To); err != nil {
+		release()
+		return err
+	}
+	defer s.inFlight.Release(sms.To, s.config.MaxInFlightPerNumber)
+
+	// Resend through the provider the message was routed to, if it is still registered
+	client, ok := s.providers[sms.Provider]
+	if !ok {
+		client = s.smsClient
+	}
+
+	// Resends while the provider's circuit is open don't use up retries
+	if !s.breakers.Ready(client.GetProvider(), s.config.ProviderBreaker, s.now()) {
+		release()
+		return common.NewServiceUnavailableError(client.GetProvider() + " provider")
+	}
+
+	if err := s.repoFor(ctx).SMS().IncrementRetryCount(ctx, id); err != nil {
+		release()
+		log.Printf("Failed to record retry of SMS %s: %v", id, err)
+		return common.NewInternalError("Failed to record SMS retry")
+	}
+	sms.RetryCount++
+
+	// Resend from the number recorded for the message, which a rotating client picked
+	from := sms.SenderID
+	if _, ok := client.(transport.SenderRotator); ok && from == "" && sms.From != client.GetProvider() {
+		from = sms.From
+	}
+
+	err = s.callProvider(ctx, client.GetProvider(), func(ctx context.Context) error {
+		return client.SendSMS(ctx, from, sms.To, sms.Message, validity, sms.MediaURLs)
+	})
+	if err != nil {
+		log.Printf("Failed to resend SMS %s to %s: %v", id, sms.To, err)
+
+		if sms.RetryCount >= s.config.SMSRetry.MaxRetries {
+			s.deadLetter(ctx, sms, err.Error())
+			s.alertSendFailure(failedSendSMS, id, sms.Provider, sms.To, err)
+		} else {
+			s.repoFor(ctx).SMS().UpdateFailure(ctx, id, models.StatusFailed, err.Error())
+			previous := sms.Status
+			sms.Status = models.StatusFailed
+			sms.FailedReason = err.Error()
+			s.forwardStatus(sms)
+			s.auditSMS(ctx, sms, previous)
+		}
+		return common.NewProviderError(sms.Provider)
+	}
+
+	if err := s.repoFor(ctx).SMS().UpdateStatus(ctx, id, models.StatusSent); err != nil {
+		log.Printf("Failed to update SMS status: %v", err)
+	}
+	previous := sms.Status
+	sms.Status = models.StatusSent
+	s.forwardStatus(sms)
+	s.auditSMS(ctx, sms, previous)
+
+	log.Printf("SMS %s resent successfully to %s after %d retries", id, sms.To, sms.RetryCount)
 	return nil
 }
 
-// NewLogsService creates a new logs service instance
-func NewLogsService(repo repository.Repository) *LogsServiceImpl {
-	return &LogsServiceImpl{
-		repo: repo,
+// remainingValidity returns how much of a message's validity period is left
+// for a resend, 0 when it has none, and whether the period has elapsed
+func remainingValidity(sms *models.SMS, now time.Time) (time.Duration, bool) {
+	if sms.ValiditySeconds <= 0 {
+		return 0, false
 	}
+	remaining := time.Duration(sms.ValiditySeconds)*time.Second - now.Sub(sms.CreatedAt)
+	if remaining < models.MinSMSValiditySeconds*time.Second {
+		return 0, true
+	}
+	return remaining.Truncate(time.Second), false
 }
 
-// GetLogs retrieves all OTP and callback activity logs
-func (s *LogsServiceImpl) GetLogs(ctx context.Context, limit int) (map[string]interface{}, error) {
-	log.Printf("Retrieving activity logs with limit: %d", limit)
-	
-	// Get OTP logs
-	otpLogs, err := s.repo.OTP().FindAll(ctx, limit)
+// deadLetter moves an SMS to the terminal dead status
+func (s *SMSServiceImpl) deadLetter(ctx context.Context, sms *models.SMS, reason string) {
+	log.Printf("Dead-lettering SMS %s after %d retries: %s", sms.ID.Hex(), sms.RetryCount, reason)
+
+	if err := s.repoFor(ctx).SMS().UpdateFailure(ctx, sms.ID.Hex(), models.StatusDead, reason); err != nil {
+		log.Printf("Failed to dead-letter SMS %s: %v", sms.ID.Hex(), err)
+		return
+	}
+	previous := sms.Status
+	sms.Status = models.StatusDead
+	sms.FailedReason = reason
+	s.forwardStatus(sms)
+	s.auditSMS(ctx, sms, previous)
+}
+
+// registerProvider makes an SMS client selectable by its provider name
+func (s *SMSServiceImpl) registerProvider(client transport.SMSClient) {
+	if s.providers == nil {
+		s.providers = make(map[string]transport.SMSClient)
+	}
+	s.providers[client.GetProvider()] = client
+}
+
+// provider returns the SMS client registered under name, or the default
+// client when name is empty
+func (s *SMSServiceImpl) provider(name string) (transport.SMSClient, error) {
+	if name == "" {
+		return s.smsClient, nil
+	}
+	if client, ok := s.providers[name]; ok {
+		return client, nil
+	}
+
+	available := make([]string, 0, len(s.providers))
+	for provider := range s.providers {
+		available = append(available, provider)
+	}
+	sort.Strings(available)
+	return nil, common.NewValidationError(fmt.Sprintf("Unknown provider %q, available providers: %s", name, strings.Join(available, ", ")))
+}
+
+// ProviderHealth reports whether an SMS provider (the default one when name is
+// empty) is reachable, reusing recent results for the configured TTL
+func (s *SMSServiceImpl) ProviderHealth(ctx context.Context, name string) (*models.ProviderHealth, error) {
+	client, err := s.provider(name)
 	if err != nil {
-		log.Printf("Failed to retrieve OTP logs: %v", err)
-		return nil, common.NewInternalError("Failed to retrieve OTP logs")
+		return nil, err
 	}
-	
-	// Get callback logs
-	callbackLogs, err := s.repo.Callback().FindAll(ctx, limit)
+
+	health := *s.health.Check(ctx, client, s.config.ProviderHealthTTL, s.now())
+	if !health.Healthy {
+		log.Printf("SMS provider %s is unhealthy: %s", health.Provider, health.Error)
+	}
+	health.Circuit = s.breakers.State(health.Provider, s.config.ProviderBreaker, s.now())
+	return &health, nil
+}
+
+// GetSMS retrieves a single SMS message by ID
+func (s *SMSServiceImpl) GetSMS(ctx context.Context, id string) (*models.SMS, error) {
+	sms, err := s.repoFor(ctx).SMS().FindByID(ctx, id)
 	if err != nil {
-		log.Printf("Failed to retrieve callback logs: %v", err)
-		return nil, common.NewInternalError("Failed to retrieve callback logs")
+		return nil, lookupError(err, "SMS message")
 	}
-	
-	// Get SMS logs
-	smsLogs, err := s.repo.SMS().FindAll(ctx, limit)
+	return sms, nil
+}
+
+// DeleteSMS soft-deletes an SMS message: it's hidden from lookups and
+// listings but stays stored for the audit history
+func (s *SMSServiceImpl) DeleteSMS(ctx context.Context, id string) error {
+	sms, err := s.repoFor(ctx).SMS().FindByID(ctx, id)
 	if err != nil {
-		log.Printf("Failed to retrieve SMS logs: %v", err)
-		return nil, common.NewInternalError("Failed to retrieve SMS logs")
+		return lookupError(err, "SMS message")
 	}
-	
-	// Format the response
-	logs := map[string]interface{}{
-		"otps": map[string]interface{}{
-			"count": len(otpLogs),
-			"data":  otpLogs,
-		},
-		"callbacks": map[string]interface{}{
-			"count": len(callbackLogs),
-			"data":  callbackLogs,
-		},
-		"sms": map[string]interface{}{
-			"count": len(smsLogs),
-			"data":  smsLogs,
-		},
-		"timestamp": time.Now(),
-		"total_records": len(otpLogs) + len(callbackLogs) + len(smsLogs),
+	if err := s.repoFor(ctx).SMS().SoftDelete(ctx, id); err != nil {
+		return lookupError(err, "SMS message")
 	}
-	
-	log.Printf("Successfully retrieved logs: %d OTPs, %d callbacks, %d SMS records", 
-		len(otpLogs), len(callbackLogs), len(smsLogs))
-	
-	return logs, nil
+	s.auditSMSDeleted(ctx, sms)
+	log.Printf("SMS %s deleted", id)
+	return nil
+}
+
+// maxBatchStatusIDs caps how many messages a single batch status lookup covers
+const maxBatchStatusIDs = 100
+
+// GetSMSStatuses looks up the delivery status of several SMS messages at once.
+// When userID is set, messages sent by anyone else are reported as not found.
+func (s *SMSServiceImpl) GetSMSStatuses(ctx context.Context, ids []string, userID string) (*models.BatchStatusResponse, error) {
+	if len(ids) == 0 {
+		return nil, common.NewValidationError("At least one message ID is required")
+	}
+	if len(ids) > maxBatchStatusIDs {
+		return nil, common.NewValidationError(fmt.Sprintf("At most %d message IDs can be checked at once", maxBatchStatusIDs))
+	}
+
+	invalid := make(map[string]string)
+	for i, id := range ids {
+		if !primitive.IsValidObjectID(id) {
+			invalid[fmt.Sprintf("ids[%d]", i)] = "is not a valid message ID"
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, common.NewValidationErrors(invalid)
+	}
+
+	found, err := s.repoFor(ctx).SMS().FindByIDs(ctx, ids)
+	if err != nil {
+		return nil, lookupError(err, "SMS messages")
+	}
+
+	response := &models.BatchStatusResponse{Statuses: make(map[string]models.MessageStatus, len(found))}
+	for _, sms := range found {
+		if userID != "" && sms.UserID != userID {
+			continue
+		}
+		response.Statuses[sms.ID.Hex()] = models.MessageStatus{Status: sms.Status, DeliveredAt: sms.DeliveredAt}
+	}
+	for _, id := range ids {
+		if _, ok := response.Statuses[id]; !ok {
+			response.NotFound = append(response.NotFound, id)
+		}
+	}
+	return response, nil
+}
+
+// maxEstimateRecipients caps how many recipients a single cost estimate covers
+const maxEstimateRecipients = 10000
+
+// EstimateSMSCost estimates what sending message to every recipient would cost,
+// pricing each segment at the rate of the recipient's country calling code.
+// Recipients must be E.164 phone numbers.
+func (s *SMSServiceImpl) EstimateSMSCost(ctx context.Context, recipients []string, message string) (*models.SMSEstimateResponse, error) {
+	if len(recipients) == 0 {
+		return nil, common.NewValidationError("At least one recipient is required")
+	}
+	if len(recipients) > maxEstimateRecipients {
+		return nil, common.NewValidationError(fmt.Sprintf("At most %d recipients can be estimated at once", maxEstimateRecipients))
+	}
+
+	invalid := make(map[string]string)
+	counts := make(map[string]int)
+	for i, phone := range recipients {
+		code := common.CallingCode(phone)
+		if code == "" {
+			invalid[fmt.Sprintf("recipients[%d]", i)] = "must be a phone number in E.164 format"
+			continue
+		}
+		counts[code]++
+	}
+	if len(invalid) > 0 {
+		return nil, common.NewValidationErrors(invalid)
+	}
+
+	segments, encoding := common.SegmentCount(message)
+	response := &models.SMSEstimateResponse{
+		Recipients:         len(recipients),
+		Encoding:           encoding,
+		SegmentsPerMessage: segments,
+		TotalSegments:      segments * len(recipients),
+		Currency:           s.config.RateCurrency,
+		Countries:          make([]models.CountryEstimate, 0, len(counts)),
+	}
+	for code, count := range counts {
+		rate := s.config.segmentRate(code)
+		estimate := models.CountryEstimate{
+			CallingCode: code,
+			Recipients:  count,
+			Segments:    segments * count,
+			Rate:        rate,
+			Cost:        roundCost(rate * float64(segments*count)),
+		}
+		response.Countries = append(response.Countries, estimate)
+		response.TotalCost += estimate.Cost
+	}
+	response.TotalCost = roundCost(response.TotalCost)
+	sort.Slice(response.Countries, func(i, j int) bool {
+		return response.Countries[i].CallingCode < response.Countries[j].CallingCode
+	})
+	return response, nil
+}
+
+// roundCost rounds an amount to a millionth of the currency unit, hiding
+// floating point noise without losing sub-cent segment rates
+func roundCost(amount float64) float64 {
+	return math.Round(amount*1e6) / 1e6
+}
+
+// OptOut adds a phone number to the suppression list
+func (s *SMSServiceImpl) OptOut(ctx context.Context, phone, reason string) error {
+	if err := s.repoFor(ctx).Suppressions().Add(ctx, phone, reason); err != nil {
+		log.Printf("Failed to opt out %s: %v", phone, err)
+		return common.NewInternalError("Failed to update opt-out status")
+	}
+	log.Printf("Phone number %s opted out (%s)", phone, reason)
+	return nil
+}
+
+// OptIn removes a phone number from the suppression list
+func (s *SMSServiceImpl) OptIn(ctx context.Context, phone string) error {
+	removed, err := s.repoFor(ctx).Suppressions().Remove(ctx, phone)
+	if err != nil {
+		log.Printf("Failed to opt in %s: %v", phone, err)
+		return common.NewInternalError("Failed to update opt-out status")
+	}
+	if removed {
+		log.Printf("Phone number %s opted back in", phone)
+	}
+	return nil
+}
+
+// IsSuppressed reports whether a phone number opted out of receiving messages
+func (s *SMSServiceImpl) IsSuppressed(ctx context.Context, phone string) (bool, error) {
+	suppressed, err := s.repoFor(ctx).Suppressions().IsSuppressed(ctx, phone)
+	if err != nil {
+		log.Printf("Failed to check suppression list for %s: %v", phone, err)
+		return false, common.NewInternalError("Failed to check opt-out status")
+	}
+	return suppressed, nil
+}
+
+// HandleInboundMessage stores an incoming message and opts the sender out
+// when it is an opt-out keyword such as STOP, or back in for START
+func (s *SMSServiceImpl) HandleInboundMessage(ctx context.Context, msg models.InboundMessage) error {
+	log.Printf("Inbound message received from %s", msg.From)
+
+	segments, encoding := common.SegmentCount(msg.Text)
+	sms := &models.SMS{
+		Direction:  models.DirectionInbound,
+		From:       msg.From,
+		To:         msg.To,
+		Message:    msg.Text,
+		Segments:   segments,
+		Encoding:   encoding,
+		Status:     models.StatusReceived,
+		Provider:   models.ProviderPlivo,
+		ProviderID: msg.MessageUUID,
+	}
+	if err := s.repoFor(ctx).SMS().Create(ctx, sms); err != nil {
+		log.Printf("Failed to store inbound message from %s: %v", msg.From, err)
+		return common.NewInternalError("Failed to store inbound message")
+	}
+	s.auditSMS(ctx, sms, "")
+
+	switch {
+	case isOptOutKeyword(msg.Text):
+		return s.OptOut(ctx, msg.From, models.SuppressionReasonKeyword)
+	case isOptInKeyword(msg.Text):
+		return s.OptIn(ctx, msg.From)
+	}
+	return nil
+}
+
+// HandleDeliveryReport records the delivery status a provider reported for an
+// outbound message it sent. Deleted messages are updated too, keeping their
+// audit history complete.
+func (s *SMSServiceImpl) HandleDeliveryReport(ctx context.Context, report models.DeliveryReport) error {
+	sms, err := s.repoFor(ctx).SMS().FindByProviderID(repository.IncludeDeleted(ctx), report.MessageUUID)
+	if err != nil {
+		return lookupError(err, "SMS message")
+	}
+	if sms.Direction == models.DirectionInbound {
+		return common.NewConflictError("Delivery reports only apply to outbound messages")
+	}
+
+	if report.Status == models.StatusFailed && report.ErrorCode != "" {
+		log.Printf("Provider reported SMS %s as undelivered with error code %s", sms.ID.Hex(), report.ErrorCode)
+	}
+	s.applyDeliveryStatus(ctx, sms, report.Status)
+	return nil
+}
+
+// checkContent rejects messages matching the content blocklist. The error
+// doesn't repeat the matched text, so it can't be used to probe the list.
+func (s *SMSServiceImpl) checkContent(message string) error {
+	pattern, blocked := s.config.blockedContent(message)
+	if !blocked {
+		return nil
+	}
+	log.Printf("Send rejected, message matches blocklist pattern %q", pattern.String())
+	return common.NewValidationError("Message contains content that is not allowed")
+}
+
+// checkMessageLength rejects empty messages and those split into more than
+// MaxSMSSegments segments, reporting the length and segments computed
+func (s *SMSServiceImpl) checkMessageLength(message string) error {
+	length, encoding := common.MessageLength(message)
+	if length == 0 {
+		return common.NewValidationError("Message must not be empty")
+	}
+
+	maxSegments := s.config.MaxSMSSegments
+	segments, _ := common.SegmentCount(message)
+	if maxSegments <= 0 || segments <= maxSegments {
+		return nil
+	}
+	return common.NewValidationError(fmt.Sprintf("Message too long: %d/%d characters (%s, %d segments, max %d segments)",
+		length, common.MaxMessageLength(encoding, maxSegments), encoding, segments, maxSegments))
+}
+
+// checkDestination rejects sends to countries outside the configured allow
+// list or on the block list
+func (s *SMSServiceImpl) checkDestination(phone string) error {
+	code := common.CallingCode(phone)
+	if s.config.destinationAllowed(code) {
+		return nil
+	}
+
+	log.Printf("Send to %s rejected, country code +%s is not allowed", phone, code)
+	if code == "" {
+		return common.NewValidationError("Destination country could not be determined, use an E.164 phone number")
+	}
+	return common.NewValidationError(fmt.Sprintf("Sending to country code +%s is not allowed", code))
+}
+
+// checkSenderID rejects sends asking for a sender ID outside the allowlist;
+// sends without one use the provider's number
+func (s *SMSServiceImpl) checkSenderID(senderID string) error {
+	if senderID == "" || s.config.senderIDAllowed(senderID) {
+		return nil
+	}
+	log.Printf("Send rejected, sender ID %q is not allowed", senderID)
+	return common.NewForbiddenError(fmt.Sprintf("Sender ID %s is not allowed", senderID))
+}
+
+// checkSuppressed rejects sends to opted-out phone numbers. It fails closed:
+// if the suppression list can't be read, nothing is sent.
+func (s *SMSServiceImpl) checkSuppressed(ctx context.Context, phone string) error {
+	suppressed, err := s.IsSuppressed(ctx, phone)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		log.Printf("Send to %s rejected, number has opted out", phone)
+		return common.NewOptedOutError()
+	}
+	return nil
+}
+
+// optOutKeywords are the standard carrier opt-out keywords
+var optOutKeywords = map[string]bool{
+	"STOP":        true,
+	"STOPALL":     true,
+	"UNSUBSCRIBE": true,
+	"CANCEL":      true,
+	"END":         true,
+	"QUIT":        true,
+}
+
+// isOptOutKeyword reports whether a message body is an opt-out keyword
+func isOptOutKeyword(text string) bool {
+	return optOutKeywords[strings.ToUpper(strings.TrimSpace(text))]
+}
+
+// optInKeywords are the standard carrier keywords for opting back in
+var optInKeywords = map[string]bool{
+	"START":  true,
+	"UNSTOP": true,
+	"YES":    true,
+}
+
+// isOptInKeyword reports whether a message body is an opt-in keyword
+func isOptInKeyword(text string) bool {
+	return optInKeywords[strings.ToUpper(strings.TrimSpace(text))]
+}
+
+// acquireSendSlot claims an in-flight send slot for a destination number
+func (s *SMSServiceImpl) acquireSendSlot(phone string) error {
+	if !s.inFlight.Acquire(phone, s.config.MaxInFlightPerNumber) {
+		log.Printf("Send to %s rejected, another send is still in flight", phone)
+		return common.NewRateLimitError("Another message to this number is still being sent. Please try again shortly.").
+			WithRetryAfter(time.Second)
+	}
+	return nil
+}
+
+// callProvider runs a provider call once the provider has a free concurrency
+// slot, waiting for one unless ctx is done first. The call gets at most
+// ProviderTimeout, and fails fast with errCircuitOpen while the provider's
+// circuit breaker is open.
+func (s *SMSServiceImpl) callProvider(ctx context.Context, provider string, call func(ctx context.Context) error) error {
+	policy := s.config.ProviderBreaker
+	if err := s.breakers.Allow(provider, policy, s.now()); err != nil {
+		return fmt.Errorf("%s: %w", provider, err)
+	}
+
+	limit := s.config.sendConcurrency(provider)
+	if err := s.sends.Acquire(ctx, provider, limit); err != nil {
+		s.breakers.Cancel(provider)
+		return fmt.Errorf("waiting for a free %s send slot: %w", provider, err)
+	}
+	defer s.sends.Release(provider, limit)
+
+	callCtx := ctx
+	if s.config.ProviderTimeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, s.config.ProviderTimeout)
+		defer cancel()
+	}
+
+	err := call(callCtx)
+	switch {
+	case err == nil || errors.Is(err, transport.ErrNotWhatsAppNumber):
+		// A recipient the provider rejected still shows the provider is up
+		s.failureStreaks.Reset(provider)
+		s.breakers.Success(provider, policy, s.now())
+	case ctx.Err() != nil:
+		// The caller gave up, which says nothing about the provider
+		s.breakers.Cancel(provider)
+	default:
+		s.breakers.Failure(provider, policy, s.now())
+	}
+	return err
+}
+
+// anonymousQuotaOwner is the quota owner of sends without an authenticated user
+const anonymousQuotaOwner = "anonymous"
+
+// quotaMonthFormat formats the UTC month a quota counter covers
+const quotaMonthFormat = "2006-01"
+
+// quotaOwner returns the owner of the quota counter a user's sends count
+// against; all anonymous sends share one
+func quotaOwner(userID string) string {
+	if userID == "" {
+		return anonymousQuotaOwner
+	}
+	return "user:" + userID
+}
+
+// quotaLimit returns the monthly SMS quota of a user, or of the anonymous
+// senders for an empty user ID; 0 means unlimited
+func (s *SMSServiceImpl) quotaLimit(ctx context.Context, userID string) int {
+	if userID == "" {
+		return s.config.AnonymousMonthlySMSQuota
+	}
+	if user, err := s.repoFor(ctx).User().FindByID(ctx, userID); err == nil && user.MonthlySMSQuota > 0 {
+		return user.MonthlySMSQuota
+	}
+	return s.config.DefaultMonthlySMSQuota
+}
+
+// quotaResetsAt returns the start of the UTC month after now
+func quotaResetsAt(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+}
+
+// GetSMSQuota returns a user's SMS usage for the current calendar month (UTC),
+// or that of the anonymous senders for an empty user ID. A limit of 0 means
+// there is no quota.
+func (s *SMSServiceImpl) GetSMSQuota(ctx context.Context, userID string) (*models.SMSQuota, error) {
+	limit := s.quotaLimit(ctx, userID)
+	owner, now := quotaOwner(userID), s.now()
+
+	used, err := s.repoFor(ctx).SMSQuotas().Count(ctx, owner, now.UTC().Format(quotaMonthFormat))
+	if err != nil {
+		log.Printf("Failed to count SMS for %s: %v", owner, err)
+		return nil, common.NewInternalError("Failed to check SMS quota")
+	}
+
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &models.SMSQuota{
+		Limit:     limit,
+		Used:      used,
+		Remaining: remaining,
+		ResetsAt:  quotaResetsAt(now),
+	}, nil
+}
+
+// forwardStatus asynchronously notifies webhook destinations of an SMS status change
+func (s *SMSServiceImpl) forwardStatus(sms *models.SMS) {
+	if !s.webhooks.Enabled() {
+		return
+	}
+
+	event := map[string]interface{}{
+		"id":         sms.ID.Hex(),
+		"to":         sms.To,
+		"status":     sms.Status,
+		"provider":   sms.Provider,
+		"updated_at": time.Now(),
+	}
+
+	queued := s.async.Go(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		if err := s.webhooks.Send(ctx, "sms.status", event); err != nil {
+			log.Printf("Failed to forward SMS status webhook for %s: %v", sms.ID.Hex(), err)
+		}
+	})
+	if !queued {
+		log.Printf("Dropped SMS status webhook for %s, service is shutting down", sms.ID.Hex())
+	}
+}
+
+// Shutdown stops background routines and flushes pending async work (webhook
+// deliveries and audit records), giving up on whatever is still running when ctx expires
+func (s *SMSServiceImpl) Shutdown(ctx context.Context) {
+	close(s.stop)
+
+	flushed, dropped := s.async.Drain(ctx)
+	log.Printf("SMS service shut down: flushed %d pending tasks, dropped %d", flushed, dropped)
+}
+
+// repoFor returns the repository of the request's tenant, or the default one
+func (s *LogsServiceImpl) repoFor(ctx context.Context) repository.Repository {
+	return repository.FromContext(ctx, s.repo)
+}
+
+// NewLogsService creates a new logs service instance
+func NewLogsService(repo repository.Repository) *LogsServiceImpl {
+	return &LogsServiceImpl{
+		repo: repo,
+	}
+}
+
+// GetLogs retrieves all OTP and callback activity logs
+// A non-empty direction restricts the SMS section to inbound or outbound messages.
+// A section that can't be retrieved is returned empty with an error note, and
+// the notes are collected under "errors"; only when every section fails is
+// the request failed.
+func (s *LogsServiceImpl) GetLogs(ctx context.Context, limit int, before models.LogCursor, filter models.SMSLogFilter) (map[string]interface{}, error) {
+	log.Printf("Retrieving activity logs with limit: %d", limit)
+	
+	sectionErrors := map[string]string{}
+
+	// Get OTP logs
+	otpLogs := []*models.OTP{}
+	var otpTotal int64
+	if note := fetchLogSection("OTP", func() error {
+		otpRepo := s.repoFor(ctx).OTP()
+		if otpRepo == nil {
+			return errSectionUnavailable
+		}
+		records, err := otpRepo.FindAll(ctx, limit, before)
+		if err != nil {
+			return err
+		}
+		total, err := otpRepo.Count(ctx, before)
+		if err != nil {
+			return err
+		}
+		otpLogs, otpTotal = records, total
+		return nil
+	}); note != "" {
+		sectionErrors["otps"] = note
+	}
+	
+	// Get callback logs
+	callbackLogs := []*models.Callback{}
+	var callbackTotal int64
+	if note := fetchLogSection("callback", func() error {
+		callbackRepo := s.repoFor(ctx).Callback()
+		if callbackRepo == nil {
+			return errSectionUnavailable
+		}
+		records, err := callbackRepo.FindAll(ctx, limit, before)
+		if err != nil {
+			return err
+		}
+		total, err := callbackRepo.Count(ctx, before)
+		if err != nil {
+			return err
+		}
+		callbackLogs, callbackTotal = records, total
+		return nil
+	}); note != "" {
+		sectionErrors["callbacks"] = note
+	}
+	
+	// Get SMS logs
+	smsLogs := []*models.SMS{}
+	var smsTotal int64
+	var deadCount int
+	byDirection := map[string]int{models.DirectionInbound: 0, models.DirectionOutbound: 0}
+	if note := fetchLogSection("SMS", func() error {
+		smsRepo := s.repoFor(ctx).SMS()
+		if smsRepo == nil {
+			return errSectionUnavailable
+		}
+		var records []*models.SMS
+		var err error
+		switch {
+		case filter.MetadataKey != "":
+			records, err = smsRepo.FindByMetadata(ctx, filter.MetadataKey, filter.MetadataValue, limit, before)
+		case filter.Direction != "":
+			records, err = smsRepo.FindByDirection(ctx, filter.Direction, limit, before)
+		default:
+			records, err = smsRepo.FindAll(ctx, limit, before)
+		}
+		if err != nil {
+			return err
+		}
+		statusCounts, err := smsRepo.CountByStatus(ctx, "")
+		if err != nil {
+			return err
+		}
+		countFilter := filter
+		countFilter.Before = before
+		total, err := smsRepo.Count(ctx, countFilter)
+		if err != nil {
+			return err
+		}
+		// The direction breakdown covers every matching message, not just this page
+		directions := make(map[string]int, 2)
+		for _, direction := range []string{models.DirectionInbound, models.DirectionOutbound} {
+			directionFilter := countFilter
+			directionFilter.Direction = direction
+			if filter.Direction != "" && filter.Direction != direction {
+				directions[direction] = 0
+				continue
+			}
+			count, err := smsRepo.Count(ctx, directionFilter)
+			if err != nil {
+				return err
+			}
+			directions[direction] = int(count)
+		}
+		smsLogs, smsTotal, deadCount, byDirection = records, total, statusCounts[models.StatusDead], directions
+		return nil
+	}); note != "" {
+		sectionErrors["sms"] = note
+	}
+
+	if len(sectionErrors) == 3 {
+		return nil, common.NewInternalError("Failed to retrieve logs")
+	}
+	
+	// Each section pages independently: its next cursor is the timestamp and
+	// ID of its last record, and is omitted once the section has no more records
+	otps := logSection(otpLogs, len(otpLogs), limit, otpTotal, sectionErrors["otps"])
+	if otps.HasMore {
+		last := otpLogs[len(otpLogs)-1]
+		otps.NextCursor = nextCursor(last.CreatedAt, last.ID)
+	}
+	callbacks := logSection(callbackLogs, len(callbackLogs), limit, callbackTotal, sectionErrors["callbacks"])
+	if callbacks.HasMore {
+		last := callbackLogs[len(callbackLogs)-1]
+		callbacks.NextCursor = nextCursor(last.RequestedAt, last.ID)
+	}
+	sms := &models.SMSLogSection{
+		LogSection:  *logSection(smsLogs, len(smsLogs), limit, smsTotal, sectionErrors["sms"]),
+		ByDirection: byDirection,
+		DeadCount:   deadCount,
+	}
+	if sms.HasMore {
+		last := smsLogs[len(smsLogs)-1]
+		sms.NextCursor = nextCursor(last.CreatedAt, last.ID)
+	}
+
+	// Format the response
+	logs := map[string]interface{}{
+		"otps":          otps,
+		"callbacks":     callbacks,
+		"sms":           sms,
+		"timestamp":     time.Now(),
+		"total_records": len(otpLogs) + len(callbackLogs) + len(smsLogs),
+	}
+	if len(sectionErrors) > 0 {
+		logs["errors"] = sectionErrors
+	}
+	
+	log.Printf("Successfully retrieved logs: %d OTPs, %d callbacks, %d SMS records (%d sections failed)", 
+		len(otpLogs), len(callbackLogs), len(smsLogs), len(sectionErrors))
+	
+	return logs, nil
+}
+
+// errSectionUnavailable fails a section of the activity logs whose
+// repository was never set up
+var errSectionUnavailable = errors.New("repository not configured")
+
+// fetchLogSection runs fetch for one section of the activity logs and returns
+// an error note when it fails
+func fetchLogSection(section string, fetch func() error) string {
+	if err := fetch(); err != nil {
+		log.Printf("Failed to retrieve %s logs: %v", section, err)
+		return fmt.Sprintf("Failed to retrieve %s logs", section)
+	}
+	return ""
+}
+
+// nextCursor formats the timestamp and ID of a record as a /logs pagination cursor
+func nextCursor(t time.Time, id primitive.ObjectID) *string {
+	cursor := models.LogCursor{At: t, ID: id}.String()
+	return &cursor
+}
+
+// logSection describes returned records of a logs section, newest first, out of
+// total records before the request's cursor. note is the section's error, if any.
+func logSection(data interface{}, returned, limit int, total int64, note string) *models.LogSection {
+	return &models.LogSection{
+		PaginatedResponse: *models.NewPaginatedResponse(data, returned, limit, 0, total),
+		Count:             returned,
+		Error:             note,
+	}
+}
+
+// ListSMS returns a page of SMS messages matching filter, newest first,
+// skipping the first offset
+func (s *LogsServiceImpl) ListSMS(ctx context.Context, filter models.SMSLogFilter, offset, limit int) (*models.PaginatedResponse, error) {
+	records, err := s.repoFor(ctx).SMS().List(ctx, filter, offset, limit)
+	if err != nil {
+		log.Printf("Failed to list SMS messages: %v", err)
+		return nil, common.NewInternalError("Failed to list SMS messages")
+	}
+	total, err := s.repoFor(ctx).SMS().Count(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to count SMS messages: %v", err)
+		return nil, common.NewInternalError("Failed to list SMS messages")
+	}
+
+	if records == nil {
+		records = []*models.SMS{}
+	}
+	return models.NewPaginatedResponse(records, len(records), limit, offset, total), nil
+}
+
+// ListAudit returns a page of the audit log, oldest first, so the history of
+// records can be replayed in order
+func (s *LogsServiceImpl) ListAudit(ctx context.Context, filter models.AuditFilter, offset, limit int) (*models.PaginatedResponse, error) {
+	audits, err := s.repoFor(ctx).Audit().List(ctx, filter, offset, limit)
+	if err != nil {
+		log.Printf("Failed to list audit records: %v", err)
+		return nil, common.NewInternalError("Failed to retrieve audit records")
+	}
+	total, err := s.repoFor(ctx).Audit().Count(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to count audit records: %v", err)
+		return nil, common.NewInternalError("Failed to retrieve audit records")
+	}
+
+	if audits == nil {
+		audits = []*models.Audit{}
+	}
+	return models.NewPaginatedResponse(audits, len(audits), limit, offset, total), nil
+}
+
+// SearchByPhone finds OTP, SMS and callback records by a partial phone number.
+// Matched phone numbers are masked in the result.
+func (s *LogsServiceImpl) SearchByPhone(ctx context.Context, query, match string, limit int) (*models.PhoneSearchResult, error) {
+	log.Printf("Searching records by phone %s match: %s", match, query)
+
+	suffix := match == models.PhoneMatchSuffix
+	result := &models.PhoneSearchResult{
+		Query:     query,
+		Match:     match,
+		OTPs:      []models.PhoneSearchMatch{},
+		SMS:       []models.PhoneSearchMatch{},
+		Callbacks: []models.PhoneSearchMatch{},
+	}
+
+	otps, err := s.repoFor(ctx).OTP().SearchByPhone(ctx, query, suffix, limit)
+	if err != nil {
+		log.Printf("Failed to search OTPs by phone: %v", err)
+		return nil, common.NewInternalError("Failed to search OTP records")
+	}
+	for _, otp := range otps {
+		result.OTPs = append(result.OTPs, models.PhoneSearchMatch{
+			ID:        otp.ID.Hex(),
+			Phone:     common.MaskPhone(otp.Phone),
+			CreatedAt: otp.CreatedAt,
+		})
+	}
+
+	smsRecords, err := s.repoFor(ctx).SMS().SearchByPhone(ctx, query, suffix, limit)
+	if err != nil {
+		log.Printf("Failed to search SMS by phone: %v", err)
+		return nil, common.NewInternalError("Failed to search SMS records")
+	}
+	for _, sms := range smsRecords {
+		result.SMS = append(result.SMS, models.PhoneSearchMatch{
+			ID:        sms.ID.Hex(),
+			Phone:     common.MaskPhone(smsCounterparty(sms)),
+			Status:    sms.Status,
+			CreatedAt: sms.CreatedAt,
+		})
+	}
+
+	callbacks, err := s.repoFor(ctx).Callback().SearchByPhone(ctx, query, suffix, limit)
+	if err != nil {
+		log.Printf("Failed to search callbacks by phone: %v", err)
+		return nil, common.NewInternalError("Failed to search callback records")
+	}
+	for _, callback := range callbacks {
+		result.Callbacks = append(result.Callbacks, models.PhoneSearchMatch{
+			ID:        callback.ID.Hex(),
+			Phone:     common.MaskPhone(callback.PhoneNumber),
+			Status:    callback.Status,
+			CreatedAt: callback.CreatedAt,
+		})
+	}
+
+	result.Total = len(result.OTPs) + len(result.SMS) + len(result.Callbacks)
+	return result, nil
+}
+
+// SearchLogs merges the OTP, SMS and callback records of a phone number created
+// within [from, to) into one timeline, newest first, of at most limit entries.
+// Zero bounds are open-ended. OTP codes are left out.
+func (s *LogsServiceImpl) SearchLogs(ctx context.Context, phone string, from, to time.Time, limit int) (*models.PhoneTimeline, error) {
+	log.Printf("Searching activity timeline of %s", common.MaskPhone(phone))
+
+	var entries []models.TimelineEntry
+
+	// Only the latest OTP of a phone number is kept
+	otp, err := s.repoFor(ctx).OTP().FindByPhone(ctx, phone)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Failed to retrieve OTP for timeline: %v", err)
+		return nil, common.NewInternalError("Failed to retrieve OTP logs")
+	}
+	if otp != nil && (from.IsZero() || !otp.CreatedAt.Before(from)) && (to.IsZero() || otp.CreatedAt.Before(to)) {
+		otp.Code = ""
+		entries = append(entries, models.TimelineEntry{Type: models.LogTypeOTP, Timestamp: otp.CreatedAt, Record: otp})
+	}
+
+	// Fetch one more record than needed to tell whether older ones were left out
+	smsLogs, err := s.repoFor(ctx).SMS().FindByPhoneBetween(ctx, phone, from, to, limit+1)
+	if err != nil {
+		log.Printf("Failed to retrieve SMS for timeline: %v", err)
+		return nil, common.NewInternalError("Failed to retrieve SMS logs")
+	}
+	for _, sms := range smsLogs {
+		entries = append(entries, models.TimelineEntry{Type: models.LogTypeSMS, Timestamp: sms.CreatedAt, Record: sms})
+	}
+
+	callbackLogs, err := s.repoFor(ctx).Callback().FindByPhoneBetween(ctx, phone, from, to, limit+1)
+	if err != nil {
+		log.Printf("Failed to retrieve callbacks for timeline: %v", err)
+		return nil, common.NewInternalError("Failed to retrieve callback logs")
+	}
+	for _, callback := range callbackLogs {
+		entries = append(entries, models.TimelineEntry{Type: models.LogTypeCallback, Timestamp: callback.RequestedAt, Record: callback})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp.After(entries[j].Timestamp) })
+
+	timeline := &models.PhoneTimeline{Phone: phone, Limit: limit, Entries: []models.TimelineEntry{}}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		timeline.HasMore = true
+	}
+	timeline.Entries = append(timeline.Entries, entries...)
+	timeline.Count = len(timeline.Entries)
+	return timeline, nil
+}
+
+// SendOTP generates and sends a 6-digit OTP
+func (s *SMSServiceImpl) SendOTP(ctx context.Context, req models.OTPRequest) (*models.OTPResponse, error) {
+	// OTPs are stored and looked up under the normalized E.164 number
+	req.PhoneNumber = common.NormalizePhone(req.PhoneNumber)
+	log.Printf("Generating OTP for phone number: %s", req.PhoneNumber)
+	defer s.otpStatus.Invalidate(s.repoFor(ctx), req.PhoneNumber)
+
+	channel := req.Channel
+	if channel == "" {
+		channel = models.ChannelSMS
+	}
+	if channel == models.ChannelWhatsApp && s.whatsApp == nil {
+		return nil, common.NewValidationError("WhatsApp delivery is not available")
+	}
+	if channel == models.ChannelWhatsApp && req.Provider != "" {
+		return nil, common.NewValidationError("A provider can only be selected for SMS delivery")
+	}
+
+	client, err := s.provider(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkDestination(req.PhoneNumber); err != nil {
+		return nil, err
+	}
+	if err := s.checkSuppressed(ctx, req.PhoneNumber); err != nil {
+		return nil, err
+	}
+
+	if err := s.acquireSendSlot(req.PhoneNumber); err != nil {
+		return nil, err
+	}
+	defer s.inFlight.Release(req.PhoneNumber, s.config.MaxInFlightPerNumber)
+
+	// Check if OTP already exists and hasn't expired
+	existingOTP, err := s.repoFor(ctx).OTP().FindByPhone(ctx, req.PhoneNumber)
+	replacing := err == nil && existingOTP != nil
+	// A verified OTP is only kept for retried verifications and doesn't hold back a new one
+	if replacing && !existingOTP.Verified {
+		// A locked out phone number can't get a fresh attempt budget until the lockout ends
+		if lockedFor := existingOTP.LockedUntil.Sub(s.now()); lockedFor > 0 {
+			log.Printf("OTP request for %s rejected, locked out for %v", req.PhoneNumber, lockedFor.Round(time.Second))
+			return nil, common.NewMaxAttemptsError(lockedFor)
+		}
+
+		// OTP exists, only allow a resend once the cooldown since it was sent has passed
+		if s.inResendCooldown(existingOTP) {
+			return &models.OTPResponse{
+				Success:  false,
+				Message:  "OTP already sent. Please wait before requesting a new one.",
+				PhoneNumber: req.PhoneNumber,
+				NormalizedPhone: req.PhoneNumber,
+				ExpiresAt: existingOTP.ExpiresAt,
+			}, nil
+		}
+	}
+
+	// Enforce the daily send cap before replacing the existing OTP, so a phone
+	// number at the cap keeps the code it already has
+	today := otpSendDay(s.now())
+	if err := s.reserveDailyOTPSend(ctx, req.PhoneNumber, today); err != nil {
+		return nil, err
+	}
+	sent := false
+	defer func() {
+		if !sent {
+			s.releaseDailyOTPSend(ctx, req.PhoneNumber, today)
+		}
+	}()
+
+	if replacing {
+		// Delete existing OTP to allow resend
+		s.repoFor(ctx).OTP().DeleteByPhone(ctx, req.PhoneNumber)
+	}
+
+	otp, err := s.generateOTP()
+	if err != nil {
+		log.Printf("Failed to generate OTP for %s: %v", req.PhoneNumber, err)
+		return nil, common.NewInternalError("Failed to generate OTP")
+	}
+
+	expiry := s.now().Add(s.config.OTPTTL)
+
+	// Create OTP record
+	otpRecord := &models.OTP{
+		Phone:      req.PhoneNumber,
+		Purpose:    otpPurpose(req.Purpose),
+		Channel:    channel,
+		Channels:   []string{channel},
+		Language:   req.Language,
+		Code:       otp,
+		ExpiresAt:  expiry,
+		MaxAttempts: s.config.OTPMaxAttempts,
+	}
+
+	// Store OTP in repository
+	err = s.repoFor(ctx).OTP().Create(ctx, otpRecord)
+	if err != nil {
+		log.Printf("Failed to store OTP for %s: %v", req.PhoneNumber, err)
+		return nil, common.NewInternalError("Failed to store OTP")
+	}
+
+	// Send OTP over the requested channel
+	if err := s.deliverOTP(ctx, client, channel, otpRecord); err != nil {
+		// Clean up stored OTP if delivery fails
+		s.repoFor(ctx).OTP().DeleteByPhone(ctx, req.PhoneNumber)
+		return nil, err
+	}
+	s.auditOTP(ctx, otpRecord, "", models.OTPStateSent)
+	sent = true
+
+	log.Printf("OTP sent successfully to %s via %s, expires at %v", req.PhoneNumber, channel, expiry)
+
+	response := &models.OTPResponse{
+		Success:   true,
+		Message:   "OTP sent successfully",
+		PhoneNumber: req.PhoneNumber,
+		NormalizedPhone: req.PhoneNumber,
+		Channel:   channel,
+		ExpiresAt: expiry,
+	}
+	// The code only ever leaves the service when exposure is explicitly enabled
+	if s.config.ExposeOTP {
+		response.OTP = otp
+	}
+	return response, nil
+}
+
+// ResendOTP sends the phone number's active OTP again, over the channel it was
+// first sent on, so a user who didn't receive it never holds two different
+// codes. Resends are subject to the resend cooldown, the daily send cap and
+// OTPMaxResends. Without an active OTP a new one is sent as by SendOTP.
+func (s *SMSServiceImpl) ResendOTP(ctx context.Context, req models.OTPRequest) (*models.OTPResponse, error) {
+	req.PhoneNumber = common.NormalizePhone(req.PhoneNumber)
+	defer s.otpStatus.Invalidate(s.repoFor(ctx), req.PhoneNumber)
+
+	existingOTP, err := s.repoFor(ctx).OTP().FindByPhone(ctx, req.PhoneNumber)
+	if err != nil || existingOTP == nil || existingOTP.Verified || !s.now().Before(existingOTP.ExpiresAt) {
+		return s.SendOTP(ctx, req)
+	}
+	log.Printf("Resending OTP to phone number: %s", req.PhoneNumber)
+
+	if existingOTP.Channel == models.ChannelWhatsApp && s.whatsApp == nil {
+		return nil, common.NewValidationError("WhatsApp delivery is not available")
+	}
+	if existingOTP.Channel == models.ChannelWhatsApp && req.Provider != "" {
+		return nil, common.NewValidationError("A provider can only be selected for SMS delivery")
+	}
+	client, err := s.provider(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkDestination(req.PhoneNumber); err != nil {
+		return nil, err
+	}
+	if err := s.checkSuppressed(ctx, req.PhoneNumber); err != nil {
+		return nil, err
+	}
+
+	if err := s.acquireSendSlot(req.PhoneNumber); err != nil {
+		return nil, err
+	}
+	defer s.inFlight.Release(req.PhoneNumber, s.config.MaxInFlightPerNumber)
+
+	if lockedFor := existingOTP.LockedUntil.Sub(s.now()); lockedFor > 0 {
+		log.Printf("OTP resend for %s rejected, locked out for %v", req.PhoneNumber, lockedFor.Round(time.Second))
+		return nil, common.NewMaxAttemptsError(lockedFor)
+	}
+	if s.inResendCooldown(existingOTP) {
+		return &models.OTPResponse{
+			Success:   false,
+			Message:   "OTP already sent. Please wait before requesting it again.",
+			PhoneNumber: req.PhoneNumber,
+			NormalizedPhone: req.PhoneNumber,
+			ExpiresAt: existingOTP.ExpiresAt,
+		}, nil
+	}
+	if existingOTP.ResendCount >= s.config.OTPMaxResends {
+		log.Printf("OTP resend for %s rejected, already resent %d times", req.PhoneNumber, existingOTP.ResendCount)
+		return nil, common.NewRateLimitError("This OTP can't be sent again. Please request a new OTP.")
+	}
+
+	// The checks above only pick the response; the resend is counted with a
+	// conditional update so concurrent resends can't pass the cap or the cooldown
+	now := s.now()
+	otpID := existingOTP.ID.Hex()
+	reserved, err := s.repoFor(ctx).OTP().ReserveResend(ctx, otpID, s.config.OTPMaxResends, now.Add(-s.config.OTPResendCooldown), now)
+	if err != nil {
+		log.Printf("Failed to record OTP resend for %s: %v", req.PhoneNumber, err)
+		return nil, common.NewInternalError("Failed to resend OTP")
+	}
+	if !reserved {
+		return &models.OTPResponse{
+			Success:   false,
+			Message:   "OTP already sent. Please wait before requesting it again.",
+			PhoneNumber: req.PhoneNumber,
+			NormalizedPhone: req.PhoneNumber,
+			ExpiresAt: existingOTP.ExpiresAt,
+		}, nil
+	}
+
+	today := otpSendDay(s.now())
+	if err := s.reserveDailyOTPSend(ctx, req.PhoneNumber, today); err != nil {
+		s.releaseResend(ctx, existingOTP)
+		return nil, err
+	}
+
+	channel := existingOTP.Channel
+	if channel == "" {
+		channel = models.ChannelSMS
+	}
+	if err := s.deliverOTP(ctx, client, channel, existingOTP); err != nil {
+		s.releaseDailyOTPSend(ctx, req.PhoneNumber, today)
+		s.releaseResend(ctx, existingOTP)
+		return nil, err
+	}
+
+	existingOTP.ResendCount++
+	existingOTP.LastSentAt = now
+	s.auditOTP(ctx, existingOTP, s.otpState(existingOTP), models.OTPStateResent)
+
+	log.Printf("OTP resent to %s via %s (resend %d)", req.PhoneNumber, channel, existingOTP.ResendCount)
+
+	response := &models.OTPResponse{
+		Success:   true,
+		Message:   "OTP resent successfully",
+		PhoneNumber: req.PhoneNumber,
+		NormalizedPhone: req.PhoneNumber,
+		Channel:   channel,
+		ExpiresAt: existingOTP.ExpiresAt,
+	}
+	if s.config.ExposeOTP {
+		response.OTP = existingOTP.Code
+	}
+	return response, nil
+}
+
+// EscalateOTP reads the phone number's active OTP out in a voice call, for
+// users whose network didn't deliver the SMS. The code must have gone
+// unverified for OTPVoiceEscalationDelay since it was created and for the
+// resend cooldown since it was last sent, and each OTP is escalated at most
+// once. Like any other send, the call counts towards the daily OTP cap and
+// is not placed to opted-out numbers.
+func (s *SMSServiceImpl) EscalateOTP(ctx context.Context, phone string) (*models.OTPResponse, error) {
+	phone = common.NormalizePhone(phone)
+	if s.voice == nil {
+		return nil, common.NewValidationError("Voice delivery is not available")
+	}
+	defer s.otpStatus.Invalidate(s.repoFor(ctx), phone)
+
+	existingOTP, err := s.repoFor(ctx).OTP().FindByPhone(ctx, phone)
+	if err != nil || existingOTP == nil || existingOTP.Verified || !s.now().Before(existingOTP.ExpiresAt) {
+		return nil, common.NewNotFoundError("active OTP")
+	}
+	if lockedFor := existingOTP.LockedUntil.Sub(s.now()); lockedFor > 0 {
+		return nil, common.NewMaxAttemptsError(lockedFor)
+	}
+
+	channels := otpChannels(existingOTP)
+	for _, channel := range channels {
+		if channel == models.ChannelVoice {
+			return nil, common.NewRateLimitError("This OTP was already sent by voice call. Please request a new OTP.")
+		}
+	}
+	wait := existingOTP.CreatedAt.Add(s.config.OTPVoiceEscalationDelay).Sub(s.now())
+	if cooldown := s.resendCooldownLeft(existingOTP); cooldown > wait {
+		wait = cooldown
+	}
+	if wait > 0 {
+		return nil, common.NewRateLimitError("Please wait for the SMS before requesting a voice call.").
+			WithRetryAfter(wait)
+	}
+
+	if err := s.checkDestination(phone); err != nil {
+		return nil, err
+	}
+	if err := s.checkSuppressed(ctx, phone); err != nil {
+		return nil, err
+	}
+	if err := s.acquireSendSlot(phone); err != nil {
+		return nil, err
+	}
+	defer s.inFlight.Release(phone, s.config.MaxInFlightPerNumber)
+
+	today := otpSendDay(s.now())
+	if err := s.reserveDailyOTPSend(ctx, phone, today); err != nil {
+		return nil, err
+	}
+
+	err = s.callProvider(ctx, models.ChannelVoice, func(ctx context.Context) error {
+		return s.voice.SendVoiceOTP(ctx, phone, existingOTP.Code)
+	})
+	if err != nil {
+		log.Printf("Failed to send OTP via voice to %s: %v", phone, err)
+		s.releaseDailyOTPSend(ctx, phone, today)
+		return nil, common.NewServiceUnavailableError("Voice provider")
+	}
+
+	existingOTP.Channels = append(channels, models.ChannelVoice)
+	existingOTP.LastSentAt = s.now()
+	if err := s.repoFor(ctx).OTP().Update(ctx, existingOTP); err != nil {
+		log.Printf("Failed to record voice escalation for %s: %v", phone, err)
+	}
+	s.auditOTP(ctx, existingOTP, s.otpState(existingOTP), models.OTPStateEscalated)
+	log.Printf("OTP for %s escalated to a voice call", phone)
+
+	response := &models.OTPResponse{
+		Success:   true,
+		Message:   "OTP is being read out in a voice call",
+		PhoneNumber: phone,
+		NormalizedPhone: phone,
+		Channel:   models.ChannelVoice,
+		ExpiresAt: existingOTP.ExpiresAt,
+	}
+	if s.config.ExposeOTP {
+		response.OTP = existingOTP.Code
+	}
+	return response, nil
+}
+
+// otpChannels returns the channels an OTP was sent over. OTPs stored before
+// channels were tracked report the channel they were created for.
+func otpChannels(otp *models.OTP) []string {
+	if len(otp.Channels) > 0 {
+		return otp.Channels
+	}
+	if otp.Channel != "" {
+		return []string{otp.Channel}
+	}
+	return []string{models.ChannelSMS}
 }
 
-// SendOTP generates and sends a 6-digit OTP
-func (s *SMSServiceImpl) SendOTP(ctx context.Context, req models.OTPRequest) (*models.OTPResponse, error) {
-	log.Printf("Generating OTP for phone number: %s", req.PhoneNumber)
+// inResendCooldown reports whether an OTP was sent too recently to send another
+func (s *SMSServiceImpl) inResendCooldown(otp *models.OTP) bool {
+	return s.resendCooldownLeft(otp) > 0
+}
 
-	// Check if OTP already exists and hasn't expired
-	existingOTP, err := s.repo.OTP().FindByPhone(ctx, req.PhoneNumber)
-	if err == nil && existingOTP != nil {
-		// OTP exists, check if we should allow resend
-		timeUntilExpiry := time.Until(existingOTP.ExpiresAt)
-		if timeUntilExpiry > 2*time.Minute {
-			return &models.OTPResponse{
-				Success:  false,
-				Message:  "OTP already sent. Please wait before requesting a new one.",
-				ExpiresAt: existingOTP.ExpiresAt,
-			}, nil
+// resendCooldownLeft returns how long until the resend cooldown of an OTP ends
+func (s *SMSServiceImpl) resendCooldownLeft(otp *models.OTP) time.Duration {
+	return otpLastSentAt(otp).Add(s.config.OTPResendCooldown).Sub(s.now())
+}
+
+// otpLastSentAt returns when an OTP was last sent, falling back to its creation
+// for OTPs that were never resent
+func otpLastSentAt(otp *models.OTP) time.Time {
+	if otp.LastSentAt.IsZero() {
+		return otp.CreatedAt
+	}
+	return otp.LastSentAt
+}
+
+// reserveDailyOTPSend counts an OTP send towards the phone number's daily cap
+// before it is made, rejecting it once the cap is reached. The count is a
+// single conditional increment, so concurrent sends can't exceed the cap. A
+// send that then fails gives its reservation back with releaseDailyOTPSend.
+func (s *SMSServiceImpl) reserveDailyOTPSend(ctx context.Context, phone, day string) error {
+	sends := s.repoFor(ctx).OTPSends()
+	if s.config.DailyOTPLimit <= 0 {
+		// Without a cap the counter is only kept for analytics
+		if _, err := sends.Increment(ctx, phone, day); err != nil {
+			log.Printf("Failed to increment daily OTP count for %s: %v", phone, err)
 		}
-		
-		// Delete existing OTP to allow resend
-		s.repo.OTP().DeleteByPhone(ctx, req.PhoneNumber)
+		return nil
 	}
 
-	// Generate 6-digit OTP
-	otp, err := s.generateOTP()
+	sent, ok, err := sends.IncrementBelow(ctx, phone, day, s.config.DailyOTPLimit)
 	if err != nil {
-		log.Printf("Failed to generate OTP for %s: %v", req.PhoneNumber, err)
-		return nil, common.NewInternalError("Failed to generate OTP")
+		log.Printf("Failed to count daily OTP send for %s: %v", phone, err)
+		return common.NewInternalError("Failed to check daily OTP limit")
 	}
+	if !ok {
+		log.Printf("Daily OTP limit reached for %s (%d/%d)", phone, sent, s.config.DailyOTPLimit)
+		return common.NewRateLimitError(fmt.Sprintf("Daily OTP limit of %d reached. Please try again tomorrow.", s.config.DailyOTPLimit))
+	}
+	return nil
+}
 
-	// Set expiry time (5 minutes from now)
-	expiry := time.Now().Add(5 * time.Minute)
-
-	// Create OTP record
-	otpRecord := &models.OTP{
-		Phone:      req.PhoneNumber,
-		Code:       otp,
-		ExpiresAt:  expiry,
-		MaxAttempts: 3,
+// releaseResend gives back a resend of otp reserved by ResendOTP that was not made
+func (s *SMSServiceImpl) releaseResend(ctx context.Context, otp *models.OTP) {
+	if err := s.repoFor(ctx).OTP().ReleaseResend(ctx, otp.ID.Hex(), otp.LastSentAt); err != nil {
+		log.Printf("Failed to release OTP resend for %s: %v", otp.Phone, err)
 	}
+}
 
-	// Store OTP in repository
-	err = s.repo.OTP().Create(ctx, otpRecord)
-	if err != nil {
-		log.Printf("Failed to store OTP for %s: %v", req.PhoneNumber, err)
-		return nil, common.NewInternalError("Failed to store OTP")
+// releaseDailyOTPSend gives back a send reserved by reserveDailyOTPSend that
+// was not made
+func (s *SMSServiceImpl) releaseDailyOTPSend(ctx context.Context, phone, day string) {
+	if err := s.repoFor(ctx).OTPSends().Decrement(ctx, phone, day); err != nil {
+		log.Printf("Failed to release daily OTP count for %s: %v", phone, err)
 	}
+}
 
-	// Send OTP via SMS
-	err = s.smsClient.SendOTP(ctx, req.PhoneNumber, otp)
-	if err != nil {
-		log.Printf("Failed to send OTP SMS to %s: %v", req.PhoneNumber, err)
-		// Clean up stored OTP if SMS fails
-		s.repo.OTP().DeleteByPhone(ctx, req.PhoneNumber)
-		return nil, common.NewServiceUnavailableError("SMS provider")
+// deliverOTP sends an OTP's code over a channel, converting delivery failures
+// into errors for the caller. SMS text is rendered in the OTP's language.
+func (s *SMSServiceImpl) deliverOTP(ctx context.Context, client transport.SMSClient, channel string, otp *models.OTP) error {
+	phone, code := otp.Phone, otp.Code
+	var err error
+	if channel == models.ChannelWhatsApp {
+		err = s.callProvider(ctx, models.ChannelWhatsApp, func(ctx context.Context) error {
+			return s.whatsApp.SendOTPTemplate(ctx, phone, code)
+		})
+	} else {
+		err = s.callProvider(ctx, client.GetProvider(), func(ctx context.Context) error {
+			message := s.otpMessages().Render(otp.Language, code, otp.ExpiresAt.Sub(s.now()))
+			if sender := s.config.otpSender(phone); sender != "" {
+				return client.SendSMS(ctx, sender, phone, message, 0, nil)
+			}
+			return client.SendOTP(ctx, phone, code, message)
+		})
+	}
+	if err == nil {
+		return nil
 	}
 
-	log.Printf("OTP sent successfully to %s, expires at %v", req.PhoneNumber, expiry)
+	log.Printf("Failed to send OTP via %s to %s: %v", channel, phone, err)
+	if errors.Is(err, transport.ErrNotWhatsAppNumber) {
+		return common.NewValidationError("Phone number is not registered on WhatsApp")
+	}
+	provider := models.ChannelWhatsApp
+	if channel != models.ChannelWhatsApp {
+		provider = client.GetProvider()
+	}
+	s.alertSendFailure(failedSendOTP, otp.ID.Hex(), provider, phone, err)
+	if channel == models.ChannelWhatsApp {
+		return common.NewServiceUnavailableError("WhatsApp provider")
+	}
+	return common.NewServiceUnavailableError("SMS provider")
+}
 
-	return &models.OTPResponse{
-		Success:   true,
-		Message:   "OTP sent successfully",
-		OTP:       otp, // In production, don't return OTP in response
-		ExpiresAt: expiry,
-	}, nil
+// rateLimitUnavailable decides about a verification whose rate limit couldn't
+// be counted because the store is unreachable. It is refused unless the
+// configuration fails open, which lets it through unthrottled.
+func (s *SMSServiceImpl) rateLimitUnavailable(key string, err error) (bool, error) {
+	if s.config.RateLimitFailOpen {
+		log.Printf("Rate limit store unavailable, verifying %s without a rate limit: %v", key, err)
+		return true, nil
+	}
+	log.Printf("Rate limit store unavailable, refusing verification for %s: %v", key, err)
+	return false, common.NewServiceUnavailableError("Verification")
 }
 
 // VerifyOTP verifies the provided OTP
 func (s *SMSServiceImpl) VerifyOTP(ctx context.Context, req models.VerifyOTPRequest) (*models.VerifyOTPResponse, error) {
+	req.PhoneNumber = common.NormalizePhone(req.PhoneNumber)
 	log.Printf("Verifying OTP for phone number: %s", req.PhoneNumber)
+	defer s.otpStatus.Invalidate(s.repoFor(ctx), req.PhoneNumber)
+
+	now := s.now()
+
+	// Back off phone numbers that keep submitting wrong codes
+	if locked, retryAfter := s.verifyBackoff.Check(req.PhoneNumber, now); locked {
+		log.Printf("Verification locked for %s after repeated failures", req.PhoneNumber)
+		return nil, common.NewRateLimitError("Too many failed verification attempts. Please try again later.").
+			WithRetryAfter(retryAfter)
+	}
+
+	// Block phone numbers flagged for a brute-force attack
+	if err := s.checkBruteForceBlock(ctx, req.PhoneNumber, now); err != nil {
+		return nil, err
+	}
+
+	// Cap verification throughput across all phone numbers
+	allowed, retryAfter, limitErr := s.verifyLimiter.Allow(ctx, "*", s.config.GlobalVerifyRateLimit, now)
+	if limitErr != nil {
+		if allowed, limitErr = s.rateLimitUnavailable("all phone numbers", limitErr); limitErr != nil {
+			return nil, limitErr
+		}
+	}
+	if !allowed {
+		log.Printf("Global verify rate limit reached")
+		return nil, common.NewRateLimitError("Too many verification requests. Please try again shortly.").
+			WithRetryAfter(retryAfter)
+	}
 
 	// Get stored OTP
-	storedOTP, err := s.repo.OTP().FindByPhone(ctx, req.PhoneNumber)
+	storedOTP, err := s.repoFor(ctx).OTP().FindByPhone(ctx, req.PhoneNumber)
+
+	// Throttle verification attempts per phone number. The limit is the one of
+	// the stored OTP's purpose, so naming another purpose gets no fresh budget.
+	limit := s.config.VerifyRateLimit
+	if err == nil && storedOTP != nil {
+		limit = s.config.verifyRateLimit(otpPurpose(storedOTP.Purpose))
+	}
+	allowed, retryAfter, limitErr = s.verifyLimiter.Allow(ctx, req.PhoneNumber, limit, now)
+	if limitErr != nil {
+		if allowed, limitErr = s.rateLimitUnavailable(req.PhoneNumber, limitErr); limitErr != nil {
+			return nil, limitErr
+		}
+	}
+	if !allowed {
+		log.Printf("Verify rate limit reached for %s", req.PhoneNumber)
+		return nil, common.NewRateLimitError("Too many verification attempts. Please try again later.").
+			WithRetryAfter(retryAfter)
+	}
+
 	if err != nil || storedOTP == nil {
 		log.Printf("OTP not found for %s: %v", req.PhoneNumber, err)
 		return &models.VerifyOTPResponse{
@@ -220,11 +1955,40 @@ func (s *SMSServiceImpl) VerifyOTP(ctx context.Context, req models.VerifyOTPRequ
 		}, nil
 	}
 
+	// A code only verifies the purpose it was sent for
+	purposeMatches := otpPurpose(req.Purpose) == otpPurpose(storedOTP.Purpose)
+
+	// A retried verification of an already verified OTP by the client that
+	// verified it succeeds again within the grace window, as long as attempts
+	// are left; each retry uses one. Any other request finds no usable OTP.
+	if storedOTP.Verified {
+		if s.graceRetry(ctx, storedOTP, req, purposeMatches) {
+			log.Printf("OTP for %s was already verified, repeating success", req.PhoneNumber)
+			return &models.VerifyOTPResponse{
+				Success: true,
+				Message: "OTP verified successfully",
+				Valid:   true,
+			}, nil
+		}
+		return &models.VerifyOTPResponse{
+			Success: false,
+			Message: "OTP not found or expired. Please request a new OTP.",
+			Valid:   false,
+		}, nil
+	}
+
+	// Reject verification while the phone number is locked out
+	if lockedFor := storedOTP.LockedUntil.Sub(s.now()); lockedFor > 0 {
+		log.Printf("Verification for %s rejected, locked out for %v", req.PhoneNumber, lockedFor.Round(time.Second))
+		return nil, common.NewMaxAttemptsError(lockedFor).WithRemainingAttempts(0)
+	}
+
 	// Check if OTP has expired
-	if time.Now().After(storedOTP.ExpiresAt) {
+	if now.After(storedOTP.ExpiresAt) {
 		log.Printf("OTP expired for %s", req.PhoneNumber)
 		// Clean up expired OTP
-		s.repo.OTP().DeleteByPhone(ctx, req.PhoneNumber)
+		s.repoFor(ctx).OTP().DeleteByPhone(ctx, req.PhoneNumber)
+		s.auditOTP(ctx, storedOTP, s.otpState(storedOTP), models.OTPStateExpired)
 		return &models.VerifyOTPResponse{
 			Success: false,
 			Message: "OTP expired. Please request a new OTP.",
@@ -235,25 +1999,16 @@ func (s *SMSServiceImpl) VerifyOTP(ctx context.Context, req models.VerifyOTPRequ
 	// Check if max attempts reached
 	if storedOTP.Attempts >= storedOTP.MaxAttempts {
 		log.Printf("Max attempts reached for %s", req.PhoneNumber)
-		return &models.VerifyOTPResponse{
-			Success: false,
-			Message: "Maximum verification attempts reached. Please request a new OTP.",
-			Valid:   false,
-		}, nil
-	}
-
-	// Increment attempts
-	err = s.repo.OTP().IncrementAttempts(ctx, req.PhoneNumber)
-	if err != nil {
-		log.Printf("Failed to increment attempts for %s: %v", req.PhoneNumber, err)
+		return failedVerification(storedOTP, "Maximum verification attempts reached. Please request a new OTP."), nil
 	}
 
 	// Check if OTP matches
-	if storedOTP.Code == req.OTP {
-		log.Printf("OTP verified successfully for %s", req.PhoneNumber)
-		
-		// Delete OTP after successful verification
-		s.repo.OTP().DeleteByPhone(ctx, req.PhoneNumber)
+	if purposeMatches && otpMatches(storedOTP.Code, req.OTP) {
+		timeToVerify := s.now().Sub(storedOTP.CreatedAt)
+		s.timeToVerify.Observe(timeToVerify)
+		log.Printf("OTP verified successfully for %s after %v", req.PhoneNumber, timeToVerify.Round(time.Second))
+		s.verifyBackoff.Succeed(req.PhoneNumber)
+		s.consumeOTP(ctx, storedOTP, req.ClientID)
 		
 		return &models.VerifyOTPResponse{
 			Success: true,
@@ -262,32 +2017,386 @@ func (s *SMSServiceImpl) VerifyOTP(ctx context.Context, req models.VerifyOTPRequ
 		}, nil
 	}
 
-	log.Printf("OTP verification failed for %s", req.PhoneNumber)
-	return &models.VerifyOTPResponse{
-		Success: false,
-		Message: "Invalid OTP. Please try again.",
-		Valid:   false,
+	// Only wrong codes, including codes submitted for another purpose, count as
+	// attempts and feed the backoff. Requests rejected earlier (throttled,
+	// expired or over MaxAttempts) never reach the comparison, so they count
+	// towards neither.
+	if !purposeMatches {
+		log.Printf("OTP verification failed for %s, purpose %s does not match the OTP", req.PhoneNumber, otpPurpose(req.Purpose))
+	} else {
+		log.Printf("OTP verification failed for %s", req.PhoneNumber)
+	}
+	// The attempt is only counted while attempts are left, so of concurrent
+	// wrong codes only MaxAttempts count; a wrong code that can't be counted
+	// is rejected without telling whether attempts remain
+	attempts, counted, err := s.repoFor(ctx).OTP().IncrementAttempts(ctx, req.PhoneNumber)
+	if err != nil {
+		log.Printf("Failed to increment attempts for %s: %v", req.PhoneNumber, err)
+		return nil, common.NewInternalError("Failed to verify OTP")
+	}
+	if counted {
+		storedOTP.Attempts = attempts
+	} else {
+		// A concurrent verification used the last attempt
+		storedOTP.Attempts = storedOTP.MaxAttempts
+	}
+	s.verifyBackoff.Fail(req.PhoneNumber, s.config.VerifyFailureBackoff, now)
+	s.detectBruteForce(ctx, req.PhoneNumber, s.now())
+	if counted && storedOTP.Attempts >= storedOTP.MaxAttempts && s.config.OTPLockout > 0 {
+		s.lockOut(ctx, storedOTP)
+	}
+	return failedVerification(storedOTP, "Invalid OTP. Please try again."), nil
+}
+
+// failedVerification describes a rejected code for an existing OTP, with the
+// attempts it has left and ErrCodeMaxAttempts once there are none
+func failedVerification(otp *models.OTP, message string) *models.VerifyOTPResponse {
+	remaining := otp.MaxAttempts - otp.Attempts
+	if remaining < 0 {
+		remaining = 0
+	}
+	response := &models.VerifyOTPResponse{
+		Success:           false,
+		Message:           message,
+		Valid:             false,
+		RemainingAttempts: &remaining,
+	}
+	if remaining == 0 {
+		response.Code = common.ErrCodeMaxAttempts
+	}
+	return response
+}
+
+// graceRetry reports whether a verification of an already verified OTP
+// repeats the success. It has to come from the client that verified the OTP,
+// within the grace window and with attempts left, and uses up an attempt.
+func (s *SMSServiceImpl) graceRetry(ctx context.Context, otp *models.OTP, req models.VerifyOTPRequest, purposeMatches bool) bool {
+	if req.ClientID == "" || otp.VerifiedBy != clientFingerprint(req.ClientID) {
+		return false
+	}
+	if !s.now().Before(otp.ExpiresAt) || !purposeMatches || otp.Attempts >= otp.MaxAttempts {
+		return false
+	}
+	if !otpMatches(otp.Code, req.OTP) {
+		return false
+	}
+	// Concurrent retries only succeed while attempts are left
+	_, counted, err := s.repoFor(ctx).OTP().IncrementAttempts(ctx, otp.Phone)
+	if err != nil {
+		log.Printf("Failed to count retried verification for %s: %v", otp.Phone, err)
+		return false
+	}
+	return counted
+}
+
+// clientFingerprint hashes a client ID so verified OTPs don't store client IP addresses
+func clientFingerprint(clientID string) string {
+	sum := sha256.Sum256([]byte(clientID))
+	return hex.EncodeToString(sum[:])
+}
+
+// consumeOTP retires a successfully verified OTP and counts the verification
+// for the daily analytics on the day the code was last sent, so the success
+// rate of a day compares its sends with their verifications. Within the grace window it is only marked verified
+// by clientID, and expires at the end of the window.
+func (s *SMSServiceImpl) consumeOTP(ctx context.Context, otp *models.OTP, clientID string) {
+	if err := s.repoFor(ctx).OTPSends().IncrementVerified(ctx, otp.Phone, otpSendDay(otpLastSentAt(otp))); err != nil {
+		log.Printf("Failed to count verification for %s: %v", otp.Phone, err)
+	}
+	s.auditOTP(ctx, otp, models.OTPStateSent, models.OTPStateVerified)
+
+	if s.config.OTPVerifyGrace <= 0 {
+		s.repoFor(ctx).OTP().DeleteByPhone(ctx, otp.Phone)
+		return
+	}
+
+	otp.Verified = true
+	otp.VerifiedAt = s.now()
+	if clientID != "" {
+		otp.VerifiedBy = clientFingerprint(clientID)
+	}
+	otp.ExpiresAt = otp.VerifiedAt.Add(s.config.OTPVerifyGrace)
+	if err := s.repoFor(ctx).OTP().Update(ctx, otp); err != nil {
+		log.Printf("Failed to mark OTP for %s as verified, deleting it: %v", otp.Phone, err)
+		s.repoFor(ctx).OTP().DeleteByPhone(ctx, otp.Phone)
+	}
+}
+
+// lockOut blocks the OTP's phone number for the configured lockout. The OTP is
+// kept at least as long as the lockout so neither cleanup nor a resend lifts it early.
+func (s *SMSServiceImpl) lockOut(ctx context.Context, otp *models.OTP) {
+	otp.LockedUntil = s.now().Add(s.config.OTPLockout)
+	if otp.ExpiresAt.Before(otp.LockedUntil) {
+		otp.ExpiresAt = otp.LockedUntil
+	}
+
+	if err := s.repoFor(ctx).OTP().Update(ctx, otp); err != nil {
+		log.Printf("Failed to lock out %s: %v", otp.Phone, err)
+		return
+	}
+	s.auditOTP(ctx, otp, models.OTPStateSent, models.OTPStateLocked)
+	log.Printf("Locked out %s until %v after %d failed attempts", otp.Phone, otp.LockedUntil, otp.Attempts)
+}
+
+// RevokeOTP deletes the active OTP for a phone number so a fresh one must be requested
+func (s *SMSServiceImpl) RevokeOTP(ctx context.Context, phone string) error {
+	phone = common.NormalizePhone(phone)
+	storedOTP, err := s.repoFor(ctx).OTP().FindByPhone(ctx, phone)
+	if err != nil || storedOTP == nil || storedOTP.Verified || s.now().After(storedOTP.ExpiresAt) {
+		return common.NewNotFoundError("active OTP")
+	}
+	defer s.otpStatus.Invalidate(s.repoFor(ctx), phone)
+
+	if err := s.repoFor(ctx).OTP().DeleteByPhone(ctx, phone); err != nil {
+		log.Printf("Failed to revoke OTP for %s: %v", phone, err)
+		return common.NewInternalError("Failed to revoke OTP")
+	}
+	s.auditOTP(ctx, storedOTP, s.otpState(storedOTP), models.OTPStateRevoked)
+
+	log.Printf("OTP revoked for %s", phone)
+	return nil
+}
+
+// ResetOTPAttempts gives the active OTP of a phone number its full attempt
+// budget back and lifts the lockout, verification backoff and verification
+// rate limit of the number, e.g. for a user who mistyped their code.
+// Brute-force flags and the global verification rate limit are left alone.
+func (s *SMSServiceImpl) ResetOTPAttempts(ctx context.Context, phone string) error {
+	phone = common.NormalizePhone(phone)
+	storedOTP, err := s.repoFor(ctx).OTP().FindByPhone(ctx, phone)
+	if err != nil || storedOTP == nil || storedOTP.Verified || s.now().After(storedOTP.ExpiresAt) {
+		return common.NewNotFoundError("active OTP")
+	}
+	defer s.otpStatus.Invalidate(s.repoFor(ctx), phone)
+
+	if err := s.repoFor(ctx).OTP().ResetAttempts(ctx, phone); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return common.NewNotFoundError("active OTP")
+		}
+		log.Printf("Failed to reset OTP attempts for %s: %v", phone, err)
+		return common.NewInternalError("Failed to reset OTP attempts")
+	}
+	s.verifyBackoff.Succeed(phone)
+	// The per-number limit is the one of the OTP's purpose, counted under the phone number
+	window := s.config.verifyRateLimit(otpPurpose(storedOTP.Purpose)).Window
+	if err := s.verifyLimiter.Reset(ctx, phone, window, s.now()); err != nil {
+		log.Printf("Warning: failed to reset the verification rate limit of %s: %v", phone, err)
+	}
+	s.auditOTP(ctx, storedOTP, s.otpState(storedOTP), models.OTPStateReset)
+
+	log.Printf("OTP attempts reset for %s after %d attempts", phone, storedOTP.Attempts)
+	return nil
+}
+
+// GetOTPStatus reports whether a phone number has an active OTP and its daily send usage.
+// Statuses are cached for OTPStatusCacheTTL, never past the expiry of the OTP.
+func (s *SMSServiceImpl) GetOTPStatus(ctx context.Context, phone string) (*models.OTPStatus, error) {
+	phone = common.NormalizePhone(phone)
+
+	repo := s.repoFor(ctx)
+	now := s.now()
+	cached, generation := s.otpStatus.Get(repo, phone, now)
+	if cached != nil {
+		return cached, nil
+	}
+
+	status := &models.OTPStatus{
+		PhoneNumber:    phone,
+		DailySendLimit: s.config.DailyOTPLimit,
+	}
+
+	storedOTP, err := repo.OTP().FindByPhone(ctx, phone)
+	if err == nil && storedOTP != nil && !storedOTP.Verified && now.Before(storedOTP.ExpiresAt) {
+		status.HasActiveOTP = true
+		status.ExpiresAt = &storedOTP.ExpiresAt
+		status.Attempts = storedOTP.Attempts
+	}
+
+	sent, err := repo.OTPSends().Count(ctx, phone, otpSendDay(now))
+	if err != nil {
+		log.Printf("Failed to read daily OTP count for %s: %v", phone, err)
+		return nil, common.NewInternalError("Failed to retrieve OTP status")
+	}
+	status.DailySendCount = sent
+
+	if s.config.OTPStatusCacheTTL > 0 {
+		expiresAt := now.Add(s.config.OTPStatusCacheTTL)
+		if status.HasActiveOTP && status.ExpiresAt.Before(expiresAt) {
+			expiresAt = *status.ExpiresAt
+		}
+		s.otpStatus.Put(repo, phone, *status, generation, now, expiresAt)
+	}
+	return status, nil
+}
+
+// GetStats returns aggregate SMS service statistics. The message counts per
+// status are restricted to phone when it isn't empty; the time to verify
+// always covers every number.
+func (s *SMSServiceImpl) GetStats(ctx context.Context, phone string) (*models.SMSStats, error) {
+	counts, err := s.repoFor(ctx).SMS().CountByStatus(ctx, phone)
+	if err != nil {
+		log.Printf("Failed to count SMS by status: %v", err)
+		return nil, common.NewInternalError("Failed to count SMS messages")
+	}
+
+	// The statuses of outbound messages are always reported, even without messages
+	statusCounts := map[models.Status]int{
+		models.StatusPending:   0,
+		models.StatusSent:      0,
+		models.StatusDelivered: 0,
+		models.StatusFailed:    0,
+	}
+	total := 0
+	for status, count := range counts {
+		statusCounts[status] = count
+		total += count
+	}
+
+	return &models.SMSStats{
+		Phone:        phone,
+		Total:        total,
+		StatusCounts: statusCounts,
+		TimeToVerify: s.timeToVerify.Snapshot(),
 	}, nil
 }
 
+// lookupError converts a repository lookup error: malformed IDs stay validation
+// errors, missing records become not found, anything else is internal
+func lookupError(err error, resource string) error {
+	if appErr, ok := err.(*common.AppError); ok {
+		return appErr
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		return common.NewNotFoundError(resource)
+	}
+	log.Printf("Failed to look up %s: %v", resource, err)
+	return common.NewInternalError("Failed to retrieve " + resource)
+}
+
+// otpPurpose returns the purpose to use for an OTP request, falling back to the default
+func otpPurpose(purpose string) string {
+	if purpose == "" {
+		return models.PurposeDefault
+	}
+	return purpose
+}
+
+// otpSendDay returns the UTC day bucket used for daily OTP counters
+func otpSendDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
 // CleanupExpiredOTPs removes expired OTPs from storage
 func (s *SMSServiceImpl) CleanupExpiredOTPs() {
 	log.Println("Starting OTP cleanup routine")
-	
-	ctx := context.Background()
-	expiredOTPs, err := s.repo.OTP().FindExpired(ctx)
+	s.runJob(s.cleanupExpiredOTPs)
+}
+
+func (s *SMSServiceImpl) cleanupExpiredOTPs(ctx context.Context) {
+	s.sweepStaleOTPs(ctx)
+
+	expiredOTPs, err := s.repoFor(ctx).OTP().FindExpired(ctx)
 	if err != nil {
 		log.Printf("Failed to find expired OTPs: %v", err)
 		return
 	}
 	
 	for _, otp := range expiredOTPs {
+		if ctx.Err() != nil {
+			log.Printf("OTP cleanup stopped early: %v", ctx.Err())
+			return
+		}
 		log.Printf("Cleaning up expired OTP for %s", otp.Phone)
-		err := s.repo.OTP().DeleteByPhone(ctx, otp.Phone)
+		err := s.repoFor(ctx).OTP().DeleteByPhone(ctx, otp.Phone)
 		if err != nil {
 			log.Printf("Failed to delete expired OTP for %s: %v", otp.Phone, err)
+		} else {
+			s.auditOTP(ctx, otp, s.otpState(otp), models.OTPStateExpired)
+		}
+		s.otpStatus.Invalidate(s.repoFor(ctx), otp.Phone)
+	}
+}
+
+// sweepStaleOTPs deletes OTPs created longer than MaxOTPLifetime ago, whatever
+// their expiry says, so an OTP stored without a usable expiry can't stay valid
+func (s *SMSServiceImpl) sweepStaleOTPs(ctx context.Context) {
+	if s.config.MaxOTPLifetime <= 0 {
+		return
+	}
+
+	deleted, err := s.repoFor(ctx).OTP().DeleteOlderThan(ctx, s.now().Add(-s.config.MaxOTPLifetime))
+	if err != nil {
+		log.Printf("Failed to sweep OTPs older than %v: %v", s.config.MaxOTPLifetime, err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Swept %d OTPs older than %v that had not expired", deleted, s.config.MaxOTPLifetime)
+	}
+}
+
+// PurgeOldRecords deletes SMS, OTP, callback and audit records and daily OTP
+// send counters older than the retention period. Unlike the OTP cleanup it
+// removes records regardless of their status, so data is not kept longer than
+// allowed.
+func (s *SMSServiceImpl) PurgeOldRecords() {
+	if s.config.RetentionPeriod <= 0 {
+		return
+	}
+	log.Println("Starting retention purge routine")
+	s.runJob(s.purgeOldRecords)
+}
+
+func (s *SMSServiceImpl) purgeOldRecords(ctx context.Context) {
+	cutoff := s.now().Add(-s.config.RetentionPeriod)
+	repo := s.repoFor(ctx)
+
+	purges := []struct {
+		name  string
+		purge func(context.Context, time.Time) (int64, error)
+	}{
+		{"SMS", repo.SMS().DeleteOlderThan},
+		{"OTP", repo.OTP().DeleteOlderThan},
+		{"callback", repo.Callback().DeleteOlderThan},
+		{"OTP send counter", repo.OTPSends().DeleteOlderThan},
+		{"audit", repo.Audit().DeleteOlderThan},
+	}
+	for _, p := range purges {
+		deleted, err := p.purge(ctx, cutoff)
+		if err != nil {
+			log.Printf("Failed to purge %s records older than %v: %v", p.name, cutoff, err)
+			continue
 		}
+		log.Printf("Purged %d %s records older than %v", deleted, p.name, cutoff)
+	}
+}
+
+// runJob runs a background job once against the default repository and once
+// against the repository of every registered tenant
+func (s *SMSServiceImpl) runJob(job func(ctx context.Context)) {
+	repos := []repository.Repository{s.repo}
+	if s.tenants != nil {
+		repos = append(repos, s.tenants.Repositories()...)
 	}
+
+	for _, repo := range repos {
+		ctx, cancel := s.jobContext()
+		job(repository.WithRepository(ctx, repo))
+		cancel()
+	}
+}
+
+// repoFor returns the repository of the request's tenant, or the default one
+func (s *SMSServiceImpl) repoFor(ctx context.Context) repository.Repository {
+	return repository.FromContext(ctx, s.repo)
+}
+
+// jobContext returns the context for one run of a background routine, bounded
+// by the configured job timeout
+func (s *SMSServiceImpl) jobContext() (context.Context, context.CancelFunc) {
+	if s.config.JobTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.config.JobTimeout)
 }
 
 // startCleanupRoutine starts the periodic cleanup of expired OTPs
@@ -295,30 +2404,88 @@ func (s *SMSServiceImpl) startCleanupRoutine() {
 	ticker := time.NewTicker(1 * time.Minute) // Run cleanup every minute
 	defer ticker.Stop()
 
-	for range ticker.C {
-		s.CleanupExpiredOTPs()
+	for {
+		select {
+		case <-ticker.C:
+			s.CleanupExpiredOTPs()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// startRetryRoutine periodically resends failed SMS messages
+func (s *SMSServiceImpl) startRetryRoutine() {
+	ticker := time.NewTicker(s.config.SMSRetry.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.RetryFailedSMS()
+		case <-s.stop:
+			return
+		}
 	}
 }
 
-// generateOTP generates a random 6-digit OTP
-func (s *SMSServiceImpl) generateOTP() (string, error) {
-	// Generate 6 random digits
-	otp := ""
-	for i := 0; i < 6; i++ {
-		num, err := rand.Int(rand.Reader, big.NewInt(10))
-		if err != nil {
-			return "", fmt.Errorf("failed to generate random number: %w", err)
+// startStatusPollRoutine periodically polls providers for delivery statuses
+func (s *SMSServiceImpl) startStatusPollRoutine() {
+	ticker := time.NewTicker(s.config.StatusPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.PollDeliveryStatus()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// startPurgeRoutine purges records older than the retention period right
+// away, so a restart doesn't postpone it by a whole interval, and then
+// periodically
+func (s *SMSServiceImpl) startPurgeRoutine() {
+	s.PurgeOldRecords()
+
+	ticker := time.NewTicker(s.config.RetentionPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.PurgeOldRecords()
+		case <-s.stop:
+			return
 		}
-		otp += fmt.Sprintf("%d", num.Int64())
 	}
-	return otp, nil
+}
+
+// otpMatches compares OTP codes in constant time to avoid leaking timing information
+func otpMatches(stored, provided string) bool {
+	if len(stored) != len(provided) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(provided)) == 1
+}
+
+// repoFor returns the repository of the request's tenant, or the default one
+func (s *CallbackServiceImpl) repoFor(ctx context.Context) repository.Repository {
+	return repository.FromContext(ctx, s.repo)
 }
 
 // NewCallbackService creates a new callback service instance
-func NewCallbackService(repo repository.Repository) *CallbackServiceImpl {
-	return &CallbackServiceImpl{
-		repo: repo,
+func NewCallbackService(repo repository.Repository, opts ...CallbackOption) *CallbackServiceImpl {
+	service := &CallbackServiceImpl{
+		repo:  repo,
+		async: newAsyncQueue(),
 	}
+	for _, opt := range opts {
+		opt(service)
+	}
+	return service
 }
 
 // RequestCallback handles callback requests
@@ -330,15 +2497,17 @@ func (s *CallbackServiceImpl) RequestCallback(ctx context.Context, req models.Ca
 		PhoneNumber: req.PhoneNumber,
 		Message:     req.Message,
 		Priority:    req.Priority,
+		PriorityRank: models.CallbackPriorityRank(req.Priority),
 		Status:      models.StatusRequested,
 	}
 	
 	// Store callback request in database
-	err := s.repo.Callback().Create(ctx, callback)
+	err := s.repoFor(ctx).Callback().Create(ctx, callback)
 	if err != nil {
 		log.Printf("Failed to store callback request for %s: %v", req.PhoneNumber, err)
 		return nil, common.NewInternalError("Failed to store callback request")
 	}
+	s.auditCallback(ctx, callback, "", callback.Status)
 	
 	// TODO: Placeholder for Plivo Voice API call
 	// This is where you would integrate with Plivo Voice API
@@ -351,7 +2520,7 @@ func (s *CallbackServiceImpl) RequestCallback(ctx context.Context, req models.Ca
 	// {
 	//   "from": "+1234567890",
 	//   "to": req.PhoneNumber,
-	//   "answer_url": "https://your-domain.com/voice/answer",
+	//   "answer_url": "https://your-domain.com/voice/answer?request_id=" + callback.ID.Hex(),
 	//   "hangup_url": "https://your-domain.com/voice/hangup",
 	//   "caller_name": "SMS App"
 	// }
@@ -367,18 +2536,101 @@ func (s *CallbackServiceImpl) RequestCallback(ctx context.Context, req models.Ca
 
 // GetCallbackStatus retrieves the status of a callback request
 func (s *CallbackServiceImpl) GetCallbackStatus(ctx context.Context, requestID string) (*models.Callback, error) {
-	callback, err := s.repo.Callback().FindByID(ctx, requestID)
+	callback, err := s.repoFor(ctx).Callback().FindByID(ctx, requestID)
 	if err != nil {
-		return nil, common.NewNotFoundError("callback request")
+		return nil, lookupError(err, "callback request")
 	}
 	return callback, nil
 }
 
-// UpdateCallbackStatus updates the status of a callback request
-func (s *CallbackServiceImpl) UpdateCallbackStatus(ctx context.Context, requestID, status string) error {
-	err := s.repo.Callback().UpdateStatus(ctx, requestID, status)
+// UpdateCallbackStatus updates the status of a callback request, rejecting
+// moves not allowed by models.CallbackTransitions
+func (s *CallbackServiceImpl) UpdateCallbackStatus(ctx context.Context, requestID string, status models.Status) error {
+	callback, err := s.GetCallbackStatus(ctx, requestID)
 	if err != nil {
+		return err
+	}
+	return s.updateStatus(ctx, callback, status)
+}
+
+// updateStatus moves a stored callback request to status and records the change
+func (s *CallbackServiceImpl) updateStatus(ctx context.Context, callback *models.Callback, status models.Status) error {
+	err := s.repoFor(ctx).Callback().UpdateStatus(ctx, callback.ID.Hex(), status)
+	if err != nil {
+		if appErr, ok := err.(*common.AppError); ok {
+			return appErr
+		}
+		if errors.Is(err, models.ErrInvalidTransition) {
+			return common.NewValidationError("Callback request cannot change status: " + err.Error())
+		}
 		return common.NewInternalError("Failed to update callback status")
 	}
+	s.auditCallback(ctx, callback, callback.Status, status)
+	callback.Status = status
 	return nil
-} 
\ No newline at end of file
+}
+
+// AnswerCallback records that the call placed for a callback request was
+// answered, moving the request to in progress. A repeated answer of the same
+// call returns the request again; requests that are no longer waiting for a
+// call are a conflict.
+func (s *CallbackServiceImpl) AnswerCallback(ctx context.Context, requestID, callUUID string) (*models.Callback, error) {
+	callback, err := s.GetCallbackStatus(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case callback.Status == models.StatusInProgress && callback.CallUUID == callUUID:
+		return callback, nil
+	case callback.Status != models.StatusRequested:
+		return nil, common.NewConflictError(fmt.Sprintf("Callback request is already %s", callback.Status))
+	}
+
+	if err := s.repoFor(ctx).Callback().MarkAnswered(ctx, requestID, callUUID); err != nil {
+		if appErr, ok := err.(*common.AppError); ok {
+			return nil, appErr
+		}
+		if errors.Is(err, models.ErrInvalidTransition) {
+			return nil, common.NewConflictError("Callback request cannot be answered: " + err.Error())
+		}
+		log.Printf("Failed to record answered call %s for callback %s: %v", callUUID, requestID, err)
+		return nil, common.NewInternalError("Failed to update callback status")
+	}
+	s.auditCallback(ctx, callback, callback.Status, models.StatusInProgress)
+	callback.Status = models.StatusInProgress
+	callback.CallUUID = callUUID
+
+	log.Printf("Callback request %s answered in call %s", requestID, callUUID)
+	return callback, nil
+}
+
+// CancelCallback cancels a callback that has not completed yet, hanging up the call if one was placed
+func (s *CallbackServiceImpl) CancelCallback(ctx context.Context, requestID string) (*models.Callback, error) {
+	callback, err := s.GetCallbackStatus(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch callback.Status {
+	case models.StatusCancelled:
+		return callback, nil
+	case models.StatusRequested, models.StatusInProgress:
+	default:
+		return nil, common.NewConflictError(fmt.Sprintf("Callback request is already %s", callback.Status))
+	}
+
+	if callback.CallUUID != "" && s.voiceClient != nil {
+		if err := s.voiceClient.HangupCall(ctx, callback.CallUUID); err != nil {
+			// The call may have ended on its own; cancelling the request still stops any retry
+			log.Printf("Failed to hang up call %s for callback %s: %v", callback.CallUUID, requestID, err)
+		}
+	}
+
+	if err := s.updateStatus(ctx, callback, models.StatusCancelled); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Callback request %s cancelled", requestID)
+	return callback, nil
+}
@@ -1,36 +1,58 @@
 package sms_service
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
-)
 
-// MockPlivoClient for testing
-type MockPlivoClient struct{}
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
-func (m *MockPlivoClient) SendSMS(to, message string) error {
-	return nil
+	"sms-app-backend/common"
+	"sms-app-backend/models"
+	"sms-app-backend/repository"
+	"sms-app-backend/sms_service/transport"
+	"sms-app-backend/webhook"
+)
+
+func newTestService() (*SMSServiceImpl, *repository.InMemoryRepository, *transport.MockSMSClient) {
+	repo := repository.NewInMemoryRepository()
+	mockClient := transport.NewMockSMSClient()
+	return NewSMSService(repo, mockClient, WithConfig(testConfig())), repo, mockClient
 }
 
-func (m *MockPlivoClient) SendOTP(to, otp string) error {
-	return nil
+// testConfig is the default configuration with OTP codes exposed so tests can verify them
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.ExposeOTP = true
+	cfg.AllowedSenderIDs = []string{"ACME", "ACMEBANK"}
+	return cfg
 }
 
 func TestSendOTP(t *testing.T) {
-	// Create mock components
-	otpRepo := NewInMemoryOTPRepository()
-	mockPlivo := &MockPlivoClient{}
-	
-	// Create service
-	service := NewSMSService(otpRepo, mockPlivo)
+	service, _, mockClient := newTestService()
 	
 	// Test OTP generation
-	req := OTPRequest{PhoneNumber: "+1234567890"}
+	req := models.OTPRequest{PhoneNumber: "+1234567890"}
 	response, err := service.SendOTP(context.Background(), req)
 	
 	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+		t.Fatalf("Expected no error, got %v", err)
 	}
 	
 	if !response.Success {
@@ -44,51 +66,111 @@ func TestSendOTP(t *testing.T) {
 	if len(response.OTP) != 6 {
 		t.Errorf("Expected 6-digit OTP, got %d digits", len(response.OTP))
 	}
+
+	calls := mockClient.Calls()
+	if len(calls) != 1 || calls[0].Method != "SendOTP" || calls[0].To != "+1234567890" || calls[0].Body != response.OTP {
+		t.Errorf("Expected the generated OTP to be sent once, got %+v", calls)
+	}
+}
+
+func TestOTPMessageCatalog(t *testing.T) {
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{"", "Your OTP is: 123456. Valid for 5 minutes. Do not share this code."},
+		{"es", "Tu código OTP es: 123456. Válido por 5 minutos. No compartas este código."},
+		{"pt-BR", "Seu código OTP é: 123456. Válido por 5 minutos. Não compartilhe este código."},
+		{"xx", "Your OTP is: 123456. Valid for 5 minutes. Do not share this code."},
+	}
+	for _, tt := range tests {
+		if got := DefaultOTPMessages.Render(tt.language, "123456", 4*time.Minute+30*time.Second); got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.language, got, tt.want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "otp_messages.json")
+	os.WriteFile(path, []byte(`{"IT": "Il tuo codice è {code}, valido {minutes} minuti"}`), 0o600)
+	catalog, err := LoadOTPMessageCatalog(path)
+	if err != nil {
+		t.Fatalf("Failed to load catalog: %v", err)
+	}
+	if got := catalog.Render("it", "123456", time.Minute); got != "Il tuo codice è 123456, valido 1 minuti" {
+		t.Errorf("Expected the loaded Italian text, got %q", got)
+	}
+	if got := catalog.Render("fr", "123456", time.Minute); !strings.HasPrefix(got, "Votre code OTP") {
+		t.Errorf("Expected built-in languages to be kept, got %q", got)
+	}
+
+	os.WriteFile(path, []byte(`{"it": "Il tuo codice"}`), 0o600)
+	if _, err := LoadOTPMessageCatalog(path); err == nil {
+		t.Error("Expected a template without {code} to be rejected")
+	}
+}
+
+func TestSendOTPLanguage(t *testing.T) {
+	service, _, mockClient := newTestService()
+	service.config.OTPResendCooldown = 0
+	ctx := context.Background()
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890", Language: "es"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	if _, err := service.ResendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"}); err != nil {
+		t.Fatalf("Failed to resend OTP: %v", err)
+	}
+
+	calls := mockClient.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected a send and a resend, got %+v", calls)
+	}
+	for _, call := range calls {
+		if !strings.HasPrefix(call.Message, "Tu código OTP es: "+response.OTP) {
+			t.Errorf("Expected the Spanish text with the code, got %q", call.Message)
+		}
+	}
 }
 
 func TestOTPExpiry(t *testing.T) {
-	otpRepo := NewInMemoryOTPRepository()
-	mockPlivo := &MockPlivoClient{}
-	service := NewSMSService(otpRepo, mockPlivo)
+	service, repo, _ := newTestService()
 	
 	// Send OTP
-	req := OTPRequest{PhoneNumber: "+1234567890"}
+	req := models.OTPRequest{PhoneNumber: "+1234567890"}
 	response, err := service.SendOTP(context.Background(), req)
 	if err != nil {
 		t.Fatalf("Failed to send OTP: %v", err)
 	}
 	
 	// Verify OTP is stored
-	otp, expiry, err := otpRepo.GetOTP("+1234567890")
+	otp, err := repo.OTP().FindByPhone(context.Background(), "+1234567890")
 	if err != nil {
-		t.Errorf("Expected OTP to be stored, got error: %v", err)
+		t.Fatalf("Expected OTP to be stored, got error: %v", err)
 	}
 	
-	if otp != response.OTP {
+	if otp.Code != response.OTP {
 		t.Errorf("Expected stored OTP to match generated OTP")
 	}
 	
 	// Check expiry is set to 5 minutes from now
 	expectedExpiry := time.Now().Add(5 * time.Minute)
-	if time.Until(expectedExpiry) > 10*time.Second {
-		t.Errorf("Expected expiry to be approximately 5 minutes from now")
+	if expectedExpiry.Sub(otp.ExpiresAt).Abs() > 10*time.Second {
+		t.Errorf("Expected expiry to be approximately 5 minutes from now, got %v", otp.ExpiresAt)
 	}
 }
 
 func TestVerifyOTP(t *testing.T) {
-	otpRepo := NewInMemoryOTPRepository()
-	mockPlivo := &MockPlivoClient{}
-	service := NewSMSService(otpRepo, mockPlivo)
+	service, _, _ := newTestService()
 	
 	// Send OTP first
-	req := OTPRequest{PhoneNumber: "+1234567890"}
+	req := models.OTPRequest{PhoneNumber: "+1234567890"}
 	response, err := service.SendOTP(context.Background(), req)
 	if err != nil {
 		t.Fatalf("Failed to send OTP: %v", err)
 	}
 	
 	// Verify with correct OTP
-	verifyReq := VerifyOTPRequest{
+	verifyReq := models.VerifyOTPRequest{
 		PhoneNumber: "+1234567890",
 		OTP:         response.OTP,
 	}
@@ -108,19 +190,17 @@ func TestVerifyOTP(t *testing.T) {
 }
 
 func TestInvalidOTP(t *testing.T) {
-	otpRepo := NewInMemoryOTPRepository()
-	mockPlivo := &MockPlivoClient{}
-	service := NewSMSService(otpRepo, mockPlivo)
+	service, _, _ := newTestService()
 	
 	// Send OTP first
-	req := OTPRequest{PhoneNumber: "+1234567890"}
+	req := models.OTPRequest{PhoneNumber: "+1234567890"}
 	_, err := service.SendOTP(context.Background(), req)
 	if err != nil {
 		t.Fatalf("Failed to send OTP: %v", err)
 	}
 	
 	// Verify with incorrect OTP
-	verifyReq := VerifyOTPRequest{
+	verifyReq := models.VerifyOTPRequest{
 		PhoneNumber: "+1234567890",
 		OTP:         "000000",
 	}
@@ -137,4 +217,3877 @@ func TestInvalidOTP(t *testing.T) {
 	if verifyResp.Valid {
 		t.Errorf("Expected OTP to be invalid, got %v", verifyResp.Valid)
 	}
-} 
\ No newline at end of file
+}
+
+func TestVerifyRateLimitPerPurpose(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	cfg := testConfig()
+	cfg.VerifyRateLimit = RateLimit{Limit: 5, Window: time.Minute}
+	cfg.PurposeVerifyRateLimits = map[string]RateLimit{
+		models.PurposePayment: {Limit: 1, Window: time.Minute},
+	}
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890", Purpose: models.PurposePayment}); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+
+	paymentReq := models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: "000000", Purpose: models.PurposePayment}
+	if _, err := service.VerifyOTP(ctx, paymentReq); err != nil {
+		t.Fatalf("Expected first payment verification to be allowed, got %v", err)
+	}
+
+	// The stricter payment limit is exhausted
+	_, err := service.VerifyOTP(ctx, paymentReq)
+	appErr, ok := err.(*common.AppError)
+	if !ok || appErr.Code != common.ErrCodeRateLimit {
+		t.Fatalf("Expected rate limit error, got %v", err)
+	}
+	// The rejected attempt counts too and only fades out over the next window
+	if appErr.RetryAfter <= 0 || appErr.RetryAfter > 120 {
+		t.Errorf("Expected retry after within two payment windows, got %d", appErr.RetryAfter)
+	}
+
+	// Naming another purpose doesn't get a fresh budget for the payment OTP
+	loginReq := models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: "000000", Purpose: models.PurposeLogin}
+	_, err = service.VerifyOTP(ctx, loginReq)
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeRateLimit {
+		t.Errorf("Expected rate limit error for another purpose, got %v", err)
+	}
+}
+
+func TestVerifyOTPPurposeMismatch(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+
+	sent, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890", Purpose: models.PurposePayment})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+
+	// The right code for another purpose counts as a wrong code
+	response, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: sent.OTP, Purpose: models.PurposeLogin})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if response.Valid {
+		t.Error("Expected a payment OTP not to verify a login")
+	}
+	if stored, _ := repo.OTP().FindByPhone(ctx, "+1234567890"); stored == nil || stored.Attempts != 1 {
+		t.Errorf("Expected the mismatch to count as an attempt, got %+v", stored)
+	}
+
+	response, err = service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: sent.OTP, Purpose: models.PurposePayment})
+	if err != nil || !response.Valid {
+		t.Errorf("Expected the payment OTP to verify a payment, got %+v, %v", response, err)
+	}
+}
+
+func TestEscalateOTP(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	mockClient := transport.NewMockSMSClient()
+	service := NewSMSService(repo, mockClient, WithConfig(testConfig()), WithVoiceOTPClient(mockClient))
+	ctx := context.Background()
+
+	if _, err := service.EscalateOTP(ctx, "+1234567890"); err == nil {
+		t.Fatal("Expected escalation without an active OTP to fail")
+	}
+
+	sent, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+
+	// The SMS gets a chance to arrive first
+	_, err = service.EscalateOTP(ctx, "+1234567890")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeRateLimit || appErr.RetryAfter <= 0 {
+		t.Fatalf("Expected a rate limit error with retry after, got %v", err)
+	}
+
+	// Nor can it be escalated within the resend cooldown
+	service.now = func() time.Time { return time.Now().Add(service.config.OTPVoiceEscalationDelay + time.Second) }
+	_, err = service.EscalateOTP(ctx, "+1234567890")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeRateLimit || appErr.RetryAfter <= 0 {
+		t.Fatalf("Expected a rate limit error within the cooldown, got %v", err)
+	}
+
+	service.now = func() time.Time { return time.Now().Add(service.config.OTPResendCooldown + time.Second) }
+	response, err := service.EscalateOTP(ctx, "+1234567890")
+	if err != nil {
+		t.Fatalf("Expected escalation to succeed, got %v", err)
+	}
+	if response.Channel != models.ChannelVoice || response.OTP != sent.OTP {
+		t.Errorf("Expected the same code by voice, got %+v", response)
+	}
+	calls := mockClient.Calls()
+	if last := calls[len(calls)-1]; last.Method != "SendVoiceOTP" || last.Body != sent.OTP {
+		t.Errorf("Expected a voice call reading the code, got %+v", last)
+	}
+
+	stored, _ := repo.OTP().FindByPhone(ctx, "+1234567890")
+	if len(stored.Channels) != 2 || stored.Channels[0] != models.ChannelSMS || stored.Channels[1] != models.ChannelVoice {
+		t.Errorf("Expected channels [sms voice], got %v", stored.Channels)
+	}
+
+	// Each OTP is read out once
+	if _, err := service.EscalateOTP(ctx, "+1234567890"); err == nil {
+		t.Error("Expected a second escalation to be rejected")
+	}
+	if count, _ := repo.OTPSends().Count(ctx, "+1234567890", otpSendDay(time.Now())); count != 2 {
+		t.Errorf("Expected the voice call to count towards the daily cap, got %d sends", count)
+	}
+
+	// Opted-out numbers and numbers over the daily cap get no call
+	later := func() time.Time { return time.Now().Add(service.config.OTPResendCooldown + time.Second) }
+	service.now = time.Now
+	service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1987654321"})
+	service.OptOut(ctx, "+1987654321", models.SuppressionReasonRequest)
+	service.now = later
+	_, err = service.EscalateOTP(ctx, "+1987654321")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeOptedOut {
+		t.Errorf("Expected an opted-out error, got %v", err)
+	}
+
+	service.now = time.Now
+	service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1555000222"})
+	for i := 1; i < service.config.DailyOTPLimit; i++ {
+		repo.OTPSends().Increment(ctx, "+1555000222", otpSendDay(time.Now()))
+	}
+	service.now = later
+	_, err = service.EscalateOTP(ctx, "+1555000222")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeRateLimit {
+		t.Errorf("Expected the daily cap to block the call, got %v", err)
+	}
+
+	// Without a voice client escalation is unavailable
+	plain, _, _ := newTestService()
+	_, err = plain.EscalateOTP(ctx, "+1234567890")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeValidation {
+		t.Errorf("Expected a validation error without voice delivery, got %v", err)
+	}
+}
+
+func TestSendOTPProviderFailure(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	mockClient.Err = errors.New("provider down")
+
+	_, err := service.SendOTP(context.Background(), models.OTPRequest{PhoneNumber: "+1234567890"})
+	appErr, ok := err.(*common.AppError)
+	if !ok || appErr.Code != common.ErrCodeServiceUnavailable {
+		t.Fatalf("Expected service unavailable error, got %v", err)
+	}
+
+	// The OTP is cleaned up when the provider fails
+	if _, err := repo.OTP().FindByPhone(context.Background(), "+1234567890"); err == nil {
+		t.Errorf("Expected OTP to be removed after provider failure")
+	}
+}
+
+func TestSendOTPDailyLimit(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	service.config.DailyOTPLimit = 2
+	ctx := context.Background()
+	phone := "+1234567890"
+	today := otpSendDay(time.Now())
+	after := func(n int) func() time.Time {
+		return func() time.Time { return time.Now().Add(time.Duration(n) * (service.config.OTPResendCooldown + time.Second)) }
+	}
+
+	// A send the provider rejects doesn't use up the cap
+	mockClient.Err = errors.New("provider down")
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone}); err == nil {
+		t.Fatal("Expected the provider failure to be returned")
+	}
+	mockClient.Err = nil
+	if count, _ := repo.OTPSends().Count(ctx, phone, today); count != 0 {
+		t.Errorf("Expected a failed send not to count, got %d", count)
+	}
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone}); err != nil {
+		t.Fatalf("Expected the first send to succeed, got %v", err)
+	}
+	service.now = after(1)
+	second, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil {
+		t.Fatalf("Expected the second send to succeed, got %v", err)
+	}
+
+	// Over the cap the pending code is kept rather than replaced
+	service.now = after(2)
+	_, err = service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeRateLimit {
+		t.Fatalf("Expected the daily cap to be enforced, got %v", err)
+	}
+	if stored, err := repo.OTP().FindByPhone(ctx, phone); err != nil || stored.Code != second.OTP {
+		t.Errorf("Expected the pending OTP to survive a capped request, got %+v, %v", stored, err)
+	}
+	if count, _ := repo.OTPSends().Count(ctx, phone, today); count != 2 {
+		t.Errorf("Expected the rejected send not to count, got %d", count)
+	}
+}
+
+func TestSendSMS(t *testing.T) {
+	service, repo, mockClient := newTestService()
+
+	response, err := service.SendSMS(context.Background(), models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	calls := mockClient.Calls()
+	if len(calls) != 1 || calls[0].Method != "SendSMS" || calls[0].Body != "Hello" {
+		t.Errorf("Expected message to be sent once, got %+v", calls)
+	}
+
+	records, _ := repo.SMS().FindByPhone(context.Background(), "+1234567890", 10)
+	if len(records) != 1 || records[0].Status != models.StatusSent {
+		t.Fatalf("Expected one sent SMS record, got %+v", records)
+	}
+	if !response.Success || response.ID != records[0].ID.Hex() || response.Status != models.StatusSent || response.Timestamp.IsZero() {
+		t.Errorf("Expected response to describe the stored SMS, got %+v", response)
+	}
+}
+
+func TestSendMMS(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	ctx := context.Background()
+	media := []string{"https://cdn.example.com/promo.jpg"}
+
+	mockClient.Err = errors.New("carrier rejected")
+	response, _ := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Sale!", MediaURLs: media})
+	stored, _ := repo.SMS().FindByID(ctx, response.ID)
+	if fmt.Sprint(stored.MediaURLs) != fmt.Sprint(media) {
+		t.Errorf("Expected the media URLs to be stored, got %+v", stored.MediaURLs)
+	}
+
+	// A retry sends the stored media again
+	mockClient.Err = nil
+	if _, err := service.RetrySMS(ctx, response.ID); err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	calls := mockClient.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected a send and a resend, got %+v", calls)
+	}
+	for _, call := range calls {
+		if fmt.Sprint(call.MediaURLs) != fmt.Sprint(media) {
+			t.Errorf("Expected the message to be sent with its media, got %+v", call)
+		}
+	}
+}
+
+func TestResponsesCarryNormalizedPhone(t *testing.T) {
+	service, _, _ := newTestService()
+	ctx := context.Background()
+
+	otpResponse, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: " +1 (234) 567-890"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if otpResponse.PhoneNumber != "+1234567890" || otpResponse.NormalizedPhone != "+1234567890" {
+		t.Errorf("Expected the normalized number in the OTP response, got %q", otpResponse.PhoneNumber)
+	}
+	resent, _ := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "001234567890"})
+	if resent.Success || resent.PhoneNumber != "+1234567890" || resent.NormalizedPhone != "+1234567890" {
+		t.Errorf("Expected a cooldown response with the normalized number, got %+v", resent)
+	}
+
+	smsResponse, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if smsResponse.PhoneNumber != "+1234567890" || smsResponse.NormalizedPhone != "+1234567890" {
+		t.Errorf("Expected the recipient in the SMS response, got %q", smsResponse.PhoneNumber)
+	}
+}
+
+func TestSendSMSDedup(t *testing.T) {
+	service, _, mockClient := newTestService()
+	ctx := context.Background()
+	req := models.SMSRequest{PhoneNumber: "+1234567890", Message: "Your order shipped", Dedup: true}
+
+	first, err := service.SendSMS(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := service.SendSMS(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !second.Duplicate || second.ID != first.ID || len(mockClient.Calls()) != 1 {
+		t.Fatalf("Expected the first message back without sending again, got %+v after %d sends", second, len(mockClient.Calls()))
+	}
+
+	// Other text, other media, another user or sender ID, or a send without
+	// the flag go out
+	for _, other := range []models.SMSRequest{
+		{PhoneNumber: req.PhoneNumber, Message: "Your order arrived", Dedup: true},
+		{PhoneNumber: req.PhoneNumber, Message: req.Message, MediaURLs: []string{"https://cdn.example.com/box.jpg"}, Dedup: true},
+		{PhoneNumber: req.PhoneNumber, Message: req.Message, UserID: "user_2", Dedup: true},
+		{PhoneNumber: req.PhoneNumber, Message: req.Message, SenderID: "ACME", Dedup: true},
+		{PhoneNumber: req.PhoneNumber, Message: req.Message},
+	} {
+		if response, _ := service.SendSMS(ctx, other); response == nil || response.Duplicate {
+			t.Errorf("Expected %+v to be sent, got %+v", other, response)
+		}
+	}
+	if len(mockClient.Calls()) != 6 {
+		t.Errorf("Expected 6 sends, got %d", len(mockClient.Calls()))
+	}
+
+	// Outside the window the message is sent again
+	service.now = func() time.Time { return time.Now().Add(service.config.SMSDedupWindow + time.Second) }
+	if response, _ := service.SendSMS(ctx, req); response.Duplicate {
+		t.Error("Expected a send after the dedup window to go out")
+	}
+
+	// A failed send can be repeated
+	service, _, mockClient = newTestService()
+	mockClient.Err = errors.New("carrier rejected")
+	service.SendSMS(ctx, req)
+	mockClient.Err = nil
+	if response, err := service.SendSMS(ctx, req); err != nil || response.Duplicate {
+		t.Errorf("Expected a failed message to be sent again, got %+v, %v", response, err)
+	}
+}
+
+func TestSendSMSProviderFailure(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	mockClient.Err = errors.New("carrier rejected")
+
+	response, err := service.SendSMS(context.Background(), models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Expected 502 AppError, got %v", err)
+	}
+
+	records, _ := repo.SMS().FindByPhone(context.Background(), "+1234567890", 10)
+	if response == nil || response.Success || response.ID != records[0].ID.Hex() || response.Status != models.StatusFailed {
+		t.Errorf("Expected failed response for the stored SMS, got %+v", response)
+	}
+}
+
+func TestVerifyOTPMaxAttemptsLockout(t *testing.T) {
+	cfg := testConfig()
+	cfg.OTPLockout = 0
+	repo := repository.NewInMemoryRepository()
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+
+	wrongCode := "000000"
+	if response.OTP == wrongCode {
+		wrongCode = "111111"
+	}
+
+	// Exhaust the attempt budget with wrong codes
+	for i := 0; i < 3; i++ {
+		verifyResp, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: wrongCode})
+		if err != nil {
+			t.Fatalf("Expected no error on attempt %d, got %v", i+1, err)
+		}
+		if verifyResp.Valid {
+			t.Fatalf("Expected wrong code to be rejected on attempt %d", i+1)
+		}
+		if remaining := verifyResp.RemainingAttempts; remaining == nil || *remaining != 2-i {
+			t.Errorf("Expected %d remaining attempts after attempt %d, got %v", 2-i, i+1, remaining)
+		}
+		if (verifyResp.Code == common.ErrCodeMaxAttempts) != (i == 2) {
+			t.Errorf("Expected the max attempts code only on the last attempt, got %d on attempt %d", verifyResp.Code, i+1)
+		}
+	}
+
+	stored, err := repo.OTP().FindByPhone(ctx, "+1234567890")
+	if err != nil {
+		t.Fatalf("Expected OTP to still be stored, got %v", err)
+	}
+	if stored.Attempts != 3 {
+		t.Errorf("Expected 3 recorded attempts, got %d", stored.Attempts)
+	}
+
+	// Even the correct code is rejected once locked out
+	verifyResp, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: response.OTP})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if verifyResp.Valid || verifyResp.Message != "Maximum verification attempts reached. Please request a new OTP." {
+		t.Errorf("Expected max attempts lockout, got %+v", verifyResp)
+	}
+	if verifyResp.RemainingAttempts == nil || *verifyResp.RemainingAttempts != 0 || verifyResp.Code != common.ErrCodeMaxAttempts {
+		t.Errorf("Expected no remaining attempts and code %d, got %+v", common.ErrCodeMaxAttempts, verifyResp)
+	}
+}
+
+func TestOTPExpiryFollowsServiceClock(t *testing.T) {
+	service, _, _ := newTestService()
+	now := time.Now()
+	service.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	if !response.ExpiresAt.Equal(now.Add(5 * time.Minute)) {
+		t.Errorf("Expected the OTP to expire 5 minutes after the service clock, got %v", response.ExpiresAt)
+	}
+
+	now = now.Add(5*time.Minute + time.Second)
+	if status, _ := service.GetOTPStatus(ctx, "+1234567890"); status.HasActiveOTP {
+		t.Errorf("Expected no active OTP once the clock passed its expiry, got %+v", status)
+	}
+	verified, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: response.OTP})
+	if err != nil || verified.Valid {
+		t.Errorf("Expected the expired OTP to be rejected, got %+v, %v", verified, err)
+	}
+}
+
+func TestOTPLifetimeAndAttemptsFromConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.OTPTTL = 2 * time.Minute
+	cfg.OTPMaxAttempts = 5
+	repo := repository.NewInMemoryRepository()
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	now := time.Now()
+	service.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"}); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	stored, _ := repo.OTP().FindByPhone(ctx, "+1234567890")
+	if !stored.ExpiresAt.Equal(now.Add(cfg.OTPTTL)) || stored.MaxAttempts != cfg.OTPMaxAttempts {
+		t.Errorf("Expected the configured lifetime and attempts, got %+v", stored)
+	}
+}
+
+func TestOTPMatches(t *testing.T) {
+	if !otpMatches("123456", "123456") {
+		t.Errorf("Expected identical codes to match")
+	}
+	if otpMatches("123456", "123457") {
+		t.Errorf("Expected different codes not to match")
+	}
+	if otpMatches("123456", "12345") {
+		t.Errorf("Expected codes of different length not to match")
+	}
+}
+
+func TestLookupUser(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	users := NewUserService(repo)
+	ctx := context.Background()
+
+	user := &models.User{Phone: "+15550001111", Email: "jane@example.com", Name: "Jane", MonthlySMSQuota: 100}
+	if err := repo.User().Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	for _, lookup := range [][2]string{{"+15550001111", ""}, {"", "jane@example.com"}} {
+		profile, err := users.LookupUser(ctx, lookup[0], lookup[1])
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if profile.ID != user.ID.Hex() || profile.Phone != user.Phone || profile.Email != user.Email || profile.Name != "Jane" {
+			t.Errorf("Unexpected profile %+v", profile)
+		}
+	}
+
+	_, err := users.LookupUser(ctx, "+15550002222", "")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected not found for an unknown phone, got %v", err)
+	}
+}
+
+func TestGetProfile(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	users := NewUserService(repo)
+	ctx := context.Background()
+
+	jane := &models.User{Phone: "+15550001111", Email: "jane@example.com", Name: "Jane"}
+	repo.User().Create(ctx, jane)
+
+	profile, err := users.GetProfile(ctx, jane.ID.Hex())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if profile.ID != jane.ID.Hex() || profile.Name != "Jane" || profile.Email != jane.Email || profile.Phone != jane.Phone {
+		t.Errorf("Unexpected profile %+v", profile)
+	}
+
+	_, err = users.GetProfile(ctx, primitive.NewObjectID().Hex())
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected not found for a deleted user, got %v", err)
+	}
+}
+
+// staleEmailUserRepository never finds users by email, like a lookup that
+// ran before another user took the address
+type staleEmailUserRepository struct {
+	repository.UserRepository
+}
+
+func (staleEmailUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return nil, repository.ErrNotFound
+}
+
+type staleEmailRepository struct {
+	*repository.InMemoryRepository
+}
+
+func (r staleEmailRepository) User() repository.UserRepository {
+	return staleEmailUserRepository{r.InMemoryRepository.User()}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	users := NewUserService(repo)
+	ctx := context.Background()
+
+	jane := &models.User{Phone: "+15550001111", Email: "jane@example.com", Name: "Jane"}
+	john := &models.User{Phone: "+15550002222", Email: "john@example.com", Name: "John"}
+	repo.User().Create(ctx, jane)
+	repo.User().Create(ctx, john)
+
+	name, email := "  Jane Doe ", "jane.doe@example.com"
+	profile, err := users.UpdateProfile(ctx, jane.ID.Hex(), models.UserProfileUpdate{Name: &name, Email: &email})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if profile.Name != "Jane Doe" || profile.Email != email || profile.Phone != jane.Phone {
+		t.Errorf("Unexpected profile %+v", profile)
+	}
+	if stored, _ := repo.User().FindByID(ctx, jane.ID.Hex()); stored.Name != "Jane Doe" || stored.Email != email {
+		t.Errorf("Expected the update to be stored, got %+v", stored)
+	}
+
+	// Keeping one's own email is fine, taking someone else's is not
+	if _, err := users.UpdateProfile(ctx, jane.ID.Hex(), models.UserProfileUpdate{Email: &email}); err != nil {
+		t.Errorf("Expected keeping the same email to succeed, got %v", err)
+	}
+	taken := john.Email
+	_, err = users.UpdateProfile(ctx, jane.ID.Hex(), models.UserProfileUpdate{Email: &taken})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a conflict for an email in use, got %v", err)
+	}
+	shouted := "  JOHN@Example.com"
+	_, err = users.UpdateProfile(ctx, jane.ID.Hex(), models.UserProfileUpdate{Email: &shouted})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a conflict for an email in use in another case, got %v", err)
+	}
+
+	// An email taken between the lookup and the update is caught by the store
+	racing := NewUserService(staleEmailRepository{repo})
+	_, err = racing.UpdateProfile(ctx, jane.ID.Hex(), models.UserProfileUpdate{Email: &taken})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a conflict from the store, got %v", err)
+	}
+
+	phone, blank := "+15550003333", " "
+	for _, update := range []models.UserProfileUpdate{{Phone: &phone}, {Name: &blank}} {
+		_, err = users.UpdateProfile(ctx, jane.ID.Hex(), update)
+		if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected a validation error for %+v, got %v", update, err)
+		}
+	}
+	if stored, _ := repo.User().FindByID(ctx, jane.ID.Hex()); stored.Phone != jane.Phone || stored.Name != "Jane Doe" {
+		t.Errorf("Expected rejected updates not to be stored, got %+v", stored)
+	}
+
+	if _, err := users.UpdateProfile(ctx, primitive.NewObjectID().Hex(), models.UserProfileUpdate{Name: &name}); err == nil {
+		t.Error("Expected an error for an unknown user")
+	}
+}
+
+func TestPhoneChange(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	users := NewUserService(repo, WithPhoneVerifier(service))
+	ctx := context.Background()
+
+	jane := &models.User{Phone: "+15550001111", Name: "Jane"}
+	john := &models.User{Phone: "+15550002222", Name: "John"}
+	repo.User().Create(ctx, jane)
+	repo.User().Create(ctx, john)
+
+	// Numbers that are taken or already the user's get no OTP
+	for _, phone := range []string{john.Phone, jane.Phone} {
+		if _, err := users.InitiatePhoneChange(ctx, jane.ID.Hex(), phone); err == nil {
+			t.Errorf("Expected changing to %s to be rejected", phone)
+		}
+	}
+	if calls := mockClient.Calls(); len(calls) != 0 {
+		t.Fatalf("Expected no OTP sent for rejected changes, got %d sends", len(calls))
+	}
+
+	// A login code for the new number doesn't confirm a change
+	login, _ := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+15550003333", Purpose: models.PurposeLogin})
+	if _, err := users.ConfirmPhoneChange(ctx, jane.ID.Hex(), "+15550003333", login.OTP); err == nil {
+		t.Error("Expected a login OTP not to confirm a phone change")
+	}
+	service.RevokeOTP(ctx, "+15550003333")
+
+	response, err := users.InitiatePhoneChange(ctx, jane.ID.Hex(), "+15550003333")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if calls := mockClient.Calls(); calls[len(calls)-1].To != "+15550003333" {
+		t.Errorf("Expected the OTP to go to the new number, got %+v", calls[len(calls)-1])
+	}
+
+	// A wrong code leaves the phone unchanged
+	if _, err := users.ConfirmPhoneChange(ctx, jane.ID.Hex(), "+15550003333", "000000"); err == nil {
+		t.Error("Expected a wrong code to be rejected")
+	}
+	if stored, _ := repo.User().FindByID(ctx, jane.ID.Hex()); stored.Phone != "+15550001111" {
+		t.Errorf("Expected the phone unchanged after a wrong code, got %s", stored.Phone)
+	}
+
+	profile, err := users.ConfirmPhoneChange(ctx, jane.ID.Hex(), "+15550003333", response.OTP)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if profile.Phone != "+15550003333" {
+		t.Errorf("Expected the new phone in the profile, got %s", profile.Phone)
+	}
+	if stored, _ := repo.User().FindByPhone(ctx, "+15550003333"); stored == nil || stored.ID != jane.ID {
+		t.Errorf("Expected the new phone to be stored, got %+v", stored)
+	} else if len(stored.PreviousPhones) != 1 || stored.PreviousPhones[0] != "+15550001111" {
+		t.Errorf("Expected the old phone to be kept for account deletion, got %v", stored.PreviousPhones)
+	}
+
+	// The old number is told about the change and existing sessions end
+	if calls := mockClient.Calls(); calls[len(calls)-1].To != "+15550001111" || !strings.Contains(calls[len(calls)-1].Body, "3333") {
+		t.Errorf("Expected a notice to the old number, got %+v", calls[len(calls)-1])
+	}
+	if !users.SessionRevoked(ctx, jane.ID.Hex(), time.Now().Add(-time.Minute).Unix()) {
+		t.Error("Expected tokens issued before the change to be revoked")
+	}
+	if users.SessionRevoked(ctx, jane.ID.Hex(), time.Now().Add(time.Minute).Unix()) {
+		t.Error("Expected tokens issued after the change to stay valid")
+	}
+	if users.SessionRevoked(ctx, john.ID.Hex(), time.Now().Add(-time.Minute).Unix()) {
+		t.Error("Expected the sessions of other users to stay valid")
+	}
+
+	// John can't take over the number afterwards
+	_, err = users.InitiatePhoneChange(ctx, john.ID.Hex(), "+15550003333")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a conflict for a number in use, got %v", err)
+	}
+
+	// Without a verifier phone numbers can't be changed
+	if _, err := NewUserService(repo).InitiatePhoneChange(ctx, john.ID.Hex(), "+15550004444"); err == nil {
+		t.Error("Expected an error without a phone verifier")
+	}
+}
+
+// stalePhoneUserRepository misses users by phone, like a lookup racing a
+// concurrent phone change
+type stalePhoneUserRepository struct {
+	repository.UserRepository
+}
+
+func (stalePhoneUserRepository) FindByPhone(ctx context.Context, phone string) (*models.User, error) {
+	return nil, repository.ErrNotFound
+}
+
+type stalePhoneRepository struct {
+	*repository.InMemoryRepository
+}
+
+func (r stalePhoneRepository) User() repository.UserRepository {
+	return stalePhoneUserRepository{r.InMemoryRepository.User()}
+}
+
+func TestConfirmPhoneChangeConflict(t *testing.T) {
+	service, repo, _ := newTestService()
+	users := NewUserService(stalePhoneRepository{repo}, WithPhoneVerifier(service))
+	ctx := context.Background()
+
+	jane := &models.User{Phone: "+15550001111", Name: "Jane"}
+	john := &models.User{Phone: "+15550002222", Name: "John"}
+	repo.User().Create(ctx, jane)
+	repo.User().Create(ctx, john)
+
+	response, err := users.InitiatePhoneChange(ctx, jane.ID.Hex(), john.Phone)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = users.ConfirmPhoneChange(ctx, jane.ID.Hex(), john.Phone, response.OTP)
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a conflict when the unique index rejects the number, got %v", err)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	service, repo, _ := newTestService()
+	users := NewUserService(repo, WithPhoneVerifier(service))
+	ctx := context.Background()
+
+	jane := &models.User{Phone: "+15550001111", Name: "Jane", PreviousPhones: []string{"+15550003333"}}
+	repo.User().Create(ctx, jane)
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: jane.Phone, Message: "Hello"})
+	service.HandleInboundMessage(ctx, models.InboundMessage{From: jane.Phone, Text: "Hi"})
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+15550003333", Message: "To her old number"})
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+15550004444", Message: "Sent by her", UserID: jane.ID.Hex()})
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+15550002222", Message: "Hello"})
+	callback, _ := NewCallbackService(repo).RequestCallback(ctx, models.CallbackRequest{PhoneNumber: "+15550003333"})
+	repo.FlaggedPhones().Create(ctx, &models.FlaggedPhone{Phone: jane.Phone, BlockedUntil: time.Now().Add(-time.Minute)})
+	repo.Audit().Create(ctx, &models.Audit{Type: "callback.pending", TargetID: callback.RequestID})
+	repo.Audit().Create(ctx, &models.Audit{Type: "sms.pending", Actor: common.ActorUser, ActorID: jane.ID.Hex()})
+	repo.Audit().Create(ctx, &models.Audit{Type: "sms.pending", Actor: common.ActorUser, ActorID: "someone_else"})
+
+	// A login code doesn't confirm the deletion
+	login, _ := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: jane.Phone, Purpose: models.PurposeLogin})
+	if _, err := users.DeleteAccount(ctx, jane.ID.Hex(), login.OTP); err == nil {
+		t.Fatal("Expected a login OTP not to confirm the deletion")
+	}
+	service.RevokeOTP(ctx, jane.Phone)
+
+	otp, _ := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: jane.Phone, Purpose: models.PurposeDeleteAccount})
+	if _, err := users.DeleteAccount(ctx, jane.ID.Hex(), "000000"); err == nil {
+		t.Fatal("Expected a wrong code to be rejected")
+	}
+	if _, err := repo.User().FindByID(ctx, jane.ID.Hex()); err != nil {
+		t.Fatalf("Expected the user to survive a wrong code, got %v", err)
+	}
+
+	summary, err := users.DeleteAccount(ctx, jane.ID.Hex(), otp.OTP)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if summary.UserID != jane.ID.Hex() || summary.SMSDeleted != 4 || summary.CallbacksDeleted != 1 || summary.AuditRecordsDeleted < 2 {
+		t.Errorf("Expected 4 SMS messages, the callback and its audit records deleted, got %+v", summary)
+	}
+
+	if _, err := repo.User().FindByID(ctx, jane.ID.Hex()); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected the user to be deleted, got %v", err)
+	}
+	if _, err := repo.OTP().FindByPhone(ctx, jane.Phone); err == nil {
+		t.Error("Expected the OTPs to be deleted")
+	}
+	if count, _ := repo.SMS().Count(ctx, models.SMSLogFilter{}); count != 1 {
+		t.Errorf("Expected only other users' messages to remain, got %d", count)
+	}
+	if count, _ := repo.OTPSends().Count(ctx, jane.Phone, otpSendDay(time.Now())); count != 0 {
+		t.Errorf("Expected the OTP send counters to be deleted, got %d", count)
+	}
+	if count, _ := repo.FlaggedPhones().Count(ctx); count != 0 {
+		t.Errorf("Expected the brute-force flags to be deleted, got %d", count)
+	}
+	audits, _ := repo.Audit().List(ctx, models.AuditFilter{}, 0, 0)
+	others := 0
+	for _, audit := range audits {
+		switch {
+		case audit.TargetID == callback.RequestID || audit.ActorID == jane.ID.Hex():
+			t.Errorf("Expected the audit records of the account to be deleted, got %+v", audit)
+		case audit.ActorID == "someone_else":
+			others++
+		}
+	}
+	if others != 1 {
+		t.Errorf("Expected other users' audit records to remain, got %d", others)
+	}
+}
+
+func TestSendSMSMonthlyQuota(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+
+	user := &models.User{Phone: "+1987654321", MonthlySMSQuota: 2}
+	if err := repo.User().Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	req := models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello", UserID: user.ID.Hex()}
+
+	for i := 0; i < 2; i++ {
+		if _, err := service.SendSMS(ctx, req); err != nil {
+			t.Fatalf("Expected send %d within quota to succeed, got %v", i+1, err)
+		}
+	}
+
+	_, err := service.SendSMS(ctx, req)
+	appErr, ok := err.(*common.AppError)
+	if !ok || appErr.Code != common.ErrCodeRateLimit || appErr.StatusCode != 429 {
+		t.Fatalf("Expected 429 rate limit error over quota, got %v", err)
+	}
+
+	quota, err := service.GetSMSQuota(ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if quota.Limit != 2 || quota.Used != 2 || quota.Remaining != 0 {
+		t.Errorf("Unexpected quota %+v", quota)
+	}
+
+	// Anonymous sends don't count against the user's quota
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"}); err != nil {
+		t.Errorf("Expected anonymous send to succeed, got %v", err)
+	}
+}
+
+func TestSendSMSAnonymousQuota(t *testing.T) {
+	cfg := testConfig()
+	cfg.AnonymousMonthlySMSQuota = 1
+	service := NewSMSService(repository.NewInMemoryRepository(), transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"}); err != nil {
+		t.Fatalf("Expected the first anonymous send to succeed, got %v", err)
+	}
+	_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567891", Message: "Hello"})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected anonymous senders to share one quota, got %v", err)
+	}
+
+	quota, err := service.GetSMSQuota(ctx, "")
+	if err != nil || quota.Limit != 1 || quota.Used != 1 || quota.Remaining != 0 {
+		t.Errorf("Unexpected anonymous quota %+v, %v", quota, err)
+	}
+}
+
+func TestSendSMSQuotaConcurrentSends(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	ctx := context.Background()
+
+	user := &models.User{Phone: "+1987654321", MonthlySMSQuota: 3}
+	repo.User().Create(ctx, user)
+
+	// Concurrent sends reserve from the quota one at a time, so no more than
+	// the quota get through
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			service.SendSMS(ctx, models.SMSRequest{PhoneNumber: fmt.Sprintf("+12345678%02d", i), Message: "Hello", UserID: user.ID.Hex()})
+		}(i)
+	}
+	wg.Wait()
+
+	if calls := mockClient.Calls(); len(calls) != 3 {
+		t.Errorf("Expected exactly 3 sends within the quota, got %d", len(calls))
+	}
+}
+
+func TestCancelCallback(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	voiceClient := transport.NewMockSMSClient()
+	service := NewCallbackService(repo, WithVoiceClient(voiceClient))
+	ctx := context.Background()
+
+	callback := &models.Callback{PhoneNumber: "+1234567890", Status: models.StatusInProgress, CallUUID: "call-123"}
+	if err := repo.Callback().Create(ctx, callback); err != nil {
+		t.Fatalf("Failed to create callback: %v", err)
+	}
+
+	cancelled, err := service.CancelCallback(ctx, callback.ID.Hex())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cancelled.Status != models.StatusCancelled {
+		t.Errorf("Expected status %s, got %s", models.StatusCancelled, cancelled.Status)
+	}
+
+	stored, _ := repo.Callback().FindByID(ctx, callback.ID.Hex())
+	if stored.Status != models.StatusCancelled {
+		t.Errorf("Expected stored status %s, got %s", models.StatusCancelled, stored.Status)
+	}
+
+	calls := voiceClient.Calls()
+	if len(calls) != 1 || calls[0].Method != "HangupCall" || calls[0].To != "call-123" {
+		t.Errorf("Expected the placed call to be hung up, got %+v", calls)
+	}
+}
+
+func TestAnswerCallback(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	service := NewCallbackService(repo)
+	ctx := context.Background()
+
+	callback := &models.Callback{PhoneNumber: "+1234567890", Message: "Your order shipped", Status: models.StatusRequested}
+	if err := repo.Callback().Create(ctx, callback); err != nil {
+		t.Fatalf("Failed to create callback: %v", err)
+	}
+
+	answered, err := service.AnswerCallback(ctx, callback.ID.Hex(), "call-123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	stored, _ := repo.Callback().FindByID(ctx, callback.ID.Hex())
+	if answered.Status != models.StatusInProgress || stored.Status != models.StatusInProgress || stored.CallUUID != "call-123" {
+		t.Errorf("Expected callback in progress in call-123, got %+v", stored)
+	}
+
+	// The provider may retry the answer webhook of the same call
+	if _, err := service.AnswerCallback(ctx, callback.ID.Hex(), "call-123"); err != nil {
+		t.Errorf("Expected a repeated answer to succeed, got %v", err)
+	}
+
+	// Another call for a request already in progress is a conflict
+	_, err = service.AnswerCallback(ctx, callback.ID.Hex(), "call-456")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeConflict {
+		t.Errorf("Expected conflict for a second call, got %v", err)
+	}
+
+	_, err = service.AnswerCallback(ctx, primitive.NewObjectID().Hex(), "call-789")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeNotFound {
+		t.Errorf("Expected not found for an unknown request, got %v", err)
+	}
+}
+
+func TestCancelCallbackErrors(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	service := NewCallbackService(repo)
+	ctx := context.Background()
+
+	completed := &models.Callback{PhoneNumber: "+1234567890", Status: models.StatusCompleted}
+	if err := repo.Callback().Create(ctx, completed); err != nil {
+		t.Fatalf("Failed to create callback: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		requestID string
+		code      int
+	}{
+		{"completed", completed.ID.Hex(), common.ErrCodeConflict},
+		{"invalid id", "not-an-object-id", common.ErrCodeValidation},
+		{"missing", primitive.NewObjectID().Hex(), common.ErrCodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.CancelCallback(ctx, tt.requestID)
+			appErr, ok := err.(*common.AppError)
+			if !ok || appErr.Code != tt.code {
+				t.Errorf("Expected error code %d, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestUpdateCallbackStatusTransitions(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	service := NewCallbackService(repo)
+	ctx := context.Background()
+
+	callback := &models.Callback{PhoneNumber: "+1234567890", Status: models.StatusRequested}
+	if err := repo.Callback().Create(ctx, callback); err != nil {
+		t.Fatalf("Failed to create callback: %v", err)
+	}
+	id := callback.ID.Hex()
+
+	for _, status := range []models.Status{models.StatusInProgress, models.StatusCompleted} {
+		if err := service.UpdateCallbackStatus(ctx, id, status); err != nil {
+			t.Fatalf("Expected moving to %s to succeed, got %v", status, err)
+		}
+	}
+
+	err := service.UpdateCallbackStatus(ctx, id, models.StatusRequested)
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeValidation {
+		t.Errorf("Expected a validation error moving completed back to requested, got %v", err)
+	}
+	if stored, _ := repo.Callback().FindByID(ctx, id); stored.Status != models.StatusCompleted {
+		t.Errorf("Expected the status to stay completed, got %s", stored.Status)
+	}
+}
+
+func TestSendOTPWhatsApp(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	smsClient := transport.NewMockSMSClient()
+	whatsAppClient := transport.NewMockSMSClient()
+	service := NewSMSService(repo, smsClient, WithConfig(testConfig()), WithWhatsAppClient(whatsAppClient))
+	ctx := context.Background()
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890", Channel: models.ChannelWhatsApp})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	calls := whatsAppClient.Calls()
+	if len(calls) != 1 || calls[0].Method != "SendOTPTemplate" || calls[0].Body != response.OTP {
+		t.Errorf("Expected the OTP to be sent over WhatsApp, got %+v", calls)
+	}
+	if len(smsClient.Calls()) != 0 {
+		t.Errorf("Expected no SMS to be sent, got %+v", smsClient.Calls())
+	}
+
+	storedOTP, err := repo.OTP().FindByPhone(ctx, "+1234567890")
+	if err != nil {
+		t.Fatalf("Expected OTP to be stored: %v", err)
+	}
+	if storedOTP.Channel != models.ChannelWhatsApp {
+		t.Errorf("Expected channel %s, got %s", models.ChannelWhatsApp, storedOTP.Channel)
+	}
+}
+
+func TestSendOTPWhatsAppUnavailable(t *testing.T) {
+	service, _, _ := newTestService()
+
+	_, err := service.SendOTP(context.Background(), models.OTPRequest{PhoneNumber: "+1234567890", Channel: models.ChannelWhatsApp})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeValidation {
+		t.Errorf("Expected validation error without a WhatsApp client, got %v", err)
+	}
+}
+
+func TestSendOTPNotWhatsAppNumber(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	whatsAppClient := transport.NewMockSMSClient()
+	whatsAppClient.Err = transport.ErrNotWhatsAppNumber
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithWhatsAppClient(whatsAppClient))
+	ctx := context.Background()
+
+	_, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890", Channel: models.ChannelWhatsApp})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeValidation {
+		t.Errorf("Expected validation error for a number without WhatsApp, got %v", err)
+	}
+
+	if _, err := repo.OTP().FindByPhone(ctx, "+1234567890"); err == nil {
+		t.Errorf("Expected OTP to be removed after failed delivery")
+	}
+}
+
+func TestGetStatsCountsByStatus(t *testing.T) {
+	service, _, mockClient := newTestService()
+	ctx := context.Background()
+
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1987654321", Message: "Hello"})
+	service.HandleInboundMessage(ctx, models.InboundMessage{From: "+1234567890", Text: "Hi"})
+	mockClient.Err = errors.New("carrier unreachable")
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+
+	stats, err := service.GetStats(ctx, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := map[models.Status]int{
+		models.StatusPending:   0,
+		models.StatusSent:      2,
+		models.StatusDelivered: 0,
+		models.StatusFailed:    1,
+		models.StatusReceived:  1,
+	}
+	if stats.Total != 4 || !reflect.DeepEqual(stats.StatusCounts, expected) {
+		t.Errorf("Expected %v out of 4 messages, got %v out of %d", expected, stats.StatusCounts, stats.Total)
+	}
+
+	// Counting a single number includes the messages it sent
+	stats, _ = service.GetStats(ctx, "+1234567890")
+	if stats.Total != 3 || stats.StatusCounts[models.StatusSent] != 1 || stats.StatusCounts[models.StatusReceived] != 1 {
+		t.Errorf("Expected 3 messages for the number, got %v", stats.StatusCounts)
+	}
+	stats, _ = service.GetStats(ctx, "+1555000000")
+	if stats.Total != 0 || len(stats.StatusCounts) != 4 {
+		t.Errorf("Expected zero counts for an unknown number, got %v", stats.StatusCounts)
+	}
+}
+
+func TestVerifyOTPRecordsTimeToVerify(t *testing.T) {
+	service, _, _ := newTestService()
+	ctx := context.Background()
+
+	for _, delay := range []time.Duration{20 * time.Second, 100 * time.Second} {
+		phone := fmt.Sprintf("+1555000%04d", int(delay.Seconds()))
+		response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+		if err != nil {
+			t.Fatalf("Failed to send OTP: %v", err)
+		}
+
+		sentAt := time.Now()
+		service.now = func() time.Time { return sentAt.Add(delay) }
+		if _, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: response.OTP}); err != nil {
+			t.Fatalf("Failed to verify OTP: %v", err)
+		}
+	}
+
+	stats, err := service.GetStats(ctx, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	verify := stats.TimeToVerify
+	if verify.Count != 2 {
+		t.Fatalf("Expected 2 observations, got %d", verify.Count)
+	}
+	if verify.AverageSeconds < 59 || verify.AverageSeconds > 61 {
+		t.Errorf("Expected average of about 60s, got %.2fs", verify.AverageSeconds)
+	}
+
+	expected := map[string]int{"10s": 0, "30s": 1, "1m0s": 1, "2m0s": 2, "+Inf": 2}
+	for _, bucket := range verify.Distribution {
+		if want, ok := expected[bucket.LE]; ok && bucket.Count != want {
+			t.Errorf("Expected %d observations <= %s, got %d", want, bucket.LE, bucket.Count)
+		}
+	}
+}
+
+// blockingSMSClient holds every send until release is closed
+type blockingSMSClient struct {
+	transport.MockSMSClient
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSMSClient) SendSMS(ctx context.Context, from, to, message string, validity time.Duration, mediaURLs []string) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.MockSMSClient.SendSMS(ctx, from, to, message, validity, mediaURLs)
+}
+
+func TestSendSMSInFlightGuard(t *testing.T) {
+	client := &blockingSMSClient{started: make(chan struct{}, 1), release: make(chan struct{})}
+	service := NewSMSService(repository.NewInMemoryRepository(), client)
+	ctx := context.Background()
+	req := models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"}
+
+	// Hold one send in flight to the number
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := service.SendSMS(ctx, req)
+		firstDone <- err
+	}()
+	<-client.started
+
+	// Simultaneous sends to the same number are rejected
+	const concurrent = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrent)
+	for i := 0; i < concurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.SendSMS(ctx, req)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeRateLimit {
+			t.Errorf("Expected concurrent send to be rate limited, got %v", err)
+		}
+	}
+
+	// Other numbers are unaffected
+	otherDone := make(chan error, 1)
+	go func() {
+		_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1987654321", Message: "Hello"})
+		otherDone <- err
+	}()
+	<-client.started
+
+	close(client.release)
+	if err := <-firstDone; err != nil {
+		t.Errorf("Expected in-flight send to succeed, got %v", err)
+	}
+	if err := <-otherDone; err != nil {
+		t.Errorf("Expected send to another number to succeed, got %v", err)
+	}
+
+	// The slot is free again once the send completes
+	if _, err := service.SendSMS(ctx, req); err != nil {
+		t.Errorf("Expected send after completion to succeed, got %v", err)
+	}
+	if calls := client.Calls(); len(calls) != 3 {
+		t.Errorf("Expected 3 messages to reach the provider, got %d", len(calls))
+	}
+}
+
+func TestVerifyFailureBackoffAcrossOTPs(t *testing.T) {
+	cfg := testConfig()
+	cfg.VerifyFailureBackoff = BackoffPolicy{Threshold: 2, Base: time.Minute, Max: time.Hour}
+	repo := repository.NewInMemoryRepository()
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+	phone := "+1234567890"
+
+	// Each OTP gets a single wrong guess, staying well under its MaxAttempts
+	for i := 0; i < 2; i++ {
+		repo.OTP().DeleteByPhone(ctx, phone)
+		if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone}); err != nil {
+			t.Fatalf("Failed to send OTP: %v", err)
+		}
+		if _, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: "000000"}); err != nil {
+			t.Fatalf("Expected wrong code to be rejected without error, got %v", err)
+		}
+	}
+
+	// A fresh OTP doesn't reset the failure budget
+	repo.OTP().DeleteByPhone(ctx, phone)
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	_, err = service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: response.OTP})
+	appErr, ok := err.(*common.AppError)
+	if !ok || appErr.Code != common.ErrCodeRateLimit {
+		t.Fatalf("Expected rate limit error after repeated failures, got %v", err)
+	}
+	if appErr.RetryAfter != 60 {
+		t.Errorf("Expected retry after 60s, got %d", appErr.RetryAfter)
+	}
+
+	// The throttled request didn't consume an attempt on the new OTP
+	storedOTP, _ := repo.OTP().FindByPhone(ctx, phone)
+	if storedOTP.Attempts != 0 {
+		t.Errorf("Expected throttled verify not to count as an attempt, got %d", storedOTP.Attempts)
+	}
+
+	// The lockout runs on the service clock
+	service.now = func() time.Time { return time.Now().Add(time.Minute + time.Second) }
+	verification, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: response.OTP})
+	if err != nil || !verification.Valid {
+		t.Errorf("Expected the code to verify once the lockout ended, got %+v, %v", verification, err)
+	}
+}
+
+func TestFailureBackoffDoubles(t *testing.T) {
+	backoff := newFailureBackoff()
+	policy := BackoffPolicy{Threshold: 2, Base: time.Minute, Max: 3 * time.Minute}
+	now := time.Now()
+
+	expected := []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute}
+	for i, want := range expected {
+		backoff.Fail("key", policy, now)
+		locked, retryAfter := backoff.Check("key", now)
+		if locked != (want > 0) || retryAfter != want {
+			t.Errorf("After %d failures expected lockout %v, got %v (locked=%v)", i+1, want, retryAfter, locked)
+		}
+	}
+
+	backoff.Succeed("key")
+	if locked, _ := backoff.Check("key", now); locked {
+		t.Errorf("Expected success to clear the lockout")
+	}
+}
+
+func TestBruteForceDetection(t *testing.T) {
+	var mu sync.Mutex
+	var alerts []webhook.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var event webhook.Event
+		json.Unmarshal(body, &event)
+		mu.Lock()
+		alerts = append(alerts, event)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	cfg := testConfig()
+	cfg.VerifyFailureBackoff = BackoffPolicy{}
+	cfg.BruteForce = BruteForcePolicy{Failures: 3, Window: time.Hour, BlockFor: 2 * time.Hour}
+	repo := repository.NewInMemoryRepository()
+	sender := webhook.NewSender([]webhook.Destination{{URL: server.URL, Secret: "secret"}})
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg), WithFailureAlerts(sender))
+	now := time.Now()
+	service.now = func() time.Time { return now }
+	ctx := context.Background()
+	phone := "+1234567890"
+
+	// Each OTP gets a single wrong guess, staying under every per-OTP limit
+	for i := 0; i < 3; i++ {
+		repo.OTP().DeleteByPhone(ctx, phone)
+		if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone}); err != nil {
+			t.Fatalf("Failed to send OTP: %v", err)
+		}
+		if _, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: "000000"}); err != nil {
+			t.Fatalf("Expected wrong code to be rejected without error, got %v", err)
+		}
+	}
+
+	page, err := service.ListFlaggedPhones(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("Failed to list flagged phones: %v", err)
+	}
+	flagged := page.Data.([]*models.FlaggedPhone)
+	if page.Total != 1 || flagged[0].Phone != phone || flagged[0].Failures != 3 || !flagged[0].BlockedUntil.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("Expected %s to be flagged after 3 failures, got %+v", phone, page)
+	}
+
+	// Even the right code of a fresh OTP is blocked until the block ends
+	repo.OTP().DeleteByPhone(ctx, phone)
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	_, err = service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: response.OTP})
+	appErr, ok := err.(*common.AppError)
+	if !ok || appErr.Code != common.ErrCodeRateLimit || appErr.RetryAfter != 7200 {
+		t.Fatalf("Expected a rate limit error for 7200s, got %v", err)
+	}
+
+	// The OTP expired during the block, so verify a new one once it ended
+	now = now.Add(2*time.Hour + time.Second)
+	response, err = service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	verified, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: response.OTP})
+	if err != nil || !verified.Valid {
+		t.Fatalf("Expected verification once the block ended, got %+v, %v", verified, err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	service.Shutdown(shutdownCtx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(alerts) != 1 || alerts[0].Type != "otp.brute_force" {
+		t.Fatalf("Expected a single brute-force alert, got %+v", alerts)
+	}
+	data := alerts[0].Data.(map[string]interface{})
+	if data["phone"] != "+******7890" || data["failures"] != float64(3) || data["window_seconds"] != float64(3600) {
+		t.Errorf("Unexpected brute-force alert %+v", data)
+	}
+}
+
+func TestVerifyGlobalRateLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.GlobalVerifyRateLimit = RateLimit{Limit: 2, Window: time.Minute}
+	service := NewSMSService(repository.NewInMemoryRepository(), transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+
+	for i, phone := range []string{"+1234567890", "+1234567891", "+1234567892"} {
+		_, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: "123456"})
+		limited := false
+		if appErr, ok := err.(*common.AppError); ok && appErr.Code == common.ErrCodeRateLimit {
+			limited = true
+		}
+		if limited != (i == 2) {
+			t.Errorf("Verify %d: expected limited=%v, got error %v", i+1, i == 2, err)
+		}
+	}
+}
+
+// failingRateLimitStore fails every operation, like an unreachable Redis
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (failingRateLimitStore) Get(ctx context.Context, key string) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (failingRateLimitStore) Delete(ctx context.Context, keys ...string) error {
+	return errors.New("connection refused")
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := newSlidingWindowLimiter(NewMemoryRateLimitStore())
+	limit := RateLimit{Limit: 4, Window: time.Minute}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		if allowed, _, _ := limiter.Allow(ctx, "key", limit, start.Add(time.Duration(i)*time.Second)); !allowed {
+			t.Fatalf("Expected event %d to be allowed", i+1)
+		}
+	}
+	allowed, retry, _ := limiter.Allow(ctx, "key", limit, start.Add(30*time.Second))
+	if allowed || retry < 30*time.Second || retry > time.Minute {
+		t.Errorf("Expected the fifth event to wait for the window to slide, got %v, %v", allowed, retry)
+	}
+
+	// Halfway into the next window only half of the previous count remains,
+	// including the rejected event
+	next := start.Add(90 * time.Second)
+	if allowed, _, _ := limiter.Allow(ctx, "key", limit, next); !allowed {
+		t.Fatal("Expected an event in the next window to be allowed")
+	}
+	if allowed, _, _ := limiter.Allow(ctx, "key", limit, next); allowed {
+		t.Error("Expected the weighted count to exceed the limit")
+	}
+	if allowed, _, _ := limiter.Allow(ctx, "other", limit, next); !allowed {
+		t.Error("Expected other keys to have their own budget")
+	}
+
+	// An unreachable store is reported to the caller
+	if allowed, _, err := newSlidingWindowLimiter(failingRateLimitStore{}).Allow(ctx, "key", limit, start); allowed || err == nil {
+		t.Errorf("Expected store errors to be returned, got %v, %v", allowed, err)
+	}
+}
+
+func TestSlidingWindowLimiterConcurrent(t *testing.T) {
+	ctx := context.Background()
+	limiter := newSlidingWindowLimiter(NewMemoryRateLimitStore())
+	limit := RateLimit{Limit: 5, Window: time.Minute}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _, _ := limiter.Allow(ctx, "key", limit, now); ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() != 5 {
+		t.Errorf("Expected exactly 5 concurrent events to be allowed, got %d", allowed.Load())
+	}
+}
+
+func TestVerifyOTPRateLimitStoreUnavailable(t *testing.T) {
+	service, _, _ := newTestService()
+	ctx := context.Background()
+	otp, _ := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"})
+	WithRateLimitStore(failingRateLimitStore{})(service)
+
+	_, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: otp.OTP})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected verification to be refused while the store is down, got %v", err)
+	}
+
+	service.config.RateLimitFailOpen = true
+	if response, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: otp.OTP}); err != nil || !response.Valid {
+		t.Errorf("Expected verification to go through when failing open, got %+v, %v", response, err)
+	}
+}
+
+func TestMemoryRateLimitStoreSweepsExpiredCounters(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRateLimitStore()
+
+	store.Incr(ctx, "stale", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	store.lastSweep = time.Time{}
+	store.Incr(ctx, "fresh", time.Minute)
+
+	if _, ok := store.counters["stale"]; ok {
+		t.Error("Expected the expired counter to be removed")
+	}
+	if count, _ := store.Get(ctx, "fresh"); count != 1 {
+		t.Errorf("Expected the fresh counter to be kept, got %d", count)
+	}
+}
+
+func TestShutdownFlushesPendingWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	delivered := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		delivered++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sender := webhook.NewSender([]webhook.Destination{{URL: server.URL, Secret: "secret"}})
+	service := NewSMSService(repository.NewInMemoryRepository(), transport.NewMockSMSClient(), WithWebhookSender(sender))
+	ctx := context.Background()
+
+	const sends = 5
+	for i := 0; i < sends; i++ {
+		phone := fmt.Sprintf("+123456789%d", i)
+		if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: phone, Message: "Hello"}); err != nil {
+			t.Fatalf("Failed to send SMS: %v", err)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	service.Shutdown(shutdownCtx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if delivered != sends {
+		t.Errorf("Expected %d webhooks to be flushed on shutdown, got %d", sends, delivered)
+	}
+}
+
+func TestFailureAlerts(t *testing.T) {
+	var mu sync.Mutex
+	alerts := make(map[string]map[string]interface{})
+	posted := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify("secret", r.Header, body, time.Minute); err != nil {
+			t.Errorf("Expected a signed alert, got %v", err)
+		}
+		var event webhook.Event
+		json.Unmarshal(body, &event)
+		data := event.Data.(map[string]interface{})
+		mu.Lock()
+		alerts[data["kind"].(string)] = data
+		posted++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	repo := repository.NewInMemoryRepository()
+	mockClient := transport.NewMockSMSClient()
+	cfg := testConfig()
+	cfg.FailureAlertThreshold = 2
+	cfg.SMSRetry = RetryPolicy{MaxRetries: 1, Interval: time.Hour, MaxAge: 2 * time.Hour}
+	sender := webhook.NewSender([]webhook.Destination{{URL: server.URL, Secret: "secret"}})
+	service := NewSMSService(repo, mockClient, WithConfig(cfg), WithFailureAlerts(sender))
+	ctx := context.Background()
+
+	// The first failure is below the threshold, the second one is reported
+	mockClient.Err = errors.New("carrier unreachable")
+	service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+15550000001"})
+	service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+15550000002"})
+
+	// A success ends the streak
+	mockClient.Err = nil
+	service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+15550000003"})
+	mockClient.Err = errors.New("carrier unreachable")
+	service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+15550000004"})
+
+	// SMS messages are only reported once their retries are exhausted
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+15550000005", Message: "Hello"})
+	service.now = func() time.Time { return time.Now().Add(90 * time.Minute) }
+	service.RetryFailedSMS()
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	service.Shutdown(shutdownCtx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Alerts are posted concurrently, so they may arrive in any order
+	if posted != 2 || len(alerts) != 2 {
+		t.Fatalf("Expected an OTP and an SMS alert, got %d: %+v", posted, alerts)
+	}
+	otpAlert, smsAlert := alerts["otp"], alerts["sms"]
+	if otpAlert["kind"] != "otp" || otpAlert["phone"] != "+*******0002" || otpAlert["provider"] != "mock" || otpAlert["error"] != "carrier unreachable" {
+		t.Errorf("Unexpected OTP alert %+v", otpAlert)
+	}
+	if smsAlert["kind"] != "sms" || smsAlert["phone"] != "+*******0005" || smsAlert["consecutive_failures"] != float64(2) {
+		t.Errorf("Unexpected SMS alert %+v", smsAlert)
+	}
+}
+
+func TestAsyncQueueDrainTimeout(t *testing.T) {
+	queue := newAsyncQueue()
+	queue.Go(func(ctx context.Context) {})
+	queue.Go(func(ctx context.Context) { <-ctx.Done() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, dropped := queue.Drain(ctx); dropped != 1 {
+		t.Errorf("Expected the blocked task to be dropped, got %d dropped", dropped)
+	}
+
+	if queue.Go(func(ctx context.Context) {}) {
+		t.Errorf("Expected drained queue to reject new tasks")
+	}
+}
+
+func TestGetSMS(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"}); err != nil {
+		t.Fatalf("Failed to send SMS: %v", err)
+	}
+	records, _ := repo.SMS().FindAll(ctx, 1, models.LogCursor{})
+
+	sms, err := service.GetSMS(ctx, records[0].ID.Hex())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sms.To != "+1234567890" || sms.Status != models.StatusSent {
+		t.Errorf("Unexpected SMS %+v", sms)
+	}
+
+	_, err = service.GetSMS(ctx, "not-an-id")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed ID, got %v", err)
+	}
+
+	_, err = service.GetSMS(ctx, primitive.NewObjectID().Hex())
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing message, got %v", err)
+	}
+}
+
+func TestDeleteSMS(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := common.WithActor(context.Background(), common.Actor{Type: common.ActorAdmin, ID: "admin_1"})
+
+	sms := &models.SMS{Direction: models.DirectionOutbound, To: "+1234567890", Status: models.StatusSent, ProviderID: "uuid-1"}
+	repo.SMS().Create(ctx, sms)
+	id := sms.ID.Hex()
+
+	if err := service.DeleteSMS(ctx, id); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err := service.GetSMS(ctx, id)
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted message, got %v", err)
+	}
+	err = service.DeleteSMS(ctx, id)
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 when deleting twice, got %v", err)
+	}
+
+	// The message is kept, and delivery reports still update it
+	if err := service.HandleDeliveryReport(ctx, models.DeliveryReport{MessageUUID: "uuid-1", Status: models.StatusDelivered}); err != nil {
+		t.Fatalf("Expected the delivery report of a deleted message to be handled, got %v", err)
+	}
+	stored, err := repo.SMS().FindByID(repository.IncludeDeleted(ctx), id)
+	if err != nil || stored.DeletedAt == nil || stored.Status != models.StatusDelivered {
+		t.Errorf("Expected the deleted message to be stored and delivered, got %+v, %v", stored, err)
+	}
+
+	// The deletion is audited with the admin who deleted it
+	service.Shutdown(ctx)
+	audits, _ := repo.Audit().List(ctx, models.AuditFilter{Type: "sms.deleted"}, 0, 0)
+	if len(audits) != 1 || audits[0].TargetID != id || audits[0].ActorID != "admin_1" || audits[0].FromStatus != string(models.StatusSent) {
+		t.Errorf("Expected one deletion audit record by admin_1, got %+v", audits)
+	}
+}
+
+func TestPollDeliveryStatusOfDeletedSMS(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	ctx := context.Background()
+
+	sms := &models.SMS{Direction: models.DirectionOutbound, To: "+1234567890", Status: models.StatusSent, ProviderID: "uuid-1", Provider: mockClient.GetProvider(), CreatedAt: time.Now()}
+	repo.SMS().Create(ctx, sms)
+	service.DeleteSMS(ctx, sms.ID.Hex())
+
+	// Deleted messages are polled like delivery reports update them
+	service.PollDeliveryStatus()
+	stored, _ := repo.SMS().FindByID(repository.IncludeDeleted(ctx), sms.ID.Hex())
+	if stored.Status != models.StatusDelivered {
+		t.Errorf("Expected the deleted message to be polled, got status %s", stored.Status)
+	}
+}
+
+func TestOptOutSuppressesSends(t *testing.T) {
+	service, _, mockClient := newTestService()
+	ctx := context.Background()
+	phone := "+1234567890"
+
+	if err := service.HandleInboundMessage(ctx, models.InboundMessage{From: phone, Text: " stop "}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if suppressed, _ := service.IsSuppressed(ctx, phone); !suppressed {
+		t.Fatalf("Expected STOP to add the number to the suppression list")
+	}
+
+	_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: phone, Message: "Hello"})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeOptedOut {
+		t.Errorf("Expected opted-out error for SendSMS, got %v", err)
+	}
+	_, err = service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeOptedOut {
+		t.Errorf("Expected opted-out error for SendOTP, got %v", err)
+	}
+	if calls := mockClient.Calls(); len(calls) != 0 {
+		t.Errorf("Expected nothing to reach the provider, got %+v", calls)
+	}
+
+	if err := service.OptIn(ctx, phone); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: phone, Message: "Hello"}); err != nil {
+		t.Errorf("Expected send after opt-in to succeed, got %v", err)
+	}
+
+	// Texting START opts the number back in after another STOP
+	service.HandleInboundMessage(ctx, models.InboundMessage{From: phone, Text: "STOP"})
+	if err := service.HandleInboundMessage(ctx, models.InboundMessage{From: phone, Text: "Start"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if suppressed, _ := service.IsSuppressed(ctx, phone); suppressed {
+		t.Error("Expected START to remove the number from the suppression list")
+	}
+}
+
+func TestIsOptOutKeyword(t *testing.T) {
+	tests := map[string]bool{
+		"STOP":              true,
+		"unsubscribe":       true,
+		" Stop\n":           true,
+		"stop sending this": false,
+		"hello":             false,
+		"":                  false,
+	}
+	for text, expected := range tests {
+		if got := isOptOutKeyword(text); got != expected {
+			t.Errorf("isOptOutKeyword(%q) = %v, expected %v", text, got, expected)
+		}
+	}
+}
+
+func TestHandleInboundMessageStoresSMS(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+
+	msg := models.InboundMessage{From: "+1234567890", To: "+1987654321", Text: "Hi there", MessageUUID: "uuid-1"}
+	if err := service.HandleInboundMessage(ctx, msg); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	records, _ := repo.SMS().FindAll(ctx, 10, models.LogCursor{})
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 stored message, got %d", len(records))
+	}
+	sms := records[0]
+	if sms.Direction != models.DirectionInbound || sms.From != msg.From || sms.Message != msg.Text || sms.ProviderID != msg.MessageUUID {
+		t.Errorf("Unexpected inbound record %+v", sms)
+	}
+	if suppressed, _ := service.IsSuppressed(ctx, msg.From); suppressed {
+		t.Errorf("Expected a regular message not to opt the sender out")
+	}
+}
+
+func TestGetLogsByDirection(t *testing.T) {
+	service, repo, _ := newTestService()
+	logsService := NewLogsService(repo)
+	ctx := context.Background()
+
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"}); err != nil {
+		t.Fatalf("Failed to send SMS: %v", err)
+	}
+	if err := service.HandleInboundMessage(ctx, models.InboundMessage{From: "+1234567890", Text: "Hi"}); err != nil {
+		t.Fatalf("Failed to handle inbound message: %v", err)
+	}
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567891", Message: "Hello"})
+
+	// The breakdown counts every message, not just those on the page
+	logs, err := logsService.GetLogs(ctx, 1, models.LogCursor{}, models.SMSLogFilter{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	counts := logs["sms"].(*models.SMSLogSection).ByDirection
+	if counts[models.DirectionInbound] != 1 || counts[models.DirectionOutbound] != 2 {
+		t.Errorf("Expected 1 inbound and 2 outbound messages, got %v", counts)
+	}
+
+	logs, _ = logsService.GetLogs(ctx, 10, models.LogCursor{}, models.SMSLogFilter{Direction: models.DirectionInbound})
+	inbound := logs["sms"].(*models.SMSLogSection).Data.([]*models.SMS)
+	if len(inbound) != 1 || inbound[0].Direction != models.DirectionInbound {
+		t.Errorf("Expected only the inbound message, got %+v", inbound)
+	}
+}
+
+func TestSearchLogsTimeline(t *testing.T) {
+	service, repo, _ := newTestService()
+	logsService := NewLogsService(repo)
+	callbackService := NewCallbackService(repo)
+	ctx := context.Background()
+	phone := "+1234567890"
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone}); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: phone, Message: "Hello"}); err != nil {
+		t.Fatalf("Failed to send SMS: %v", err)
+	}
+	if err := service.HandleInboundMessage(ctx, models.InboundMessage{From: phone, Text: "Hi"}); err != nil {
+		t.Fatalf("Failed to handle inbound message: %v", err)
+	}
+	if _, err := callbackService.RequestCallback(ctx, models.CallbackRequest{PhoneNumber: phone}); err != nil {
+		t.Fatalf("Failed to request callback: %v", err)
+	}
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567891", Message: "Someone else"})
+
+	timeline, err := logsService.SearchLogs(ctx, phone, time.Time{}, time.Time{}, 10)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if timeline.Count != 4 || timeline.HasMore {
+		t.Fatalf("Expected 4 entries of the phone number, got %d (has more: %v)", timeline.Count, timeline.HasMore)
+	}
+	types := map[string]int{}
+	for i, entry := range timeline.Entries {
+		types[entry.Type]++
+		if i > 0 && entry.Timestamp.After(timeline.Entries[i-1].Timestamp) {
+			t.Errorf("Expected entries newest first, entry %d is newer than the one before", i)
+		}
+		if otp, ok := entry.Record.(*models.OTP); ok && otp.Code != "" {
+			t.Error("Expected the OTP code to be left out")
+		}
+	}
+	if types[models.LogTypeOTP] != 1 || types[models.LogTypeSMS] != 2 || types[models.LogTypeCallback] != 1 {
+		t.Errorf("Expected 1 OTP, 2 SMS and 1 callback, got %v", types)
+	}
+
+	timeline, _ = logsService.SearchLogs(ctx, phone, time.Time{}, time.Time{}, 2)
+	if timeline.Count != 2 || !timeline.HasMore {
+		t.Errorf("Expected 2 entries with more left out, got %d (has more: %v)", timeline.Count, timeline.HasMore)
+	}
+
+	timeline, _ = logsService.SearchLogs(ctx, phone, time.Now().Add(time.Hour), time.Time{}, 10)
+	if timeline.Count != 0 || timeline.Entries == nil {
+		t.Errorf("Expected an empty timeline after the range, got %+v", timeline.Entries)
+	}
+}
+
+// failingSMSRepository fails every SMS listing
+type failingSMSRepository struct {
+	repository.SMSRepository
+}
+
+func (failingSMSRepository) FindAll(ctx context.Context, limit int, before models.LogCursor) ([]*models.SMS, error) {
+	return nil, errors.New("connection reset")
+}
+
+// degradedRepository has no OTP repository and an SMS repository that fails
+type degradedRepository struct {
+	*repository.InMemoryRepository
+}
+
+func (r degradedRepository) OTP() repository.OTPRepository {
+	return nil
+}
+
+func (r degradedRepository) SMS() repository.SMSRepository {
+	return failingSMSRepository{r.InMemoryRepository.SMS()}
+}
+
+func TestGetLogsDegradesFailedSections(t *testing.T) {
+	repo := degradedRepository{repository.NewInMemoryRepository()}
+	ctx := context.Background()
+
+	if _, err := NewCallbackService(repo).RequestCallback(ctx, models.CallbackRequest{PhoneNumber: "+1234567890"}); err != nil {
+		t.Fatalf("Failed to request callback: %v", err)
+	}
+
+	logs, err := NewLogsService(repo).GetLogs(ctx, 10, models.LogCursor{}, models.SMSLogFilter{})
+	if err != nil {
+		t.Fatalf("Expected the callbacks to be returned, got %v", err)
+	}
+	callbacks := logs["callbacks"].(*models.LogSection)
+	if callbacks.Count != 1 || callbacks.Error != "" {
+		t.Errorf("Expected the callback section to succeed, got %+v", callbacks)
+	}
+	for section, data := range map[string]*models.LogSection{"otps": logs["otps"].(*models.LogSection), "sms": &logs["sms"].(*models.SMSLogSection).LogSection} {
+		if data.Count != 0 || data.Error == "" {
+			t.Errorf("Expected the %s section to be empty with an error note, got %+v", section, data)
+		}
+	}
+	if errs := logs["errors"].(map[string]string); len(errs) != 2 {
+		t.Errorf("Expected 2 section errors, got %v", errs)
+	}
+	if logs["total_records"] != 1 {
+		t.Errorf("Expected 1 record in total, got %v", logs["total_records"])
+	}
+}
+
+func TestGetLogsByMetadata(t *testing.T) {
+	service, repo, _ := newTestService()
+	logsService := NewLogsService(repo)
+	ctx := context.Background()
+
+	tagged, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Sale", Metadata: map[string]string{"campaign": "spring"}})
+	if err != nil {
+		t.Fatalf("Failed to send SMS: %v", err)
+	}
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567891", Message: "Sale", Metadata: map[string]string{"campaign": "autumn"}})
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567892", Message: "Hello"})
+
+	logs, err := logsService.GetLogs(ctx, 10, models.LogCursor{}, models.SMSLogFilter{MetadataKey: "campaign", MetadataValue: "spring"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	records := logs["sms"].(*models.SMSLogSection).Data.([]*models.SMS)
+	if len(records) != 1 || records[0].ID.Hex() != tagged.ID || records[0].Metadata["campaign"] != "spring" {
+		t.Errorf("Expected only the spring campaign message, got %+v", records)
+	}
+}
+
+func TestListSMS(t *testing.T) {
+	service, repo, _ := newTestService()
+	logsService := NewLogsService(repo)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		service.SendSMS(ctx, models.SMSRequest{PhoneNumber: fmt.Sprintf("+123456789%d", i), Message: "Hello", UserID: fmt.Sprintf("user-%d", i%2)})
+	}
+	service.HandleInboundMessage(ctx, models.InboundMessage{From: "+1234567890", Text: "Hi"})
+
+	// A user's listing only holds the messages they sent
+	page, err := logsService.ListSMS(ctx, models.SMSLogFilter{UserID: "user-1"}, 0, 10)
+	if err != nil || page.Total != 2 || len(page.Data.([]*models.SMS)) != 2 {
+		t.Errorf("Expected the 2 messages of user-1, got %+v, %v", page, err)
+	}
+
+	page, err = logsService.ListSMS(ctx, models.SMSLogFilter{Direction: models.DirectionOutbound}, 2, 2)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	records := page.Data.([]*models.SMS)
+	if len(records) != 2 || page.Total != 5 || !page.HasMore || page.Offset != 2 || page.Limit != 2 {
+		t.Errorf("Expected the second page of 2 out of 5 with more to come, got %+v", page)
+	}
+
+	page, _ = logsService.ListSMS(ctx, models.SMSLogFilter{Direction: models.DirectionOutbound}, 4, 2)
+	if records := page.Data.([]*models.SMS); len(records) != 1 || page.HasMore {
+		t.Errorf("Expected the last record without more to come, got %+v", page)
+	}
+
+	// The logs sections report their totals too
+	logs, _ := logsService.GetLogs(ctx, 4, models.LogCursor{}, models.SMSLogFilter{})
+	section := logs["sms"].(*models.SMSLogSection)
+	if section.Total != 6 || !section.HasMore || section.NextCursor == nil {
+		t.Errorf("Expected 6 SMS in total with more to come, got total %d, has_more %v", section.Total, section.HasMore)
+	}
+
+	// Later pages count the records before their cursor
+	before, _ := models.ParseLogCursor(*section.NextCursor)
+	logs, _ = logsService.GetLogs(ctx, 4, before, models.SMSLogFilter{})
+	if section := logs["sms"].(*models.SMSLogSection); section.Total != 2 || section.Count != 2 || section.HasMore || section.NextCursor != nil {
+		t.Errorf("Expected the 2 remaining SMS without more to come, got %+v", section.LogSection)
+	}
+
+	logs, _ = logsService.GetLogs(ctx, 6, models.LogCursor{}, models.SMSLogFilter{})
+	if section := logs["sms"].(*models.SMSLogSection); section.HasMore || section.NextCursor != nil {
+		t.Errorf("Expected a full last page not to offer a next cursor, got %v", section.NextCursor)
+	}
+}
+
+func TestRevokeOTP(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+
+	if err := service.RevokeOTP(ctx, "+1234567890"); err == nil {
+		t.Fatal("Expected error when no OTP is active")
+	} else if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 AppError, got %v", err)
+	}
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"}); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	if err := service.RevokeOTP(ctx, "+1234567890"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if otp, err := repo.OTP().FindByPhone(ctx, "+1234567890"); err == nil && otp != nil {
+		t.Error("Expected OTP to be deleted")
+	}
+}
+
+func TestResetOTPAttempts(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+	phone := "+1234567890"
+
+	if err := service.ResetOTPAttempts(ctx, phone); err == nil {
+		t.Fatal("Expected error when no OTP is active")
+	} else if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 AppError, got %v", err)
+	}
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	wrongCode := "000000"
+	if response.OTP == wrongCode {
+		wrongCode = "111111"
+	}
+	for i := 0; i < 3; i++ {
+		service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: wrongCode})
+	}
+	if stored, _ := repo.OTP().FindByPhone(ctx, phone); stored.LockedUntil.IsZero() {
+		t.Fatal("Expected the phone number to be locked out")
+	}
+
+	if err := service.ResetOTPAttempts(ctx, phone); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	stored, _ := repo.OTP().FindByPhone(ctx, phone)
+	if stored.Attempts != 0 || !stored.LockedUntil.IsZero() {
+		t.Fatalf("Expected no attempts and no lockout, got %d attempts locked until %v", stored.Attempts, stored.LockedUntil)
+	}
+	verified, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: response.OTP})
+	if err != nil || !verified.Valid {
+		t.Errorf("Expected the code to verify after the reset, got %+v, %v", verified, err)
+	}
+
+	// A verified OTP is no longer active
+	if err := service.ResetOTPAttempts(ctx, phone); err == nil {
+		t.Error("Expected error once the OTP is verified")
+	}
+}
+
+func TestResetOTPAttemptsLiftsRateLimit(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	cfg := testConfig()
+	cfg.VerifyRateLimit = RateLimit{Limit: 3, Window: 15 * time.Minute}
+	cfg.VerifyFailureBackoff = BackoffPolicy{}
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+	adminCtx := common.WithActor(ctx, common.Actor{Type: common.ActorAdmin, ID: "admin_1"})
+	phone := "+1234567890"
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	wrongCode := "000000"
+	if response.OTP == wrongCode {
+		wrongCode = "111111"
+	}
+	for i := 0; i < 3; i++ {
+		service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: wrongCode})
+	}
+
+	// The wrong codes used up the number's rate limit too, which the reset lifts
+	if err := service.ResetOTPAttempts(adminCtx, phone); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	verified, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: response.OTP})
+	if err != nil || !verified.Valid {
+		t.Errorf("Expected the code to verify after the reset, got %+v, %v", verified, err)
+	}
+
+	service.Shutdown(ctx)
+	audits, _ := repo.Audit().List(ctx, models.AuditFilter{Type: "otp.reset"}, 0, 0)
+	if len(audits) != 1 || audits[0].Actor != common.ActorAdmin || audits[0].ActorID != "admin_1" {
+		t.Errorf("Expected the reset to be audited with the admin, got %+v", audits)
+	}
+}
+
+type lookupCountingOTPRepository struct {
+	repository.OTPRepository
+	lookups *int
+}
+
+func (r lookupCountingOTPRepository) FindByPhone(ctx context.Context, phone string) (*models.OTP, error) {
+	*r.lookups++
+	return r.OTPRepository.FindByPhone(ctx, phone)
+}
+
+type lookupCountingRepository struct {
+	*repository.InMemoryRepository
+	lookups *int
+}
+
+func (r lookupCountingRepository) OTP() repository.OTPRepository {
+	return lookupCountingOTPRepository{r.InMemoryRepository.OTP(), r.lookups}
+}
+
+func TestGetOTPStatusCache(t *testing.T) {
+	var lookups int
+	repo := lookupCountingRepository{repository.NewInMemoryRepository(), &lookups}
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(testConfig()))
+	now := time.Now()
+	service.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	service.GetOTPStatus(ctx, "+1234567890")
+	if status, _ := service.GetOTPStatus(ctx, "+1234567890"); status.HasActiveOTP || lookups != 1 {
+		t.Fatalf("Expected the second poll to be served from the cache, got %d lookups", lookups)
+	}
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	if status, _ := service.GetOTPStatus(ctx, "+1234567890"); !status.HasActiveOTP || status.DailySendCount != 1 {
+		t.Errorf("Expected sending to refresh the status, got %+v", status)
+	}
+
+	service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: "000000"})
+	if status, _ := service.GetOTPStatus(ctx, "+1234567890"); status.Attempts != 1 {
+		t.Errorf("Expected a failed verification to refresh the attempts, got %+v", status)
+	}
+	service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: response.OTP})
+	if status, _ := service.GetOTPStatus(ctx, "+1234567890"); status.HasActiveOTP {
+		t.Errorf("Expected a verified OTP not to be active, got %+v", status)
+	}
+
+	service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567891"})
+	service.GetOTPStatus(ctx, "+1234567891")
+	service.RevokeOTP(ctx, "+1234567891")
+	if status, _ := service.GetOTPStatus(ctx, "+1234567891"); status.HasActiveOTP {
+		t.Errorf("Expected a revoked OTP not to be active, got %+v", status)
+	}
+
+	before := lookups
+	now = now.Add(testConfig().OTPStatusCacheTTL)
+	service.GetOTPStatus(ctx, "+1234567891")
+	if lookups != before+1 {
+		t.Errorf("Expected an expired entry to be looked up again, got %d lookups", lookups-before)
+	}
+}
+
+func TestOTPStatusCacheInvalidatesPerKey(t *testing.T) {
+	cache := newOTPStatusCache()
+	repo := repository.NewInMemoryRepository()
+	now := time.Now()
+	expiresAt := now.Add(time.Minute)
+
+	_, generation := cache.Get(repo, "+1234567890", now)
+	cache.Invalidate(repo, "+1234567891")
+	cache.Put(repo, "+1234567890", models.OTPStatus{PhoneNumber: "+1234567890"}, generation, now, expiresAt)
+	if status, _ := cache.Get(repo, "+1234567890", now); status == nil {
+		t.Errorf("Expected an invalidation of another number not to drop the lookup")
+	}
+
+	_, generation = cache.Get(repo, "+1234567891", now)
+	cache.Invalidate(repo, "+1234567891")
+	cache.Put(repo, "+1234567891", models.OTPStatus{PhoneNumber: "+1234567891"}, generation, now, expiresAt)
+	if status, _ := cache.Get(repo, "+1234567891", now); status != nil {
+		t.Errorf("Expected a lookup that raced with its own invalidation not to be cached")
+	}
+}
+
+func TestSearchByPhoneInboundCounterparty(t *testing.T) {
+	service, repo, _ := newTestService()
+	logsService := NewLogsService(repo)
+	ctx := context.Background()
+
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234561111", Message: "Hello"}); err != nil {
+		t.Fatalf("Failed to send SMS: %v", err)
+	}
+	inbound := &models.SMS{Direction: models.DirectionInbound, From: "+1987652222", To: "+1234561111", Message: "STOP", Status: models.StatusReceived}
+	if err := repo.SMS().Create(ctx, inbound); err != nil {
+		t.Fatalf("Failed to store inbound SMS: %v", err)
+	}
+
+	result, err := logsService.SearchByPhone(ctx, "2222", models.PhoneMatchSuffix, 10)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(result.SMS) != 1 || result.SMS[0].ID != inbound.ID.Hex() || result.SMS[0].Phone != "+******2222" {
+		t.Errorf("Expected the inbound message with its masked sender, got %+v", result.SMS)
+	}
+
+	// The service's own number on an inbound message is not a match
+	result, _ = logsService.SearchByPhone(ctx, "1111", models.PhoneMatchSuffix, 10)
+	if len(result.SMS) != 1 || result.SMS[0].ID == inbound.ID.Hex() {
+		t.Errorf("Expected only the outbound message to match its recipient, got %+v", result.SMS)
+	}
+}
+
+func TestExportLogs(t *testing.T) {
+	service, repo, _ := newTestService()
+	logsService := NewLogsService(repo)
+	ctx := context.Background()
+
+	for _, message := range []string{"First", "=HYPERLINK(\"http://evil\")"} {
+		if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: message}); err != nil {
+			t.Fatalf("Failed to send SMS: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := logsService.ExportLogs(ctx, models.LogTypeSMS, time.Time{}, time.Time{}, &buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected valid CSV, got %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "id" || rows[1][4] != "First" || rows[2][4] != "'=HYPERLINK(\"http://evil\")" {
+		t.Errorf("Expected header and both messages oldest first with formulas escaped, got %v", rows)
+	}
+	if rows[1][3] != "'+1234567890" {
+		t.Errorf("Expected a leading + to be escaped, got %q", rows[1][3])
+	}
+
+	buf.Reset()
+	logsService.ExportLogs(ctx, models.LogTypeSMS, time.Now().Add(time.Hour), time.Time{}, &buf)
+	if rows, _ := csv.NewReader(&buf).ReadAll(); len(rows) != 1 {
+		t.Errorf("Expected only the header outside the range, got %v", rows)
+	}
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"}); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	buf.Reset()
+	if err := logsService.ExportLogs(ctx, models.LogTypeOTP, time.Time{}, time.Time{}, &buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	otp, _ := repo.OTP().FindByPhone(ctx, "+1234567890")
+	if strings.Contains(buf.String(), otp.Code) {
+		t.Error("Expected OTP codes to be left out of the export")
+	}
+
+	if err := logsService.ExportLogs(ctx, "users", time.Time{}, time.Time{}, &buf); err == nil {
+		t.Error("Expected error for an unknown log type")
+	}
+}
+
+func TestGetDailyAnalytics(t *testing.T) {
+	service, repo, _ := newTestService()
+	logsService := NewLogsService(repo)
+	ctx := context.Background()
+
+	for _, phone := range []string{"+1234567890", "+1234567891"} {
+		if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: phone, Message: "Hello"}); err != nil {
+			t.Fatalf("Failed to send SMS: %v", err)
+		}
+	}
+	sent, _ := repo.SMS().FindByPhone(ctx, "+1234567890", 1)
+	repo.SMS().UpdateStatus(ctx, sent[0].ID.Hex(), models.StatusDelivered)
+	service.HandleInboundMessage(ctx, models.InboundMessage{From: "+1234567890", Text: "Hi"})
+
+	repo.Callback().Create(ctx, &models.Callback{PhoneNumber: "+1234567890", Status: models.StatusCompleted})
+
+	for _, phone := range []string{"+1234567890", "+1234567891"} {
+		if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone}); err != nil {
+			t.Fatalf("Failed to send OTP: %v", err)
+		}
+	}
+	otp, _ := repo.OTP().FindByPhone(ctx, "+1234567890")
+	service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: otp.Code})
+	// A retry within the grace window is not counted again
+	service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: otp.Code})
+
+	analytics, err := logsService.GetDailyAnalytics(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(analytics.Days) != defaultAnalyticsDays {
+		t.Fatalf("Expected %d days, got %d", defaultAnalyticsDays, len(analytics.Days))
+	}
+	if analytics.Days[0].SMSSent != 0 {
+		t.Errorf("Expected empty days to be zero-filled, got %+v", analytics.Days[0])
+	}
+
+	today := analytics.Days[len(analytics.Days)-1]
+	if today.Day != time.Now().UTC().Format("2006-01-02") || analytics.To != today.Day {
+		t.Errorf("Expected the series to end today, got %s", today.Day)
+	}
+	if today.OTPsSent != 2 || today.OTPsVerified != 1 || today.OTPVerifyRate != 0.5 {
+		t.Errorf("Expected 2 OTPs sent and 1 verified, got %+v", today)
+	}
+	if today.SMSSent != 2 || today.SMSDelivered != 1 || today.SMSDeliveryRate != 0.5 {
+		t.Errorf("Expected 2 outbound SMS with 1 delivered, got %+v", today)
+	}
+	if today.CallbacksRequested != 1 || today.CallbackCompletionRate != 1 {
+		t.Errorf("Expected 1 completed callback, got %+v", today)
+	}
+
+	from := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	if _, err := logsService.GetDailyAnalytics(ctx, from, from.AddDate(0, 0, -1)); err == nil {
+		t.Error("Expected from after to to be rejected")
+	}
+	if _, err := logsService.GetDailyAnalytics(ctx, from, from.AddDate(0, 0, maxAnalyticsDays)); err == nil {
+		t.Error("Expected a range over the maximum to be rejected")
+	}
+	week, err := logsService.GetDailyAnalytics(ctx, from, from.AddDate(0, 0, 6))
+	if err != nil || len(week.Days) != 7 || week.From != "2024-01-10" || week.Days[6].Day != "2024-01-16" {
+		t.Errorf("Expected 7 days from 2024-01-10, got %+v, %v", week, err)
+	}
+}
+
+func TestDailyAnalyticsCountsVerificationOnSendDay(t *testing.T) {
+	service, repo, _ := newTestService()
+	logsService := NewLogsService(repo)
+	ctx := context.Background()
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"}); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	// The code went out yesterday and is verified today
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	otp, _ := repo.OTP().FindByPhone(ctx, "+1234567890")
+	otp.LastSentAt = yesterday
+	repo.OTP().Update(ctx, otp)
+	if _, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: otp.Code}); err != nil {
+		t.Fatalf("Failed to verify OTP: %v", err)
+	}
+
+	analytics, err := logsService.GetDailyAnalytics(ctx, yesterday, time.Now())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := analytics.Days[0].OTPsVerified; got != 1 {
+		t.Errorf("Expected the verification on the send day, got %d", got)
+	}
+	if got := analytics.Days[1].OTPsVerified; got != 0 {
+		t.Errorf("Expected no verification today, got %d", got)
+	}
+}
+
+func TestRetryFailedSMS(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	mockClient := transport.NewMockSMSClient()
+	cfg := testConfig()
+	cfg.SMSRetry = RetryPolicy{MaxRetries: 2, MaxAge: time.Hour}
+	service := NewSMSService(repo, mockClient, WithConfig(cfg))
+	ctx := context.Background()
+
+	mockClient.Err = errors.New("carrier rejected")
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+
+	records, _ := repo.SMS().FindAll(ctx, 1, models.LogCursor{})
+	id := records[0].ID.Hex()
+	if records[0].Status != models.StatusFailed || records[0].FailedReason != "carrier rejected" {
+		t.Fatalf("Expected failed SMS with reason, got %+v", records[0])
+	}
+
+	service.RetryFailedSMS()
+	sms, _ := repo.SMS().FindByID(ctx, id)
+	if sms.Status != models.StatusFailed || sms.RetryCount != 1 {
+		t.Fatalf("Expected failed SMS after 1 retry, got status %s with %d retries", sms.Status, sms.RetryCount)
+	}
+
+	service.RetryFailedSMS()
+	sms, _ = repo.SMS().FindByID(ctx, id)
+	if sms.Status != models.StatusDead || sms.RetryCount != 2 {
+		t.Fatalf("Expected dead SMS after 2 retries, got status %s with %d retries", sms.Status, sms.RetryCount)
+	}
+
+	logs, _ := NewLogsService(repo).GetLogs(ctx, 10, models.LogCursor{}, models.SMSLogFilter{})
+	if dead := logs["sms"].(*models.SMSLogSection).DeadCount; dead != 1 {
+		t.Errorf("Expected dead_count 1, got %v", dead)
+	}
+
+	// A manual retry reprocesses dead messages
+	mockClient.Err = nil
+	sms, err := service.RetrySMS(ctx, id)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sms.Status != models.StatusSent || sms.RetryCount != 3 {
+		t.Errorf("Expected sent SMS after 3 retries, got status %s with %d retries", sms.Status, sms.RetryCount)
+	}
+}
+
+func TestRetrySMSRejectsSentMessage(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	records, _ := repo.SMS().FindAll(ctx, 1, models.LogCursor{})
+
+	_, err := service.RetrySMS(ctx, records[0].ID.Hex())
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 AppError, got %v", err)
+	}
+}
+
+func TestRetryFailedSMSClaimAndBackoff(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	mockClient := transport.NewMockSMSClient()
+	cfg := testConfig()
+	cfg.SMSRetry = RetryPolicy{MaxRetries: 5, Interval: time.Minute, MaxAge: 24 * time.Hour}
+	service := NewSMSService(repo, mockClient, WithConfig(cfg))
+	ctx := context.Background()
+
+	mockClient.Err = errors.New("carrier rejected")
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	records, _ := repo.SMS().FindAll(ctx, 1, models.LogCursor{})
+	id := records[0].ID.Hex()
+
+	retries := func() int {
+		sms, _ := repo.SMS().FindByID(ctx, id)
+		return sms.RetryCount
+	}
+	at := func(offset time.Duration) {
+		service.now = func() time.Time { return time.Now().Add(offset) }
+	}
+
+	// The first resend waits one interval, the second one two
+	service.RetryFailedSMS()
+	if got := retries(); got != 0 {
+		t.Fatalf("Expected no retry within the interval, got %d", got)
+	}
+	at(90 * time.Second)
+	service.RetryFailedSMS()
+	if got := retries(); got != 1 {
+		t.Fatalf("Expected 1 retry after the interval, got %d", got)
+	}
+	service.RetryFailedSMS()
+	if got := retries(); got != 1 {
+		t.Fatalf("Expected the second retry to back off, got %d retries", got)
+	}
+	at(3 * time.Minute)
+	service.RetryFailedSMS()
+	if got := retries(); got != 2 {
+		t.Fatalf("Expected 2 retries after backing off, got %d", got)
+	}
+
+	// A message claimed by another resend is left alone
+	if claimed, _ := repo.SMS().ClaimRetry(ctx, id, models.StatusFailed); !claimed {
+		t.Fatal("Expected to claim the failed message")
+	}
+	if claimed, _ := repo.SMS().ClaimRetry(ctx, id, models.StatusFailed); claimed {
+		t.Error("Expected a second claim to fail")
+	}
+	if _, err := service.RetrySMS(ctx, id); err == nil {
+		t.Error("Expected a manual retry of a claimed message to fail")
+	}
+	at(10 * time.Minute)
+	service.RetryFailedSMS()
+	if got := retries(); got != 2 {
+		t.Errorf("Expected no retry of a claimed message, got %d retries", got)
+	}
+
+	// A claim whose resend never finished is released and retried
+	at(retryClaimTimeout + time.Minute)
+	service.RetryFailedSMS()
+	sms, _ := repo.SMS().FindByID(ctx, id)
+	if sms.Status != models.StatusFailed || sms.RetryCount != 3 {
+		t.Errorf("Expected the stale claim to be released and retried, got status %s with %d retries", sms.Status, sms.RetryCount)
+	}
+}
+
+func TestSendSMSMessageLength(t *testing.T) {
+	service, _, mockClient := newTestService()
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{"GSM-7", strings.Repeat("a", 1531), "Message too long: 1531/1530 characters (GSM-7, 11 segments, max 10 segments)"},
+		{"UCS-2", strings.Repeat("я", 671), "Message too long: 671/670 characters (UCS-2, 11 segments, max 10 segments)"},
+		{"empty", "", "Message must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: tt.message})
+			appErr, ok := err.(*common.AppError)
+			if !ok || appErr.StatusCode != http.StatusBadRequest || appErr.Details != tt.expected {
+				t.Errorf("Expected 400 with details %q, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	// Multipart messages up to the cap are sent, and the cap is configurable
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: strings.Repeat("a", 1530)}); err != nil {
+		t.Errorf("Expected a 10 segment message to be sent, got %v", err)
+	}
+	service.config.MaxSMSSegments = 0
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: strings.Repeat("a", 1531)}); err != nil {
+		t.Errorf("Expected no cap with MaxSMSSegments 0, got %v", err)
+	}
+	if len(mockClient.Calls()) != 2 {
+		t.Errorf("Expected only the messages within the cap to be sent, got %d sends", len(mockClient.Calls()))
+	}
+}
+
+func TestSendSMSValidity(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	ctx := context.Background()
+
+	mockClient.Err = errors.New("carrier rejected")
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello", ValiditySeconds: 600})
+
+	calls := mockClient.Calls()
+	if len(calls) != 1 || calls[0].Validity != 10*time.Minute {
+		t.Errorf("Expected the validity to be passed to the client, got %+v", calls)
+	}
+	records, _ := repo.SMS().FindAll(ctx, 1, models.LogCursor{})
+	if records[0].ValiditySeconds != 600 {
+		t.Fatalf("Expected the validity to be stored, got %+v", records[0])
+	}
+	id := records[0].ID.Hex()
+
+	// A resend only asks for what is left of the period
+	mockClient.Err = nil
+	createdAt := records[0].CreatedAt
+	service.now = func() time.Time { return createdAt.Add(4 * time.Minute) }
+	if _, err := service.RetrySMS(ctx, id); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if calls = mockClient.Calls(); calls[len(calls)-1].Validity != 6*time.Minute {
+		t.Errorf("Expected a resend with 6 minutes of validity, got %v", calls[len(calls)-1].Validity)
+	}
+
+	// Once the period has elapsed the message is dead-lettered without a send
+	repo.SMS().UpdateFailure(ctx, id, models.StatusFailed, "carrier rejected")
+	service.now = func() time.Time { return createdAt.Add(11 * time.Minute) }
+	if _, err := service.RetrySMS(ctx, id); err == nil {
+		t.Fatal("Expected an expired message not to be resent")
+	}
+	sms, _ := repo.SMS().FindByID(ctx, id)
+	if sms.Status != models.StatusDead || len(mockClient.Calls()) != 2 {
+		t.Errorf("Expected a dead SMS and no new send, got status %s after %d sends", sms.Status, len(mockClient.Calls()))
+	}
+}
+
+func TestSendSMSWithSenderID(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	ctx := context.Background()
+
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello", SenderID: "ACMEBANK"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	calls := mockClient.Calls()
+	if len(calls) != 1 || calls[0].From != "ACMEBANK" {
+		t.Errorf("Expected the sender ID to be passed to the client, got %+v", calls)
+	}
+	records, _ := repo.SMS().FindAll(ctx, 1, models.LogCursor{})
+	if records[0].From != "ACMEBANK" || records[0].SenderID != "ACMEBANK" {
+		t.Errorf("Expected the sender ID to be stored, got %+v", records[0])
+	}
+
+	// Sender IDs outside the allowlist are rejected before anything is sent
+	_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello", SenderID: "OTHERBANK"})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a sender ID outside the allowlist to be forbidden, got %v", err)
+	}
+	if calls := mockClient.Calls(); len(calls) != 1 {
+		t.Errorf("Expected no send with a forbidden sender ID, got %+v", calls)
+	}
+}
+
+func TestParseSenderIDs(t *testing.T) {
+	senderIDs, err := ParseSenderIDs(" ACME, +15550000000 ,,")
+	if err != nil || len(senderIDs) != 2 || senderIDs[0] != "ACME" || senderIDs[1] != "+15550000000" {
+		t.Errorf("Expected both sender IDs, got %v, %v", senderIDs, err)
+	}
+	if _, err := ParseSenderIDs("ACME BANK LTD"); err == nil {
+		t.Error("Expected an invalid sender ID to be rejected")
+	}
+}
+
+// countingOTPRepository counts IncrementAttempts calls
+type countingOTPRepository struct {
+	repository.OTPRepository
+	increments *int
+}
+
+func (r countingOTPRepository) IncrementAttempts(ctx context.Context, phone string) (int, bool, error) {
+	*r.increments++
+	return r.OTPRepository.IncrementAttempts(ctx, phone)
+}
+
+type countingRepository struct {
+	*repository.InMemoryRepository
+	increments *int
+}
+
+func (r countingRepository) OTP() repository.OTPRepository {
+	return countingOTPRepository{r.InMemoryRepository.OTP(), r.increments}
+}
+
+func TestVerifyOTPCorrectCodeFirstTry(t *testing.T) {
+	var increments int
+	repo := countingRepository{repository.NewInMemoryRepository(), &increments}
+	cfg := testConfig()
+	cfg.OTPVerifyGrace = 0
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+
+	verifyResp, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: response.OTP})
+	if err != nil || !verifyResp.Valid {
+		t.Fatalf("Expected correct code to verify, got %+v, %v", verifyResp, err)
+	}
+	if increments != 0 {
+		t.Errorf("Expected a successful verification not to count as an attempt, got %d increments", increments)
+	}
+	if _, err := repo.OTP().FindByPhone(ctx, "+1234567890"); err == nil {
+		t.Error("Expected OTP to be deleted after verification")
+	}
+}
+
+func TestVerifyOTPAttemptsBoundary(t *testing.T) {
+	cfg := testConfig()
+	cfg.OTPLockout = 0
+	repo := repository.NewInMemoryRepository()
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+	phone := "+1234567890"
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	wrongCode := "000000"
+	if response.OTP == wrongCode {
+		wrongCode = "111111"
+	}
+
+	// MaxAttempts-1 wrong codes still leave room for the correct one
+	for i := 1; i < 3; i++ {
+		service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: wrongCode})
+		if stored, _ := repo.OTP().FindByPhone(ctx, phone); stored.Attempts != i {
+			t.Fatalf("Expected %d recorded attempts, got %d", i, stored.Attempts)
+		}
+	}
+	verifyResp, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: response.OTP})
+	if err != nil || !verifyResp.Valid {
+		t.Errorf("Expected correct code after 2 wrong codes to verify, got %+v, %v", verifyResp, err)
+	}
+}
+
+func TestVerifyOTPConcurrentWrongCodes(t *testing.T) {
+	cfg := testConfig()
+	cfg.VerifyFailureBackoff = BackoffPolicy{}
+	repo := repository.NewInMemoryRepository()
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+	phone := "+1234567890"
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	wrongCode := "000000"
+	if response.OTP == wrongCode {
+		wrongCode = "111111"
+	}
+
+	// Wrong codes racing past the MaxAttempts check still count only up to it
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: wrongCode})
+		}()
+	}
+	wg.Wait()
+
+	stored, _ := repo.OTP().FindByPhone(ctx, phone)
+	if stored.Attempts != stored.MaxAttempts {
+		t.Errorf("Expected attempts to stop at %d, got %d", stored.MaxAttempts, stored.Attempts)
+	}
+}
+
+func TestVerifyExpiredOTP(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	stored, _ := repo.OTP().FindByPhone(ctx, "+1234567890")
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	repo.OTP().Update(ctx, stored)
+
+	verifyResp, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: response.OTP})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if verifyResp.Valid || verifyResp.Message != "OTP expired. Please request a new OTP." {
+		t.Errorf("Expected expired OTP to be rejected, got %+v", verifyResp)
+	}
+	if _, err := repo.OTP().FindByPhone(ctx, "+1234567890"); err == nil {
+		t.Error("Expected expired OTP to be deleted")
+	}
+}
+
+// stalledOTPRepository blocks FindExpired until its context is done, like a
+// Mongo server that stopped responding
+type stalledOTPRepository struct {
+	repository.OTPRepository
+}
+
+func (r stalledOTPRepository) FindExpired(ctx context.Context) ([]*models.OTP, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type stalledRepository struct {
+	*repository.InMemoryRepository
+}
+
+func (r stalledRepository) OTP() repository.OTPRepository {
+	return stalledOTPRepository{r.InMemoryRepository.OTP()}
+}
+
+func TestCleanupExpiredOTPsIsBounded(t *testing.T) {
+	cfg := testConfig()
+	cfg.JobTimeout = 20 * time.Millisecond
+	service := NewSMSService(stalledRepository{repository.NewInMemoryRepository()}, transport.NewMockSMSClient(), WithConfig(cfg))
+
+	done := make(chan struct{})
+	go func() {
+		service.CleanupExpiredOTPs()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected cleanup to give up once the job timeout expired")
+	}
+}
+
+func TestCleanupSweepsStaleOTPs(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	cfg := testConfig()
+	cfg.MaxOTPLifetime = time.Hour
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+
+	// An OTP whose expiry was never set to something sensible
+	stale := &models.OTP{Phone: "+1234567890", Code: "123456", MaxAttempts: 3, ExpiresAt: time.Now().Add(365 * 24 * time.Hour)}
+	if err := repo.OTP().Create(ctx, stale); err != nil {
+		t.Fatalf("Failed to create OTP: %v", err)
+	}
+
+	service.CleanupExpiredOTPs()
+	if otp, _ := repo.OTP().FindByPhone(ctx, stale.Phone); otp == nil {
+		t.Fatal("Expected an OTP within its lifetime to be kept")
+	}
+
+	service.now = func() time.Time { return time.Now().Add(time.Hour + time.Minute) }
+	service.CleanupExpiredOTPs()
+	if otp, _ := repo.OTP().FindByPhone(ctx, stale.Phone); otp != nil {
+		t.Errorf("Expected the OTP to be swept after its maximum lifetime, got %+v", otp)
+	}
+}
+
+func TestPurgeOldRecords(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	cfg := testConfig()
+	cfg.RetentionPeriod = 30 * 24 * time.Hour
+	cfg.RetentionPurgeInterval = 0
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(cfg))
+	ctx := context.Background()
+
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"})
+	repo.Callback().Create(ctx, &models.Callback{PhoneNumber: "+1234567890", Status: models.StatusRequested})
+	repo.Audit().Create(ctx, &models.Audit{Type: "sms.status", TargetID: "sms_1"})
+	day := time.Now().UTC().Format("2006-01-02")
+
+	service.PurgeOldRecords()
+	if sms, _ := repo.SMS().FindAll(ctx, 0, models.LogCursor{}); len(sms) != 1 {
+		t.Fatalf("Expected records within the retention period to be kept, got %d SMS", len(sms))
+	}
+	if count, _ := repo.OTPSends().Count(ctx, "+1234567890", day); count != 1 {
+		t.Fatalf("Expected the OTP send counter to be kept, got %d", count)
+	}
+
+	now := time.Now()
+	// Send counters are kept per day, so they go once their whole day is past
+	service.now = func() time.Time { return now.Add(cfg.RetentionPeriod + 24*time.Hour) }
+	service.PurgeOldRecords()
+	if sms, _ := repo.SMS().FindAll(ctx, 0, models.LogCursor{}); len(sms) != 0 {
+		t.Errorf("Expected old SMS to be purged, got %d", len(sms))
+	}
+	if otps, _ := repo.OTP().FindAll(ctx, 0, models.LogCursor{}); len(otps) != 0 {
+		t.Errorf("Expected old OTPs to be purged, got %d", len(otps))
+	}
+	if callbacks, _ := repo.Callback().FindAll(ctx, 0, models.LogCursor{}); len(callbacks) != 0 {
+		t.Errorf("Expected old callbacks to be purged, got %d", len(callbacks))
+	}
+	if count, _ := repo.OTPSends().Count(ctx, "+1234567890", day); count != 0 {
+		t.Errorf("Expected old OTP send counters to be purged, got %d", count)
+	}
+	if count, _ := repo.Audit().Count(ctx, models.AuditFilter{}); count != 0 {
+		t.Errorf("Expected old audit records to be purged, got %d", count)
+	}
+}
+
+func TestOTPPhoneNormalization(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	ctx := context.Background()
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1 555 123 4567"}); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	if _, err := repo.OTP().FindByPhone(ctx, "+15551234567"); err != nil {
+		t.Fatalf("Expected OTP to be stored under the normalized number, got %v", err)
+	}
+
+	status, err := service.GetOTPStatus(ctx, "+1 (555) 123-4567")
+	if err != nil || !status.HasActiveOTP {
+		t.Errorf("Expected an active OTP for the formatted number, got %+v, %v", status, err)
+	}
+
+	calls := mockClient.Calls()
+	code := calls[len(calls)-1].Body
+	response, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+15551234567", OTP: code})
+	if err != nil || !response.Success {
+		t.Errorf("Expected verification without spaces to succeed, got %+v, %v", response, err)
+	}
+}
+
+func TestSendSMSProviderSelection(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	defaultClient := transport.NewMockSMSClient()
+	twilio := transport.NewMockClient(models.ProviderTwilio, transport.WithMockCapture())
+	service := NewSMSService(repo, defaultClient, WithProviders(twilio))
+	ctx := context.Background()
+
+	response, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello", Provider: models.ProviderTwilio})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sent := twilio.SentMessages(); len(sent) != 1 || len(defaultClient.Calls()) != 0 {
+		t.Errorf("Expected the message to go through the selected provider only")
+	}
+	sms, _ := repo.SMS().FindByID(ctx, response.ID)
+	if sms.Provider != models.ProviderTwilio {
+		t.Errorf("Expected provider %q to be stored, got %q", models.ProviderTwilio, sms.Provider)
+	}
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1987654321", Provider: models.ProviderTwilio}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if sent := twilio.SentMessages(); len(sent) != 2 || sent[1].Method != "SendOTP" {
+		t.Errorf("Expected the OTP to go through the selected provider, got %+v", sent)
+	}
+
+	_, err = service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello", Provider: "carrier-pigeon"})
+	appErr, ok := err.(*common.AppError)
+	if !ok || appErr.StatusCode != http.StatusBadRequest || !strings.Contains(appErr.Details, "mock, twilio") {
+		t.Errorf("Expected validation error listing the available providers, got %v", err)
+	}
+}
+
+func TestCircuitBreakers(t *testing.T) {
+	breakers := newCircuitBreakers()
+	policy := BreakerPolicy{MinRequests: 4, FailureRatio: 0.5, Window: time.Minute, OpenFor: 30 * time.Second}
+	now := time.Now()
+
+	// Failures below MinRequests or the ratio keep the circuit closed
+	breakers.Success("p", policy, now)
+	breakers.Success("p", policy, now)
+	breakers.Failure("p", policy, now)
+	if state := breakers.State("p", policy, now); state != models.CircuitClosed {
+		t.Fatalf("Expected a closed circuit, got %s", state)
+	}
+	breakers.Failure("p", policy, now)
+	if err := breakers.Allow("p", policy, now); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("Expected the circuit to open at half the calls failing, got %v", err)
+	}
+
+	// After OpenFor a single probe is let through
+	now = now.Add(policy.OpenFor)
+	if state := breakers.State("p", policy, now); state != models.CircuitHalfOpen {
+		t.Errorf("Expected a half-open circuit, got %s", state)
+	}
+	if err := breakers.Allow("p", policy, now); err != nil {
+		t.Fatalf("Expected the probe to be allowed, got %v", err)
+	}
+	if err := breakers.Allow("p", policy, now); err == nil {
+		t.Error("Expected only one probe at a time")
+	}
+
+	// A failed probe reopens the circuit, a successful one closes it
+	breakers.Failure("p", policy, now)
+	if breakers.Ready("p", policy, now) {
+		t.Error("Expected a failed probe to reopen the circuit")
+	}
+	now = now.Add(policy.OpenFor)
+	breakers.Allow("p", policy, now)
+	breakers.Success("p", policy, now)
+	if state := breakers.State("p", policy, now); state != models.CircuitClosed {
+		t.Errorf("Expected a successful probe to close the circuit, got %s", state)
+	}
+
+	// Failures in an earlier window are forgotten
+	breakers.Failure("p", policy, now)
+	breakers.Failure("p", policy, now)
+	breakers.Failure("p", policy, now)
+	now = now.Add(policy.Window)
+	breakers.Failure("p", policy, now)
+	if !breakers.Ready("p", policy, now) {
+		t.Error("Expected failures of the previous window not to count")
+	}
+}
+
+// rotatingSMSClient hands out its sender numbers in turn
+type rotatingSMSClient struct {
+	*transport.MockSMSClient
+	numbers []string
+	next    int
+}
+
+func (c *rotatingSMSClient) NextSender() string {
+	number := c.numbers[c.next%len(c.numbers)]
+	c.next++
+	return number
+}
+
+func TestSendSMSSenderRotation(t *testing.T) {
+	mockClient := transport.NewMockSMSClient()
+	client := &rotatingSMSClient{MockSMSClient: mockClient, numbers: []string{"+15550000001", "+15550000002"}}
+	repo := repository.NewInMemoryRepository()
+	service := NewSMSService(repo, client, WithConfig(testConfig()))
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		response, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		ids = append(ids, response.ID)
+	}
+	// An explicit sender ID is used as is
+	service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello", SenderID: "ACME"})
+
+	calls := mockClient.Calls()
+	for i, want := range []string{"+15550000001", "+15550000002", "+15550000001", "ACME"} {
+		if calls[i].From != want {
+			t.Errorf("Send %d: expected to go out from %s, got %q", i, want, calls[i].From)
+		}
+	}
+	for i, id := range ids {
+		sms, _ := repo.SMS().FindByID(ctx, id)
+		if sms.From != calls[i].From {
+			t.Errorf("Expected message %d to record sender %s, got %s", i, calls[i].From, sms.From)
+		}
+	}
+
+	// Retries go out from the recorded number rather than the next in rotation
+	mockClient.Err = errors.New("carrier unreachable")
+	response, _ := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	mockClient.Err = nil
+	if _, err := service.RetrySMS(ctx, response.ID); err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	calls = mockClient.Calls()
+	if sent, retried := calls[len(calls)-2].From, calls[len(calls)-1].From; sent != "+15550000002" || retried != sent {
+		t.Errorf("Expected the retry to go out from %s like the first attempt, got %s", sent, retried)
+	}
+}
+
+// slowSMSClient sends nothing until its context is done
+type slowSMSClient struct {
+	*transport.MockSMSClient
+}
+
+func (c slowSMSClient) SendSMS(ctx context.Context, from, to, message string, validity time.Duration, mediaURLs []string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSendSMSProviderCircuit(t *testing.T) {
+	mockClient := transport.NewMockSMSClient()
+	cfg := testConfig()
+	cfg.ProviderTimeout = 20 * time.Millisecond
+	cfg.ProviderBreaker = BreakerPolicy{MinRequests: 2, FailureRatio: 1, Window: time.Minute, OpenFor: time.Minute}
+	service := NewSMSService(repository.NewInMemoryRepository(), slowSMSClient{mockClient}, WithConfig(cfg))
+	ctx := context.Background()
+
+	// Calls that outlive the provider timeout fail and trip the breaker
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+		if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeProviderFailed {
+			t.Fatalf("Expected a provider error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("Expected the call to be cut off by the provider timeout, took %v", elapsed)
+		}
+	}
+
+	// While open, sends fail fast with 503
+	_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while the circuit is open, got %v", err)
+	}
+	health, _ := service.ProviderHealth(ctx, "")
+	if health.Circuit != models.CircuitOpen {
+		t.Errorf("Expected the health check to report the open circuit, got %+v", health)
+	}
+}
+
+func TestProviderHealthIsCached(t *testing.T) {
+	service, _, mockClient := newTestService()
+	ctx := context.Background()
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	countChecks := func() int {
+		checks := 0
+		for _, call := range mockClient.Calls() {
+			if call.Method == "ProviderStatus" {
+				checks++
+			}
+		}
+		return checks
+	}
+
+	health, err := service.ProviderHealth(ctx, "")
+	if err != nil || !health.Healthy || health.Provider != "mock" {
+		t.Fatalf("Expected healthy mock provider, got %+v, %v", health, err)
+	}
+	service.ProviderHealth(ctx, "")
+	if checks := countChecks(); checks != 1 {
+		t.Errorf("Expected the second check to be served from cache, got %d provider calls", checks)
+	}
+
+	// Failures are reported and cached once the previous result expires
+	mockClient.Err = errors.New("invalid credentials")
+	now = now.Add(service.config.ProviderHealthTTL)
+	health, _ = service.ProviderHealth(ctx, "")
+	if health.Healthy || health.Error != "invalid credentials" || countChecks() != 2 {
+		t.Errorf("Expected a fresh unhealthy result, got %+v after %d calls", health, countChecks())
+	}
+
+	if _, err := service.ProviderHealth(ctx, "unknown"); err == nil {
+		t.Error("Expected error for an unknown provider")
+	}
+}
+
+func TestPollDeliveryStatus(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	ctx := context.Background()
+
+	tracked := &models.SMS{To: "+1234567890", Message: "Hello", Status: models.StatusSent, Provider: "mock", ProviderID: "uuid-1"}
+	untracked := &models.SMS{To: "+1234567891", Message: "Hello", Status: models.StatusSent, Provider: "mock"}
+	repo.SMS().Create(ctx, tracked)
+	repo.SMS().Create(ctx, untracked)
+
+	// Messages past the max age are no longer polled
+	service.now = func() time.Time { return time.Now().Add(service.config.StatusPollMaxAge + time.Hour) }
+	service.PollDeliveryStatus()
+	if calls := mockClient.Calls(); len(calls) != 0 {
+		t.Fatalf("Expected no status lookups for expired messages, got %+v", calls)
+	}
+
+	service.now = time.Now
+	service.PollDeliveryStatus()
+	calls := mockClient.Calls()
+	if len(calls) != 1 || calls[0].Method != "FetchStatus" || calls[0].To != "uuid-1" {
+		t.Fatalf("Expected a single status lookup for uuid-1, got %+v", calls)
+	}
+
+	sms, _ := repo.SMS().FindByID(ctx, tracked.ID.Hex())
+	if sms.Status != models.StatusDelivered || sms.DeliveredAt == nil {
+		t.Errorf("Expected delivered SMS with a delivery time, got %+v", sms)
+	}
+	if sms, _ := repo.SMS().FindByID(ctx, untracked.ID.Hex()); sms.Status != models.StatusSent {
+		t.Errorf("Expected SMS without a provider ID to be left alone, got %s", sms.Status)
+	}
+
+	// Delivered messages are terminal and not polled again
+	service.PollDeliveryStatus()
+	if calls := mockClient.Calls(); len(calls) != 1 {
+		t.Errorf("Expected delivered message not to be polled again, got %d lookups", len(calls))
+	}
+}
+
+func TestSendOTPResendCooldown(t *testing.T) {
+	service, _, mockClient := newTestService()
+	ctx := context.Background()
+	req := models.OTPRequest{PhoneNumber: "+1234567890"}
+
+	first, err := service.SendOTP(ctx, req)
+	if err != nil || !first.Success {
+		t.Fatalf("Expected first OTP to be sent, got %+v, %v", first, err)
+	}
+
+	sentAt := time.Now()
+	service.now = func() time.Time { return sentAt.Add(service.config.OTPResendCooldown - time.Second) }
+	second, err := service.SendOTP(ctx, req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if second.Success || len(mockClient.Calls()) != 1 {
+		t.Fatalf("Expected resend within the cooldown to be rejected, got %+v", second)
+	}
+
+	service.now = func() time.Time { return sentAt.Add(service.config.OTPResendCooldown + time.Second) }
+	third, err := service.SendOTP(ctx, req)
+	if err != nil || !third.Success {
+		t.Fatalf("Expected resend after the cooldown to succeed, got %+v, %v", third, err)
+	}
+	if len(mockClient.Calls()) != 2 {
+		t.Errorf("Expected a new OTP to be sent, got %d sends", len(mockClient.Calls()))
+	}
+}
+
+func TestOTPLockoutCooldown(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+	phone := "+1234567890"
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	wrongCode := "000000"
+	if response.OTP == wrongCode {
+		wrongCode = "111111"
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: wrongCode}); err != nil {
+			t.Fatalf("Expected wrong code to be rejected without error on attempt %d, got %v", i+1, err)
+		}
+	}
+
+	stored, _ := repo.OTP().FindByPhone(ctx, phone)
+	if stored.LockedUntil.IsZero() || stored.ExpiresAt.Before(stored.LockedUntil) {
+		t.Fatalf("Expected OTP to be locked and kept for the lockout, got %+v", stored)
+	}
+
+	// Both verification and new OTP requests are blocked while locked out
+	_, err = service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: response.OTP})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeMaxAttempts || appErr.RetryAfter != 900 {
+		t.Fatalf("Expected max attempts error with retry after 900s, got %v", err)
+	} else if appErr.RemainingAttempts == nil || *appErr.RemainingAttempts != 0 {
+		t.Errorf("Expected the lockout to report no remaining attempts, got %v", appErr.RemainingAttempts)
+	}
+	service.now = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	_, err = service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeMaxAttempts || appErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected new OTP to be blocked during the lockout, got %v", err)
+	}
+
+	// Once the lockout ends a fresh OTP with a new attempt budget can be requested
+	service.now = func() time.Time { return time.Now().Add(16 * time.Minute) }
+	response, err = service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+	if err != nil || !response.Success {
+		t.Fatalf("Expected OTP after the lockout to be sent, got %+v, %v", response, err)
+	}
+	if stored, _ := repo.OTP().FindByPhone(ctx, phone); stored.Attempts != 0 || !stored.LockedUntil.IsZero() {
+		t.Errorf("Expected a fresh OTP, got %+v", stored)
+	}
+}
+
+func TestGetSMSStatuses(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+
+	sent, _ := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+	delivered, _ := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567891", Message: "Hello"})
+	deliveredAt := time.Now()
+	repo.SMS().UpdateStatus(ctx, delivered.ID, models.StatusDelivered)
+	repo.SMS().UpdateDeliveryTime(ctx, delivered.ID, deliveredAt)
+	missing := primitive.NewObjectID().Hex()
+
+	response, err := service.GetSMSStatuses(ctx, []string{sent.ID, delivered.ID, missing}, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if status := response.Statuses[sent.ID]; status.Status != models.StatusSent || status.DeliveredAt != nil {
+		t.Errorf("Expected sent status without delivery time, got %+v", status)
+	}
+	if status := response.Statuses[delivered.ID]; status.Status != models.StatusDelivered || status.DeliveredAt == nil || !status.DeliveredAt.Equal(deliveredAt) {
+		t.Errorf("Expected delivered status with delivery time, got %+v", status)
+	}
+	if len(response.NotFound) != 1 || response.NotFound[0] != missing {
+		t.Errorf("Expected %s to be reported as not found, got %v", missing, response.NotFound)
+	}
+
+	// Another user's messages are reported as not found
+	owned, _ := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567892", Message: "Hello", UserID: "user-1"})
+	response, err = service.GetSMSStatuses(ctx, []string{owned.ID, sent.ID}, "user-1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := response.Statuses[owned.ID]; !ok || len(response.Statuses) != 1 || len(response.NotFound) != 1 || response.NotFound[0] != sent.ID {
+		t.Errorf("Expected only the caller's message to be found, got %+v", response)
+	}
+
+	_, err = service.GetSMSStatuses(ctx, []string{sent.ID, "not-an-id"}, "")
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Fields["ids[1]"] == "" {
+		t.Errorf("Expected field error for the invalid ID, got %v", err)
+	}
+
+	tooMany := make([]string, maxBatchStatusIDs+1)
+	for i := range tooMany {
+		tooMany[i] = sent.ID
+	}
+	if _, err := service.GetSMSStatuses(ctx, tooMany, ""); err == nil {
+		t.Error("Expected error for a batch over the cap")
+	}
+}
+
+func TestSendOTPHidesCodeByDefault(t *testing.T) {
+	service := NewSMSService(repository.NewInMemoryRepository(), transport.NewMockSMSClient())
+
+	response, err := service.SendOTP(context.Background(), models.OTPRequest{PhoneNumber: "+1234567890"})
+	if err != nil || !response.Success {
+		t.Fatalf("Expected OTP to be sent, got %+v, %v", response, err)
+	}
+	if response.OTP != "" {
+		t.Errorf("Expected OTP not to be exposed by default, got %q", response.OTP)
+	}
+
+	body, _ := json.Marshal(response)
+	if strings.Contains(string(body), `"otp"`) {
+		t.Errorf("Expected otp field to be omitted, got %s", body)
+	}
+}
+
+func TestTenantRepositoriesAreIsolated(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	tenants := repository.NewInMemoryRepositoryFactory("acme")
+	service := NewSMSService(repo, transport.NewMockSMSClient(), WithConfig(testConfig()), WithTenantRepositories(tenants))
+
+	acme, _ := tenants.ForTenant(context.Background(), "acme")
+	ctx := repository.WithRepository(context.Background(), acme)
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if records, _ := acme.SMS().FindByPhone(context.Background(), "+1234567890", 10); len(records) != 1 {
+		t.Errorf("Expected the SMS in the tenant repository, got %d records", len(records))
+	}
+	if records, _ := repo.SMS().FindByPhone(context.Background(), "+1234567890", 10); len(records) != 0 {
+		t.Errorf("Expected nothing in the default repository, got %d records", len(records))
+	}
+
+	acme.OTP().Create(context.Background(), &models.OTP{
+		Phone:     "+1234567890",
+		Code:      "123456",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	service.CleanupExpiredOTPs()
+	if _, err := acme.OTP().FindByPhone(context.Background(), "+1234567890"); err == nil {
+		t.Error("Expected cleanup to remove the tenant's expired OTP")
+	}
+}
+
+func TestEstimateSMSCost(t *testing.T) {
+	cfg := testConfig()
+	cfg.SegmentRates = map[string]float64{"1": 0.01, "44": 0.04}
+	cfg.DefaultSegmentRate = 0.05
+	service := NewSMSService(repository.NewInMemoryRepository(), transport.NewMockSMSClient(), WithConfig(cfg))
+
+	// 200 GSM-7 characters take two segments
+	message := strings.Repeat("a", 200)
+	recipients := []string{"+15551234567", "+15557654321", "+442079460958", "+353851234567"}
+	estimate, err := service.EstimateSMSCost(context.Background(), recipients, message)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if estimate.Recipients != 4 || estimate.SegmentsPerMessage != 2 || estimate.TotalSegments != 8 || estimate.Encoding != common.EncodingGSM7 {
+		t.Errorf("Unexpected segment counts %+v", estimate)
+	}
+	want := []models.CountryEstimate{
+		{CallingCode: "1", Recipients: 2, Segments: 4, Rate: 0.01, Cost: 0.04},
+		{CallingCode: "353", Recipients: 1, Segments: 2, Rate: 0.05, Cost: 0.1},
+		{CallingCode: "44", Recipients: 1, Segments: 2, Rate: 0.04, Cost: 0.08},
+	}
+	if fmt.Sprint(estimate.Countries) != fmt.Sprint(want) {
+		t.Errorf("Expected breakdown %+v, got %+v", want, estimate.Countries)
+	}
+	if estimate.TotalCost != 0.22 || estimate.Currency != "USD" {
+		t.Errorf("Expected a total of 0.22 USD, got %v %s", estimate.TotalCost, estimate.Currency)
+	}
+
+	_, err = service.EstimateSMSCost(context.Background(), []string{"+15551234567", "5551234567"}, message)
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Fields["recipients[1]"] == "" {
+		t.Errorf("Expected field error for the invalid recipient, got %v", err)
+	}
+}
+
+func TestParseSegmentRates(t *testing.T) {
+	rates, err := ParseSegmentRates("1:0.0075, 44:0.04,353:0.06")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(rates) != 3 || rates["1"] != 0.0075 || rates["44"] != 0.04 || rates["353"] != 0.06 {
+		t.Errorf("Unexpected rates %v", rates)
+	}
+
+	for _, value := range []string{"4:0.01", "44", "44:free", "44:-1"} {
+		if _, err := ParseSegmentRates(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestDestinationCountryRestrictions(t *testing.T) {
+	cfg := testConfig()
+	cfg.AllowedCallingCodes = []string{"1", "44"}
+	cfg.BlockedCallingCodes = []string{"44"}
+	mockClient := transport.NewMockSMSClient()
+	service := NewSMSService(repository.NewInMemoryRepository(), mockClient, WithConfig(cfg))
+	ctx := context.Background()
+
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+15551234567", Message: "Hello"}); err != nil {
+		t.Errorf("Expected send to an allowed country to succeed, got %v", err)
+	}
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+15551234567"}); err != nil {
+		t.Errorf("Expected OTP to an allowed country to succeed, got %v", err)
+	}
+
+	for _, phone := range []string{"+442079460958", "+919876543210"} {
+		_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: phone, Message: "Hello"})
+		if appErr, ok := err.(*common.AppError); !ok || appErr.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected send to be rejected with 400, got %v", phone, err)
+		}
+		if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone}); err == nil {
+			t.Errorf("%s: expected OTP to be rejected", phone)
+		}
+	}
+	if calls := mockClient.Calls(); len(calls) != 2 {
+		t.Errorf("Expected only the allowed sends to reach the provider, got %+v", calls)
+	}
+
+	// Unconfigured lists allow every country
+	service, _, _ = newTestService()
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+919876543210", Message: "Hello"}); err != nil {
+		t.Errorf("Expected every country to be allowed by default, got %v", err)
+	}
+}
+
+func TestSendOTPSenderName(t *testing.T) {
+	cfg := testConfig()
+	cfg.OTPSenderName = "ACMEBANK"
+	cfg.OTPSenderCallingCodes = []string{"44"}
+	mockClient := transport.NewMockSMSClient()
+	service := NewSMSService(repository.NewInMemoryRepository(), mockClient, WithConfig(cfg))
+	ctx := context.Background()
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+442079460958"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+15551234567"}); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+
+	calls := mockClient.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 sends, got %+v", calls)
+	}
+	if calls[0].Method != "SendSMS" || calls[0].From != "ACMEBANK" || !strings.Contains(calls[0].Body, response.OTP) {
+		t.Errorf("Expected the OTP to a listed country from the sender name, got %+v", calls[0])
+	}
+	if calls[1].Method != "SendOTP" || calls[1].From != "" {
+		t.Errorf("Expected the OTP to another country from the from-number, got %+v", calls[1])
+	}
+}
+
+func TestParseCallingCodes(t *testing.T) {
+	codes, err := ParseCallingCodes("1, +44,353")
+	if err != nil || fmt.Sprint(codes) != "[1 44 353]" {
+		t.Errorf("Expected [1 44 353], got %v (%v)", codes, err)
+	}
+	if codes, err := ParseCallingCodes(""); err != nil || len(codes) != 0 {
+		t.Errorf("Expected no codes for an empty list, got %v (%v)", codes, err)
+	}
+	if _, err := ParseCallingCodes("1,4"); err == nil {
+		t.Error("Expected error for an incomplete calling code")
+	}
+}
+
+func TestContentBlocklist(t *testing.T) {
+	patterns, err := ParseContentBlocklist("# gambling\n\\bcasino\\b\n\nfree\\s+money\n")
+	if err != nil || len(patterns) != 2 {
+		t.Fatalf("Expected 2 patterns, got %v (%v)", patterns, err)
+	}
+	if _, err := ParseContentBlocklist("ok\n(unclosed"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error naming line 2, got %v", err)
+	}
+
+	cfg := testConfig()
+	cfg.BlockedContent = patterns
+	mockClient := transport.NewMockSMSClient()
+	repo := repository.NewInMemoryRepository()
+	service := NewSMSService(repo, mockClient, WithConfig(cfg))
+	ctx := context.Background()
+
+	for _, message := range []string{"Visit our CASINO tonight", "Claim your free   money now"} {
+		_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+15551234567", Message: message})
+		appErr, ok := err.(*common.AppError)
+		if !ok || appErr.Code != common.ErrCodeValidation {
+			t.Fatalf("%q: expected a validation error, got %v", message, err)
+		}
+		if strings.Contains(strings.ToLower(appErr.Message+appErr.Details), "casino") || strings.Contains(appErr.Message+appErr.Details, "money") {
+			t.Errorf("Expected the error not to echo the blocked content, got %+v", appErr)
+		}
+	}
+
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+15551234567", Message: "Casinos aside, see you at 5"}); err != nil {
+		t.Errorf("Expected whole-word patterns to allow other words, got %v", err)
+	}
+	if calls := mockClient.Calls(); len(calls) != 1 {
+		t.Errorf("Expected only the allowed message to reach the provider, got %+v", calls)
+	}
+	if stored, _ := repo.SMS().FindByPhone(ctx, "+15551234567", 10); len(stored) != 1 {
+		t.Errorf("Expected blocked messages not to be stored, got %d", len(stored))
+	}
+}
+
+// seededOTPGenerator produces a reproducible sequence of codes
+type seededOTPGenerator struct {
+	rng *mathrand.Rand
+}
+
+func (g seededOTPGenerator) Generate(length int) (string, error) {
+	return fmt.Sprintf("%0*d", length, g.rng.Intn(1000000)), nil
+}
+
+func TestSendOTPUsesGenerator(t *testing.T) {
+	ctx := context.Background()
+	codes := func() []string {
+		generator := seededOTPGenerator{mathrand.New(mathrand.NewSource(42))}
+		service := NewSMSService(repository.NewInMemoryRepository(), transport.NewMockSMSClient(),
+			WithConfig(testConfig()), WithOTPGenerator(generator))
+
+		var codes []string
+		for _, phone := range []string{"+1234567890", "+1234567891"} {
+			response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			codes = append(codes, response.OTP)
+		}
+		return codes
+	}
+
+	first, second := codes(), codes()
+	if fmt.Sprint(first) != fmt.Sprint(second) {
+		t.Errorf("Expected the same seed to produce the same codes, got %v and %v", first, second)
+	}
+}
+
+type badOTPGenerator struct{}
+
+func (badOTPGenerator) Generate(length int) (string, error) {
+	return "12ab", nil
+}
+
+func TestSendOTPRejectsMalformedGeneratedCode(t *testing.T) {
+	service := NewSMSService(repository.NewInMemoryRepository(), transport.NewMockSMSClient(), WithOTPGenerator(badOTPGenerator{}))
+	if _, err := service.SendOTP(context.Background(), models.OTPRequest{PhoneNumber: "+1234567890"}); err == nil {
+		t.Error("Expected an unverifiable generated code to be rejected")
+	}
+}
+
+func TestRandomOTPGenerator(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		otp, err := RandomOTPGenerator{}.Generate(otpLength)
+		if err != nil || len(otp) != otpLength || strings.Trim(otp, "0123456789") != "" {
+			t.Fatalf("Expected %d random digits, got %q (%v)", otpLength, otp, err)
+		}
+	}
+}
+
+func TestVerifyOTPRetryWithinGrace(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	response, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	verifyFrom := func(clientID, code string) *models.VerifyOTPResponse {
+		verifyResp, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1234567890", OTP: code, ClientID: clientID})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return verifyResp
+	}
+	verify := func(code string) *models.VerifyOTPResponse {
+		return verifyFrom("203.0.113.7|app/1.0", code)
+	}
+
+	if !verify(response.OTP).Valid {
+		t.Fatal("Expected the first verification to succeed")
+	}
+	stored, err := repo.OTP().FindByPhone(ctx, "+1234567890")
+	if err != nil || !stored.Verified || !stored.VerifiedAt.Equal(now) || !stored.ExpiresAt.Equal(now.Add(service.config.OTPVerifyGrace)) {
+		t.Fatalf("Expected the OTP to be kept as verified until the grace window ends, got %+v (%v)", stored, err)
+	}
+
+	// A retried request with the same code succeeds again and uses an attempt,
+	// another code doesn't
+	if !verify(response.OTP).Valid {
+		t.Error("Expected a retried verification to succeed within the grace window")
+	}
+	if stored, _ := repo.OTP().FindByPhone(ctx, "+1234567890"); stored.Attempts != 1 {
+		t.Errorf("Expected the retry to count as an attempt, got %d", stored.Attempts)
+	}
+	wrong := "000000"
+	if response.OTP == wrong {
+		wrong = "111111"
+	}
+	if verify(wrong).Valid {
+		t.Error("Expected a different code to be rejected after verification")
+	}
+
+	// Another client can't replay the code
+	if verifyFrom("198.51.100.9|curl/8.0", response.OTP).Valid {
+		t.Error("Expected a replay from another client to be rejected")
+	}
+	if verifyFrom("", response.OTP).Valid {
+		t.Error("Expected a replay without a client to be rejected")
+	}
+	if status, _ := service.GetOTPStatus(ctx, "+1234567890"); status.HasActiveOTP {
+		t.Error("Expected a verified OTP not to be reported as active")
+	}
+
+	// Retries stop once the OTP's attempts are used up
+	for i := 1; i < stored.MaxAttempts; i++ {
+		verify(response.OTP)
+	}
+	if verify(response.OTP).Valid {
+		t.Error("Expected retries to stop once no attempts are left")
+	}
+
+	now = now.Add(service.config.OTPVerifyGrace + time.Second)
+	if verify(response.OTP).Valid {
+		t.Error("Expected the code to be rejected once the grace window ended")
+	}
+
+	// A verified OTP doesn't hold back a new one, even within the resend cooldown
+	if newResponse, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1234567890"}); err != nil || !newResponse.Success {
+		t.Errorf("Expected a new OTP after verification, got %+v, %v", newResponse, err)
+	}
+}
+
+func TestSendSMSConcurrencyLimit(t *testing.T) {
+	client := &blockingSMSClient{started: make(chan struct{}, 2), release: make(chan struct{})}
+	cfg := testConfig()
+	cfg.MaxConcurrentSends = 0
+	cfg.ProviderConcurrency = map[string]int{client.GetProvider(): 1}
+	service := NewSMSService(repository.NewInMemoryRepository(), client, WithConfig(cfg))
+	ctx := context.Background()
+
+	// Hold the provider's only slot
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"})
+		firstDone <- err
+	}()
+	<-client.started
+
+	// A send that gives up while waiting never reaches the provider
+	shortCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := service.SendSMS(shortCtx, models.SMSRequest{PhoneNumber: "+1987654321", Message: "Hello"}); err == nil {
+		t.Error("Expected a send whose context expired while waiting to fail")
+	}
+
+	// A patient send waits for the slot instead of failing
+	waitingDone := make(chan error, 1)
+	go func() {
+		_, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1555123456", Message: "Hello"})
+		waitingDone <- err
+	}()
+	select {
+	case <-client.started:
+		t.Fatal("Expected the second send to wait for a free slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(client.release)
+	if err := <-firstDone; err != nil {
+		t.Errorf("Expected the first send to succeed, got %v", err)
+	}
+	if err := <-waitingDone; err != nil {
+		t.Errorf("Expected the waiting send to succeed, got %v", err)
+	}
+	if calls := client.Calls(); len(calls) != 2 {
+		t.Errorf("Expected 2 messages to reach the provider, got %d", len(calls))
+	}
+}
+
+func TestResendOTP(t *testing.T) {
+	cfg := testConfig()
+	cfg.OTPMaxResends = 2
+	cfg.OTPResendCooldown = time.Minute
+	mockClient := transport.NewMockSMSClient()
+	repo := repository.NewInMemoryRepository()
+	service := NewSMSService(repo, mockClient, WithConfig(cfg))
+	ctx := context.Background()
+	req := models.OTPRequest{PhoneNumber: "+1234567890"}
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	first, err := service.SendOTP(ctx, req)
+	if err != nil || !first.Success {
+		t.Fatalf("Expected the OTP to be sent, got %+v, %v", first, err)
+	}
+
+	if response, err := service.ResendOTP(ctx, req); err != nil || response.Success {
+		t.Errorf("Expected a resend within the cooldown to be rejected, got %+v, %v", response, err)
+	}
+
+	for i := 1; i <= cfg.OTPMaxResends; i++ {
+		now = now.Add(cfg.OTPResendCooldown + time.Second)
+		response, err := service.ResendOTP(ctx, req)
+		if err != nil || !response.Success || response.OTP != first.OTP {
+			t.Fatalf("Resend %d: expected the same code to be sent again, got %+v, %v", i, response, err)
+		}
+	}
+	stored, _ := repo.OTP().FindByPhone(ctx, "+1234567890")
+	if stored.ResendCount != cfg.OTPMaxResends || !stored.LastSentAt.Equal(now) {
+		t.Errorf("Expected %d resends recorded, got %+v", cfg.OTPMaxResends, stored)
+	}
+	calls := mockClient.Calls()
+	if len(calls) != 1+cfg.OTPMaxResends || calls[len(calls)-1].Body != first.OTP {
+		t.Errorf("Expected the original code to be resent, got %+v", calls)
+	}
+
+	now = now.Add(cfg.OTPResendCooldown + time.Second)
+	_, err = service.ResendOTP(ctx, req)
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeRateLimit {
+		t.Errorf("Expected resends over the cap to be rate limited, got %v", err)
+	}
+
+	// Without an active OTP a new code is sent
+	stored.ExpiresAt = now.Add(-time.Second)
+	repo.OTP().Update(ctx, stored)
+	response, err := service.ResendOTP(ctx, req)
+	if err != nil || !response.Success {
+		t.Fatalf("Expected a new OTP once the old one expired, got %+v, %v", response, err)
+	}
+	if stored, _ := repo.OTP().FindByPhone(ctx, "+1234567890"); stored.ResendCount != 0 {
+		t.Errorf("Expected a fresh OTP, got %+v", stored)
+	}
+}
+
+func TestResendOTPConcurrent(t *testing.T) {
+	cfg := testConfig()
+	cfg.OTPResendCooldown = time.Minute
+	cfg.MaxInFlightPerNumber = 0
+	mockClient := transport.NewMockSMSClient()
+	service := NewSMSService(repository.NewInMemoryRepository(), mockClient, WithConfig(cfg))
+	ctx := context.Background()
+	req := models.OTPRequest{PhoneNumber: "+1234567890"}
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	if _, err := service.SendOTP(ctx, req); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	now = now.Add(cfg.OTPResendCooldown + time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.ResendOTP(ctx, req)
+		}()
+	}
+	wg.Wait()
+	if calls := mockClient.Calls(); len(calls) != 2 {
+		t.Errorf("Expected one of the concurrent resends to be sent, got %d sends", len(calls))
+	}
+}
+
+func TestAuditOTPActions(t *testing.T) {
+	repo := repository.NewInMemoryRepository()
+	mockClient := transport.NewMockSMSClient()
+	cfg := testConfig()
+	cfg.OTPResendCooldown = time.Minute
+	service := NewSMSService(repo, mockClient, WithConfig(cfg), WithVoiceOTPClient(mockClient))
+	ctx := common.WithActor(context.Background(), common.Actor{Type: common.ActorUser, ID: "user_1"})
+	adminCtx := common.WithActor(context.Background(), common.Actor{Type: common.ActorAdmin, ID: "admin_1"})
+	phone := "+1234567890"
+
+	if _, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone, Purpose: "login"}); err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	cooldown := service.config.OTPResendCooldown + time.Second
+	service.now = func() time.Time { return time.Now().Add(cooldown) }
+	if _, err := service.ResendOTP(ctx, models.OTPRequest{PhoneNumber: phone, Purpose: "login"}); err != nil {
+		t.Fatalf("Failed to resend OTP: %v", err)
+	}
+	service.now = func() time.Time { return time.Now().Add(2 * cooldown) }
+	if _, err := service.EscalateOTP(ctx, phone); err != nil {
+		t.Fatalf("Failed to escalate OTP: %v", err)
+	}
+	if err := service.ResetOTPAttempts(adminCtx, phone); err != nil {
+		t.Fatalf("Failed to reset OTP attempts: %v", err)
+	}
+	service.Shutdown(ctx)
+
+	audits, _ := repo.Audit().List(ctx, models.AuditFilter{Type: "otp"}, 0, 0)
+	var got []string
+	for _, audit := range audits {
+		got = append(got, fmt.Sprintf("%s %s:%s purpose=%s reason=%s", audit.Type, audit.Actor, audit.ActorID, audit.Purpose, audit.Reason))
+	}
+	want := []string{
+		"otp.sent user:user_1 purpose=login reason=",
+		"otp.resent user:user_1 purpose=login reason=",
+		"otp.escalated user:user_1 purpose=login reason=",
+		"otp.reset admin:admin_1 purpose=login reason=",
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected audit records\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := common.WithActor(context.Background(), common.Actor{Type: common.ActorUser, ID: "user_1"})
+
+	if _, err := service.SendSMS(ctx, models.SMSRequest{PhoneNumber: "+1234567890", Message: "Hello"}); err != nil {
+		t.Fatalf("Failed to send SMS: %v", err)
+	}
+	otp, err := service.SendOTP(ctx, models.OTPRequest{PhoneNumber: "+1987654321"})
+	if err != nil {
+		t.Fatalf("Failed to send OTP: %v", err)
+	}
+	if _, err := service.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: "+1987654321", OTP: otp.OTP}); err != nil {
+		t.Fatalf("Failed to verify OTP: %v", err)
+	}
+
+	callbacks := NewCallbackService(repo)
+	adminCtx := common.WithActor(context.Background(), common.Actor{Type: common.ActorAdmin, ID: "admin_1"})
+	callback, err := callbacks.RequestCallback(ctx, models.CallbackRequest{PhoneNumber: "+1555000111"})
+	if err != nil {
+		t.Fatalf("Failed to request callback: %v", err)
+	}
+	if _, err := callbacks.CancelCallback(adminCtx, callback.RequestID); err != nil {
+		t.Fatalf("Failed to cancel callback: %v", err)
+	}
+
+	// Audit records are written in the background; shutting down flushes them
+	service.Shutdown(ctx)
+	callbacks.Shutdown(ctx)
+
+	audits, _ := repo.Audit().List(ctx, models.AuditFilter{}, 0, 0)
+	var got []string
+	for _, audit := range audits {
+		got = append(got, fmt.Sprintf("%s %s->%s %s:%s %s", audit.Type, audit.FromStatus, audit.ToStatus, audit.Actor, audit.ActorID, audit.Phone))
+	}
+	want := []string{
+		"sms.pending ->pending user:user_1 +******7890",
+		"sms.sent pending->sent user:user_1 +******7890",
+		"otp.sent ->sent user:user_1 +******4321",
+		"otp.verified sent->verified user:user_1 +******4321",
+		"callback.requested ->requested user:user_1 +******0111",
+		"callback.cancelled requested->cancelled admin:admin_1 +******0111",
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected audit records\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	page, err := NewLogsService(repo).ListAudit(ctx, models.AuditFilter{Type: "callback"}, 0, 10)
+	if err != nil {
+		t.Fatalf("Failed to list audit records: %v", err)
+	}
+	if page.Total != 2 || page.HasMore {
+		t.Errorf("Expected both callback records, got %+v", page)
+	}
+}
+
+// flakyAuditRepository fails the first writes to the audit log
+type flakyAuditRepository struct {
+	repository.AuditRepository
+	failures *int
+}
+
+func (r flakyAuditRepository) Create(ctx context.Context, audit *models.Audit) error {
+	if *r.failures > 0 {
+		*r.failures--
+		return errors.New("audit log unavailable")
+	}
+	return r.AuditRepository.Create(ctx, audit)
+}
+
+type flakyAuditStore struct {
+	*repository.InMemoryRepository
+	failures *int
+}
+
+func (r flakyAuditStore) Audit() repository.AuditRepository {
+	return flakyAuditRepository{r.InMemoryRepository.Audit(), r.failures}
+}
+
+func TestAuditRetriesFailedWrites(t *testing.T) {
+	defer func(delay time.Duration) { auditRetryDelay = delay }(auditRetryDelay)
+	auditRetryDelay = time.Millisecond
+
+	failures := auditAttempts - 1
+	repo := flakyAuditStore{repository.NewInMemoryRepository(), &failures}
+	callbacks := NewCallbackService(repo)
+	ctx := context.Background()
+
+	// The state change doesn't wait for the audit log
+	if _, err := callbacks.RequestCallback(ctx, models.CallbackRequest{PhoneNumber: "+1234567890"}); err != nil {
+		t.Fatalf("Failed to request callback: %v", err)
+	}
+	callbacks.Shutdown(ctx)
+
+	audits, _ := repo.Audit().List(ctx, models.AuditFilter{}, 0, 0)
+	if len(audits) != 1 || audits[0].Actor != common.ActorSystem {
+		t.Errorf("Expected the record written by the last attempt, by the system, got %+v", audits)
+	}
+}
+
+func TestPollDeliveryStatusInBatches(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	ctx := context.Background()
+
+	// The provider keeps reporting the messages as sent, so they stay pending
+	// and each batch has to start after the previous one
+	mockClient.DeliveryStatus = models.StatusSent
+	total := statusPollBatchSize + statusPollBatchSize/2
+	for i := 0; i < total; i++ {
+		repo.SMS().Create(ctx, &models.SMS{To: "+1234567890", Message: "Hello", Status: models.StatusSent, Provider: "mock", ProviderID: fmt.Sprintf("uuid-%d", i)})
+	}
+
+	service.PollDeliveryStatus()
+	calls := mockClient.Calls()
+	if len(calls) != total {
+		t.Fatalf("Expected %d status lookups, got %d", total, len(calls))
+	}
+	seen := map[string]bool{}
+	for _, call := range calls {
+		seen[call.To] = true
+	}
+	if len(seen) != total {
+		t.Errorf("Expected every message to be looked up once, got %d distinct lookups", len(seen))
+	}
+}
+
+func TestDeliveryFailureIsNotResent(t *testing.T) {
+	service, repo, mockClient := newTestService()
+	ctx := context.Background()
+
+	sms := &models.SMS{Direction: models.DirectionOutbound, To: "+1234567890", Message: "Hello", Status: models.StatusSent, Provider: "mock", ProviderID: "uuid-1"}
+	repo.SMS().Create(ctx, sms)
+
+	if err := service.HandleDeliveryReport(ctx, models.DeliveryReport{MessageUUID: "uuid-1", Status: models.StatusFailed}); err != nil {
+		t.Fatalf("Failed to handle delivery report: %v", err)
+	}
+	stored, _ := repo.SMS().FindByID(ctx, sms.ID.Hex())
+	if stored.Status != models.StatusDead || stored.FailedReason == "" {
+		t.Fatalf("Expected a message the provider failed to be dead-lettered, got %+v", stored)
+	}
+
+	service.RetryFailedSMS()
+	if calls := mockClient.Calls(); len(calls) != 0 {
+		t.Errorf("Expected the accepted message not to be resent, got %+v", calls)
+	}
+}
+
+func TestHandleDeliveryReport(t *testing.T) {
+	service, repo, _ := newTestService()
+	ctx := context.Background()
+
+	outbound := &models.SMS{Direction: models.DirectionOutbound, To: "+1234567890", Status: models.StatusSent, ProviderID: "uuid-1"}
+	inbound := &models.SMS{Direction: models.DirectionInbound, From: "+1234567890", Status: models.StatusReceived, ProviderID: "uuid-2"}
+	repo.SMS().Create(ctx, outbound)
+	repo.SMS().Create(ctx, inbound)
+
+	if err := service.HandleDeliveryReport(ctx, models.DeliveryReport{MessageUUID: "uuid-1", Status: models.StatusDelivered}); err != nil {
+		t.Fatalf("Failed to handle delivery report: %v", err)
+	}
+	if stored, _ := repo.SMS().FindByID(ctx, outbound.ID.Hex()); stored.Status != models.StatusDelivered || stored.DeliveredAt == nil {
+		t.Errorf("Expected the message to be delivered, got %+v", stored)
+	}
+
+	err := service.HandleDeliveryReport(ctx, models.DeliveryReport{MessageUUID: "uuid-3", Status: models.StatusDelivered})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeNotFound {
+		t.Errorf("Expected a not found error for an unknown message, got %v", err)
+	}
+	err = service.HandleDeliveryReport(ctx, models.DeliveryReport{MessageUUID: "uuid-2", Status: models.StatusDelivered})
+	if appErr, ok := err.(*common.AppError); !ok || appErr.Code != common.ErrCodeConflict {
+		t.Errorf("Expected a conflict for an inbound message, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package sms_service
+
+import (
+	"sync"
+	"time"
+
+	"sms-app-backend/models"
+	"sms-app-backend/repository"
+)
+
+// otpStatusSweepSize is the number of cached statuses above which a store
+// first drops the expired ones
+const otpStatusSweepSize = 1024
+
+// otpStatusCache keeps OTP status lookups for a few seconds so countdown
+// timers polling the status don't reach the database on every request.
+// Every change to a phone number's OTP invalidates its entry.
+type otpStatusCache struct {
+	mu      sync.Mutex
+	entries map[otpStatusKey]otpStatusEntry
+	// invalidated holds the generation of each key's last invalidation, so a
+	// lookup that raced with a change to its own key is not stored
+	invalidated map[otpStatusKey]uint64
+	// generation counts invalidations; the ones up to forgotten were dropped
+	// from invalidated, so lookups older than that are not stored either
+	generation uint64
+	forgotten  uint64
+}
+
+// otpStatusKey scopes cached statuses to the repository of the request's
+// tenant; repositories are compared by identity
+type otpStatusKey struct {
+	repo  repository.Repository
+	phone string
+}
+
+type otpStatusEntry struct {
+	status    models.OTPStatus
+	expiresAt time.Time
+}
+
+func newOTPStatusCache() *otpStatusCache {
+	return &otpStatusCache{
+		entries:     make(map[otpStatusKey]otpStatusEntry),
+		invalidated: make(map[otpStatusKey]uint64),
+	}
+}
+
+// Get returns a copy of the cached status, or nil when there is none or it
+// expired, along with the generation to pass to Put after a fresh lookup
+func (c *otpStatusCache) Get(repo repository.Repository, phone string, now time.Time) (*models.OTPStatus, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := otpStatusKey{repo: repo, phone: phone}
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, c.generation
+	}
+	if !now.Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, c.generation
+	}
+	status := entry.status
+	return &status, c.generation
+}
+
+// Put caches a status until expiresAt unless the phone number's status was
+// invalidated since the Get that returned generation
+func (c *otpStatusCache) Put(repo repository.Repository, phone string, status models.OTPStatus, generation uint64, now, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := otpStatusKey{repo: repo, phone: phone}
+	if c.invalidated[key] > generation || generation < c.forgotten || !now.Before(expiresAt) {
+		return
+	}
+	if len(c.entries) >= otpStatusSweepSize {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[key] = otpStatusEntry{status: status, expiresAt: expiresAt}
+}
+
+// Invalidate drops the cached status of a phone number
+func (c *otpStatusCache) Invalidate(repo repository.Repository, phone string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.invalidated) >= otpStatusSweepSize {
+		c.invalidated = make(map[otpStatusKey]uint64)
+		c.forgotten = c.generation
+	}
+	c.generation++
+	key := otpStatusKey{repo: repo, phone: phone}
+	c.invalidated[key] = c.generation
+	delete(c.entries, key)
+}
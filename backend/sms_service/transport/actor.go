@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"sms-app-backend/auth"
+	"sms-app-backend/common"
+)
+
+// ActorMiddleware stores who makes the request in the request context, where
+// the services pick it up for the audit log. Admins are told apart from users
+// by their role; unauthenticated requests are made by a user without an ID.
+// It must run after the auth middleware.
+func ActorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := common.Actor{Type: common.ActorUser}
+		if claims, ok := auth.CurrentUser(c); ok {
+			actor.ID = claims.UserID
+			if claims.Role == auth.RoleAdmin {
+				actor.Type = common.ActorAdmin
+			}
+		}
+
+		c.Request = c.Request.WithContext(common.WithActor(c.Request.Context(), actor))
+		c.Next()
+	}
+}
+
+// providerActor records requests made by a provider's webhook as made by the system
+func providerActor(c *gin.Context) {
+	c.Request = c.Request.WithContext(common.WithActor(c.Request.Context(), common.Actor{Type: common.ActorSystem}))
+	c.Next()
+}
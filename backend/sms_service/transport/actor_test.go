@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"sms-app-backend/auth"
+	"sms-app-backend/common"
+)
+
+func TestActorMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var actor common.Actor
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		if userID := c.GetHeader("X-User"); userID != "" {
+			auth.SetCurrentUser(c, &auth.Claims{UserID: userID, Role: c.GetHeader("X-Role")})
+		}
+	}, ActorMiddleware(), func(c *gin.Context) {
+		actor = common.ActorFromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		userID string
+		role   string
+		want   common.Actor
+	}{
+		{"anonymous", "", "", common.Actor{Type: common.ActorUser}},
+		{"user", "user_1", "", common.Actor{Type: common.ActorUser, ID: "user_1"}},
+		{"admin", "admin_1", auth.RoleAdmin, common.Actor{Type: common.ActorAdmin, ID: "admin_1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-User", tt.userID)
+			req.Header.Set("X-Role", tt.role)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+			if actor != tt.want {
+				t.Errorf("Expected actor %+v, got %+v", tt.want, actor)
+			}
+		})
+	}
+}
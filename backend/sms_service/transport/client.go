@@ -2,73 +2,217 @@ package transport
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
 	"sms-app-backend/models"
 )
 
 // SMSClient defines the interface for SMS service clients
+// A non-empty from overrides the client's default sender number.
 type SMSClient interface {
-	SendSMS(ctx context.Context, to, message string) error
-	SendOTP(ctx context.Context, to, otp string) error
+	// SendSMS sends message to to; a validity of 0 keeps the provider's default
+	// expiry. With mediaURLs the message is sent as MMS carrying them.
+	SendSMS(ctx context.Context, from, to, message string, validity time.Duration, mediaURLs []string) error
+	// SendOTP sends the rendered OTP text message, which contains otp; the code
+	// is passed on its own for providers with a dedicated verification API
+	SendOTP(ctx context.Context, to, otp, message string) error
+	// ProviderStatus checks that the provider is reachable with the configured
+	// credentials and reports the account balance when available
+	ProviderStatus(ctx context.Context) (*models.ProviderHealth, error)
+	// FetchStatus looks up the delivery status of a sent message by its provider
+	// ID, mapped to models.StatusPending, StatusSent, StatusDelivered or StatusFailed
+	FetchStatus(ctx context.Context, providerID string) (models.Status, error)
 	GetProvider() string
 }
 
+// VoiceClient defines the interface for voice call providers
+type VoiceClient interface {
+	HangupCall(ctx context.Context, callUUID string) error
+	// SendVoiceOTP places a call to the phone number that reads the code out
+	SendVoiceOTP(ctx context.Context, to, otp string) error
+}
+
 // PlivoClient implements SMSClient for Plivo SMS service
 type PlivoClient struct {
-	authID    string
-	authToken string
-	from      string
-	baseURL   string
+	authID     string
+	authToken  string
+	from       string
+	senders    *senderPool
+	baseURL    string
+	accountURL string
+	httpClient *http.Client
+}
+
+// PlivoOption configures a PlivoClient
+type PlivoOption func(*PlivoClient)
+
+// WithSenderNumbers rotates sends without an explicit sender over numbers,
+// using strategy RotationRoundRobin or RotationLeastRecentlyUsed, instead of
+// always sending from the configured number
+func WithSenderNumbers(strategy string, numbers ...string) PlivoOption {
+	return func(pc *PlivoClient) {
+		if len(numbers) > 0 {
+			pc.senders = newSenderPool(strategy, numbers)
+		}
+	}
+}
+
+// WithPlivoHTTPClient calls the Plivo API with client, e.g. one built by
+// NewHTTPClient to go through a proxy. Nil keeps the default client.
+func WithPlivoHTTPClient(client *http.Client) PlivoOption {
+	return func(pc *PlivoClient) {
+		if client != nil {
+			pc.httpClient = client
+		}
+	}
 }
 
 // NewPlivoClient creates a new Plivo client
-func NewPlivoClient(authID, authToken, from string) *PlivoClient {
-	return &PlivoClient{
-		authID:    authID,
-		authToken: authToken,
-		from:      from,
-		baseURL:   "https://api.plivo.com/v1/Account/" + authID + "/Message/",
+func NewPlivoClient(authID, authToken, from string, opts ...PlivoOption) *PlivoClient {
+	pc := &PlivoClient{
+		authID:     authID,
+		authToken:  authToken,
+		from:       from,
+		baseURL:    "https://api.plivo.com/v1/Account/" + authID + "/Message/",
+		accountURL: "https://api.plivo.com/v1/Account/" + authID + "/",
+		httpClient: defaultHTTPClient(),
+	}
+	for _, opt := range opts {
+		opt(pc)
+	}
+	return pc
+}
+
+// NextSender returns the number the next send without an explicit sender goes
+// out from: the next one in rotation, or the configured number
+func (pc *PlivoClient) NextSender() string {
+	if pc.senders == nil {
+		return pc.from
 	}
+	return pc.senders.Next()
 }
 
-// SendSMS sends an SMS message via Plivo
-func (pc *PlivoClient) SendSMS(ctx context.Context, to, message string) error {
-	// Implementation would use HTTP client to call Plivo API
+// SendSMS sends an SMS message via Plivo from the given sender, or the next sender number when empty
+func (pc *PlivoClient) SendSMS(ctx context.Context, from, to, message string, validity time.Duration, mediaURLs []string) error {
+	if from == "" {
+		from = pc.NextSender()
+	} else if pc.senders != nil {
+		pc.senders.Used(from)
+	}
+	// Implementation would use HTTP client to call Plivo API with src=from,
+	// and message_expiry set to the validity in seconds when it's not 0.
+	// Messages with media are sent with type=mms and media_urls.
 	// For now, return nil to indicate success
 	return nil
 }
 
-// SendOTP sends an OTP message via Plivo
-func (pc *PlivoClient) SendOTP(ctx context.Context, to, otp string) error {
-	message := "Your OTP is: " + otp + ". Valid for 5 minutes. Do not share this code."
-	return pc.SendSMS(ctx, to, message)
+// SendOTP sends an OTP message via Plivo from the next sender number
+func (pc *PlivoClient) SendOTP(ctx context.Context, to, otp, message string) error {
+	return pc.SendSMS(ctx, "", to, message, 0, nil)
 }
 
-// GetProvider returns the provider name
-func (pc *PlivoClient) GetProvider() string {
-	return models.ProviderPlivo
+// HangupCall hangs up an ongoing Plivo voice call
+func (pc *PlivoClient) HangupCall(ctx context.Context, callUUID string) error {
+	// Implementation would call DELETE /Call/{call_uuid}/ on the Plivo API
+	// For now, return nil to indicate success
+	return nil
 }
 
-// MockClient implements SMSClient for testing
-type MockClient struct {
-	provider string
+// SendVoiceOTP calls the phone number and reads the OTP digit by digit
+func (pc *PlivoClient) SendVoiceOTP(ctx context.Context, to, otp string) error {
+	// Implementation would call POST /Call/ from the configured number with an
+	// answer_url returning <Speak> XML that reads the code twice
+	// For now, return nil to indicate success
+	return nil
 }
 
-// NewMockClient creates a new mock SMS client
-func NewMockClient(provider string) *MockClient {
-	return &MockClient{provider: provider}
+// ProviderStatus fetches the Plivo account to verify the credentials and read the cash credits
+func (pc *PlivoClient) ProviderStatus(ctx context.Context) (*models.ProviderHealth, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pc.accountURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(pc.authID, pc.authToken)
+
+	resp, err := pc.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("plivo account request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errors.New("plivo rejected the configured credentials")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("plivo account request returned status %d", resp.StatusCode)
+	}
+
+	var account struct {
+		CashCredits string `json:"cash_credits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return nil, fmt.Errorf("invalid plivo account response: %w", err)
+	}
+
+	health := &models.ProviderHealth{Provider: pc.GetProvider(), Healthy: true, CheckedAt: time.Now()}
+	if balance, err := strconv.ParseFloat(account.CashCredits, 64); err == nil {
+		health.Balance = &balance
+	}
+	return health, nil
 }
 
-// SendSMS mock implementation
-func (mc *MockClient) SendSMS(ctx context.Context, to, message string) error {
-	return nil
+// FetchStatus fetches a message from Plivo and maps its message_state to an SMS status
+func (pc *PlivoClient) FetchStatus(ctx context.Context, providerID string) (models.Status, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pc.baseURL+url.PathEscape(providerID)+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(pc.authID, pc.authToken)
+
+	resp, err := pc.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("plivo message request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("plivo message request returned status %d", resp.StatusCode)
+	}
+
+	var message struct {
+		MessageState string `json:"message_state"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		return "", fmt.Errorf("invalid plivo message response: %w", err)
+	}
+	return ParsePlivoMessageState(message.MessageState)
 }
 
-// SendOTP mock implementation
-func (mc *MockClient) SendOTP(ctx context.Context, to, otp string) error {
-	return nil
+// ParsePlivoMessageState maps a Plivo message state, as fetched or posted to
+// the delivery report webhook, to an SMS status
+func ParsePlivoMessageState(state string) (models.Status, error) {
+	switch strings.ToLower(state) {
+	case "queued":
+		return models.StatusPending, nil
+	case "sent":
+		return models.StatusSent, nil
+	case "delivered":
+		return models.StatusDelivered, nil
+	case "failed", "undelivered", "rejected":
+		return models.StatusFailed, nil
+	default:
+		return "", fmt.Errorf("unknown plivo message state %q", state)
+	}
 }
 
 // GetProvider returns the provider name
-func (mc *MockClient) GetProvider() string {
-	return mc.provider
-} 
\ No newline at end of file
+func (pc *PlivoClient) GetProvider() string {
+	return models.ProviderPlivo
+}
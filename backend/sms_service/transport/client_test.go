@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sms-app-backend/models"
+)
+
+func TestMockClientDefault(t *testing.T) {
+	client := NewMockClient("mock")
+
+	if err := client.SendSMS(context.Background(), "", "+1234567890", "Hello", 0, nil); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := client.SendOTP(context.Background(), "+1234567890", "123456", "Your OTP is: 123456"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if client.GetProvider() != "mock" {
+		t.Errorf("Expected provider mock, got %s", client.GetProvider())
+	}
+	if sent := client.SentMessages(); len(sent) != 0 {
+		t.Errorf("Expected nothing captured without WithMockCapture, got %d messages", len(sent))
+	}
+}
+
+func TestMockClientCapture(t *testing.T) {
+	client := NewMockClient("mock", WithMockCapture())
+	ctx := context.Background()
+
+	client.SendSMS(ctx, "", "+1234567890", "Hello", 0, nil)
+	client.SendOTP(ctx, "+1987654321", "123456", "Your OTP is: 123456")
+
+	sent := client.SentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("Expected 2 captured messages, got %d", len(sent))
+	}
+	if sent[0].Method != "SendSMS" || sent[0].To != "+1234567890" || sent[0].Body != "Hello" {
+		t.Errorf("Unexpected first message %+v", sent[0])
+	}
+	if sent[1].Method != "SendOTP" || sent[1].To != "+1987654321" || sent[1].Body != "123456" || sent[1].Message != "Your OTP is: 123456" {
+		t.Errorf("Unexpected second message %+v", sent[1])
+	}
+}
+
+func TestMockClientFailures(t *testing.T) {
+	providerErr := errors.New("provider down")
+	client := NewMockClient("mock", WithMockError(providerErr), WithMockCapture())
+	if err := client.SendSMS(context.Background(), "", "+1234567890", "Hello", 0, nil); err != providerErr {
+		t.Errorf("Expected fixed error, got %v", err)
+	}
+	if len(client.SentMessages()) != 0 {
+		t.Errorf("Expected failed sends not to be captured")
+	}
+
+	client = NewMockClient("mock", WithMockFailureRate(1))
+	if err := client.SendSMS(context.Background(), "", "+1234567890", "Hello", 0, nil); err != ErrMockFailure {
+		t.Errorf("Expected simulated failure, got %v", err)
+	}
+}
+
+func TestMockClientLatency(t *testing.T) {
+	client := NewMockClient("mock", WithMockLatency(time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := client.SendSMS(ctx, "", "+1234567890", "Hello", 0, nil); err != context.DeadlineExceeded {
+		t.Errorf("Expected the send to be cut short by the context, got %v", err)
+	}
+}
+
+func TestPlivoProviderStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, token, ok := r.BasicAuth(); !ok || id != "auth-id" || token != "good-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"cash_credits":"12.50000"}`))
+	}))
+	defer server.Close()
+
+	client := NewPlivoClient("auth-id", "good-token", "+15550000000")
+	client.accountURL = server.URL
+	health, err := client.ProviderStatus(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !health.Healthy || health.Balance == nil || *health.Balance != 12.5 {
+		t.Errorf("Expected healthy account with balance 12.5, got %+v", health)
+	}
+
+	client = NewPlivoClient("auth-id", "bad-token", "+15550000000")
+	client.accountURL = server.URL
+	if _, err := client.ProviderStatus(context.Background()); err == nil {
+		t.Error("Expected error for rejected credentials")
+	}
+}
+
+func TestPlivoFetchStatus(t *testing.T) {
+	states := map[string]models.Status{
+		"queued":      models.StatusPending,
+		"sent":        models.StatusSent,
+		"delivered":   models.StatusDelivered,
+		"undelivered": models.StatusFailed,
+		"rejected":    models.StatusFailed,
+	}
+
+	var state string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/msg-uuid/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"message_uuid":"msg-uuid","message_state":"` + state + `"}`))
+	}))
+	defer server.Close()
+
+	client := NewPlivoClient("auth-id", "token", "+15550000000")
+	client.baseURL = server.URL + "/"
+	for state = range states {
+		status, err := client.FetchStatus(context.Background(), "msg-uuid")
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", state, err)
+		}
+		if status != states[state] {
+			t.Errorf("%s: expected status %s, got %s", state, states[state], status)
+		}
+	}
+
+	if _, err := client.FetchStatus(context.Background(), "unknown"); err == nil {
+		t.Error("Expected error for an unknown message")
+	}
+}
+
+func TestTwilioClient(t *testing.T) {
+	var messageStatus string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, token, ok := r.BasicAuth(); !ok || id != "AC1" || token != "good-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/Balance.json":
+			w.Write([]byte(`{"balance":"8.25","currency":"USD"}`))
+		case "/Messages/SM1.json":
+			w.Write([]byte(`{"sid":"SM1","status":"` + messageStatus + `"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewTwilioClient("AC1", "good-token", "+15550000000")
+	client.accountURL = server.URL + "/"
+	health, err := client.ProviderStatus(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !health.Healthy || health.Provider != models.ProviderTwilio || health.Balance == nil || *health.Balance != 8.25 {
+		t.Errorf("Expected healthy account with balance 8.25, got %+v", health)
+	}
+
+	statuses := map[string]models.Status{
+		"queued":      models.StatusPending,
+		"sent":        models.StatusSent,
+		"delivered":   models.StatusDelivered,
+		"undelivered": models.StatusFailed,
+	}
+	for messageStatus = range statuses {
+		status, err := client.FetchStatus(context.Background(), "SM1")
+		if err != nil || status != statuses[messageStatus] {
+			t.Errorf("%s: expected status %s, got %s (%v)", messageStatus, statuses[messageStatus], status, err)
+		}
+	}
+	if _, err := client.FetchStatus(context.Background(), "SM2"); err == nil {
+		t.Error("Expected error for an unknown message")
+	}
+	if err := client.SendSMS(context.Background(), "", "+15550000001", "Hello", 0, nil); !errors.Is(err, ErrTwilioSendUnsupported) {
+		t.Errorf("Expected sending to fail until it is supported, got %v", err)
+	}
+
+	client = NewTwilioClient("AC1", "bad-token", "+15550000000")
+	client.accountURL = server.URL + "/"
+	if _, err := client.ProviderStatus(context.Background()); err == nil {
+		t.Error("Expected error for rejected credentials")
+	}
+}
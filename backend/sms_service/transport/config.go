@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"sms-app-backend/common"
+)
+
+// HandlerConfig holds the tunable settings of the HTTP handler
+type HandlerConfig struct {
+	// DefaultListLimit is used by listing endpoints when no limit is requested
+	DefaultListLimit int
+	// MaxListLimit caps the limit accepted by listing endpoints
+	MaxListLimit int
+	// AdminMiddleware authenticates admin-only routes; when empty those routes reject every request
+	AdminMiddleware []gin.HandlerFunc
+	// WebhookMiddleware authenticates provider webhooks such as inbound messages,
+	// e.g. PlivoSignatureMiddleware; when empty webhooks are accepted unchecked
+	WebhookMiddleware []gin.HandlerFunc
+	// UnavailableReason explains 503 responses when the handler has no backing service
+	UnavailableReason string
+	// DefaultPhoneRegion is the ISO 3166-1 alpha-2 region, e.g. "US", used to
+	// turn phone numbers entered without a country code into E.164; when empty
+	// such numbers are rejected
+	DefaultPhoneRegion string
+	// AvailabilityCheck, when set, is consulted on every request; routes answer
+	// 503 while it returns false, e.g. until the database is reachable
+	AvailabilityCheck func() bool
+	// Voice configures the XML read out when a callback call is answered
+	Voice VoiceSettings
+}
+
+// DefaultHandlerConfig returns the default HTTP handler configuration
+func DefaultHandlerConfig() HandlerConfig {
+	return HandlerConfig{
+		DefaultListLimit: 100,
+		MaxListLimit:     1000,
+	}
+}
+
+// HandlerOption configures the HTTP handler
+type HandlerOption func(*HandlerConfig)
+
+// WithListLimits overrides the default and maximum limits of listing endpoints.
+// Non-positive values keep the defaults.
+func WithListLimits(defaultLimit, maxLimit int) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		if defaultLimit > 0 {
+			cfg.DefaultListLimit = defaultLimit
+		}
+		if maxLimit > 0 {
+			cfg.MaxListLimit = maxLimit
+		}
+		if cfg.DefaultListLimit > cfg.MaxListLimit {
+			cfg.DefaultListLimit = cfg.MaxListLimit
+		}
+	}
+}
+
+// WithAdminMiddleware sets the handlers that authenticate and authorize admin-only routes
+func WithAdminMiddleware(handlers ...gin.HandlerFunc) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		cfg.AdminMiddleware = handlers
+	}
+}
+
+// WithWebhookMiddleware sets the handlers that verify provider webhooks come from the provider
+func WithWebhookMiddleware(handlers ...gin.HandlerFunc) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		cfg.WebhookMiddleware = handlers
+	}
+}
+
+// WithUnavailableReason sets the explanation returned by every route while the
+// backing service is unavailable, e.g. because the database could not be reached
+func WithUnavailableReason(reason string) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		cfg.UnavailableReason = reason
+	}
+}
+
+// WithAvailabilityCheck makes every route answer 503 while check returns false
+func WithAvailabilityCheck(check func() bool) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		cfg.AvailabilityCheck = check
+	}
+}
+
+// WithDefaultPhoneRegion sets the region of phone numbers entered without a
+// country code. Unknown regions are ignored.
+func WithDefaultPhoneRegion(region string) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		if common.ValidPhoneRegion(region) {
+			cfg.DefaultPhoneRegion = strings.ToUpper(region)
+		}
+	}
+}
+
+// WithVoiceSettings sets the provider dialect, language, voice and redirect URL
+// of the XML served when a callback call is answered
+func WithVoiceSettings(settings VoiceSettings) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		cfg.Voice = settings
+	}
+}
@@ -1,12 +1,20 @@
 package transport
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"sms-app-backend/auth"
 	"sms-app-backend/common"
 	"sms-app-backend/models"
 )
@@ -14,24 +22,84 @@ import (
 // Endpoints holds all the endpoints for the SMS service
 type Endpoints struct {
 	SendOTP     gin.HandlerFunc
+	ResendOTP   gin.HandlerFunc
+	EscalateOTP gin.HandlerFunc
 	VerifyOTP   gin.HandlerFunc
 	SendSMS     gin.HandlerFunc
 	GetOTPStatus gin.HandlerFunc
+	RevokeOTP   gin.HandlerFunc
+	ResetOTPAttempts gin.HandlerFunc
+	RetrySMS    gin.HandlerFunc
+	ProviderHealth gin.HandlerFunc
+	GetStats    gin.HandlerFunc
+	GetMessage  gin.HandlerFunc
+	DeleteMessage gin.HandlerFunc
+	ListSMS     gin.HandlerFunc
+	BatchStatus gin.HandlerFunc
+	Estimate    gin.HandlerFunc
+	OptOut      gin.HandlerFunc
+	OptIn       gin.HandlerFunc
+	Inbound     gin.HandlerFunc
+	DeliveryReport gin.HandlerFunc
 	RequestCallback gin.HandlerFunc
 	GetCallbackStatus gin.HandlerFunc
+	CancelCallback    gin.HandlerFunc
+	VoiceAnswer       gin.HandlerFunc
 	GetLogs     gin.HandlerFunc
+	ExportLogs  gin.HandlerFunc
+	ListAudit   gin.HandlerFunc
+	SearchLogs  gin.HandlerFunc
+	SearchPhone gin.HandlerFunc
+	ListFlaggedPhones gin.HandlerFunc
+	DailyAnalytics gin.HandlerFunc
+	LookupUser  gin.HandlerFunc
+	GetProfile    gin.HandlerFunc
+	UpdateProfile gin.HandlerFunc
+	InitiatePhoneChange gin.HandlerFunc
+	ConfirmPhoneChange  gin.HandlerFunc
+	DeleteAccount       gin.HandlerFunc
 }
 
 // MakeEndpoints creates endpoints for the SMS service
-func MakeEndpoints(svc interface{}) Endpoints {
+func MakeEndpoints(svc interface{}, cfg HandlerConfig) Endpoints {
 	return Endpoints{
-		SendOTP:     makeSendOTPEndpoint(svc),
-		VerifyOTP:   makeVerifyOTPEndpoint(svc),
-		SendSMS:     makeSendSMSEndpoint(svc),
-		GetOTPStatus: makeGetOTPStatusEndpoint(svc),
-		RequestCallback: makeRequestCallbackEndpoint(svc),
+		SendOTP:     makeSendOTPEndpoint(svc, cfg),
+		ResendOTP:   makeResendOTPEndpoint(svc, cfg),
+		EscalateOTP: makeEscalateOTPEndpoint(svc, cfg),
+		VerifyOTP:   makeVerifyOTPEndpoint(svc, cfg),
+		SendSMS:     makeSendSMSEndpoint(svc, cfg),
+		GetOTPStatus: makeGetOTPStatusEndpoint(svc, cfg),
+		RevokeOTP:    makeRevokeOTPEndpoint(svc, cfg),
+		ResetOTPAttempts: makeResetOTPAttemptsEndpoint(svc, cfg),
+		RetrySMS:     makeRetrySMSEndpoint(svc),
+		ProviderHealth: makeProviderHealthEndpoint(svc),
+		GetStats:     makeGetStatsEndpoint(svc, cfg),
+		GetMessage:   makeGetMessageEndpoint(svc),
+		DeleteMessage: makeDeleteMessageEndpoint(svc),
+		ListSMS:      makeListSMSEndpoint(svc, cfg),
+		BatchStatus:  makeBatchStatusEndpoint(svc),
+		Estimate:     makeEstimateEndpoint(svc, cfg),
+		OptOut:       makeOptOutEndpoint(svc, cfg, true),
+		OptIn:        makeOptOutEndpoint(svc, cfg, false),
+		Inbound:      makeInboundEndpoint(svc),
+		DeliveryReport: makeDeliveryReportEndpoint(svc),
+		RequestCallback: makeRequestCallbackEndpoint(svc, cfg),
 		GetCallbackStatus: makeGetCallbackStatusEndpoint(svc),
-		GetLogs:     makeGetLogsEndpoint(svc),
+		CancelCallback:    makeCancelCallbackEndpoint(svc),
+		VoiceAnswer:       makeVoiceAnswerEndpoint(svc, cfg),
+		GetLogs:     makeGetLogsEndpoint(svc, cfg),
+		ExportLogs:  makeExportLogsEndpoint(svc),
+		ListAudit:   makeListAuditEndpoint(svc, cfg),
+		SearchLogs:  makeSearchLogsEndpoint(svc, cfg),
+		SearchPhone: makeSearchPhoneEndpoint(svc),
+		ListFlaggedPhones: makeListFlaggedPhonesEndpoint(svc, cfg),
+		DailyAnalytics: makeDailyAnalyticsEndpoint(svc),
+		LookupUser:   makeLookupUserEndpoint(svc, cfg),
+		GetProfile:    makeGetProfileEndpoint(svc),
+		UpdateProfile: makeUpdateProfileEndpoint(svc),
+		InitiatePhoneChange: makeInitiatePhoneChangeEndpoint(svc, cfg),
+		ConfirmPhoneChange:  makeConfirmPhoneChangeEndpoint(svc, cfg),
+		DeleteAccount:       makeDeleteAccountEndpoint(svc),
 	}
 }
 
@@ -43,29 +111,20 @@ func MakeEndpoints(svc interface{}) Endpoints {
 // @Param request body models.OTPRequest true "OTP Request"
 // @Success 200 {object} models.OTPResponse
 // @Failure 400 {object} common.AppError
+// @Failure 429 {object} common.AppError
 // @Failure 500 {object} common.AppError
 // @Router /sms/send-otp [post]
-func makeSendOTPEndpoint(svc interface{}) gin.HandlerFunc {
+func makeSendOTPEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var req models.OTPRequest
-		
-		if err := c.ShouldBindJSON(&req); err != nil {
-			appErr := common.NewValidationError("Invalid request format: " + err.Error())
-			c.JSON(appErr.StatusCode, appErr)
-			return
-		}
-
-		// Validate phone number format
-		if !isValidPhoneNumber(req.PhoneNumber) {
-			appErr := common.NewValidationError("Invalid phone number format")
-			c.JSON(appErr.StatusCode, appErr)
+		req, ok := bindOTPRequest(c, cfg.DefaultPhoneRegion)
+		if !ok {
 			return
 		}
 
 		// Send OTP
 		smsSvc, ok := svc.(interface{ SendOTP(ctx context.Context, req models.OTPRequest) (*models.OTPResponse, error) })
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Service not available"})
+			respondServiceUnavailable(c)
 			return
 		}
 		
@@ -81,15 +140,128 @@ func makeSendOTPEndpoint(svc interface{}) gin.HandlerFunc {
 			return
 		}
 
-		// In production, don't return the actual OTP in response
-		if response.Success {
-			response.OTP = "" // Remove OTP from response for security
+		// Echo the canonical number so clients can display it
+		response.PhoneNumber = req.PhoneNumber
+		response.NormalizedPhone = req.PhoneNumber
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// @Summary Resend OTP
+// @Description Send the active OTP for the phone number again without changing the code, or a new OTP if none is active. Subject to the resend cooldown and a cap on resends per OTP.
+// @Tags SMS
+// @Accept json
+// @Produce json
+// @Param request body models.OTPRequest true "OTP Request"
+// @Success 200 {object} models.OTPResponse
+// @Failure 400 {object} common.AppError
+// @Failure 429 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /sms/resend-otp [post]
+func makeResendOTPEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, ok := bindOTPRequest(c, cfg.DefaultPhoneRegion)
+		if !ok {
+			return
+		}
+
+		smsSvc, ok := svc.(interface{ ResendOTP(ctx context.Context, req models.OTPRequest) (*models.OTPResponse, error) })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		response, err := smsSvc.ResendOTP(c.Request.Context(), req)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to resend OTP: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Echo the canonical number so clients can display it
+		response.PhoneNumber = req.PhoneNumber
+		response.NormalizedPhone = req.PhoneNumber
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// @Summary Escalate OTP to Voice
+// @Description Read the active OTP out in a voice call when it wasn't verified after being sent by SMS. Allowed once per OTP, after a configurable wait since it was sent.
+// @Tags SMS
+// @Accept json
+// @Produce json
+// @Param request body models.OTPRequest true "OTP Request"
+// @Success 200 {object} models.OTPResponse
+// @Failure 400 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Failure 429 {object} common.AppError
+// @Failure 503 {object} common.AppError
+// @Router /sms/otp/escalate [post]
+func makeEscalateOTPEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, ok := bindOTPRequest(c, cfg.DefaultPhoneRegion)
+		if !ok {
+			return
+		}
+
+		smsSvc, ok := svc.(interface{ EscalateOTP(ctx context.Context, phone string) (*models.OTPResponse, error) })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		response, err := smsSvc.EscalateOTP(c.Request.Context(), req.PhoneNumber)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to escalate OTP: " + err.Error())
+			}
+			if appErr.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(appErr.RetryAfter))
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
 		}
 
+		response.PhoneNumber = req.PhoneNumber
 		c.JSON(http.StatusOK, response)
 	}
 }
 
+// bindOTPRequest binds and validates an OTP send request, writing a 400
+// response and returning false when it is invalid
+func bindOTPRequest(c *gin.Context, region string) (models.OTPRequest, bool) {
+	var req models.OTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		appErr := bindingError(err)
+		c.JSON(appErr.StatusCode, appErr)
+		return req, false
+	}
+
+	// Normalize and validate phone number format
+	req.PhoneNumber = common.NormalizePhoneForRegion(req.PhoneNumber, region)
+	if !isValidPhoneNumber(req.PhoneNumber) {
+		appErr := common.NewValidationError("Invalid phone number format")
+		c.JSON(appErr.StatusCode, appErr)
+		return req, false
+	}
+
+	// Validate purpose format
+	if req.Purpose != "" && !models.IsValidPurpose(req.Purpose) {
+		appErr := common.NewValidationError("Invalid purpose format")
+		c.JSON(appErr.StatusCode, appErr)
+		return req, false
+	}
+	return req, true
+}
+
 // @Summary Verify OTP
 // @Description Verify the OTP sent to the specified phone number
 // @Tags SMS
@@ -98,19 +270,21 @@ func makeSendOTPEndpoint(svc interface{}) gin.HandlerFunc {
 // @Param request body models.VerifyOTPRequest true "OTP Verification Request"
 // @Success 200 {object} models.VerifyOTPResponse
 // @Failure 400 {object} common.AppError
+// @Failure 429 {object} common.AppError "Throttled, or locked out after too many wrong codes with remaining_attempts 0"
 // @Failure 500 {object} common.AppError
 // @Router /sms/verify-otp [post]
-func makeVerifyOTPEndpoint(svc interface{}) gin.HandlerFunc {
+func makeVerifyOTPEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req models.VerifyOTPRequest
 		
 		if err := c.ShouldBindJSON(&req); err != nil {
-			appErr := common.NewValidationError("Invalid request format: " + err.Error())
+			appErr := bindingError(err)
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
-		// Validate phone number format
+		// Normalize and validate phone number format
+		req.PhoneNumber = common.NormalizePhoneForRegion(req.PhoneNumber, cfg.DefaultPhoneRegion)
 		if !isValidPhoneNumber(req.PhoneNumber) {
 			appErr := common.NewValidationError("Invalid phone number format")
 			c.JSON(appErr.StatusCode, appErr)
@@ -124,10 +298,19 @@ func makeVerifyOTPEndpoint(svc interface{}) gin.HandlerFunc {
 			return
 		}
 
+		// Validate purpose format
+		if req.Purpose != "" && !models.IsValidPurpose(req.Purpose) {
+			appErr := common.NewValidationError("Invalid purpose format")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		req.ClientID = c.ClientIP() + "|" + c.Request.UserAgent()
+
 		// Verify OTP
 		smsSvc, ok := svc.(interface{ VerifyOTP(ctx context.Context, req models.VerifyOTPRequest) (*models.VerifyOTPResponse, error) })
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Service not available"})
+			respondServiceUnavailable(c)
 			return
 		}
 		
@@ -139,56 +322,102 @@ func makeVerifyOTPEndpoint(svc interface{}) gin.HandlerFunc {
 			} else {
 				appErr = common.NewInternalError("Failed to verify OTP: " + err.Error())
 			}
+			if appErr.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(appErr.RetryAfter))
+			}
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
+		response.PhoneNumber = req.PhoneNumber
 		c.JSON(http.StatusOK, response)
 	}
 }
 
 // @Summary Send SMS
-// @Description Send a text message to the specified phone number
+// @Description Send a text message to the specified phone number. Long or Unicode messages are split into segments, up to the configured segment cap. Messages with media_urls are sent as MMS.
 // @Tags SMS
 // @Accept json
 // @Produce json
 // @Param request body models.SMSRequest true "SMS Request"
 // @Success 200 {object} models.SMSResponse
 // @Failure 400 {object} common.AppError
+// @Failure 403 {object} common.AppError "Sender ID not in the allowlist"
+// @Failure 429 {object} common.AppError
 // @Failure 500 {object} common.AppError
+// @Failure 502 {object} models.SMSResponse "Message stored with status failed because the provider rejected it"
+// @Header 200,429 {int} X-Quota-Limit "Monthly SMS quota of the authenticated user, or shared by anonymous senders"
+// @Header 200,429 {int} X-Quota-Remaining "SMS remaining this month for the authenticated user, or anonymous senders"
 // @Router /sms/send-sms [post]
-func makeSendSMSEndpoint(svc interface{}) gin.HandlerFunc {
+func makeSendSMSEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req models.SMSRequest
 		
 		if err := c.ShouldBindJSON(&req); err != nil {
-			appErr := common.NewValidationError("Invalid request format: " + err.Error())
+			appErr := bindingError(err)
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
-		// Validate phone number format
+		// Normalize and validate phone number format
+		req.PhoneNumber = common.NormalizePhoneForRegion(req.PhoneNumber, cfg.DefaultPhoneRegion)
 		if !isValidPhoneNumber(req.PhoneNumber) {
 			appErr := common.NewValidationError("Invalid phone number format")
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
-		// Validate message length
-		if len(req.Message) == 0 || len(req.Message) > 160 {
-			appErr := common.NewValidationError("Message must be between 1 and 160 characters")
+		// Validate the optional sender ID
+		if !IsValidSenderID(req.SenderID) {
+			appErr := common.NewValidationError("Invalid sender ID: use up to 11 letters and digits or an E.164 phone number")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Validate the optional metadata tags
+		if fields := validateMetadata(req.Metadata); len(fields) > 0 {
+			appErr := common.NewValidationErrors(fields)
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Validate the optional MMS attachments
+		if fields := validateMediaURLs(req.MediaURLs); len(fields) > 0 {
+			appErr := common.NewValidationErrors(fields)
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Validate the optional validity period
+		if req.ValiditySeconds != 0 && (req.ValiditySeconds < models.MinSMSValiditySeconds || req.ValiditySeconds > models.MaxSMSValiditySeconds) {
+			appErr := common.NewValidationErrors(map[string]string{
+				"validity_seconds": fmt.Sprintf("must be between %d and %d", models.MinSMSValiditySeconds, models.MaxSMSValiditySeconds),
+			})
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
+
+		// Attribute the message to the authenticated user, if any
+		if claims, ok := auth.CurrentUser(c); ok {
+			req.UserID = claims.UserID
+		}
+
 		// Send SMS
-		smsSvc, ok := svc.(interface{ SendSMS(ctx context.Context, req models.SMSRequest) error })
+		smsSvc, ok := svc.(interface {
+			SendSMS(ctx context.Context, req models.SMSRequest) (*models.SMSResponse, error)
+		})
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Service not available"})
+			respondServiceUnavailable(c)
 			return
 		}
 		
-		err := smsSvc.SendSMS(c.Request.Context(), req)
+		response, err := smsSvc.SendSMS(c.Request.Context(), req)
+		setQuotaHeaders(c, svc, req.UserID)
+		if response != nil {
+			response.PhoneNumber = req.PhoneNumber
+			response.NormalizedPhone = req.PhoneNumber
+		}
 		if err != nil {
 			var appErr *common.AppError
 			if e, ok := err.(*common.AppError); ok {
@@ -196,30 +425,35 @@ func makeSendSMSEndpoint(svc interface{}) gin.HandlerFunc {
 			} else {
 				appErr = common.NewInternalError("Failed to send SMS: " + err.Error())
 			}
+			if appErr.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(appErr.RetryAfter))
+			}
+			// The message was stored but not sent: report its ID and failed status
+			if response != nil {
+				c.JSON(appErr.StatusCode, response)
+				return
+			}
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
-		c.JSON(http.StatusOK, models.SMSResponse{
-			Success:   true,
-			Message:   "SMS sent successfully",
-			Timestamp: time.Now(),
-		})
+		c.JSON(http.StatusOK, response)
 	}
 }
 
 // @Summary Get OTP Status
-// @Description Check the status of OTP for a phone number
+// @Description Check the status of OTP for a phone number, including daily send usage
 // @Tags SMS
 // @Accept json
 // @Produce json
 // @Param phone path string true "Phone Number"
 // @Success 200 {object} models.OTPStatus
 // @Failure 400 {object} common.AppError
+// @Failure 500 {object} common.AppError
 // @Router /sms/otp-status/{phone} [get]
-func makeGetOTPStatusEndpoint(svc interface{}) gin.HandlerFunc {
+func makeGetOTPStatusEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		phoneNumber := c.Param("phone")
+		phoneNumber := common.NormalizePhoneForRegion(c.Param("phone"), cfg.DefaultPhoneRegion)
 		
 		if !isValidPhoneNumber(phoneNumber) {
 			appErr := common.NewValidationError("Invalid phone number format")
@@ -227,16 +461,54 @@ func makeGetOTPStatusEndpoint(svc interface{}) gin.HandlerFunc {
 			return
 		}
 
-		// This would typically check if an OTP exists and its expiry
+		// Get OTP status
+		smsSvc, ok := svc.(interface{ GetOTPStatus(ctx context.Context, phone string) (*models.OTPStatus, error) })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
 		// For security reasons, we don't expose OTP details
-		c.JSON(http.StatusOK, models.OTPStatus{
-			PhoneNumber: phoneNumber,
-			HasActiveOTP: false, // In production, check actual status
-			Attempts:    0,
-		})
+		status, err := smsSvc.GetOTPStatus(c.Request.Context(), phoneNumber)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to get OTP status: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
 	}
 }
 
+// setQuotaHeaders exposes the remaining monthly SMS quota of the
+// authenticated user, or the one anonymous senders share
+func setQuotaHeaders(c *gin.Context, svc interface{}, userID string) {
+	quotaSvc, ok := svc.(interface{ GetSMSQuota(ctx context.Context, userID string) (*models.SMSQuota, error) })
+	if !ok {
+		return
+	}
+
+	quota, err := quotaSvc.GetSMSQuota(c.Request.Context(), userID)
+	if err != nil || quota.Limit <= 0 {
+		return
+	}
+
+	c.Header("X-Quota-Limit", strconv.Itoa(quota.Limit))
+	c.Header("X-Quota-Remaining", strconv.Itoa(quota.Remaining))
+	c.Header("X-Quota-Reset", strconv.FormatInt(quota.ResetsAt.Unix(), 10))
+}
+
+// respondServiceUnavailable writes a 503 response for endpoints whose backing service is missing
+func respondServiceUnavailable(c *gin.Context) {
+	appErr := common.NewServiceUnavailableError("SMS")
+	c.JSON(appErr.StatusCode, appErr)
+}
+
 // isValidPhoneNumber performs basic phone number validation
 func isValidPhoneNumber(phone string) bool {
 	// Basic validation: should be at least 10 digits and start with +
@@ -254,6 +526,95 @@ func isValidPhoneNumber(phone string) bool {
 	return true
 }
 
+// maxAlphanumericSenderIDLength is the longest alphanumeric sender ID carriers accept
+const maxAlphanumericSenderIDLength = 11
+
+// IsValidSenderID validates an optional sender ID: either an E.164 number or
+// 1-11 letters and digits containing at least one letter
+func IsValidSenderID(senderID string) bool {
+	if senderID == "" {
+		return true
+	}
+	if senderID[0] == '+' {
+		// E.164 allows at most 15 digits
+		return isValidPhoneNumber(senderID) && len(senderID) <= 16
+	}
+	if len(senderID) > maxAlphanumericSenderIDLength {
+		return false
+	}
+
+	hasLetter := false
+	for _, r := range senderID {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			hasLetter = true
+		case r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return hasLetter
+}
+
+// Metadata limits, keeping tags small enough to index
+const (
+	maxMetadataEntries     = 10
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 256
+)
+
+// validateMetadata checks SMS metadata tags, returning a message per invalid key.
+// Keys are limited to letters, digits, '_' and '-' so they are safe to query on.
+func validateMetadata(metadata map[string]string) map[string]string {
+	fields := make(map[string]string)
+	if len(metadata) > maxMetadataEntries {
+		fields["metadata"] = fmt.Sprintf("must have at most %d entries", maxMetadataEntries)
+		return fields
+	}
+	for key, value := range metadata {
+		switch {
+		case !isValidMetadataKey(key):
+			fields["metadata."+key] = fmt.Sprintf("key must be 1-%d letters, digits, '_' or '-'", maxMetadataKeyLength)
+		case len(value) > maxMetadataValueLength:
+			fields["metadata."+key] = fmt.Sprintf("must be at most %d characters", maxMetadataValueLength)
+		}
+	}
+	return fields
+}
+
+// validateMediaURLs checks MMS attachment URLs, returning a message per invalid
+// URL. Providers fetch the media themselves, so only absolute HTTPS URLs are
+// accepted.
+func validateMediaURLs(mediaURLs []string) map[string]string {
+	fields := make(map[string]string)
+	if len(mediaURLs) > models.MaxMediaURLs {
+		fields["media_urls"] = fmt.Sprintf("must have at most %d entries", models.MaxMediaURLs)
+		return fields
+	}
+	for i, mediaURL := range mediaURLs {
+		parsed, err := url.Parse(mediaURL)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.User != nil {
+			fields[fmt.Sprintf("media_urls.%d", i)] = "must be an absolute HTTPS URL"
+		}
+	}
+	return fields
+}
+
+// isValidMetadataKey validates a metadata key
+func isValidMetadataKey(key string) bool {
+	if key == "" || len(key) > maxMetadataKeyLength {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // isValidOTP validates OTP format
 func isValidOTP(otp string) bool {
 	if len(otp) != 6 {
@@ -270,136 +631,1876 @@ func isValidOTP(otp string) bool {
 	return true
 }
 
-// @Summary Request Callback
-// @Description Request a callback call to the specified phone number
-// @Tags Callback
+// @Summary Revoke OTP
+// @Description Invalidate the active OTP for a phone number (admin only)
+// @Tags SMS
 // @Accept json
 // @Produce json
-// @Param request body models.CallbackRequest true "Callback Request"
-// @Success 200 {object} models.CallbackResponse
+// @Security BearerAuth
+// @Param phone path string true "Phone number"
+// @Success 200 {object} models.RevokeOTPResponse
 // @Failure 400 {object} common.AppError
-// @Failure 500 {object} common.AppError
-// @Router /callback/request [post]
-func makeRequestCallbackEndpoint(svc interface{}) gin.HandlerFunc {
+// @Failure 401 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Router /sms/otp/{phone} [delete]
+func makeRevokeOTPEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var req models.CallbackRequest
-		
-		if err := c.ShouldBindJSON(&req); err != nil {
-			appErr := common.NewValidationError("Invalid request format: " + err.Error())
+		phoneNumber := common.NormalizePhoneForRegion(c.Param("phone"), cfg.DefaultPhoneRegion)
+
+		if !isValidPhoneNumber(phoneNumber) {
+			appErr := common.NewValidationError("Invalid phone number format")
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
-		// Validate phone number format
-		if !isValidPhoneNumber(req.PhoneNumber) {
+		smsSvc, ok := svc.(interface{ RevokeOTP(ctx context.Context, phone string) error })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		if err := smsSvc.RevokeOTP(c.Request.Context(), phoneNumber); err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to revoke OTP: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, models.RevokeOTPResponse{
+			Success:     true,
+			Message:     "OTP revoked successfully",
+			PhoneNumber: phoneNumber,
+			Deleted:     true,
+		})
+	}
+}
+
+// @Summary Reset OTP Attempts
+// @Description Give the active OTP for a phone number its full attempt budget back and lift the lockout of the number, e.g. for a user who mistyped their code (admin only)
+// @Tags SMS
+// @Produce json
+// @Security BearerAuth
+// @Param phone path string true "Phone number"
+// @Success 200 {object} models.ResetOTPAttemptsResponse
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Router /sms/otp/{phone}/reset-attempts [post]
+func makeResetOTPAttemptsEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		phoneNumber := common.NormalizePhoneForRegion(c.Param("phone"), cfg.DefaultPhoneRegion)
+
+		if !isValidPhoneNumber(phoneNumber) {
 			appErr := common.NewValidationError("Invalid phone number format")
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
-		// Request callback
-		callbackSvc, ok := svc.(interface{ RequestCallback(ctx context.Context, req models.CallbackRequest) (*models.CallbackResponse, error) })
+		smsSvc, ok := svc.(interface{ ResetOTPAttempts(ctx context.Context, phone string) error })
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Service not available"})
+			respondServiceUnavailable(c)
 			return
 		}
-		
-		response, err := callbackSvc.RequestCallback(c.Request.Context(), req)
-		if err != nil {
+
+		if err := smsSvc.ResetOTPAttempts(c.Request.Context(), phoneNumber); err != nil {
 			var appErr *common.AppError
 			if e, ok := err.(*common.AppError); ok {
 				appErr = e
 			} else {
-				appErr = common.NewInternalError("Failed to request callback: " + err.Error())
+				appErr = common.NewInternalError("Failed to reset OTP attempts: " + err.Error())
 			}
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
-		c.JSON(http.StatusOK, response)
+		c.JSON(http.StatusOK, models.ResetOTPAttemptsResponse{
+			Success:     true,
+			Message:     "OTP attempts reset successfully",
+			PhoneNumber: phoneNumber,
+		})
 	}
 }
 
-// @Summary Get Callback Status
-// @Description Get the status of a callback request
-// @Tags Callback
+// @Summary Get SMS Stats
+// @Description Get aggregate SMS statistics: message counts per status, optionally for a single phone number, and the time-to-verify distribution and average. Statistics for a single phone number are admin only.
+// @Tags SMS
 // @Accept json
 // @Produce json
-// @Param request_id path string true "Callback Request ID"
-// @Success 200 {object} models.Callback
+// @Security BearerAuth
+// @Param phone query string false "Only count messages sent to or received from this phone number (admin only)"
+// @Success 200 {object} models.SMSStats
 // @Failure 400 {object} common.AppError
-// @Failure 404 {object} common.AppError
-// @Router /callback/status/{request_id} [get]
-func makeGetCallbackStatusEndpoint(svc interface{}) gin.HandlerFunc {
+// @Failure 401 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /sms/stats [get]
+func makeGetStatsEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		requestID := c.Param("request_id")
-		
-		if requestID == "" {
-			appErr := common.NewValidationError("Request ID is required")
-			c.JSON(appErr.StatusCode, appErr)
-			return
+		phone := c.Query("phone")
+		if phone != "" {
+			phone = common.NormalizePhoneForRegion(phone, cfg.DefaultPhoneRegion)
+			if !isValidPhoneNumber(phone) {
+				appErr := common.NewValidationError("Invalid phone number format")
+				c.JSON(appErr.StatusCode, appErr)
+				return
+			}
 		}
 
-		// Get callback status
-		callbackSvc, ok := svc.(interface{ GetCallbackStatus(ctx context.Context, requestID string) (*models.Callback, error) })
+		smsSvc, ok := svc.(interface {
+			GetStats(ctx context.Context, phone string) (*models.SMSStats, error)
+		})
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Service not available"})
+			respondServiceUnavailable(c)
 			return
 		}
-		
-		callback, err := callbackSvc.GetCallbackStatus(c.Request.Context(), requestID)
+
+		stats, err := smsSvc.GetStats(c.Request.Context(), phone)
 		if err != nil {
 			var appErr *common.AppError
 			if e, ok := err.(*common.AppError); ok {
 				appErr = e
 			} else {
-				appErr = common.NewInternalError("Failed to get callback status: " + err.Error())
+				appErr = common.NewInternalError("Failed to get stats: " + err.Error())
 			}
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
 
-		c.JSON(http.StatusOK, callback)
+		c.JSON(http.StatusOK, stats)
 	}
 }
 
-// @Summary Get Activity Logs
-// @Description Get all OTP and callback activity logs
-// @Tags Logs
+// @Summary Get SMS Message
+// @Description Get one of the caller's SMS messages, including its delivery status and time. Admins can get any message; other users get 404 for messages they didn't send.
+// @Tags SMS
 // @Accept json
 // @Produce json
-// @Param limit query int false "Limit number of records (default: 100)"
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} common.AppError
-// @Router /logs [get]
-func makeGetLogsEndpoint(svc interface{}) gin.HandlerFunc {
+// @Security BearerAuth
+// @Param id path string true "SMS message ID"
+// @Success 200 {object} models.SMS
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Router /sms/messages/{id} [get]
+func makeGetMessageEndpoint(svc interface{}) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get limit from query parameter, default to 100
-		limitStr := c.DefaultQuery("limit", "100")
-		limit, err := strconv.Atoi(limitStr)
-		if err != nil || limit <= 0 {
-			limit = 100
+		claims, ok := auth.CurrentUser(c)
+		if !ok {
+			appErr := common.NewUnauthorizedError("Authorization header required")
+			c.JSON(appErr.StatusCode, appErr)
+			return
 		}
-		
-		// Get logs from service
-		logsSvc, ok := svc.(interface{ GetLogs(ctx context.Context, limit int) (map[string]interface{}, error) })
+
+		smsSvc, ok := svc.(interface{ GetSMS(ctx context.Context, id string) (*models.SMS, error) })
 		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Service not available"})
+			respondServiceUnavailable(c)
 			return
 		}
-		
-		logs, err := logsSvc.GetLogs(c.Request.Context(), limit)
+
+		sms, err := smsSvc.GetSMS(c.Request.Context(), c.Param("id"))
 		if err != nil {
 			var appErr *common.AppError
 			if e, ok := err.(*common.AppError); ok {
 				appErr = e
 			} else {
-				appErr = common.NewInternalError("Failed to get logs: " + err.Error())
+				appErr = common.NewInternalError("Failed to get SMS message: " + err.Error())
 			}
 			c.JSON(appErr.StatusCode, appErr)
 			return
 		}
+		// Another user's message is reported as missing so IDs can't be probed
+		if claims.Role != auth.RoleAdmin && sms.UserID != claims.UserID {
+			appErr := common.NewNotFoundError("SMS message")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
 
-		c.JSON(http.StatusOK, logs)
+		c.JSON(http.StatusOK, sms)
 	}
-} 
\ No newline at end of file
+}
+
+// @Summary Delete SMS Message
+// @Description Soft-delete an SMS message: it's hidden from lookups and listings but kept for the audit history (admin only)
+// @Tags SMS
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "SMS message ID"
+// @Success 200 {object} models.DeleteSMSResponse
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Router /sms/messages/{id} [delete]
+func makeDeleteMessageEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		smsSvc, ok := svc.(interface{ DeleteSMS(ctx context.Context, id string) error })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		id := c.Param("id")
+		if err := smsSvc.DeleteSMS(c.Request.Context(), id); err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to delete SMS message: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, models.DeleteSMSResponse{
+			Success: true,
+			Message: "SMS message deleted successfully",
+			ID:      id,
+		})
+	}
+}
+
+// @Summary Batch SMS Status
+// @Description Get the delivery status of up to 100 of the caller's SMS messages in one request. Messages the caller didn't send are reported as not found, except to admins.
+// @Tags SMS
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.BatchStatusRequest true "Message IDs"
+// @Success 200 {object} models.BatchStatusResponse
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Router /sms/status/batch [post]
+func makeBatchStatusEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Users only see the messages they sent
+		claims, ok := auth.CurrentUser(c)
+		if !ok {
+			appErr := common.NewUnauthorizedError("Authorization header required")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		userID := claims.UserID
+		if claims.Role == auth.RoleAdmin {
+			userID = ""
+		}
+
+		smsSvc, ok := svc.(interface {
+			GetSMSStatuses(ctx context.Context, ids []string, userID string) (*models.BatchStatusResponse, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		var req models.BatchStatusRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			appErr := bindingError(err)
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		response, err := smsSvc.GetSMSStatuses(c.Request.Context(), req.IDs, userID)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to get SMS statuses: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// @Summary Estimate SMS Cost
+// @Description Estimate the cost of sending a message to up to 10000 recipients, priced per segment by destination country
+// @Tags SMS
+// @Accept json
+// @Produce json
+// @Param request body models.SMSEstimateRequest true "Recipients and message"
+// @Success 200 {object} models.SMSEstimateResponse
+// @Failure 400 {object} common.AppError
+// @Router /sms/estimate [post]
+func makeEstimateEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		smsSvc, ok := svc.(interface {
+			EstimateSMSCost(ctx context.Context, recipients []string, message string) (*models.SMSEstimateResponse, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		var req models.SMSEstimateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			appErr := bindingError(err)
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		for i, phone := range req.Recipients {
+			req.Recipients[i] = common.NormalizePhoneForRegion(phone, cfg.DefaultPhoneRegion)
+		}
+
+		response, err := smsSvc.EstimateSMSCost(c.Request.Context(), req.Recipients, req.Message)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to estimate SMS cost: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// @Summary SMS Provider Health
+// @Description Check that an SMS provider is reachable with the configured credentials and report its balance and circuit breaker state. Results are cached briefly.
+// @Tags SMS
+// @Produce json
+// @Param provider query string false "Provider to check (default: the configured provider)"
+// @Success 200 {object} models.ProviderHealth
+// @Failure 400 {object} common.AppError
+// @Failure 503 {object} models.ProviderHealth
+// @Router /sms/provider/health [get]
+func makeProviderHealthEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		smsSvc, ok := svc.(interface {
+			ProviderHealth(ctx context.Context, provider string) (*models.ProviderHealth, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		health, err := smsSvc.ProviderHealth(c.Request.Context(), c.Query("provider"))
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to check provider health: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		status := http.StatusOK
+		if !health.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, health)
+	}
+}
+
+// @Summary Retry SMS Message
+// @Description Resend a failed or dead-lettered SMS message (admin only)
+// @Tags SMS
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "SMS message ID"
+// @Success 200 {object} models.SMS
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Failure 409 {object} common.AppError
+// @Failure 503 {object} common.AppError
+// @Router /sms/retry/{id} [post]
+func makeRetrySMSEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		smsSvc, ok := svc.(interface{ RetrySMS(ctx context.Context, id string) (*models.SMS, error) })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		sms, err := smsSvc.RetrySMS(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to retry SMS message: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, sms)
+	}
+}
+
+// @Summary Opt Out / Opt In
+// @Description Add a phone number to the suppression list so it receives no further SMS or OTPs (opt-out), or remove it again (opt-in, admin only). Recipients opt back in themselves by texting START.
+// @Tags SMS
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.OptOutRequest true "Opt-out Request"
+// @Success 200 {object} models.OptOutResponse
+// @Failure 400 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /sms/opt-out [post]
+// @Router /sms/opt-in [post]
+func makeOptOutEndpoint(svc interface{}, cfg HandlerConfig, optOut bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.OptOutRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			appErr := bindingError(err)
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		req.PhoneNumber = common.NormalizePhoneForRegion(req.PhoneNumber, cfg.DefaultPhoneRegion)
+		if !isValidPhoneNumber(req.PhoneNumber) {
+			appErr := common.NewValidationError("Invalid phone number format")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		smsSvc, ok := svc.(interface {
+			OptOut(ctx context.Context, phone, reason string) error
+			OptIn(ctx context.Context, phone string) error
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		var err error
+		message := "Phone number opted out successfully"
+		if optOut {
+			err = smsSvc.OptOut(c.Request.Context(), req.PhoneNumber, models.SuppressionReasonRequest)
+		} else {
+			err = smsSvc.OptIn(c.Request.Context(), req.PhoneNumber)
+			message = "Phone number opted in successfully"
+		}
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to update opt-out status: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, models.OptOutResponse{
+			Success:     true,
+			Message:     message,
+			PhoneNumber: req.PhoneNumber,
+			OptedOut:    optOut,
+		})
+	}
+}
+
+// @Summary Inbound SMS Webhook
+// @Description Receive an incoming message from Plivo and store it as an inbound SMS; opt-out keywords such as STOP add the sender to the suppression list
+// @Tags SMS
+// @Accept x-www-form-urlencoded,json
+// @Produce json
+// @Param From formData string true "Sender phone number"
+// @Param To formData string false "Receiving phone number"
+// @Param Text formData string false "Message text"
+// @Param MessageUUID formData string false "Plivo message UUID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} common.AppError
+// @Router /sms/inbound [post]
+func makeInboundEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payload, err := parsePlivoPayload(c)
+		if err != nil {
+			appErr := common.NewValidationError("Invalid webhook body: " + err.Error())
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		msg := models.InboundMessage{
+			From:        payload["From"],
+			To:          payload["To"],
+			Text:        payload["Text"],
+			MessageUUID: payload["MessageUUID"],
+		}
+		if msg.From == "" {
+			appErr := common.NewValidationErrors(map[string]string{"From": "is required"})
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Plivo sends numbers without the leading +
+		if !strings.HasPrefix(msg.From, "+") {
+			msg.From = "+" + msg.From
+		}
+		if msg.To != "" && !strings.HasPrefix(msg.To, "+") {
+			msg.To = "+" + msg.To
+		}
+
+		smsSvc, ok := svc.(interface{ HandleInboundMessage(ctx context.Context, msg models.InboundMessage) error })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		if err := smsSvc.HandleInboundMessage(c.Request.Context(), msg); err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to process inbound message: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
+// @Summary Delivery Report Webhook
+// @Description Receive the delivery status of an outbound message from Plivo and record it on the stored SMS
+// @Tags SMS
+// @Accept x-www-form-urlencoded,json
+// @Produce json
+// @Param MessageUUID formData string true "Plivo message UUID"
+// @Param Status formData string true "Message state" Enums(queued, sent, delivered, undelivered, failed, rejected)
+// @Param ErrorCode formData string false "Plivo error code of a failed delivery"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Router /sms/delivery-report [post]
+func makeDeliveryReportEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payload, err := parsePlivoPayload(c)
+		if err != nil {
+			appErr := common.NewValidationError("Invalid webhook body: " + err.Error())
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		fields := map[string]string{}
+		if payload["MessageUUID"] == "" {
+			fields["MessageUUID"] = "is required"
+		}
+		status, err := ParsePlivoMessageState(payload["Status"])
+		if err != nil {
+			fields["Status"] = "must be a Plivo message state"
+		}
+		if len(fields) > 0 {
+			appErr := common.NewValidationErrors(fields)
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		smsSvc, ok := svc.(interface {
+			HandleDeliveryReport(ctx context.Context, report models.DeliveryReport) error
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		report := models.DeliveryReport{MessageUUID: payload["MessageUUID"], Status: status, ErrorCode: payload["ErrorCode"]}
+		if err := smsSvc.HandleDeliveryReport(c.Request.Context(), report); err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to process delivery report: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
+// maxWebhookBody bounds the size of provider webhook bodies read into memory
+const maxWebhookBody = 1 << 20
+
+// parsePlivoPayload reads the parameters of a Plivo webhook, which posts them
+// form-encoded or as a JSON object depending on the application's settings.
+// The Content-Type header picks the format, but a body that is clearly the
+// other format is parsed as such instead of silently yielding no parameters.
+// Only the first value of repeated form parameters is kept, and JSON values
+// that aren't strings are converted to their JSON text. The body is restored
+// for later handlers.
+func parsePlivoPayload(c *gin.Context) (map[string]string, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if len(body) > maxWebhookBody {
+		return nil, fmt.Errorf("body is larger than %d bytes", maxWebhookBody)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, errors.New("body is empty")
+	}
+
+	contentType := c.ContentType()
+	switch {
+	case trimmed[0] == '{', strings.Contains(contentType, "json") && !bytes.ContainsRune(trimmed, '='):
+		return parseJSONPayload(trimmed)
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		if err := c.Request.ParseMultipartForm(maxWebhookBody); err != nil {
+			return nil, fmt.Errorf("invalid multipart form: %w", err)
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		return firstValues(c.Request.PostForm), nil
+	default:
+		values, err := url.ParseQuery(string(trimmed))
+		if err != nil {
+			return nil, fmt.Errorf("invalid form body: %w", err)
+		}
+		return firstValues(values), nil
+	}
+}
+
+// parseJSONPayload flattens a JSON object into its top-level string values
+func parseJSONPayload(body []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+	if fields == nil {
+		return nil, errors.New("JSON body is not an object")
+	}
+
+	payload := make(map[string]string, len(fields))
+	for key, value := range fields {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			payload[key] = v
+		case json.Number:
+			payload[key] = v.String()
+		default:
+			text, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON value of %s: %w", key, err)
+			}
+			payload[key] = string(text)
+		}
+	}
+	return payload, nil
+}
+
+// firstValues keeps the first value of every form parameter
+func firstValues(values url.Values) map[string]string {
+	payload := make(map[string]string, len(values))
+	for key, list := range values {
+		if len(list) > 0 {
+			payload[key] = list[0]
+		}
+	}
+	return payload
+}
+
+// @Summary Request Callback
+// @Description Request a callback call to the specified phone number
+// @Tags Callback
+// @Accept json
+// @Produce json
+// @Param request body models.CallbackRequest true "Callback Request"
+// @Success 200 {object} models.CallbackResponse
+// @Failure 400 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /callback/request [post]
+func makeRequestCallbackEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.CallbackRequest
+		
+		if err := c.ShouldBindJSON(&req); err != nil {
+			appErr := bindingError(err)
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Normalize and validate phone number format
+		req.PhoneNumber = common.NormalizePhoneForRegion(req.PhoneNumber, cfg.DefaultPhoneRegion)
+		if !isValidPhoneNumber(req.PhoneNumber) {
+			appErr := common.NewValidationError("Invalid phone number format")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Normalize and validate the priority the callback queue is ordered by
+		priority, ok := models.ParseCallbackPriority(req.Priority)
+		if !ok {
+			appErr := common.NewValidationErrors(map[string]string{
+				"priority": fmt.Sprintf("must be one of %s, %s or %s", models.PriorityLow, models.PriorityNormal, models.PriorityHigh),
+			})
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		req.Priority = priority
+
+		// Request callback
+		callbackSvc, ok := svc.(interface{ RequestCallback(ctx context.Context, req models.CallbackRequest) (*models.CallbackResponse, error) })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+		
+		response, err := callbackSvc.RequestCallback(c.Request.Context(), req)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to request callback: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Echo the canonical number so clients can display it
+		response.PhoneNumber = req.PhoneNumber
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// @Summary Get Callback Status
+// @Description Get the status of a callback request
+// @Tags Callback
+// @Accept json
+// @Produce json
+// @Param request_id path string true "Callback Request ID"
+// @Success 200 {object} models.Callback
+// @Failure 400 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Router /callback/status/{request_id} [get]
+func makeGetCallbackStatusEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.Param("request_id")
+		
+		if requestID == "" {
+			appErr := common.NewValidationError("Request ID is required")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Get callback status
+		callbackSvc, ok := svc.(interface{ GetCallbackStatus(ctx context.Context, requestID string) (*models.Callback, error) })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+		
+		callback, err := callbackSvc.GetCallbackStatus(c.Request.Context(), requestID)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to get callback status: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, callback)
+	}
+}
+
+// @Summary Cancel Callback
+// @Description Cancel a callback request that has not completed yet, hanging up the call if one was placed
+// @Tags Callback
+// @Accept json
+// @Produce json
+// @Param request_id path string true "Callback Request ID"
+// @Success 200 {object} models.Callback
+// @Failure 400 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Failure 409 {object} common.AppError
+// @Router /callback/{request_id} [delete]
+func makeCancelCallbackEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.Param("request_id")
+
+		callbackSvc, ok := svc.(interface{ CancelCallback(ctx context.Context, requestID string) (*models.Callback, error) })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		callback, err := callbackSvc.CancelCallback(c.Request.Context(), requestID)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to cancel callback: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, callback)
+	}
+}
+
+// @Summary Voice Answer Webhook
+// @Description Called by Plivo when the call placed for a callback request is answered. Marks the request in progress and returns Plivo XML that reads its message (twice for high priority), then redirects to the configured URL or hangs up. Requests that can't be answered get XML that hangs up, also with status 200, so Plivo always gets instructions rather than an error response.
+// @Tags Callback
+// @Accept x-www-form-urlencoded
+// @Accept json
+// @Produce xml
+// @Param request_id query string true "Callback Request ID, passed as a custom parameter of the answer URL"
+// @Success 200 {string} string "Plivo XML reading the message, or hanging up"
+// @Router /voice/answer [post]
+func makeVoiceAnswerEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Every failure hangs up with status 200, so Plivo ends the call
+		// cleanly instead of handling an error response
+		hangup := func() { c.Data(http.StatusOK, "application/xml", RenderVoiceHangup()) }
+
+		payload, err := parsePlivoPayload(c)
+		if err != nil {
+			hangup()
+			return
+		}
+
+		// The request ID is a custom parameter of the answer URL, or of the body
+		// when Plivo forwards custom parameters there
+		requestID := c.Query("request_id")
+		if requestID == "" {
+			requestID = payload["request_id"]
+		}
+		if requestID == "" {
+			hangup()
+			return
+		}
+
+		callbackSvc, ok := svc.(interface {
+			AnswerCallback(ctx context.Context, requestID, callUUID string) (*models.Callback, error)
+		})
+		if !ok {
+			hangup()
+			return
+		}
+
+		callback, err := callbackSvc.AnswerCallback(c.Request.Context(), requestID, payload["CallUUID"])
+		if err != nil {
+			hangup()
+			return
+		}
+
+		body, err := RenderVoiceAnswer(cfg.Voice, callback.Message, callbackRepeats(callback.Priority))
+		if err != nil {
+			hangup()
+			return
+		}
+		c.Data(http.StatusOK, "application/xml", body)
+	}
+}
+
+// @Summary Get Activity Logs
+// @Description Get all OTP and callback activity logs. A section that can't be retrieved is returned empty with an error note, also listed under errors; the request only fails when every section does.
+// @Tags Logs
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Limit number of records per section (default: 100, clamped to the configured maximum, 1000 by default); the limit used is returned as limit_applied"
+// @Param cursor query string false "Return the records after this cursor, the next_cursor of the section on a previous page; a bare RFC 3339 timestamp returns the records older than it"
+// @Param direction query string false "Only return inbound or outbound SMS" Enums(inbound, outbound)
+// @Param metadata query string false "Only return SMS tagged with this metadata pair, as key:value (cannot be combined with direction)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /logs [get]
+func makeGetLogsEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get limit from query parameter, clamped to the configured maximum
+		limit := parseLimit(c, cfg.DefaultListLimit, cfg.MaxListLimit)
+
+		// Optional cursor: only records after it are returned
+		var before models.LogCursor
+		if cursor := c.Query("cursor"); cursor != "" {
+			parsed, err := models.ParseLogCursor(cursor)
+			if err != nil {
+				appErr := common.NewValidationError("Invalid cursor, expected a next_cursor or an RFC 3339 timestamp")
+				c.JSON(appErr.StatusCode, appErr)
+				return
+			}
+			before = parsed
+		}
+		
+		// Optional SMS direction and metadata filters
+		filter, appErr := parseSMSLogFilter(c)
+		if appErr != nil {
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		if filter.Direction != "" && filter.MetadataKey != "" {
+			appErr := common.NewValidationError("Metadata and direction filters cannot be combined")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		
+		// Get logs from service
+		logsSvc, ok := svc.(interface {
+			GetLogs(ctx context.Context, limit int, before models.LogCursor, filter models.SMSLogFilter) (map[string]interface{}, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+		
+		logs, err := logsSvc.GetLogs(c.Request.Context(), limit, before, filter)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to get logs: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// limit_applied is the limit the logs were read with, which differs from
+		// the requested one when that was missing, invalid or over the maximum;
+		// limit carries the same value for older clients
+		logs["limit"] = limit
+		logs["limit_applied"] = limit
+		c.JSON(http.StatusOK, logs)
+	}
+}
+
+// @Summary List SMS Messages
+// @Description List the caller's SMS messages, newest first, a page at a time. Admins list every message.
+// @Tags SMS
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Limit number of records (default: 100, clamped to the configured maximum)"
+// @Param offset query int false "Number of records to skip (default: 0)"
+// @Param direction query string false "Only return inbound or outbound SMS" Enums(inbound, outbound)
+// @Param metadata query string false "Only return SMS tagged with this metadata pair, as key:value"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /sms/messages [get]
+func makeListSMSEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := parseLimit(c, cfg.DefaultListLimit, cfg.MaxListLimit)
+		offset, appErr := parseOffset(c)
+		if appErr != nil {
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		filter, appErr := parseSMSLogFilter(c)
+		if appErr != nil {
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Users only see the messages they sent
+		claims, ok := auth.CurrentUser(c)
+		if !ok {
+			appErr := common.NewUnauthorizedError("Authorization header required")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		if claims.Role != auth.RoleAdmin {
+			filter.UserID = claims.UserID
+		}
+
+		logsSvc, ok := svc.(interface {
+			ListSMS(ctx context.Context, filter models.SMSLogFilter, offset, limit int) (*models.PaginatedResponse, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		page, err := logsSvc.ListSMS(c.Request.Context(), filter, offset, limit)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to list SMS messages: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// parseOffset reads the offset query parameter of paginated lists, 0 when missing
+func parseOffset(c *gin.Context) (int, *common.AppError) {
+	value := c.Query("offset")
+	if value == "" {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(value)
+	if err != nil || offset < 0 {
+		return 0, common.NewValidationError("Offset must be a non-negative integer")
+	}
+	return offset, nil
+}
+
+// parseSMSLogFilter reads the optional direction and metadata (key:value) filters of SMS listings
+func parseSMSLogFilter(c *gin.Context) (models.SMSLogFilter, *common.AppError) {
+	filter := models.SMSLogFilter{Direction: c.Query("direction")}
+	if filter.Direction != "" && filter.Direction != models.DirectionInbound && filter.Direction != models.DirectionOutbound {
+		return filter, common.NewValidationError("Direction must be inbound or outbound")
+	}
+
+	if metadata := c.Query("metadata"); metadata != "" {
+		key, value, found := strings.Cut(metadata, ":")
+		if !found || !isValidMetadataKey(key) {
+			return filter, common.NewValidationError("Metadata filter must be key:value with a key of letters, digits, '_' or '-'")
+		}
+		filter.MetadataKey, filter.MetadataValue = key, value
+	}
+	return filter, nil
+}
+
+// @Summary Export Activity Logs
+// @Description Download SMS, OTP or callback records as CSV, streamed oldest first. OTP codes are not included, and cells that would start a spreadsheet formula are prefixed with a single quote.
+// @Tags Logs
+// @Produce text/csv
+// @Security BearerAuth
+// @Param type query string true "Record type" Enums(sms, otp, callback)
+// @Param from query string false "Only records at or after this time (RFC 3339 or YYYY-MM-DD)"
+// @Param to query string false "Only records before this time (RFC 3339, or YYYY-MM-DD to include that whole day)"
+// @Success 200 {file} file
+// @Failure 400 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /logs/export [get]
+func makeExportLogsEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logType := c.Query("type")
+		if logType != models.LogTypeSMS && logType != models.LogTypeOTP && logType != models.LogTypeCallback {
+			appErr := common.NewValidationError("Type must be sms, otp or callback")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		from, err := parseExportTime(c.Query("from"), false)
+		if err != nil {
+			appErr := common.NewValidationError("Invalid from, expected an RFC 3339 timestamp or YYYY-MM-DD date")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		to, err := parseExportTime(c.Query("to"), true)
+		if err != nil {
+			appErr := common.NewValidationError("Invalid to, expected an RFC 3339 timestamp or YYYY-MM-DD date")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		logsSvc, ok := svc.(interface {
+			ExportLogs(ctx context.Context, logType string, from, to time.Time, w io.Writer) error
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		out := &exportWriter{c: c, filename: fmt.Sprintf("%s-logs-%s.csv", logType, time.Now().UTC().Format("20060102T150405Z"))}
+		if err := logsSvc.ExportLogs(c.Request.Context(), logType, from, to, out); err != nil {
+			if out.started {
+				// Headers are already sent; the truncated download is all we can signal
+				c.Error(err)
+				return
+			}
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to export logs: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+	}
+}
+
+// @Summary Search Activity Timeline
+// @Description Get the OTP, SMS and callback records of one phone number as a single timeline, newest first. Each entry carries its type (otp, sms or callback). OTP codes are left out.
+// @Tags Logs
+// @Produce json
+// @Security BearerAuth
+// @Param phone query string true "Phone number, normalized like the numbers records are stored under"
+// @Param from query string false "Only records at or after this time (RFC 3339 or YYYY-MM-DD)"
+// @Param to query string false "Only records before this time (RFC 3339, or YYYY-MM-DD to include that whole day)"
+// @Param limit query int false "Limit number of entries (default: 100, clamped to the configured maximum)"
+// @Success 200 {object} models.PhoneTimeline
+// @Failure 400 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /logs/search [get]
+func makeSearchLogsEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		phone := common.NormalizePhoneForRegion(c.Query("phone"), cfg.DefaultPhoneRegion)
+		if !isValidPhoneNumber(phone) {
+			appErr := common.NewValidationErrors(map[string]string{"phone": "must be a valid phone number"})
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		from, err := parseExportTime(c.Query("from"), false)
+		if err != nil {
+			appErr := common.NewValidationError("Invalid from, expected an RFC 3339 timestamp or YYYY-MM-DD date")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		to, err := parseExportTime(c.Query("to"), true)
+		if err != nil {
+			appErr := common.NewValidationError("Invalid to, expected an RFC 3339 timestamp or YYYY-MM-DD date")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+			appErr := common.NewValidationError("from must be before to")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		limit := parseLimit(c, cfg.DefaultListLimit, cfg.MaxListLimit)
+
+		logsSvc, ok := svc.(interface {
+			SearchLogs(ctx context.Context, phone string, from, to time.Time, limit int) (*models.PhoneTimeline, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		timeline, err := logsSvc.SearchLogs(c.Request.Context(), phone, from, to, limit)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to search logs: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, timeline)
+	}
+}
+
+// @Summary List Audit Log
+// @Description List recorded state changes of OTPs, SMS messages and callback requests, oldest first, so the history can be replayed. Phone numbers are masked. Admin only.
+// @Tags Logs
+// @Produce json
+// @Security BearerAuth
+// @Param from query string false "Only changes at or after this time (RFC 3339 or YYYY-MM-DD)"
+// @Param to query string false "Only changes before this time (RFC 3339, or YYYY-MM-DD to include that whole day)"
+// @Param type query string false "Only this event type, e.g. sms.delivered, or every event of a kind: otp, sms or callback"
+// @Param limit query int false "Limit number of records (default: 100, clamped to the configured maximum)"
+// @Param offset query int false "Number of records to skip (default: 0)"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /audit [get]
+func makeListAuditEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := parseLimit(c, cfg.DefaultListLimit, cfg.MaxListLimit)
+		offset, appErr := parseOffset(c)
+		if appErr != nil {
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		from, err := parseExportTime(c.Query("from"), false)
+		if err != nil {
+			appErr := common.NewValidationError("Invalid from, expected an RFC 3339 timestamp or YYYY-MM-DD date")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		to, err := parseExportTime(c.Query("to"), true)
+		if err != nil {
+			appErr := common.NewValidationError("Invalid to, expected an RFC 3339 timestamp or YYYY-MM-DD date")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+			appErr := common.NewValidationError("From must be before to")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		eventType := strings.ToLower(strings.TrimSpace(c.Query("type")))
+		if eventType != "" && !isValidAuditType(eventType) {
+			appErr := common.NewValidationError("Type must be otp, sms or callback, optionally followed by a state, e.g. sms.delivered")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		logsSvc, ok := svc.(interface {
+			ListAudit(ctx context.Context, filter models.AuditFilter, offset, limit int) (*models.PaginatedResponse, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		filter := models.AuditFilter{From: from, To: to, Type: eventType}
+		page, err := logsSvc.ListAudit(c.Request.Context(), filter, offset, limit)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to list audit records: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// isValidAuditType reports whether an audit type filter names a record kind,
+// optionally followed by a state of letters and underscores
+func isValidAuditType(eventType string) bool {
+	kind, state, hasState := strings.Cut(eventType, ".")
+	if kind != models.AuditKindOTP && kind != models.AuditKindSMS && kind != models.AuditKindCallback {
+		return false
+	}
+	if !hasState {
+		return true
+	}
+	if state == "" {
+		return false
+	}
+	for _, char := range state {
+		if (char < 'a' || char > 'z') && char != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// exportWriter sends the CSV download headers on the first write and flushes
+// every write to the client so exports stream instead of buffering
+type exportWriter struct {
+	c        *gin.Context
+	filename string
+	started  bool
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.c.Header("Content-Type", "text/csv; charset=utf-8")
+		w.c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", w.filename))
+		w.c.Status(http.StatusOK)
+		w.started = true
+	}
+	n, err := w.c.Writer.Write(p)
+	w.c.Writer.Flush()
+	return n, err
+}
+
+// parseExportTime parses an RFC 3339 timestamp or a YYYY-MM-DD date. A date used
+// as an upper bound is moved to the end of that day. Empty values are open-ended.
+func parseExportTime(value string, upper bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseLimit reads the limit query parameter, falling back to defaultLimit
+// when missing or invalid and clamping it to maxLimit
+func parseLimit(c *gin.Context, defaultLimit, maxLimit int) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit
+}
+
+// @Summary Daily Analytics
+// @Description Daily counts and success rates of OTPs sent and verified, SMS delivered and callbacks completed, one entry per UTC day including empty days. OTP verifications count towards the day the code was sent. Admin only.
+// @Tags Analytics
+// @Produce json
+// @Security BearerAuth
+// @Param from query string false "First day, YYYY-MM-DD (default: 29 days before to)"
+// @Param to query string false "Last day, YYYY-MM-DD (default: today)"
+// @Success 200 {object} models.DailyAnalyticsResponse
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /analytics/daily [get]
+func makeDailyAnalyticsEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, err := parseAnalyticsDay(c.Query("from"))
+		if err != nil {
+			appErr := common.NewValidationError("Invalid from, expected a YYYY-MM-DD date")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		to, err := parseAnalyticsDay(c.Query("to"))
+		if err != nil {
+			appErr := common.NewValidationError("Invalid to, expected a YYYY-MM-DD date")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		logsSvc, ok := svc.(interface {
+			GetDailyAnalytics(ctx context.Context, from, to time.Time) (*models.DailyAnalyticsResponse, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		response, err := logsSvc.GetDailyAnalytics(c.Request.Context(), from, to)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to get analytics: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// parseAnalyticsDay parses a YYYY-MM-DD day in UTC; an empty value is the zero time
+func parseAnalyticsDay(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// Partial phone search limits
+const (
+	minPhoneSearchLength    = 3
+	defaultPhoneSearchLimit = 20
+	maxPhoneSearchLimit     = 50
+)
+
+// @Summary Search by Partial Phone
+// @Description Prefix or suffix search across OTP, SMS and callback records. Matched numbers are masked.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param q query string true "Partial phone number (at least 3 digits)"
+// @Param match query string false "Match mode: prefix or suffix (default: prefix when q starts with +, otherwise suffix)"
+// @Param limit query int false "Maximum matches per record type (default: 20, max: 50)"
+// @Success 200 {object} models.PhoneSearchResult
+// @Failure 400 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /admin/search/phone [get]
+func makeSearchPhoneEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, match, appErr := parsePhoneSearchQuery(c.Query("q"), c.Query("match"))
+		if appErr != nil {
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		limit := parseLimit(c, defaultPhoneSearchLimit, maxPhoneSearchLimit)
+
+		// Search records
+		logsSvc, ok := svc.(interface{ SearchByPhone(ctx context.Context, query, match string, limit int) (*models.PhoneSearchResult, error) })
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		result, err := logsSvc.SearchByPhone(c.Request.Context(), query, match, limit)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to search by phone: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		result.Limit = limit
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// parsePhoneSearchQuery validates a partial phone query and resolves the match mode
+func parsePhoneSearchQuery(q, match string) (string, string, *common.AppError) {
+	q = strings.TrimSpace(q)
+	digits := strings.TrimPrefix(q, "+")
+
+	if len(digits) < minPhoneSearchLength || len(digits) > 15 {
+		return "", "", common.NewValidationError("Query must contain between 3 and 15 digits")
+	}
+	for _, char := range digits {
+		if char < '0' || char > '9' {
+			return "", "", common.NewValidationError("Query must contain digits only")
+		}
+	}
+
+	if match == "" {
+		match = models.PhoneMatchSuffix
+		if strings.HasPrefix(q, "+") {
+			match = models.PhoneMatchPrefix
+		}
+	}
+
+	switch match {
+	case models.PhoneMatchPrefix:
+		// Stored numbers are in international format
+		return "+" + digits, match, nil
+	case models.PhoneMatchSuffix:
+		return digits, match, nil
+	default:
+		return "", "", common.NewValidationError("Match must be 'prefix' or 'suffix'")
+	}
+}
+
+// @Summary List Flagged Phones
+// @Description List phone numbers flagged for a suspected OTP brute-force attack, newest first, for review. Admin only.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Limit number of records (default: 100, clamped to the configured maximum)"
+// @Param offset query int false "Number of records to skip (default: 0)"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Router /admin/flagged-phones [get]
+func makeListFlaggedPhonesEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := parseLimit(c, cfg.DefaultListLimit, cfg.MaxListLimit)
+		offset, appErr := parseOffset(c)
+		if appErr != nil {
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		smsSvc, ok := svc.(interface {
+			ListFlaggedPhones(ctx context.Context, offset, limit int) (*models.PaginatedResponse, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		page, err := smsSvc.ListFlaggedPhones(c.Request.Context(), offset, limit)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to list flagged phone numbers: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// @Summary Look Up User
+// @Description Find a user account by phone number or email, for support tooling. Requires an admin token.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param phone query string false "Phone number; exactly one of phone and email is required"
+// @Param email query string false "Email address; exactly one of phone and email is required"
+// @Success 200 {object} models.UserProfile
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 403 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Security BearerAuth
+// @Router /users/lookup [get]
+func makeLookupUserEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		phone := strings.TrimSpace(c.Query("phone"))
+		email := strings.TrimSpace(c.Query("email"))
+		if (phone == "") == (email == "") {
+			appErr := common.NewValidationError("Exactly one of phone and email is required")
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+		if phone != "" {
+			phone = common.NormalizePhoneForRegion(phone, cfg.DefaultPhoneRegion)
+			if !isValidPhoneNumber(phone) {
+				appErr := common.NewValidationError("Invalid phone number format")
+				c.JSON(appErr.StatusCode, appErr)
+				return
+			}
+		}
+
+		// Look up the user
+		userSvc, ok := svc.(interface {
+			LookupUser(ctx context.Context, phone, email string) (*models.UserProfile, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		profile, err := userSvc.LookupUser(c.Request.Context(), phone, email)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to look up user: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+// @Summary Get Profile
+// @Description Return the profile of the authenticated user
+// @Tags Users
+// @Produce json
+// @Success 200 {object} models.UserProfile
+// @Failure 401 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Security BearerAuth
+// @Router /users/profile [get]
+func makeGetProfileEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		// Get the profile
+		userSvc, ok := svc.(interface {
+			GetProfile(ctx context.Context, userID string) (*models.UserProfile, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		profile, err := userSvc.GetProfile(c.Request.Context(), userID)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to get profile: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+// @Summary Update Profile
+// @Description Change the name and/or email of the authenticated user. The email must be valid and not used by another account; the phone number can't be changed here.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body models.UserProfileUpdate true "Fields to change"
+// @Success 200 {object} models.UserProfile
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Failure 409 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Security BearerAuth
+// @Router /users/profile [put]
+func makeUpdateProfileEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		var update models.UserProfileUpdate
+		if err := c.ShouldBindJSON(&update); err != nil {
+			appErr := bindingError(err)
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Update the profile
+		userSvc, ok := svc.(interface {
+			UpdateProfile(ctx context.Context, userID string, update models.UserProfileUpdate) (*models.UserProfile, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		profile, err := userSvc.UpdateProfile(c.Request.Context(), userID, update)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to update profile: " + err.Error())
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+// requireUser returns the authenticated user's ID, answering 401 for anonymous requests
+func requireUser(c *gin.Context) (string, bool) {
+	claims, ok := auth.CurrentUser(c)
+	if !ok {
+		appErr := common.NewUnauthorizedError("Authorization header required")
+		c.JSON(appErr.StatusCode, appErr)
+		return "", false
+	}
+	return claims.UserID, true
+}
+
+// bindPhoneChange reads a phone change request with the number normalized to E.164
+func bindPhoneChange(c *gin.Context, cfg HandlerConfig) (models.PhoneChangeRequest, bool) {
+	var req models.PhoneChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		appErr := bindingError(err)
+		c.JSON(appErr.StatusCode, appErr)
+		return req, false
+	}
+
+	req.PhoneNumber = common.NormalizePhoneForRegion(req.PhoneNumber, cfg.DefaultPhoneRegion)
+	if !isValidPhoneNumber(req.PhoneNumber) {
+		appErr := common.NewValidationError("Invalid phone number format")
+		c.JSON(appErr.StatusCode, appErr)
+		return req, false
+	}
+	return req, true
+}
+
+// @Summary Start Phone Change
+// @Description Send an OTP to the new phone number of the authenticated user. The number must not belong to another account.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body models.PhoneChangeRequest true "New phone number"
+// @Success 200 {object} models.OTPResponse
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 409 {object} common.AppError
+// @Failure 429 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Security BearerAuth
+// @Router /users/change-phone/initiate [post]
+func makeInitiatePhoneChangeEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		req, ok := bindPhoneChange(c, cfg)
+		if !ok {
+			return
+		}
+
+		// Send the OTP to the new number
+		userSvc, ok := svc.(interface {
+			InitiatePhoneChange(ctx context.Context, userID, phone string) (*models.OTPResponse, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		response, err := userSvc.InitiatePhoneChange(c.Request.Context(), userID, req.PhoneNumber)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to start phone change: " + err.Error())
+			}
+			if appErr.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(appErr.RetryAfter))
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		response.PhoneNumber = req.PhoneNumber
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// @Summary Confirm Phone Change
+// @Description Verify the OTP sent to the new phone number and move the authenticated user to it. The old number gets a notice of the change and every session of the user is revoked, so the user has to sign in again.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body models.PhoneChangeRequest true "New phone number and the OTP sent to it"
+// @Success 200 {object} models.UserProfile
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 409 {object} common.AppError
+// @Failure 429 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Security BearerAuth
+// @Router /users/change-phone/confirm [post]
+func makeConfirmPhoneChangeEndpoint(svc interface{}, cfg HandlerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+		req, ok := bindPhoneChange(c, cfg)
+		if !ok {
+			return
+		}
+		if req.OTP == "" {
+			appErr := common.NewValidationErrors(map[string]string{"otp": "is required"})
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Verify the OTP and change the number
+		userSvc, ok := svc.(interface {
+			ConfirmPhoneChange(ctx context.Context, userID, phone, code string) (*models.UserProfile, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		profile, err := userSvc.ConfirmPhoneChange(c.Request.Context(), userID, req.PhoneNumber, req.OTP)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to change phone number: " + err.Error())
+			}
+			if appErr.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(appErr.RetryAfter))
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+// @Summary Delete Account
+// @Description Permanently delete the authenticated user together with the OTPs and SMS messages of their phone number. Request an OTP with purpose delete_account to the user's phone number first and pass it here.
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param request body models.AccountDeletionRequest true "OTP confirming the deletion"
+// @Success 200 {object} models.AccountDeletionSummary
+// @Failure 400 {object} common.AppError
+// @Failure 401 {object} common.AppError
+// @Failure 404 {object} common.AppError
+// @Failure 429 {object} common.AppError
+// @Failure 500 {object} common.AppError
+// @Security BearerAuth
+// @Router /users/me [delete]
+func makeDeleteAccountEndpoint(svc interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUser(c)
+		if !ok {
+			return
+		}
+
+		var req models.AccountDeletionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			appErr := bindingError(err)
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		// Delete the account
+		userSvc, ok := svc.(interface {
+			DeleteAccount(ctx context.Context, userID, code string) (*models.AccountDeletionSummary, error)
+		})
+		if !ok {
+			respondServiceUnavailable(c)
+			return
+		}
+
+		summary, err := userSvc.DeleteAccount(c.Request.Context(), userID, req.OTP)
+		if err != nil {
+			var appErr *common.AppError
+			if e, ok := err.(*common.AppError); ok {
+				appErr = e
+			} else {
+				appErr = common.NewInternalError("Failed to delete account: " + err.Error())
+			}
+			if appErr.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(appErr.RetryAfter))
+			}
+			c.JSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		c.JSON(http.StatusOK, summary)
+	}
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"sms-app-backend/models"
+)
+
+// ProviderMock is the name of the mock client built when no provider is configured
+const ProviderMock = "mock"
+
+// NewClientFromEnv builds the SMS client selected by SMS_PROVIDER (plivo or
+// mock). Without SMS_PROVIDER the provider whose credentials are set is used,
+// and the mock client when there are none. A provider with only part of its
+// credentials set is an error naming the missing variables.
+//
+// Plivo reads PLIVO_AUTH_ID, PLIVO_AUTH_TOKEN and PLIVO_FROM_NUMBER, or
+// PLIVO_FROM_NUMBERS with PLIVO_FROM_ROTATION to rotate over several numbers.
+// Twilio, selected or detected from TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN or
+// TWILIO_FROM_NUMBER, is an error until its client can send messages.
+//
+// The provider API is called with httpClient, or a default client when nil.
+func NewClientFromEnv(httpClient *http.Client) (SMSClient, error) {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("SMS_PROVIDER")))
+	if provider == "" {
+		provider = detectProvider()
+	}
+	return newProviderFromEnv(provider, httpClient)
+}
+
+// NewAdditionalClientsFromEnv builds the clients of the comma-separated
+// providers in SMS_ADDITIONAL_PROVIDERS, which requests can select by name,
+// e.g. for tenants that must use a specific carrier. Each reads the same
+// variables as when selected by SMS_PROVIDER; the default provider is skipped.
+func NewAdditionalClientsFromEnv(httpClient *http.Client, defaultProvider string) ([]SMSClient, error) {
+	var clients []SMSClient
+	seen := map[string]bool{defaultProvider: true}
+	for _, provider := range strings.Split(os.Getenv("SMS_ADDITIONAL_PROVIDERS"), ",") {
+		provider = strings.ToLower(strings.TrimSpace(provider))
+		if provider == "" || seen[provider] {
+			continue
+		}
+		seen[provider] = true
+
+		client, err := newProviderFromEnv(provider, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// newProviderFromEnv builds the client of the named provider from its variables
+func newProviderFromEnv(provider string, httpClient *http.Client) (SMSClient, error) {
+	switch provider {
+	case models.ProviderPlivo:
+		return newPlivoClientFromEnv(httpClient)
+	case models.ProviderTwilio:
+		return nil, fmt.Errorf("twilio provider can't be used: %w", ErrTwilioSendUnsupported)
+	case ProviderMock:
+		return NewMockClient(ProviderMock), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q, expected %s or %s", provider, models.ProviderPlivo, ProviderMock)
+	}
+}
+
+// detectProvider picks the provider that has any of its variables set
+func detectProvider() string {
+	if anyEnv("PLIVO_AUTH_ID", "PLIVO_AUTH_TOKEN", "PLIVO_FROM_NUMBER", "PLIVO_FROM_NUMBERS") {
+		return models.ProviderPlivo
+	}
+	if anyEnv("TWILIO_ACCOUNT_SID", "TWILIO_AUTH_TOKEN", "TWILIO_FROM_NUMBER") {
+		return models.ProviderTwilio
+	}
+	return ProviderMock
+}
+
+func newPlivoClientFromEnv(httpClient *http.Client) (SMSClient, error) {
+	from := os.Getenv("PLIVO_FROM_NUMBER")
+	opts := []PlivoOption{WithPlivoHTTPClient(httpClient)}
+	if senders := ParseSenderNumbers(os.Getenv("PLIVO_FROM_NUMBERS")); len(senders) > 0 {
+		rotation, err := ParseRotationStrategy(os.Getenv("PLIVO_FROM_ROTATION"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PLIVO_FROM_ROTATION: %w", err)
+		}
+		opts = append(opts, WithSenderNumbers(rotation, senders...))
+		if from == "" {
+			from = senders[0]
+		}
+	}
+
+	missing := missingEnv("PLIVO_AUTH_ID", "PLIVO_AUTH_TOKEN")
+	if from == "" {
+		missing = append(missing, "PLIVO_FROM_NUMBER or PLIVO_FROM_NUMBERS")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("plivo provider is missing %s", strings.Join(missing, ", "))
+	}
+	return NewPlivoClient(os.Getenv("PLIVO_AUTH_ID"), os.Getenv("PLIVO_AUTH_TOKEN"), from, opts...), nil
+}
+
+// anyEnv reports whether any of the variables is set to a non-empty value
+func anyEnv(names ...string) bool {
+	return len(missingEnv(names...)) < len(names)
+}
+
+// missingEnv returns the variables that are unset or empty
+func missingEnv(names ...string) []string {
+	var missing []string
+	for _, name := range names {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// providerEnv lists every variable NewClientFromEnv reads, so tests start from a clean slate
+var providerEnv = []string{
+	"SMS_PROVIDER", "SMS_ADDITIONAL_PROVIDERS",
+	"PLIVO_AUTH_ID", "PLIVO_AUTH_TOKEN", "PLIVO_FROM_NUMBER", "PLIVO_FROM_NUMBERS", "PLIVO_FROM_ROTATION",
+	"TWILIO_ACCOUNT_SID", "TWILIO_AUTH_TOKEN", "TWILIO_FROM_NUMBER",
+}
+
+func TestNewClientFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		provider string
+		err      string
+	}{
+		{"nothing configured", nil, ProviderMock, ""},
+		{"explicit mock", map[string]string{"SMS_PROVIDER": "mock", "PLIVO_AUTH_ID": "id"}, ProviderMock, ""},
+		{"plivo detected", map[string]string{"PLIVO_AUTH_ID": "id", "PLIVO_AUTH_TOKEN": "token", "PLIVO_FROM_NUMBER": "+15550000000"}, "plivo", ""},
+		{"plivo with rotation", map[string]string{"PLIVO_AUTH_ID": "id", "PLIVO_AUTH_TOKEN": "token", "PLIVO_FROM_NUMBERS": "+15550000001,+15550000002", "PLIVO_FROM_ROTATION": "lru"}, "plivo", ""},
+		{"plivo partial", map[string]string{"PLIVO_AUTH_ID": "id"}, "", "plivo provider is missing PLIVO_AUTH_TOKEN, PLIVO_FROM_NUMBER or PLIVO_FROM_NUMBERS"},
+		{"plivo bad rotation", map[string]string{"PLIVO_AUTH_ID": "id", "PLIVO_AUTH_TOKEN": "token", "PLIVO_FROM_NUMBERS": "+15550000001", "PLIVO_FROM_ROTATION": "random"}, "", "invalid PLIVO_FROM_ROTATION"},
+		// Twilio can't send yet, so it must not start up pretending to
+		{"twilio detected", map[string]string{"TWILIO_ACCOUNT_SID": "AC1", "TWILIO_AUTH_TOKEN": "token", "TWILIO_FROM_NUMBER": "+15550000000"}, "", "twilio provider can't be used"},
+		{"twilio selected", map[string]string{"SMS_PROVIDER": "Twilio", "TWILIO_ACCOUNT_SID": "AC1", "TWILIO_AUTH_TOKEN": "token", "TWILIO_FROM_NUMBER": "+15550000000"}, "", "twilio provider can't be used"},
+		{"unknown provider", map[string]string{"SMS_PROVIDER": "carrier-pigeon"}, "", `unknown SMS provider "carrier-pigeon"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range providerEnv {
+				t.Setenv(name, tt.env[name])
+			}
+
+			client, err := NewClientFromEnv(nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if client.GetProvider() != tt.provider {
+				t.Errorf("Expected provider %s, got %s", tt.provider, client.GetProvider())
+			}
+		})
+	}
+}
+
+func TestNewAdditionalClientsFromEnv(t *testing.T) {
+	for _, name := range providerEnv {
+		t.Setenv(name, "")
+	}
+	t.Setenv("PLIVO_AUTH_ID", "id")
+	t.Setenv("PLIVO_AUTH_TOKEN", "token")
+	t.Setenv("PLIVO_FROM_NUMBER", "+15550000000")
+
+	// The default provider and repeated names are registered once
+	t.Setenv("SMS_ADDITIONAL_PROVIDERS", "Mock, plivo,mock,")
+	clients, err := NewAdditionalClientsFromEnv(nil, "plivo")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(clients) != 1 || clients[0].GetProvider() != ProviderMock {
+		t.Errorf("Expected only the mock client, got %v", clients)
+	}
+
+	t.Setenv("SMS_ADDITIONAL_PROVIDERS", "mock,carrier-pigeon")
+	if _, err := NewAdditionalClientsFromEnv(nil, "plivo"); err == nil || !strings.Contains(err.Error(), `unknown SMS provider "carrier-pigeon"`) {
+		t.Errorf("Expected unknown provider error, got %v", err)
+	}
+
+	t.Setenv("SMS_ADDITIONAL_PROVIDERS", "twilio")
+	if _, err := NewAdditionalClientsFromEnv(nil, "plivo"); err == nil || !errors.Is(err, ErrTwilioSendUnsupported) {
+		t.Errorf("Expected twilio to be rejected, got %v", err)
+	}
+
+	t.Setenv("PLIVO_AUTH_TOKEN", "")
+	t.Setenv("SMS_ADDITIONAL_PROVIDERS", "plivo")
+	if _, err := NewAdditionalClientsFromEnv(nil, "mock"); err == nil || !strings.Contains(err.Error(), "plivo provider is missing PLIVO_AUTH_TOKEN") {
+		t.Errorf("Expected missing credentials error, got %v", err)
+	}
+}
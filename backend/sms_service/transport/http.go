@@ -11,34 +11,149 @@ import (
 // HTTPHandler handles HTTP requests for the SMS service
 type HTTPHandler struct {
 	endpoints Endpoints
+	available bool
+	reason    string
+	check     func() bool
+	admin     []gin.HandlerFunc
+	webhook   []gin.HandlerFunc
 }
 
-// NewHTTPHandler creates a new HTTP handler
-func NewHTTPHandler(svc interface{}) *HTTPHandler {
+// NewHTTPHandler creates a new HTTP handler.
+// A nil svc means the backing service is unavailable; routes are still
+// registered but respond with 503 Service Unavailable.
+func NewHTTPHandler(svc interface{}, opts ...HandlerOption) *HTTPHandler {
+	cfg := DefaultHandlerConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	admin := cfg.AdminMiddleware
+	if len(admin) == 0 {
+		admin = []gin.HandlerFunc{denyAdmin}
+	}
+
 	return &HTTPHandler{
-		endpoints: MakeEndpoints(svc),
+		endpoints: MakeEndpoints(svc, cfg),
+		available: svc != nil,
+		reason:    cfg.UnavailableReason,
+		check:     cfg.AvailabilityCheck,
+		admin:     admin,
+		webhook:   cfg.WebhookMiddleware,
 	}
 }
 
 // RegisterRoutes registers all SMS service routes
 func (h *HTTPHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router = router.Group("", h.serviceGuard())
+
 	sms := router.Group("/sms")
 	{
 		sms.POST("/send-otp", h.endpoints.SendOTP)
+		sms.POST("/resend-otp", h.endpoints.ResendOTP)
+		sms.POST("/otp/escalate", h.endpoints.EscalateOTP)
 		sms.POST("/verify-otp", h.endpoints.VerifyOTP)
 		sms.POST("/send-sms", h.endpoints.SendSMS)
 		sms.GET("/otp-status/:phone", h.endpoints.GetOTPStatus)
+		sms.GET("/stats", h.adminOnlyWithQuery("phone", h.endpoints.GetStats)...)
+		sms.GET("/provider/health", h.endpoints.ProviderHealth)
+		sms.GET("/messages", h.endpoints.ListSMS)
+		sms.GET("/messages/:id", h.endpoints.GetMessage)
+		sms.DELETE("/messages/:id", h.adminOnly(h.endpoints.DeleteMessage)...)
+		sms.POST("/status/batch", h.endpoints.BatchStatus)
+		sms.POST("/estimate", h.endpoints.Estimate)
+		sms.POST("/opt-out", h.endpoints.OptOut)
+		sms.POST("/opt-in", h.adminOnly(h.endpoints.OptIn)...)
+		sms.POST("/inbound", h.providerWebhook(h.endpoints.Inbound)...)
+		sms.POST("/delivery-report", h.providerWebhook(h.endpoints.DeliveryReport)...)
+		sms.DELETE("/otp/:phone", h.adminOnly(h.endpoints.RevokeOTP)...)
+		sms.POST("/otp/:phone/reset-attempts", h.adminOnly(h.endpoints.ResetOTPAttempts)...)
+		sms.POST("/retry/:id", h.adminOnly(h.endpoints.RetrySMS)...)
 	}
 	
 	callback := router.Group("/callback")
 	{
 		callback.POST("/request", h.endpoints.RequestCallback)
 		callback.GET("/status/:request_id", h.endpoints.GetCallbackStatus)
+		callback.DELETE("/:request_id", h.endpoints.CancelCallback)
 	}
+
+	router.POST("/voice/answer", h.providerWebhook(h.endpoints.VoiceAnswer)...)
 	
 	logs := router.Group("/logs")
 	{
-		logs.GET("", h.endpoints.GetLogs)
+		logs.GET("", h.adminOnly(h.endpoints.GetLogs)...)
+		logs.GET("/export", h.adminOnly(h.endpoints.ExportLogs)...)
+		logs.GET("/search", h.adminOnly(h.endpoints.SearchLogs)...)
+	}
+
+	router.GET("/audit", h.adminOnly(h.endpoints.ListAudit)...)
+
+	analytics := router.Group("/analytics")
+	{
+		analytics.GET("/daily", h.adminOnly(h.endpoints.DailyAnalytics)...)
+	}
+
+	admin := router.Group("/admin")
+	{
+		admin.GET("/search/phone", h.adminOnly(h.endpoints.SearchPhone)...)
+		admin.GET("/flagged-phones", h.adminOnly(h.endpoints.ListFlaggedPhones)...)
+	}
+
+	users := router.Group("/users")
+	{
+		users.GET("/lookup", h.adminOnly(h.endpoints.LookupUser)...)
+		users.GET("/profile", h.endpoints.GetProfile)
+		users.PUT("/profile", h.endpoints.UpdateProfile)
+		users.POST("/change-phone/initiate", h.endpoints.InitiatePhoneChange)
+		users.POST("/change-phone/confirm", h.endpoints.ConfirmPhoneChange)
+		users.DELETE("/me", h.endpoints.DeleteAccount)
+	}
+}
+
+// adminOnly prefixes a handler with the admin middleware chain
+func (h *HTTPHandler) adminOnly(handler gin.HandlerFunc) []gin.HandlerFunc {
+	handlers := make([]gin.HandlerFunc, 0, len(h.admin)+1)
+	handlers = append(handlers, h.admin...)
+	return append(handlers, handler)
+}
+
+// adminOnlyWithQuery runs the admin middleware chain before a handler only
+// when the query parameter param is set, e.g. for per-phone statistics
+func (h *HTTPHandler) adminOnlyWithQuery(param string, handler gin.HandlerFunc) []gin.HandlerFunc {
+	public := func(c *gin.Context) {
+		if c.Query(param) == "" {
+			handler(c)
+			c.Abort()
+		}
+	}
+	return append([]gin.HandlerFunc{public}, h.adminOnly(handler)...)
+}
+
+// providerWebhook prefixes a provider webhook handler with the webhook middleware chain
+func (h *HTTPHandler) providerWebhook(handler gin.HandlerFunc) []gin.HandlerFunc {
+	handlers := make([]gin.HandlerFunc, 0, len(h.webhook)+2)
+	handlers = append(handlers, h.webhook...)
+	return append(handlers, providerActor, handler)
+}
+
+// denyAdmin rejects admin-only routes when no admin middleware is configured
+func denyAdmin(c *gin.Context) {
+	appErr := common.NewForbiddenError("Admin access is not configured")
+	c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+}
+
+// serviceGuard rejects requests with 503 when the backing service is unavailable
+func (h *HTTPHandler) serviceGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.available || (h.check != nil && !h.check()) {
+			appErr := common.NewServiceUnavailableError("SMS")
+			if h.reason != "" {
+				appErr.Details = "SMS service is running in degraded mode: " + h.reason
+			}
+			c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+			return
+		}
+		c.Next()
 	}
 }
 
@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"sms-app-backend/common"
+)
+
+func newTestRouter(svc interface{}) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	NewHTTPHandler(svc).RegisterRoutes(r.Group("/api"))
+	return r
+}
+
+func TestNilServiceReturnsServiceUnavailable(t *testing.T) {
+	r := newTestRouter(nil)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/api/sms/send-otp", `{"phone_number":"+1234567890"}`},
+		{http.MethodPost, "/api/sms/verify-otp", `{"phone_number":"+1234567890","otp":"123456"}`},
+		{http.MethodPost, "/api/sms/send-sms", `{"phone_number":"+1234567890","message":"Hello"}`},
+		{http.MethodGet, "/api/sms/otp-status/+1234567890", ""},
+		{http.MethodPost, "/api/callback/request", `{"phone_number":"+1234567890"}`},
+		{http.MethodGet, "/api/callback/status/abc", ""},
+		{http.MethodGet, "/api/logs", ""},
+		{http.MethodGet, "/api/admin/search/phone?q=4567", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("Expected status 503, got %d", w.Code)
+			}
+
+			var appErr common.AppError
+			if err := json.Unmarshal(w.Body.Bytes(), &appErr); err != nil {
+				t.Fatalf("Expected AppError JSON, got %s", w.Body.String())
+			}
+			if appErr.Code != common.ErrCodeServiceUnavailable {
+				t.Errorf("Expected code %d, got %d", common.ErrCodeServiceUnavailable, appErr.Code)
+			}
+		})
+	}
+}
+
+func TestUnavailableReason(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	NewHTTPHandler(nil, WithUnavailableReason("the database is not reachable")).RegisterRoutes(r.Group("/api"))
+
+	// Admin routes are guarded too, before the admin middleware runs
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/api/sms/stats"},
+		{http.MethodPost, "/api/sms/retry/abc"},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(route.method, route.path, nil))
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s: expected status 503, got %d", route.path, w.Code)
+		}
+		var appErr common.AppError
+		json.Unmarshal(w.Body.Bytes(), &appErr)
+		if appErr.Details != "SMS service is running in degraded mode: the database is not reachable" {
+			t.Errorf("%s: expected degraded mode details, got %q", route.path, appErr.Details)
+		}
+	}
+}
+
+func TestServiceMissingMethodReturnsServiceUnavailable(t *testing.T) {
+	// A service that doesn't implement the endpoint's methods is treated as unavailable
+	r := newTestRouter(struct{}{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sms/otp-status/+1234567890", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
+
+func TestAdminRouteDeniedWithoutAdminMiddleware(t *testing.T) {
+	r := newTestRouter(struct{}{})
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodDelete, "/api/sms/otp/+1234567890"},
+		{http.MethodGet, "/api/admin/search/phone?q=4567"},
+		{http.MethodPost, "/api/sms/opt-in"},
+		{http.MethodGet, "/api/logs"},
+		{http.MethodGet, "/api/logs/search?phone=%2B15551234567"},
+		{http.MethodGet, "/api/logs/export?type=sms"},
+		{http.MethodGet, "/api/analytics/daily"},
+	}
+	for _, route := range routes {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(route.method, route.path, nil))
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected status 403, got %d", route.method, route.path, w.Code)
+		}
+	}
+}
+
+func TestAdminRouteRunsAdminMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	reject := func(c *gin.Context) {
+		appErr := common.NewUnauthorizedError("Authorization header required")
+		c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+	}
+	NewHTTPHandler(struct{}{}, WithAdminMiddleware(reject)).RegisterRoutes(r.Group("/api"))
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/sms/otp/+1234567890", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
+
+func TestAvailabilityCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	available := false
+	NewHTTPHandler(struct{}{}, WithAvailabilityCheck(func() bool { return available })).RegisterRoutes(r.Group("/api"))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/sms/otp/+1234567890", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while unavailable, got %d", w.Code)
+	}
+
+	// Once available, the request reaches the admin guard
+	available = true
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/sms/otp/+1234567890", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 once available, got %d", w.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPClientConfig configures the HTTP client provider clients call their APIs with
+type HTTPClientConfig struct {
+	// Timeout bounds each request including reading the response; 0 keeps the default
+	Timeout time.Duration
+	// MaxIdleConns caps idle connections kept open across all hosts
+	MaxIdleConns int
+	// MaxIdleConnsPerHost caps idle connections kept open to each provider host
+	MaxIdleConnsPerHost int
+	// ProxyURL routes every request through this proxy; empty uses the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables
+	ProxyURL string
+}
+
+// DefaultHTTPClientConfig returns the default provider HTTP client configuration
+func DefaultHTTPClientConfig() HTTPClientConfig {
+	return HTTPClientConfig{
+		Timeout:             10 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 20,
+	}
+}
+
+// NewHTTPClient builds an HTTP client to share between provider clients, so
+// they reuse connections and all go through the same proxy
+func NewHTTPClient(cfg HTTPClientConfig) (*http.Client, error) {
+	defaults := DefaultHTTPClientConfig()
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = defaults.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q, expected e.g. http://proxy.internal:3128", cfg.ProxyURL)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}, nil
+}
+
+// defaultHTTPClient is used by provider clients built without a shared HTTP client
+func defaultHTTPClient() *http.Client {
+	client, _ := NewHTTPClient(DefaultHTTPClientConfig())
+	return client
+}
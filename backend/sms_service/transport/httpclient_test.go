@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	client, err := NewHTTPClient(HTTPClientConfig{ProxyURL: "http://proxy.internal:3128"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if client.Timeout != 10*time.Second {
+		t.Errorf("Expected the default timeout, got %v", client.Timeout)
+	}
+
+	transport := client.Transport.(*http.Transport)
+	if transport.MaxIdleConns != 100 || transport.MaxIdleConnsPerHost != 20 {
+		t.Errorf("Expected the default idle connection limits, got %d and %d", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "https://api.plivo.com/v1/Account/id/Message/", nil)
+	proxy, err := transport.Proxy(req)
+	if err != nil || proxy == nil || proxy.Host != "proxy.internal:3128" {
+		t.Errorf("Expected requests to go through the configured proxy, got %v, %v", proxy, err)
+	}
+
+	for _, proxyURL := range []string{"proxy.internal", "://bad"} {
+		if _, err := NewHTTPClient(HTTPClientConfig{ProxyURL: proxyURL}); err == nil {
+			t.Errorf("Expected an error for proxy URL %q", proxyURL)
+		}
+	}
+}
+
+func TestProviderClientsUseSharedHTTPClient(t *testing.T) {
+	shared := &http.Client{Timeout: time.Second}
+
+	if pc := NewPlivoClient("id", "token", "+15550000000", WithPlivoHTTPClient(shared)); pc.httpClient != shared {
+		t.Error("Expected the Plivo client to use the shared HTTP client")
+	}
+	if tc := NewTwilioClient("AC1", "token", "+15550000000", WithTwilioHTTPClient(shared)); tc.httpClient != shared {
+		t.Error("Expected the Twilio client to use the shared HTTP client")
+	}
+	if pc := NewPlivoClient("id", "token", "+15550000000", WithPlivoHTTPClient(nil)); pc.httpClient == nil || pc.httpClient.Timeout == 0 {
+		t.Error("Expected a nil HTTP client to keep the default client with a timeout")
+	}
+}
@@ -0,0 +1,249 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"sms-app-backend/models"
+)
+
+// MockCall records a single call made to a MockSMSClient or a capturing MockClient
+type MockCall struct {
+	Method string
+	From   string
+	To     string
+	Body   string
+	// Message is the full text of an OTP send, whose Body is the code
+	Message string
+	// Validity is the expiry requested for an SMS send
+	Validity time.Duration
+	// MediaURLs are the attachments of an MMS send
+	MediaURLs []string
+}
+
+// ErrMockFailure is returned by a MockClient when a simulated random failure occurs
+var ErrMockFailure = errors.New("mock provider: simulated failure")
+
+// MockClient implements SMSClient for running without a provider and for
+// simulating one in tests. Without options every send succeeds immediately;
+// options simulate failures and delays, and record sends like MockSMSClient.
+type MockClient struct {
+	provider    string
+	failureRate float64
+	err         error
+	latency     time.Duration
+	capture     bool
+
+	mu   sync.Mutex
+	rand *rand.Rand
+	sent []SentMessage
+}
+
+// SentMessage is a send recorded by a capturing MockClient, in the same form
+// as the calls recorded by MockSMSClient
+type SentMessage = MockCall
+
+// MockOption configures a MockClient
+type MockOption func(*MockClient)
+
+// WithMockFailureRate makes a fraction (0..1) of sends fail with ErrMockFailure
+func WithMockFailureRate(rate float64) MockOption {
+	return func(mc *MockClient) {
+		mc.failureRate = rate
+	}
+}
+
+// WithMockError makes every send fail with err
+func WithMockError(err error) MockOption {
+	return func(mc *MockClient) {
+		mc.err = err
+	}
+}
+
+// WithMockLatency delays every send by d, or until the context is done
+func WithMockLatency(d time.Duration) MockOption {
+	return func(mc *MockClient) {
+		mc.latency = d
+	}
+}
+
+// WithMockCapture records every successful send for SentMessages
+func WithMockCapture() MockOption {
+	return func(mc *MockClient) {
+		mc.capture = true
+	}
+}
+
+// NewMockClient creates a new mock SMS client reporting provider as its name
+func NewMockClient(provider string, opts ...MockOption) *MockClient {
+	mc := &MockClient{
+		provider: provider,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, opt := range opts {
+		opt(mc)
+	}
+	return mc
+}
+
+// SendSMS succeeds unless a failure is simulated
+func (mc *MockClient) SendSMS(ctx context.Context, from, to, message string, validity time.Duration, mediaURLs []string) error {
+	return mc.send(ctx, SentMessage{Method: "SendSMS", From: from, To: to, Body: message, Validity: validity, MediaURLs: mediaURLs})
+}
+
+// SendOTP succeeds unless a failure is simulated
+func (mc *MockClient) SendOTP(ctx context.Context, to, otp, message string) error {
+	return mc.send(ctx, SentMessage{Method: "SendOTP", To: to, Body: otp, Message: message})
+}
+
+// ProviderStatus reports the mock provider as healthy, or unhealthy when configured WithMockError
+func (mc *MockClient) ProviderStatus(ctx context.Context) (*models.ProviderHealth, error) {
+	if mc.err != nil {
+		return nil, mc.err
+	}
+	return &models.ProviderHealth{Provider: mc.provider, Healthy: true, CheckedAt: time.Now()}, nil
+}
+
+// FetchStatus reports every message as delivered, or fails when configured WithMockError
+func (mc *MockClient) FetchStatus(ctx context.Context, providerID string) (models.Status, error) {
+	if mc.err != nil {
+		return "", mc.err
+	}
+	return models.StatusDelivered, nil
+}
+
+// GetProvider returns the provider name
+func (mc *MockClient) GetProvider() string {
+	return mc.provider
+}
+
+// SentMessages returns a copy of the successful sends recorded so far
+// (requires WithMockCapture)
+func (mc *MockClient) SentMessages() []SentMessage {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	sent := make([]SentMessage, len(mc.sent))
+	copy(sent, mc.sent)
+	return sent
+}
+
+func (mc *MockClient) send(ctx context.Context, call SentMessage) error {
+	if mc.latency > 0 {
+		timer := time.NewTimer(mc.latency)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if mc.err != nil {
+		return mc.err
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.failureRate > 0 && mc.rand.Float64() < mc.failureRate {
+		return ErrMockFailure
+	}
+	if mc.capture {
+		mc.sent = append(mc.sent, call)
+	}
+	return nil
+}
+
+// MockSMSClient implements SMSClient, WhatsAppClient and VoiceClient for tests, recording every call it receives
+type MockSMSClient struct {
+	// Err, when set, is returned by every send and hangup call
+	Err error
+	// DeliveryStatus is reported by FetchStatus (delivered when empty)
+	DeliveryStatus models.Status
+
+	mu    sync.Mutex
+	calls []MockCall
+}
+
+// NewMockSMSClient creates a new recording mock SMS client
+func NewMockSMSClient() *MockSMSClient {
+	return &MockSMSClient{}
+}
+
+// SendSMS records the message and returns Err
+func (m *MockSMSClient) SendSMS(ctx context.Context, from, to, message string, validity time.Duration, mediaURLs []string) error {
+	m.record(MockCall{Method: "SendSMS", From: from, To: to, Body: message, Validity: validity, MediaURLs: mediaURLs})
+	return m.Err
+}
+
+// SendOTP records the code and text and returns Err
+func (m *MockSMSClient) SendOTP(ctx context.Context, to, otp, message string) error {
+	m.record(MockCall{Method: "SendOTP", To: to, Body: otp, Message: message})
+	return m.Err
+}
+
+// SendOTPTemplate records the code sent over WhatsApp and returns Err
+func (m *MockSMSClient) SendOTPTemplate(ctx context.Context, to, code string) error {
+	m.record(MockCall{Method: "SendOTPTemplate", To: to, Body: code})
+	return m.Err
+}
+
+// HangupCall records the call UUID and returns Err
+func (m *MockSMSClient) HangupCall(ctx context.Context, callUUID string) error {
+	m.record(MockCall{Method: "HangupCall", To: callUUID})
+	return m.Err
+}
+
+// SendVoiceOTP records the code read out in a voice call and returns Err
+func (m *MockSMSClient) SendVoiceOTP(ctx context.Context, to, otp string) error {
+	m.record(MockCall{Method: "SendVoiceOTP", To: to, Body: otp})
+	return m.Err
+}
+
+// ProviderStatus records the check and reports healthy unless Err is set
+func (m *MockSMSClient) ProviderStatus(ctx context.Context) (*models.ProviderHealth, error) {
+	m.record(MockCall{Method: "ProviderStatus"})
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &models.ProviderHealth{Provider: m.GetProvider(), Healthy: true, CheckedAt: time.Now()}, nil
+}
+
+// FetchStatus records the lookup and returns DeliveryStatus, or Err when set
+func (m *MockSMSClient) FetchStatus(ctx context.Context, providerID string) (models.Status, error) {
+	m.record(MockCall{Method: "FetchStatus", To: providerID})
+	if m.Err != nil {
+		return "", m.Err
+	}
+	if m.DeliveryStatus == "" {
+		return models.StatusDelivered, nil
+	}
+	return m.DeliveryStatus, nil
+}
+
+// GetProvider returns the provider name
+func (m *MockSMSClient) GetProvider() string {
+	return "mock"
+}
+
+// Calls returns a copy of the calls received so far
+func (m *MockSMSClient) Calls() []MockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	calls := make([]MockCall, len(m.calls))
+	copy(calls, m.calls)
+	return calls
+}
+
+func (m *MockSMSClient) record(call MockCall) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = append(m.calls, call)
+}
@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Strategies for rotating through several sender numbers
+const (
+	// RotationRoundRobin uses the numbers in turn
+	RotationRoundRobin = "round_robin"
+	// RotationLeastRecentlyUsed uses the number that sent least recently,
+	// counting sends from an explicitly requested number too
+	RotationLeastRecentlyUsed = "least_recently_used"
+)
+
+// SenderRotator is implemented by clients that spread sends over several
+// sender numbers. The service asks for the number before sending so it can
+// record which one a message went out from.
+type SenderRotator interface {
+	NextSender() string
+}
+
+// ParseRotationStrategy validates a rotation strategy name; an empty name is round robin
+func ParseRotationStrategy(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", RotationRoundRobin:
+		return RotationRoundRobin, nil
+	case RotationLeastRecentlyUsed, "lru":
+		return RotationLeastRecentlyUsed, nil
+	default:
+		return "", fmt.Errorf("unknown sender rotation strategy %q", name)
+	}
+}
+
+// ParseSenderNumbers splits a comma-separated list of sender numbers,
+// dropping blanks and duplicates
+func ParseSenderNumbers(list string) []string {
+	var numbers []string
+	seen := make(map[string]bool)
+	for _, number := range strings.Split(list, ",") {
+		number = strings.TrimSpace(number)
+		if number == "" || seen[number] {
+			continue
+		}
+		seen[number] = true
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+// senderPool hands out sender numbers according to a rotation strategy
+type senderPool struct {
+	strategy string
+	numbers  []string
+
+	mu   sync.Mutex
+	next int
+	// lastUsed holds the sequence number of the latest send from each number,
+	// 0 for numbers that haven't sent yet
+	lastUsed map[string]uint64
+	sends    uint64
+}
+
+func newSenderPool(strategy string, numbers []string) *senderPool {
+	return &senderPool{
+		strategy: strategy,
+		numbers:  numbers,
+		lastUsed: make(map[string]uint64, len(numbers)),
+	}
+}
+
+// Next returns the number to send the next message from
+func (p *senderPool) Next() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.numbers) == 0 {
+		return ""
+	}
+
+	if p.strategy == RotationLeastRecentlyUsed {
+		// Numbers that never sent come first, in the configured order
+		next := p.numbers[0]
+		for _, number := range p.numbers[1:] {
+			if p.lastUsed[number] < p.lastUsed[next] {
+				next = number
+			}
+		}
+		p.sends++
+		p.lastUsed[next] = p.sends
+		return next
+	}
+
+	next := p.numbers[p.next%len(p.numbers)]
+	p.next++
+	return next
+}
+
+// Used records a send from an explicitly requested number that belongs to the pool
+func (p *senderPool) Used(number string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, n := range p.numbers {
+		if n == number {
+			p.sends++
+			p.lastUsed[number] = p.sends
+			return
+		}
+	}
+}
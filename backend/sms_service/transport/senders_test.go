@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseSenderNumbers(t *testing.T) {
+	got := ParseSenderNumbers(" +15550000001, ,+15550000002,+15550000001,")
+	want := []string{"+15550000001", "+15550000002"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := ParseSenderNumbers(""); len(got) != 0 {
+		t.Errorf("Expected no numbers, got %v", got)
+	}
+
+	for name, want := range map[string]string{"": RotationRoundRobin, "LRU": RotationLeastRecentlyUsed, "least_recently_used": RotationLeastRecentlyUsed} {
+		if got, err := ParseRotationStrategy(name); err != nil || got != want {
+			t.Errorf("%q: expected %s, got %s (%v)", name, want, got, err)
+		}
+	}
+	if _, err := ParseRotationStrategy("random"); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
+
+func TestPlivoSenderRotation(t *testing.T) {
+	numbers := []string{"+15550000001", "+15550000002", "+15550000003"}
+
+	client := NewPlivoClient("auth-id", "token", "+15550000000", WithSenderNumbers(RotationRoundRobin, numbers...))
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, client.NextSender())
+	}
+	if want := append(numbers, numbers[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected round robin %v, got %v", want, got)
+	}
+
+	// Explicit sends from a pool number push it to the back of the queue
+	client = NewPlivoClient("auth-id", "token", "+15550000000", WithSenderNumbers(RotationLeastRecentlyUsed, numbers...))
+	client.SendSMS(context.Background(), numbers[0], "+15551234567", "Hello", 0, nil)
+	client.SendSMS(context.Background(), "+15559999999", "+15551234567", "Hello", 0, nil)
+	got = nil
+	for i := 0; i < 4; i++ {
+		got = append(got, client.NextSender())
+	}
+	if want := []string{numbers[1], numbers[2], numbers[0], numbers[1]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected least recently used %v, got %v", want, got)
+	}
+
+	// Without a pool every send goes out from the configured number
+	client = NewPlivoClient("auth-id", "token", "+15550000000")
+	if got := client.NextSender(); got != "+15550000000" {
+		t.Errorf("Expected the configured number, got %s", got)
+	}
+}
@@ -0,0 +1,199 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"sms-app-backend/common"
+)
+
+// Headers Plivo attaches to the webhooks it sends
+const (
+	PlivoSignatureHeader = "X-Plivo-Signature-V3"
+	PlivoNonceHeader     = "X-Plivo-Signature-V3-Nonce"
+)
+
+// maxWebhookBodyBytes caps the webhook bodies read to check their signature;
+// Plivo's form posts are a few kilobytes
+const maxWebhookBodyBytes = 64 << 10
+
+// Signature verification errors
+var (
+	ErrMissingPlivoSignature = errors.New("missing X-Plivo-Signature-V3 or nonce header")
+	ErrInvalidPlivoSignature = errors.New("X-Plivo-Signature-V3 does not match the request")
+)
+
+// PlivoSignature computes Plivo's V3 signature: the base64 HMAC-SHA256, keyed
+// with the auth token, of "<base>.<nonce>", with the base built like Plivo's
+// server SDKs do (see plivoBaseURL).
+func PlivoSignature(authToken, method, rawURL string, params url.Values, nonce string) (string, error) {
+	base, err := plivoBaseURL(method, rawURL, params)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte(authToken))
+	mac.Write([]byte(base + "." + nonce))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// VerifyPlivoSignature checks the V3 signature headers of a Plivo webhook.
+// rawURL is the public URL Plivo called and params the form parameters of the
+// body. The header may hold several comma-separated signatures while Plivo
+// rotates the auth token; one match is enough.
+func VerifyPlivoSignature(authToken, method, rawURL string, params url.Values, header http.Header) error {
+	signatures := header.Get(PlivoSignatureHeader)
+	nonce := header.Get(PlivoNonceHeader)
+	if authToken == "" || signatures == "" || nonce == "" {
+		return ErrMissingPlivoSignature
+	}
+
+	expected, err := PlivoSignature(authToken, method, rawURL, params, nonce)
+	if err != nil {
+		return ErrInvalidPlivoSignature
+	}
+	for _, signature := range strings.Split(signatures, ",") {
+		if hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature))) {
+			return nil
+		}
+	}
+	return ErrInvalidPlivoSignature
+}
+
+// PlivoSignatureMiddleware rejects webhook requests whose X-Plivo-Signature-V3
+// header doesn't match the request with 403 Forbidden. publicURL is the scheme
+// and host Plivo calls, e.g. https://api.example.com; when empty it's taken
+// from the request and its X-Forwarded-Proto header. An empty auth token
+// rejects every request.
+func PlivoSignatureMiddleware(authToken, publicURL string) gin.HandlerFunc {
+	publicURL = strings.TrimSuffix(publicURL, "/")
+
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBodyBytes))
+		if err != nil {
+			appErr := common.NewValidationError("Failed to read request body")
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				appErr.Details = "Request body too large"
+				appErr.StatusCode = http.StatusRequestEntityTooLarge
+			}
+			c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+			return
+		}
+		// Restore the body for the handler
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		params := url.Values{}
+		if strings.HasPrefix(c.ContentType(), "application/x-www-form-urlencoded") {
+			if params, err = url.ParseQuery(string(body)); err != nil {
+				params = url.Values{}
+			}
+		} else if len(body) > 0 {
+			// Other payloads are signed as a single unnamed parameter
+			params.Set("", string(body))
+		}
+
+		if err := VerifyPlivoSignature(authToken, c.Request.Method, requestURL(c.Request, publicURL), params, c.Request.Header); err != nil {
+			appErr := common.NewForbiddenError("Invalid webhook signature")
+			appErr.Details = err.Error()
+			c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestURL rebuilds the URL the client called
+func requestURL(r *http.Request, publicURL string) string {
+	if publicURL == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+		}
+		publicURL = scheme + "://" + r.Host
+	}
+	return publicURL + r.URL.RequestURI()
+}
+
+// plivoBaseURL builds the string Plivo signs, without the nonce. GET
+// parameters are merged into the query, which is sorted as key=value pairs.
+// With body parameters the URL always ends in "?" and the sorted query, then
+// "." when the query isn't empty, then the body parameters concatenated as
+// keyvalue, sorted: https://host/path?a=1.FromXTo1 or https://host/path?FromXTo1.
+func plivoBaseURL(method, rawURL string, params url.Values) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsed.Query()
+	if method == http.MethodGet {
+		for key, values := range params {
+			query[key] = append(query[key], values...)
+		}
+	}
+
+	base := parsed.Scheme + "://" + parsed.Host + parsed.Path
+	sorted := sortedQuery(query)
+	if method == http.MethodGet || len(params) == 0 {
+		if sorted != "" {
+			base += "?" + sorted
+		}
+		return base, nil
+	}
+
+	base += "?" + sorted
+	if sorted != "" {
+		base += "."
+	}
+	return base + sortedParams(params), nil
+}
+
+// sortedQuery joins parameters as key=value pairs sorted by key, then value
+func sortedQuery(values url.Values) string {
+	var pairs []string
+	for _, key := range sortedKeys(values) {
+		for _, value := range sortedValues(values[key]) {
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sortedParams concatenates body parameters as keyvalue, sorted by key, then value
+func sortedParams(values url.Values) string {
+	var b strings.Builder
+	for _, key := range sortedKeys(values) {
+		for _, value := range sortedValues(values[key]) {
+			b.WriteString(key)
+			b.WriteString(value)
+		}
+	}
+	return b.String()
+}
+
+func sortedKeys(values url.Values) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedValues(values []string) []string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return sorted
+}
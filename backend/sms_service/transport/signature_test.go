@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"sms-app-backend/models"
+)
+
+type inboundRecorder struct {
+	messages []models.InboundMessage
+}
+
+func (s *inboundRecorder) HandleInboundMessage(ctx context.Context, msg models.InboundMessage) error {
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func TestPlivoSignatureBaseURL(t *testing.T) {
+	params := url.Values{"To": {"15550001111"}, "From": {"15552223333"}, "Text": {"hi"}}
+	base, err := plivoBaseURL(http.MethodPost, "https://api.example.com/api/sms/inbound?b=2&a=1", params)
+	if err != nil {
+		t.Fatalf("Failed to build base URL: %v", err)
+	}
+	want := "https://api.example.com/api/sms/inbound?a=1&b=2.From15552223333TexthiTo15550001111"
+	if base != want {
+		t.Errorf("Expected %q, got %q", want, base)
+	}
+
+	// Without a query the body parameters still follow a "?"
+	base, _ = plivoBaseURL(http.MethodPost, "https://api.example.com/api/sms/inbound", params)
+	if want := "https://api.example.com/api/sms/inbound?From15552223333TexthiTo15550001111"; base != want {
+		t.Errorf("Expected %q, got %q", want, base)
+	}
+
+	base, _ = plivoBaseURL(http.MethodPost, "https://api.example.com/api/sms/inbound?a=1", nil)
+	if base != "https://api.example.com/api/sms/inbound?a=1" {
+		t.Errorf("Expected a POST without body parameters to sign the URL only, got %q", base)
+	}
+
+	base, _ = plivoBaseURL(http.MethodGet, "https://api.example.com/answer?b=2", url.Values{"a": {"1"}})
+	if base != "https://api.example.com/answer?a=1&b=2" {
+		t.Errorf("Expected GET params to be merged into the query, got %q", base)
+	}
+}
+
+func TestPlivoSignatureKnownValues(t *testing.T) {
+	// Signatures of the base strings Plivo's SDKs build, computed outside this package
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		params url.Values
+		want   string
+	}{
+		{"POST", http.MethodPost, "https://answer.url", url.Values{"CallUUID": {"97ceeb52-58b6-11e1-86da-77300b68f8bb"}, "Duration": {"300"}}, "lGsA7wORSRimdxCEqlJfbyxIYTxOsbYjbXU05rrpSpE="},
+		{"GET", http.MethodGet, "https://answer.url?b=2", url.Values{"a": {"1"}}, "T4cW9hhqCOy7PTgxc9NXYL44Poo0GxhLbFm7IZXl0Bs="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PlivoSignature("my_auth_token", tt.method, tt.url, tt.params, "12345")
+			if err != nil {
+				t.Fatalf("Failed to sign: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected signature %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPlivoSignatureMiddlewareBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/inbound", PlivoSignatureMiddleware("token", ""), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	body := url.Values{"Text": {strings.Repeat("a", maxWebhookBodyBytes)}}.Encode()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/inbound", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 for an oversized body, got %d", w.Code)
+	}
+}
+
+func TestPlivoSignatureMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := &inboundRecorder{}
+	r := gin.New()
+	NewHTTPHandler(svc, WithWebhookMiddleware(PlivoSignatureMiddleware("token", "https://api.example.com/"))).RegisterRoutes(r.Group("/api"))
+
+	body := url.Values{"From": {"15552223333"}, "To": {"15550001111"}, "Text": {"STOP"}}
+	signature, err := PlivoSignature("token", http.MethodPost, "https://api.example.com/api/sms/inbound", body, "12345")
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	forged, _ := PlivoSignature("other-token", http.MethodPost, "https://api.example.com/api/sms/inbound", body, "12345")
+
+	tests := []struct {
+		name      string
+		signature string
+		nonce     string
+		want      int
+	}{
+		{"valid", signature, "12345", http.StatusOK},
+		{"one of several", forged + "," + signature, "12345", http.StatusOK},
+		{"unsigned", "", "", http.StatusForbidden},
+		{"forged", forged, "12345", http.StatusForbidden},
+		{"replayed with another nonce", signature, "67890", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/sms/inbound", strings.NewReader(body.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.signature != "" {
+				req.Header.Set(PlivoSignatureHeader, tt.signature)
+				req.Header.Set(PlivoNonceHeader, tt.nonce)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// Only the signed requests reach the service, with the body intact
+	if len(svc.messages) != 2 || svc.messages[0].Text != "STOP" || svc.messages[0].From != "+15552223333" {
+		t.Errorf("Expected the two signed messages to be handled, got %+v", svc.messages)
+	}
+}
+
+func TestPlivoSignatureMiddlewareWithoutToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/inbound", PlivoSignatureMiddleware("", ""), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// An empty token would make signatures trivial to forge
+	signature, _ := PlivoSignature("", http.MethodPost, "http://example.com/inbound", nil, "1")
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/inbound", nil)
+	req.Header.Set(PlivoSignatureHeader, signature)
+	req.Header.Set(PlivoNonceHeader, "1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without an auth token, got %d", w.Code)
+	}
+}
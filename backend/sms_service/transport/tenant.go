@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gin-gonic/gin"
+
+	"sms-app-backend/auth"
+	"sms-app-backend/common"
+	"sms-app-backend/repository"
+)
+
+// TenantHeader names the tenant an admin acts for
+const TenantHeader = "X-Tenant-ID"
+
+// TenantQueryParam names the tenant in the URL of provider webhooks, e.g.
+// /api/sms/delivery-report?tenant=acme
+const TenantQueryParam = "tenant"
+
+// TenantMiddleware resolves the request's tenant and stores its repository in
+// the request context, where the services pick it up. Users act for the
+// tenant of their token's tenant claim; the X-Tenant-ID header must match it
+// unless the user is an admin, who may name any registered tenant. Requests
+// naming no tenant use the default repository.
+// It must run after the auth middleware.
+func TenantMiddleware(factory repository.RepositoryFactory) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID := c.GetHeader(TenantHeader)
+		claimed := c.GetString(auth.ContextTenantIDKey)
+		switch {
+		case claimed != "" && tenantID != "" && tenantID != claimed:
+			if c.GetString(auth.ContextUserRoleKey) != auth.RoleAdmin {
+				appErr := common.NewForbiddenError("Token is not valid for tenant " + tenantID)
+				c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+				return
+			}
+		case claimed != "":
+			tenantID = claimed
+		case tenantID != "" && c.GetString(auth.ContextUserRoleKey) != auth.RoleAdmin:
+			appErr := common.NewForbiddenError("The " + TenantHeader + " header requires a token for the tenant")
+			c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+			return
+		}
+
+		useTenant(c, factory, tenantID, TenantHeader)
+	}
+}
+
+// WebhookTenantMiddleware routes provider webhooks to the tenant named by the
+// tenant query parameter of the webhook URL configured with the provider.
+// It must run after the webhook signature is verified, which covers the URL.
+func WebhookTenantMiddleware(factory repository.RepositoryFactory) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		useTenant(c, factory, c.Query(TenantQueryParam), TenantQueryParam)
+	}
+}
+
+// useTenant stores the repository of tenantID in the request context, leaving
+// the default repository for an empty ID. field names where the ID came from.
+func useTenant(c *gin.Context, factory repository.RepositoryFactory, tenantID, field string) {
+	if tenantID == "" {
+		c.Next()
+		return
+	}
+
+	repo, err := factory.ForTenant(c.Request.Context(), tenantID)
+	switch {
+	case errors.Is(err, repository.ErrInvalidTenant):
+		appErr := common.NewValidationErrors(map[string]string{
+			field: "must be 1-32 letters, digits, underscores or hyphens",
+		})
+		c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+		return
+	case errors.Is(err, repository.ErrUnknownTenant):
+		appErr := common.NewNotFoundError("tenant")
+		c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+		return
+	case err != nil:
+		log.Printf("Failed to open repository for tenant %s: %v", tenantID, err)
+		appErr := common.NewServiceUnavailableError("Tenant storage")
+		c.AbortWithStatusJSON(appErr.StatusCode, appErr)
+		return
+	}
+
+	c.Request = c.Request.WithContext(repository.WithRepository(c.Request.Context(), repo))
+	c.Next()
+}
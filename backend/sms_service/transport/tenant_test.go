@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"sms-app-backend/auth"
+	"sms-app-backend/repository"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defaultRepo := repository.NewInMemoryRepository()
+	tenants := repository.NewInMemoryRepositoryFactory("acme", "globex")
+	acme, _ := tenants.ForTenant(context.Background(), "acme")
+	globex, _ := tenants.ForTenant(context.Background(), "globex")
+
+	var resolved repository.Repository
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		if claimed := c.GetHeader("X-Claimed-Tenant"); claimed != "" {
+			c.Set(auth.ContextTenantIDKey, claimed)
+		}
+		c.Set(auth.ContextUserRoleKey, c.GetHeader("X-Role"))
+	}, TenantMiddleware(tenants), func(c *gin.Context) {
+		resolved = repository.FromContext(c.Request.Context(), defaultRepo)
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name    string
+		header  string
+		claimed string
+		role    string
+		want    int
+		repo    repository.Repository
+	}{
+		{"no tenant", "", "", "", http.StatusOK, defaultRepo},
+		{"header without a token", "acme", "", "", http.StatusForbidden, nil},
+		{"claim", "", "acme", "", http.StatusOK, acme},
+		{"matching header and claim", "acme", "acme", "", http.StatusOK, acme},
+		{"conflicting header", "globex", "acme", "", http.StatusForbidden, nil},
+		{"admin header", "globex", "", auth.RoleAdmin, http.StatusOK, globex},
+		{"admin header overrides claim", "globex", "acme", auth.RoleAdmin, http.StatusOK, globex},
+		{"unknown tenant", "", "initech", "", http.StatusNotFound, nil},
+		{"invalid tenant", "../admin", "", auth.RoleAdmin, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved = nil
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(TenantHeader, tt.header)
+			}
+			if tt.claimed != "" {
+				req.Header.Set("X-Claimed-Tenant", tt.claimed)
+			}
+			req.Header.Set("X-Role", tt.role)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+			if resolved != tt.repo {
+				t.Errorf("Expected repository %p, got %p", tt.repo, resolved)
+			}
+		})
+	}
+}
+
+func TestWebhookTenantMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defaultRepo := repository.NewInMemoryRepository()
+	tenants := repository.NewInMemoryRepositoryFactory("acme")
+	acme, _ := tenants.ForTenant(context.Background(), "acme")
+
+	var resolved repository.Repository
+	r := gin.New()
+	r.POST("/delivery-report", WebhookTenantMiddleware(tenants), func(c *gin.Context) {
+		resolved = repository.FromContext(c.Request.Context(), defaultRepo)
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		query string
+		want  int
+		repo  repository.Repository
+	}{
+		{"", http.StatusOK, defaultRepo},
+		{"?tenant=acme", http.StatusOK, acme},
+		{"?tenant=initech", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		resolved = nil
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/delivery-report"+tt.query, nil))
+		if w.Code != tt.want || resolved != tt.repo {
+			t.Errorf("%q: expected status %d and repository %p, got %d and %p", tt.query, tt.want, tt.repo, w.Code, resolved)
+		}
+	}
+}
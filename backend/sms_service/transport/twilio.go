@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"sms-app-backend/models"
+)
+
+// ErrTwilioSendUnsupported is returned for sends through Twilio, which can
+// only check the account and fetch message statuses so far
+var ErrTwilioSendUnsupported = errors.New("sending messages through twilio is not supported yet")
+
+// TwilioClient implements SMSClient for the Twilio Programmable Messaging API
+type TwilioClient struct {
+	accountSID string
+	authToken  string
+	from       string
+	accountURL string
+	httpClient *http.Client
+}
+
+// TwilioOption configures a TwilioClient
+type TwilioOption func(*TwilioClient)
+
+// WithTwilioHTTPClient calls the Twilio API with client, e.g. one built by
+// NewHTTPClient to go through a proxy. Nil keeps the default client.
+func WithTwilioHTTPClient(client *http.Client) TwilioOption {
+	return func(tc *TwilioClient) {
+		if client != nil {
+			tc.httpClient = client
+		}
+	}
+}
+
+// NewTwilioClient creates a new Twilio client
+func NewTwilioClient(accountSID, authToken, from string, opts ...TwilioOption) *TwilioClient {
+	tc := &TwilioClient{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		accountURL: "https://api.twilio.com/2010-04-01/Accounts/" + accountSID + "/",
+		httpClient: defaultHTTPClient(),
+	}
+	for _, opt := range opts {
+		opt(tc)
+	}
+	return tc
+}
+
+// SendSMS fails with ErrTwilioSendUnsupported rather than reporting a message
+// as sent that never left
+func (tc *TwilioClient) SendSMS(ctx context.Context, from, to, message string, validity time.Duration, mediaURLs []string) error {
+	// Implementation would POST Messages.json with From=from, or the configured
+	// number when empty, To and Body, and ValidityPeriod set to the validity in
+	// seconds when it's not 0. Messages with media carry a MediaUrl parameter
+	// per URL, which Twilio sends as MMS.
+	return ErrTwilioSendUnsupported
+}
+
+// SendOTP sends an OTP message via Twilio from the configured number; like
+// SendSMS it fails until sending is supported
+func (tc *TwilioClient) SendOTP(ctx context.Context, to, otp, message string) error {
+	return tc.SendSMS(ctx, "", to, message, 0, nil)
+}
+
+// ProviderStatus fetches the Twilio account balance to verify the credentials
+func (tc *TwilioClient) ProviderStatus(ctx context.Context) (*models.ProviderHealth, error) {
+	var account struct {
+		Balance string `json:"balance"`
+	}
+	status, err := tc.get(ctx, "Balance.json", &account)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusUnauthorized {
+		return nil, errors.New("twilio rejected the configured credentials")
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("twilio balance request returned status %d", status)
+	}
+
+	health := &models.ProviderHealth{Provider: tc.GetProvider(), Healthy: true, CheckedAt: time.Now()}
+	if balance, err := strconv.ParseFloat(account.Balance, 64); err == nil {
+		health.Balance = &balance
+	}
+	return health, nil
+}
+
+// FetchStatus fetches a message from Twilio and maps its status to an SMS status
+func (tc *TwilioClient) FetchStatus(ctx context.Context, providerID string) (models.Status, error) {
+	var message struct {
+		Status string `json:"status"`
+	}
+	status, err := tc.get(ctx, "Messages/"+url.PathEscape(providerID)+".json", &message)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("twilio message request returned status %d", status)
+	}
+
+	switch message.Status {
+	case "accepted", "scheduled", "queued", "sending":
+		return models.StatusPending, nil
+	case "sent":
+		return models.StatusSent, nil
+	case "delivered", "read":
+		return models.StatusDelivered, nil
+	case "failed", "undelivered", "canceled":
+		return models.StatusFailed, nil
+	default:
+		return "", fmt.Errorf("unknown twilio message status %q", message.Status)
+	}
+}
+
+// GetProvider returns the provider name
+func (tc *TwilioClient) GetProvider() string {
+	return models.ProviderTwilio
+}
+
+// get calls a Twilio account resource and returns the HTTP status, decoding
+// the body into out when it is 200 OK
+func (tc *TwilioClient) get(ctx context.Context, resource string, out interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tc.accountURL+resource, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.SetBasicAuth(tc.accountSID, tc.authToken)
+
+	resp, err := tc.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("twilio request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("invalid twilio response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"sms-app-backend/common"
+)
+
+func init() {
+	// Report validation failures under the JSON/form names clients send
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(requestFieldName)
+	}
+}
+
+// requestFieldName returns the JSON (or form) name of a request struct field
+func requestFieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// bindingError converts a request binding failure into a validation error,
+// with a message per invalid field when the failure can be attributed to fields
+func bindingError(err error) *common.AppError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make(map[string]string, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields[fe.Field()] = fieldErrorMessage(fe)
+		}
+		return common.NewValidationErrors(fields)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return common.NewValidationErrors(map[string]string{
+			typeErr.Field: fmt.Sprintf("must be a %s", typeErr.Type.Kind()),
+		})
+	}
+
+	return common.NewValidationError("Invalid request format: " + err.Error())
+}
+
+// sizeUnit names what a length rule counts for the field's kind
+func sizeUnit(fe validator.FieldError) string {
+	switch fe.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return "items"
+	default:
+		return "characters"
+	}
+}
+
+// fieldErrorMessage describes a failed validation rule in plain words
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return "must be one of: " + strings.Join(strings.Fields(fe.Param()), ", ")
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		return fmt.Sprintf("must be at least %s %s", fe.Param(), sizeUnit(fe))
+	case "max":
+		return fmt.Sprintf("must be at most %s %s", fe.Param(), sizeUnit(fe))
+	case "len":
+		return fmt.Sprintf("must be exactly %s characters", fe.Param())
+	default:
+		return "is invalid"
+	}
+}
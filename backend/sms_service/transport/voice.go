@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"sms-app-backend/models"
+)
+
+// defaultCallbackMessage is read out for callback requests without a message of their own
+const defaultCallbackMessage = "Hello, this is the callback you requested."
+
+// VoiceSettings configures the Plivo XML served when the call placed for a
+// callback request is answered. Only Plivo places calls.
+type VoiceSettings struct {
+	// Language the message is read in, e.g. "en-US"; empty uses Plivo's default
+	Language string
+	// Voice reading the message, "WOMAN" or "MAN"; empty uses Plivo's default
+	Voice string
+	// RedirectURL, when set, is fetched by the provider for further instructions
+	// once the message was read, e.g. to connect the caller to an agent; without
+	// it the call is hung up
+	RedirectURL string
+}
+
+// voiceResponse is the Plivo answer XML
+type voiceResponse struct {
+	XMLName  xml.Name     `xml:"Response"`
+	Speak    *voiceSpeech `xml:"Speak,omitempty"`
+	Redirect string       `xml:"Redirect,omitempty"`
+	Hangup   *struct{}    `xml:"Hangup,omitempty"`
+}
+
+type voiceSpeech struct {
+	Voice    string `xml:"voice,attr,omitempty"`
+	Language string `xml:"language,attr,omitempty"`
+	Loop     int    `xml:"loop,attr,omitempty"`
+	Text     string `xml:",chardata"`
+}
+
+// RenderVoiceAnswer renders the XML that reads message out repeat times,
+// then redirects to the configured URL or hangs up. An empty message reads a
+// generic greeting.
+func RenderVoiceAnswer(settings VoiceSettings, message string, repeat int) ([]byte, error) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		message = defaultCallbackMessage
+	}
+
+	speech := &voiceSpeech{
+		Voice:    settings.Voice,
+		Language: settings.Language,
+		Text:     message,
+	}
+	if repeat > 1 {
+		speech.Loop = repeat
+	}
+
+	response := voiceResponse{Speak: speech, Redirect: settings.RedirectURL}
+	if settings.RedirectURL == "" {
+		response.Hangup = &struct{}{}
+	}
+	return marshalVoiceResponse(response)
+}
+
+// RenderVoiceHangup renders the XML that hangs up the call straight away
+func RenderVoiceHangup() []byte {
+	body, _ := marshalVoiceResponse(voiceResponse{Hangup: &struct{}{}})
+	return body
+}
+
+func marshalVoiceResponse(response voiceResponse) ([]byte, error) {
+	body, err := xml.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// callbackRepeats is how often the message of a callback is read out; high
+// priority messages are repeated so they aren't missed
+func callbackRepeats(priority string) int {
+	if strings.EqualFold(strings.TrimSpace(priority), models.PriorityHigh) {
+		return 2
+	}
+	return 1
+}
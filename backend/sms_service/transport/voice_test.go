@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderVoiceAnswer(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings VoiceSettings
+		message  string
+		repeat   int
+		want     string
+	}{
+		{
+			"plivo",
+			VoiceSettings{Language: "en-GB", Voice: "WOMAN"},
+			"Your order <42> shipped",
+			1,
+			`<Response><Speak voice="WOMAN" language="en-GB">Your order &lt;42&gt; shipped</Speak><Hangup></Hangup></Response>`,
+		},
+		{
+			"redirect",
+			VoiceSettings{RedirectURL: "https://example.com/agent"},
+			"Urgent",
+			2,
+			`<Response><Speak loop="2">Urgent</Speak><Redirect>https://example.com/agent</Redirect></Response>`,
+		},
+		{
+			"default message",
+			VoiceSettings{},
+			"  ",
+			1,
+			`<Response><Speak>` + defaultCallbackMessage + `</Speak><Hangup></Hangup></Response>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := RenderVoiceAnswer(tt.settings, tt.message, tt.repeat)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got := strings.TrimPrefix(string(body), `<?xml version="1.0" encoding="UTF-8"?>`+"\n"); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCallbackRepeats(t *testing.T) {
+	for priority, want := range map[string]int{"high": 2, "HIGH": 2, "normal": 1, "": 1} {
+		if got := callbackRepeats(priority); got != want {
+			t.Errorf("Priority %q: expected %d repeats, got %d", priority, want, got)
+		}
+	}
+}
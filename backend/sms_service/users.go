@@ -0,0 +1,298 @@
+package sms_service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"sms-app-backend/common"
+	"sms-app-backend/models"
+	"sms-app-backend/repository"
+)
+
+// PhoneVerifier sends OTPs and checks them, and sends account notices, e.g.
+// SMSServiceImpl
+type PhoneVerifier interface {
+	SendOTP(ctx context.Context, req models.OTPRequest) (*models.OTPResponse, error)
+	VerifyOTP(ctx context.Context, req models.VerifyOTPRequest) (*models.VerifyOTPResponse, error)
+	SendSMS(ctx context.Context, req models.SMSRequest) (*models.SMSResponse, error)
+}
+
+// UserServiceImpl implements UserService
+type UserServiceImpl struct {
+	repo     repository.Repository
+	verifier PhoneVerifier
+}
+
+// NewUserService creates a new user service instance
+func NewUserService(repo repository.Repository, opts ...UserOption) *UserServiceImpl {
+	service := &UserServiceImpl{
+		repo: repo,
+	}
+	for _, opt := range opts {
+		opt(service)
+	}
+	return service
+}
+
+func (s *UserServiceImpl) repoFor(ctx context.Context) repository.Repository {
+	return repository.FromContext(ctx, s.repo)
+}
+
+// LookupUser finds a user by phone number or, when phone is empty, by email
+func (s *UserServiceImpl) LookupUser(ctx context.Context, phone, email string) (*models.UserProfile, error) {
+	var user *models.User
+	var err error
+	if phone != "" {
+		log.Printf("Looking up user by phone %s", phone)
+		user, err = s.repoFor(ctx).User().FindByPhone(ctx, phone)
+	} else {
+		log.Println("Looking up user by email")
+		user, err = s.repoFor(ctx).User().FindByEmail(ctx, email)
+	}
+	if err != nil {
+		return nil, lookupError(err, "user")
+	}
+	return user.Profile(), nil
+}
+
+// GetProfile returns the profile of a user by ID
+func (s *UserServiceImpl) GetProfile(ctx context.Context, userID string) (*models.UserProfile, error) {
+	user, err := s.repoFor(ctx).User().FindByID(ctx, userID)
+	if err != nil {
+		return nil, lookupError(err, "user")
+	}
+	return user.Profile(), nil
+}
+
+// UpdateProfile changes the name and email of a user. The email must not
+// belong to another user.
+func (s *UserServiceImpl) UpdateProfile(ctx context.Context, userID string, update models.UserProfileUpdate) (*models.UserProfile, error) {
+	if update.Phone != nil {
+		return nil, common.NewValidationErrors(map[string]string{
+			"phone": "can't be changed here, the new number has to be verified first",
+		})
+	}
+
+	users := s.repoFor(ctx).User()
+	user, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, lookupError(err, "user")
+	}
+
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" {
+			return nil, common.NewValidationErrors(map[string]string{"name": "must not be empty"})
+		}
+		user.Name = name
+	}
+	if update.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*update.Email))
+		existing, err := users.FindByEmail(ctx, email)
+		switch {
+		case err == nil && existing.ID != user.ID:
+			return nil, common.NewConflictError("Email is already in use by another account")
+		case err != nil && !errors.Is(err, repository.ErrNotFound):
+			log.Printf("Failed to check email of user %s: %v", userID, err)
+			return nil, common.NewInternalError("Failed to update profile")
+		}
+		user.Email = email
+	}
+
+	// The unique index catches an email taken since the lookup above
+	if err := users.Update(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return nil, common.NewConflictError("Email is already in use by another account")
+		}
+		log.Printf("Failed to update profile of user %s: %v", userID, err)
+		return nil, common.NewInternalError("Failed to update profile")
+	}
+	log.Printf("Updated profile of user %s", userID)
+	return user.Profile(), nil
+}
+
+// InitiatePhoneChange sends an OTP to the number a user wants to move to.
+// The number must not belong to another user.
+func (s *UserServiceImpl) InitiatePhoneChange(ctx context.Context, userID, phone string) (*models.OTPResponse, error) {
+	if s.verifier == nil {
+		return nil, common.NewServiceUnavailableError("Phone verification")
+	}
+
+	user, err := s.phoneChangeUser(ctx, userID, phone)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s requested a phone change to %s", user.ID.Hex(), phone)
+	return s.verifier.SendOTP(ctx, models.OTPRequest{PhoneNumber: phone, Purpose: models.PurposeChangePhone})
+}
+
+// ConfirmPhoneChange moves a user to the new number once the OTP sent to it
+// by InitiatePhoneChange is verified. Every session of the user is revoked and
+// the old number is told about the change, so a hijacked account is noticed.
+func (s *UserServiceImpl) ConfirmPhoneChange(ctx context.Context, userID, phone, code string) (*models.UserProfile, error) {
+	if s.verifier == nil {
+		return nil, common.NewServiceUnavailableError("Phone verification")
+	}
+
+	user, err := s.phoneChangeUser(ctx, userID, phone)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only a code sent for a phone change confirms one, not e.g. a login code
+	if err := s.verify(ctx, phone, code, models.PurposeChangePhone); err != nil {
+		return nil, err
+	}
+
+	previous := user.Phone
+	user.Phone = phone
+	user.PreviousPhones = append(user.PreviousPhones, previous)
+	user.SessionsRevokedAt = time.Now()
+	// The unique index catches a number taken since phoneChangeUser
+	if err := s.repoFor(ctx).User().Update(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return nil, common.NewConflictError("Phone number is already in use by another account")
+		}
+		log.Printf("Failed to change phone of user %s: %v", userID, err)
+		return nil, common.NewInternalError("Failed to change phone number")
+	}
+	log.Printf("User %s changed phone from %s to %s", userID, previous, phone)
+
+	notice := fmt.Sprintf("The phone number of your account was changed to a number ending in %s. If you didn't do this, contact support.",
+		common.PhoneLast4(phone))
+	if _, err := s.verifier.SendSMS(ctx, models.SMSRequest{PhoneNumber: previous, Message: notice}); err != nil {
+		log.Printf("Warning: failed to notify %s of the phone change of user %s: %v", previous, userID, err)
+	}
+	return user.Profile(), nil
+}
+
+// SessionRevoked reports whether a token issued to userID at issuedAt (Unix
+// seconds) was revoked. Tokens of unknown users, e.g. admins without an
+// account, are not revoked; tokens without an issue time are once any session
+// of the user was revoked.
+func (s *UserServiceImpl) SessionRevoked(ctx context.Context, userID string, issuedAt int64) bool {
+	user, err := s.repoFor(ctx).User().FindByID(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("Warning: failed to check the sessions of user %s: %v", userID, err)
+		}
+		return false
+	}
+	return !user.SessionsRevokedAt.IsZero() && issuedAt < user.SessionsRevokedAt.Unix()
+}
+
+// phoneChangeUser loads the user changing to phone, rejecting numbers that
+// are already theirs or belong to someone else
+func (s *UserServiceImpl) phoneChangeUser(ctx context.Context, userID, phone string) (*models.User, error) {
+	users := s.repoFor(ctx).User()
+	user, err := users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, lookupError(err, "user")
+	}
+	if user.Phone == phone {
+		return nil, common.NewValidationError("This is already your phone number")
+	}
+
+	existing, err := users.FindByPhone(ctx, phone)
+	switch {
+	case err == nil && existing.ID != user.ID:
+		return nil, common.NewConflictError("Phone number is already in use by another account")
+	case err != nil && !errors.Is(err, repository.ErrNotFound):
+		log.Printf("Failed to check phone of user %s: %v", userID, err)
+		return nil, common.NewInternalError("Failed to change phone number")
+	}
+	return user, nil
+}
+
+// verify checks code against the OTP last sent to phone, which must have been
+// sent for purpose
+func (s *UserServiceImpl) verify(ctx context.Context, phone, code, purpose string) error {
+	pending, err := s.repoFor(ctx).OTP().FindByPhone(ctx, phone)
+	if err != nil || pending.Purpose != purpose {
+		return common.NewValidationError(fmt.Sprintf("No OTP for %s was sent to this number. Please request a new OTP.", purpose))
+	}
+
+	verification, err := s.verifier.VerifyOTP(ctx, models.VerifyOTPRequest{PhoneNumber: phone, OTP: code, Purpose: purpose})
+	if err != nil {
+		return err
+	}
+	if !verification.Valid {
+		return common.NewValidationError(verification.Message)
+	}
+	return nil
+}
+
+// DeleteAccount erases a user along with every record of them, once code is
+// verified against an OTP sent to their number with purpose delete_account:
+// their SMS messages, OTPs, send counters, callbacks, brute-force flags and
+// audit records, under their current number and any they changed away from.
+// The opt-out list keeps the numbers so they stay opted out.
+func (s *UserServiceImpl) DeleteAccount(ctx context.Context, userID, code string) (*models.AccountDeletionSummary, error) {
+	if s.verifier == nil {
+		return nil, common.NewServiceUnavailableError("Phone verification")
+	}
+
+	repo := s.repoFor(ctx)
+	user, err := repo.User().FindByID(ctx, userID)
+	if err != nil {
+		return nil, lookupError(err, "user")
+	}
+	if err := s.verify(ctx, user.Phone, code, models.PurposeDeleteAccount); err != nil {
+		return nil, err
+	}
+
+	phones := append([]string{user.Phone}, user.PreviousPhones...)
+	summary := &models.AccountDeletionSummary{UserID: userID, DeletedAt: time.Now()}
+	failed := func(what string, err error) (*models.AccountDeletionSummary, error) {
+		log.Printf("Failed to delete %s of user %s: %v", what, userID, err)
+		return nil, common.NewInternalError("Failed to delete account")
+	}
+
+	targets, err := repo.SMS().DeleteByUser(ctx, userID, phones)
+	if err != nil {
+		return failed("SMS messages", err)
+	}
+	summary.SMSDeleted = int64(len(targets))
+
+	for _, phone := range phones {
+		// The verified OTP may still be kept for retried verifications
+		if otp, err := repo.OTP().FindByPhone(ctx, phone); err == nil {
+			targets = append(targets, otp.ID.Hex())
+			summary.OTPsDeleted++
+		}
+		if err := repo.OTP().DeleteByPhone(ctx, phone); err != nil {
+			return failed("OTPs", err)
+		}
+	}
+	if _, err := repo.OTPSends().DeleteByPhones(ctx, phones); err != nil {
+		return failed("OTP send counters", err)
+	}
+
+	callbacks, err := repo.Callback().DeleteByPhones(ctx, phones)
+	if err != nil {
+		return failed("callbacks", err)
+	}
+	summary.CallbacksDeleted = int64(len(callbacks))
+	targets = append(targets, callbacks...)
+
+	if _, err := repo.FlaggedPhones().DeleteByPhones(ctx, phones); err != nil {
+		return failed("flagged phones", err)
+	}
+	summary.AuditRecordsDeleted, err = repo.Audit().DeleteByTargets(ctx, append(targets, userID), userID)
+	if err != nil {
+		return failed("audit records", err)
+	}
+
+	if err := repo.User().Delete(ctx, userID); err != nil {
+		return failed("user", err)
+	}
+
+	log.Printf("Deleted account of user %s with %d SMS messages, %d OTPs, %d callbacks and %d audit records",
+		userID, summary.SMSDeleted, summary.OTPsDeleted, summary.CallbacksDeleted, summary.AuditRecordsDeleted)
+	return summary, nil
+}
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature headers attached to every outbound webhook
+const (
+	HeaderSignature = "X-Signature"
+	HeaderTimestamp = "X-Timestamp"
+)
+
+// Verification errors
+var (
+	ErrMissingSignature = errors.New("webhook: missing signature or timestamp header")
+	ErrInvalidSignature = errors.New("webhook: signature does not match body")
+	ErrExpiredTimestamp = errors.New("webhook: timestamp outside tolerance")
+)
+
+// Destination is a webhook receiver and the secret used to sign payloads sent to it
+type Destination struct {
+	URL    string
+	Secret string
+}
+
+// Event is the JSON envelope posted to webhook destinations
+type Event struct {
+	Type      string      `json:"type"`
+	Data      interface{} `json:"data"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Sign computes the hex-encoded HMAC-SHA256 of "<timestamp>.<body>" using secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the X-Signature and X-Timestamp headers of a received webhook.
+// Receivers should call it with the raw request body and their destination secret:
+//
+//	body, _ := io.ReadAll(r.Body)
+//	if err := webhook.Verify(secret, r.Header, body, 5*time.Minute); err != nil {
+//		// reject the request
+//	}
+//
+// A tolerance of 0 skips the timestamp freshness check.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	signature := header.Get(HeaderSignature)
+	timestampStr := header.Get(HeaderTimestamp)
+	if signature == "" || timestampStr == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return ErrMissingSignature
+	}
+
+	if tolerance > 0 {
+		age := time.Since(time.Unix(timestamp, 0))
+		if age > tolerance || age < -tolerance {
+			return ErrExpiredTimestamp
+		}
+	}
+
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Sender posts signed events to the configured destinations
+type Sender struct {
+	destinations []Destination
+	client       *http.Client
+}
+
+// NewSender creates a new webhook sender
+func NewSender(destinations []Destination) *Sender {
+	return &Sender{
+		destinations: destinations,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Enabled reports whether any destinations are configured
+func (s *Sender) Enabled() bool {
+	return s != nil && len(s.destinations) > 0
+}
+
+// Send posts an event to every destination, signing the body with each destination's secret
+func (s *Sender) Send(ctx context.Context, eventType string, data interface{}) error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	body, err := json.Marshal(Event{Type: eventType, Data: data, Timestamp: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("webhook: failed to encode event: %w", err)
+	}
+
+	var errs []error
+	for _, destination := range s.destinations {
+		if err := s.post(ctx, destination, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// post delivers a signed body to a single destination
+func (s *Sender) post(ctx context.Context, destination Destination, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, destination.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: invalid destination %s: %w", destination.URL, err)
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(destination.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: failed to post to %s: %w", destination.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s responded with status %d", destination.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// ParseDestinations parses destinations in the form "<url>|<secret>,<url>|<secret>"
+func ParseDestinations(value string) ([]Destination, error) {
+	var destinations []Destination
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "|", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid webhook destination %q, expected <url>|<secret>", entry)
+		}
+		destinations = append(destinations, Destination{URL: parts[0], Secret: parts[1]})
+	}
+	return destinations, nil
+}
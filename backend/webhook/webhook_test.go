@@ -0,0 +1,73 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSendSignsBody(t *testing.T) {
+	received := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- Verify("secret", r.Header, body, time.Minute)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewSender([]Destination{{URL: server.URL, Secret: "secret"}})
+	if err := sender.Send(context.Background(), "sms.sent", map[string]string{"id": "123"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := <-received; err != nil {
+		t.Errorf("Expected signature to verify, got %v", err)
+	}
+}
+
+func TestVerifyRejectsTamperedBody(t *testing.T) {
+	timestamp := time.Now().Unix()
+	header := http.Header{}
+	header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	header.Set(HeaderSignature, Sign("secret", timestamp, []byte(`{"status":"sent"}`)))
+
+	if err := Verify("secret", header, []byte(`{"status":"sent"}`), time.Minute); err != nil {
+		t.Errorf("Expected original body to verify, got %v", err)
+	}
+	if err := Verify("secret", header, []byte(`{"status":"failed"}`), time.Minute); err != ErrInvalidSignature {
+		t.Errorf("Expected ErrInvalidSignature for tampered body, got %v", err)
+	}
+	if err := Verify("other", header, []byte(`{"status":"sent"}`), time.Minute); err != ErrInvalidSignature {
+		t.Errorf("Expected ErrInvalidSignature for wrong secret, got %v", err)
+	}
+}
+
+func TestVerifyRejectsStaleTimestamp(t *testing.T) {
+	timestamp := time.Now().Add(-time.Hour).Unix()
+	body := []byte(`{}`)
+	header := http.Header{}
+	header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	header.Set(HeaderSignature, Sign("secret", timestamp, body))
+
+	if err := Verify("secret", header, body, 5*time.Minute); err != ErrExpiredTimestamp {
+		t.Errorf("Expected ErrExpiredTimestamp, got %v", err)
+	}
+}
+
+func TestParseDestinations(t *testing.T) {
+	destinations, err := ParseDestinations("https://a.example.com/hook|s1, https://b.example.com/hook?x=1|s2")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(destinations) != 2 || destinations[1].URL != "https://b.example.com/hook?x=1" || destinations[1].Secret != "s2" {
+		t.Errorf("Unexpected destinations: %+v", destinations)
+	}
+
+	if _, err := ParseDestinations("https://a.example.com/hook"); err == nil {
+		t.Errorf("Expected error for destination without secret")
+	}
+}
@@ -70,6 +70,7 @@ export default function AdminPage() {
         return 'bg-green-100 text-green-800';
       case 'pending':
       case 'requested':
+      case 'retrying':
         return 'bg-yellow-100 text-yellow-800';
       case 'failed':
       case 'cancelled':